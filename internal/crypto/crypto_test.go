@@ -2,8 +2,17 @@ package crypto
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestArgon2KeyDerivation(t *testing.T) {
@@ -279,3 +288,215 @@ func TestBase64Encoding(t *testing.T) {
 		t.Error("Decoded URL data does not match original")
 	}
 }
+
+func TestSRPHandshake(t *testing.T) {
+	if !srpN.ProbablyPrime(20) {
+		t.Fatal("SRP group modulus is not prime")
+	}
+
+	identity := "alice@example.com"
+	secret := []byte("derived-login-secret")
+	salt, _ := GenerateSalt(16)
+
+	verifier := ComputeSRPVerifier(identity, secret, salt)
+	if err := ValidateSRPVerifier(verifier); err != nil {
+		t.Fatalf("Verifier rejected: %v", err)
+	}
+
+	clientSecret, clientPublic, err := GenerateSRPClientEphemeral()
+	if err != nil {
+		t.Fatalf("Failed to generate client ephemeral: %v", err)
+	}
+	serverSecret, serverPublic, err := GenerateSRPServerEphemeral(verifier)
+	if err != nil {
+		t.Fatalf("Failed to generate server ephemeral: %v", err)
+	}
+
+	clientProof, expectedServerProof, err := ComputeSRPClientProof(identity, secret, salt, clientSecret, clientPublic, serverPublic)
+	if err != nil {
+		t.Fatalf("Failed to compute client proof: %v", err)
+	}
+
+	serverProof, err := VerifySRPClientProof(identity, salt, verifier, clientPublic, serverSecret, serverPublic, clientProof)
+	if err != nil {
+		t.Fatalf("Server rejected valid proof: %v", err)
+	}
+	if !bytes.Equal(serverProof, expectedServerProof) {
+		t.Error("Server proof does not match client expectation")
+	}
+
+	// A wrong secret must not produce an accepted proof
+	wrongProof, _, err := ComputeSRPClientProof(identity, []byte("wrong-secret"), salt, clientSecret, clientPublic, serverPublic)
+	if err != nil {
+		t.Fatalf("Failed to compute client proof: %v", err)
+	}
+	if _, err := VerifySRPClientProof(identity, salt, verifier, clientPublic, serverSecret, serverPublic, wrongProof); err != ErrSRPProofMismatch {
+		t.Errorf("Expected proof mismatch, got %v", err)
+	}
+
+	// A client public value of zero mod N is rejected
+	if _, err := VerifySRPClientProof(identity, salt, verifier, srpPad(srpN), serverSecret, serverPublic, clientProof); err == nil {
+		t.Error("Expected invalid client public value to be rejected")
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// Test vectors from RFC 6238 appendix B (SHA-1), truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range vectors {
+		if code := TOTPCode(secret, TOTPStep(time.Unix(unix, 0))); code != expected {
+			t.Errorf("TOTP at %d: expected %s, got %s", unix, expected, code)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	step, ok := ValidateTOTP(secret, "005924", now.Add(TOTPPeriod*time.Second))
+	if !ok || step != TOTPStep(now) {
+		t.Error("Code from the previous step should be accepted")
+	}
+
+	if _, ok := ValidateTOTP(secret, "005924", now.Add(5*TOTPPeriod*time.Second)); ok {
+		t.Error("Stale code should be rejected")
+	}
+
+	uri := TOTPURI("Yamony", "alice@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Yamony:alice@example.com?") || !strings.Contains(uri, "secret="+EncodeTOTPSecret(secret)) {
+		t.Errorf("Unexpected otpauth URI: %s", uri)
+	}
+}
+
+// softAuthenticator is a minimal software WebAuthn authenticator with one ES256 credential
+type softAuthenticator struct {
+	rpID         string
+	origin       string
+	credentialID []byte
+	key          *ecdsa.PrivateKey
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T, rpID, origin string) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate authenticator key: %v", err)
+	}
+	credentialID, err := GenerateRandomBytes(16)
+	if err != nil {
+		t.Fatalf("Failed to generate credential id: %v", err)
+	}
+	return &softAuthenticator{rpID: rpID, origin: origin, credentialID: credentialID, key: key}
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+// create answers a navigator.credentials.create() call with "none" attestation
+func (a *softAuthenticator) create(challenge []byte) (clientDataJSON, attestationObject []byte) {
+	point, _ := a.key.PublicKey.Bytes()
+	coseKey := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	coseKey = append(coseKey, point[1:33]...)
+	coseKey = append(coseKey, 0x22, 0x58, 0x20)
+	coseKey = append(coseKey, point[33:]...)
+
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, coseKey...)
+	authData := a.authData(0x45, attested)
+
+	attestationObject = []byte{0xa3, 0x63, 'f', 'm', 't', 0x64, 'n', 'o', 'n', 'e', 0x67, 'a', 't', 't', 'S', 't', 'm', 't', 0xa0, 0x68, 'a', 'u', 't', 'h', 'D', 'a', 't', 'a', 0x59}
+	attestationObject = binary.BigEndian.AppendUint16(attestationObject, uint16(len(authData)))
+	attestationObject = append(attestationObject, authData...)
+
+	return a.clientData("webauthn.create", challenge), attestationObject
+}
+
+// get answers a navigator.credentials.get() call
+func (a *softAuthenticator) get(challenge []byte, flags byte) WebAuthnAssertion {
+	a.signCount++
+	clientDataJSON := a.clientData("webauthn.get", challenge)
+	authData := a.authData(flags, nil)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, _ := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+
+	return WebAuthnAssertion{ClientDataJSON: clientDataJSON, AuthenticatorData: authData, Signature: signature}
+}
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	rp := WebAuthnRelyingParty{ID: "localhost", Origin: "http://localhost:3001"}
+	authenticator := newSoftAuthenticator(t, rp.ID, rp.Origin)
+
+	challenge, err := GenerateWebAuthnChallenge()
+	if err != nil {
+		t.Fatalf("Failed to generate challenge: %v", err)
+	}
+
+	clientDataJSON, attestationObject := authenticator.create(challenge)
+	credential, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject, true)
+	if err != nil {
+		t.Fatalf("Registration failed: %v", err)
+	}
+	if !bytes.Equal(credential.ID, authenticator.credentialID) {
+		t.Error("Registered credential id does not match the authenticator")
+	}
+
+	otherChallenge, _ := GenerateWebAuthnChallenge()
+	if _, err := rp.VerifyRegistration(otherChallenge, clientDataJSON, attestationObject, true); err == nil {
+		t.Error("Registration should fail for a different challenge")
+	}
+
+	phished := WebAuthnRelyingParty{ID: rp.ID, Origin: "https://evil.example"}
+	if _, err := phished.VerifyRegistration(challenge, clientDataJSON, attestationObject, true); err == nil {
+		t.Error("Registration should fail for a different origin")
+	}
+
+	challenge, _ = GenerateWebAuthnChallenge()
+	signCount, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, authenticator.get(challenge, 0x05), true)
+	if err != nil {
+		t.Fatalf("Assertion failed: %v", err)
+	}
+	if signCount != 1 {
+		t.Errorf("Expected sign count 1, got %d", signCount)
+	}
+
+	// Presence without verification is enough for a second factor but not a passwordless login
+	challenge, _ = GenerateWebAuthnChallenge()
+	assertion := authenticator.get(challenge, 0x01)
+	if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, signCount, assertion, true); err != ErrWebAuthnUserNotVerified {
+		t.Errorf("Expected ErrWebAuthnUserNotVerified, got %v", err)
+	}
+	if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, signCount, assertion, false); err != nil {
+		t.Errorf("Assertion without user verification failed: %v", err)
+	}
+
+	// Replaying an old counter points to a cloned authenticator
+	if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, 10, assertion, false); err != ErrWebAuthnSignCount {
+		t.Errorf("Expected ErrWebAuthnSignCount, got %v", err)
+	}
+
+	assertion.Signature[len(assertion.Signature)-1] ^= 0xff
+	if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, signCount, assertion, false); err == nil {
+		t.Error("Assertion should fail with a tampered signature")
+	}
+}
@@ -0,0 +1,201 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// SRP-6a as described in RFC 5054, using the 2048-bit group and SHA-256.
+// The client derives x from its secret and keeps it; the server only ever
+// stores the verifier v = g^x and proves knowledge of it during login.
+
+const (
+	// SRPSecretSize is the size of the random ephemeral secrets a and b in bytes
+	SRPSecretSize = 32
+)
+
+// ErrSRPProofMismatch is returned when a peer's proof does not match the expected value
+var ErrSRPProofMismatch = errors.New("srp proof mismatch")
+
+var (
+	srpN = mustParseSRPGroup("" +
+		"AC6BDB41324A9A9BF166DE5E1389582FAF72B6651987EE07FC3192943DB56050" +
+		"A37329CBB4A099ED8193E0757767A13DD52312AB4B03310DCD7F48A9DA04FD50" +
+		"E8083969EDB767B0CF6095179A163AB3661A05FBD5FAAAE82918A9962F0B93B8" +
+		"55F97993EC975EEAA80D740ADBF4FF747359D041D5C33EA71D281E446B14773B" +
+		"CA97B43A23FB801676BD207A436C6481F1D2B9078717461A5B9D32E688F87748" +
+		"544523B524B0D57D5EA77A2775D2ECFA032CFBDBF52FB3786160279004E57AE6" +
+		"AF874E7303CE53299CCC041C7BC308D82A5698F3A8D0C38271AE35F8E9DBFBB6" +
+		"94B5C803D89F7AE435DE236D525F54759B65E372FCD68EF20FA7111F9E4AFF73")
+	srpG = big.NewInt(2)
+	srpK = new(big.Int).SetBytes(srpHash(srpPad(srpN), srpPad(srpG)))
+)
+
+func mustParseSRPGroup(hex string) *big.Int {
+	n, ok := new(big.Int).SetString(hex, 16)
+	if !ok {
+		panic("crypto: invalid SRP group prime")
+	}
+	return n
+}
+
+// srpPad left-pads n with zeros to the length of the group prime
+func srpPad(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, (srpN.BitLen()+7)/8))
+}
+
+func srpHash(parts ...[]byte) []byte {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// ComputeSRPVerifier derives the verifier v = g^x that the server stores at registration.
+// It runs on the client; secret should be derived from the master key rather than being
+// the raw master password.
+func ComputeSRPVerifier(identity string, secret, salt []byte) []byte {
+	x := srpPrivateKey(identity, secret, salt)
+	return srpPad(new(big.Int).Exp(srpG, x, srpN))
+}
+
+// srpPrivateKey computes x = H(s | H(I | ":" | P))
+func srpPrivateKey(identity string, secret, salt []byte) *big.Int {
+	inner := srpHash([]byte(identity), []byte(":"), secret)
+	return new(big.Int).SetBytes(srpHash(salt, inner))
+}
+
+// ValidateSRPVerifier checks that a client-supplied verifier is a usable group element
+func ValidateSRPVerifier(verifier []byte) error {
+	v := new(big.Int).SetBytes(verifier)
+	if v.Cmp(big.NewInt(1)) <= 0 || v.Cmp(srpN) >= 0 {
+		return fmt.Errorf("srp verifier is out of range")
+	}
+	return nil
+}
+
+// ValidateSRPPublic rejects ephemeral public values that are zero modulo N,
+// which would let the peer force a known session key
+func ValidateSRPPublic(public []byte) error {
+	p := new(big.Int).SetBytes(public)
+	if p.Sign() == 0 || new(big.Int).Mod(p, srpN).Sign() == 0 {
+		return fmt.Errorf("srp public value is invalid")
+	}
+	return nil
+}
+
+// GenerateSRPClientEphemeral creates the client's secret a and public value A = g^a
+func GenerateSRPClientEphemeral() (secret, public []byte, err error) {
+	secret, err = GenerateRandomBytes(SRPSecretSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	a := new(big.Int).SetBytes(secret)
+	return secret, srpPad(new(big.Int).Exp(srpG, a, srpN)), nil
+}
+
+// GenerateSRPServerEphemeral creates the server's secret b and public value B = kv + g^b
+func GenerateSRPServerEphemeral(verifier []byte) (secret, public []byte, err error) {
+	if err := ValidateSRPVerifier(verifier); err != nil {
+		return nil, nil, err
+	}
+
+	secret, err = GenerateRandomBytes(SRPSecretSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b := new(big.Int).SetBytes(secret)
+	v := new(big.Int).SetBytes(verifier)
+	B := new(big.Int).Mul(srpK, v)
+	B.Add(B, new(big.Int).Exp(srpG, b, srpN))
+	B.Mod(B, srpN)
+
+	return secret, srpPad(B), nil
+}
+
+// srpScrambler computes u = H(PAD(A) | PAD(B))
+func srpScrambler(clientPublic, serverPublic *big.Int) (*big.Int, error) {
+	u := new(big.Int).SetBytes(srpHash(srpPad(clientPublic), srpPad(serverPublic)))
+	if u.Sign() == 0 {
+		return nil, fmt.Errorf("srp scrambling parameter is zero")
+	}
+	return u, nil
+}
+
+// srpClientProof computes M1 = H(H(N) xor H(g) | H(I) | s | A | B | K)
+func srpClientProof(identity string, salt []byte, clientPublic, serverPublic *big.Int, key []byte) []byte {
+	hN := srpHash(srpPad(srpN))
+	hG := srpHash(srpPad(srpG))
+	for i := range hN {
+		hN[i] ^= hG[i]
+	}
+	return srpHash(hN, srpHash([]byte(identity)), salt, srpPad(clientPublic), srpPad(serverPublic), key)
+}
+
+// srpServerProof computes M2 = H(A | M1 | K)
+func srpServerProof(clientPublic *big.Int, clientProof, key []byte) []byte {
+	return srpHash(srpPad(clientPublic), clientProof, key)
+}
+
+// ComputeSRPClientProof runs the client side of the exchange. It returns the proof M1
+// to send to the server and the proof M2 the server is expected to answer with.
+func ComputeSRPClientProof(identity string, secret, salt, clientSecret, clientPublic, serverPublic []byte) (clientProof, serverProof []byte, err error) {
+	if err := ValidateSRPPublic(serverPublic); err != nil {
+		return nil, nil, err
+	}
+
+	A := new(big.Int).SetBytes(clientPublic)
+	B := new(big.Int).SetBytes(serverPublic)
+	u, err := srpScrambler(A, B)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// S = (B - kg^x) ^ (a + ux)
+	x := srpPrivateKey(identity, secret, salt)
+	base := new(big.Int).Exp(srpG, x, srpN)
+	base.Mul(base, srpK)
+	base.Sub(B, base)
+	base.Mod(base, srpN)
+
+	exp := new(big.Int).Mul(u, x)
+	exp.Add(exp, new(big.Int).SetBytes(clientSecret))
+
+	key := srpHash(srpPad(new(big.Int).Exp(base, exp, srpN)))
+	clientProof = srpClientProof(identity, salt, A, B, key)
+	return clientProof, srpServerProof(A, clientProof, key), nil
+}
+
+// VerifySRPClientProof runs the server side of the exchange. It checks the client's
+// proof M1 against the stored verifier and returns the server proof M2.
+func VerifySRPClientProof(identity string, salt, verifier, clientPublic, serverSecret, serverPublic, clientProof []byte) ([]byte, error) {
+	if err := ValidateSRPPublic(clientPublic); err != nil {
+		return nil, err
+	}
+
+	A := new(big.Int).SetBytes(clientPublic)
+	B := new(big.Int).SetBytes(serverPublic)
+	u, err := srpScrambler(A, B)
+	if err != nil {
+		return nil, err
+	}
+
+	// S = (Av^u) ^ b
+	v := new(big.Int).SetBytes(verifier)
+	base := new(big.Int).Exp(v, u, srpN)
+	base.Mul(base, A)
+	base.Mod(base, srpN)
+
+	key := srpHash(srpPad(new(big.Int).Exp(base, new(big.Int).SetBytes(serverSecret), srpN)))
+	expected := srpClientProof(identity, salt, A, B, key)
+	if subtle.ConstantTimeCompare(expected, clientProof) != 1 {
+		return nil, ErrSRPProofMismatch
+	}
+
+	return srpServerProof(A, clientProof, key), nil
+}
@@ -0,0 +1,308 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Server side of the WebAuthn Level 2 registration and authentication
+// ceremonies. Attestation statements are not verified: the relying party asks
+// for "none" conveyance, so the authenticator's make and model are not trusted.
+
+const (
+	// WebAuthnChallengeSize is the size of generated ceremony challenges in bytes
+	WebAuthnChallengeSize = 32
+
+	webauthnFlagUserPresent  = 0x01
+	webauthnFlagUserVerified = 0x04
+	webauthnFlagAttested     = 0x40
+
+	// authenticator data is rpIdHash (32) | flags (1) | signCount (4)
+	webauthnAuthDataMinLength = 37
+)
+
+// COSE algorithm identifiers accepted for credential keys
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+	COSEAlgRS256 = -257
+)
+
+var (
+	// ErrWebAuthnSignCount is returned when a credential's signature counter did
+	// not increase, which suggests the authenticator has been cloned
+	ErrWebAuthnSignCount = errors.New("webauthn signature counter did not increase")
+	// ErrWebAuthnUserNotVerified is returned when the ceremony required user verification
+	ErrWebAuthnUserNotVerified = errors.New("webauthn user verification required")
+)
+
+// WebAuthnRelyingParty identifies the site credentials are scoped to
+type WebAuthnRelyingParty struct {
+	ID     string
+	Origin string
+}
+
+// WebAuthnCredential is a newly registered public key credential
+type WebAuthnCredential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+	AAGUID    []byte
+}
+
+// WebAuthnAssertion is an authenticator's response to a get() ceremony.
+// UserHandle is the user.id stored with a discoverable credential, if any
+type WebAuthnAssertion struct {
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+	UserHandle        []byte
+}
+
+type webauthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type webauthnAuthData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	rest      []byte
+}
+
+// GenerateWebAuthnChallenge creates a random challenge for a ceremony
+func GenerateWebAuthnChallenge() ([]byte, error) {
+	return GenerateRandomBytes(WebAuthnChallengeSize)
+}
+
+// VerifyRegistration checks an authenticator's response to a create() ceremony
+// and returns the credential to store
+func (rp WebAuthnRelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte, requireUserVerification bool) (*WebAuthnCredential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	decoded, rest, err := cborDecode(attestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation object: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("invalid attestation object: trailing data")
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid attestation object")
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("attestation object has no authenticator data")
+	}
+
+	authData, err := rp.verifyAuthData(rawAuthData, requireUserVerification)
+	if err != nil {
+		return nil, err
+	}
+	if authData.flags&webauthnFlagAttested == 0 {
+		return nil, fmt.Errorf("authenticator data has no attested credential")
+	}
+
+	// attested credential data is aaguid (16) | idLength (2) | id | COSE_Key
+	data := authData.rest
+	if len(data) < 18 {
+		return nil, fmt.Errorf("attested credential data is truncated")
+	}
+	aaguid := data[:16]
+	idLength := int(binary.BigEndian.Uint16(data[16:18]))
+	data = data[18:]
+	if idLength == 0 || idLength > 1023 || len(data) < idLength {
+		return nil, fmt.Errorf("invalid credential id length")
+	}
+	credentialID := data[:idLength]
+	data = data[idLength:]
+
+	if _, rest, err = cborDecode(data); err != nil {
+		return nil, fmt.Errorf("invalid credential public key: %w", err)
+	}
+	publicKey := data[:len(data)-len(rest)]
+	if _, err := parseCOSEKey(publicKey); err != nil {
+		return nil, err
+	}
+
+	return &WebAuthnCredential{
+		ID:        append([]byte(nil), credentialID...),
+		PublicKey: append([]byte(nil), publicKey...),
+		SignCount: authData.signCount,
+		AAGUID:    append([]byte(nil), aaguid...),
+	}, nil
+}
+
+// VerifyAssertion checks an authenticator's response to a get() ceremony
+// against a stored credential and returns the new signature counter
+func (rp WebAuthnRelyingParty) VerifyAssertion(challenge, publicKey []byte, storedSignCount uint32, assertion WebAuthnAssertion, requireUserVerification bool) (uint32, error) {
+	if err := rp.verifyClientData(assertion.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := rp.verifyAuthData(assertion.AuthenticatorData, requireUserVerification)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(assertion.ClientDataJSON)
+	signed := append(append([]byte(nil), assertion.AuthenticatorData...), clientDataHash[:]...)
+	if !key.verify(signed, assertion.Signature) {
+		return 0, fmt.Errorf("invalid webauthn signature")
+	}
+
+	// Authenticators that do not keep a counter always report zero
+	if (authData.signCount != 0 || storedSignCount != 0) && authData.signCount <= storedSignCount {
+		return 0, ErrWebAuthnSignCount
+	}
+
+	return authData.signCount, nil
+}
+
+func (rp WebAuthnRelyingParty) verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	var clientData webauthnClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return fmt.Errorf("invalid client data: %w", err)
+	}
+
+	if clientData.Type != ceremony {
+		return fmt.Errorf("client data type must be %s", ceremony)
+	}
+
+	received, err := base64.RawURLEncoding.DecodeString(clientData.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return fmt.Errorf("client data challenge mismatch")
+	}
+
+	if clientData.Origin != rp.Origin {
+		return fmt.Errorf("client data origin mismatch")
+	}
+
+	return nil
+}
+
+func (rp WebAuthnRelyingParty) verifyAuthData(raw []byte, requireUserVerification bool) (*webauthnAuthData, error) {
+	if len(raw) < webauthnAuthDataMinLength {
+		return nil, fmt.Errorf("authenticator data is truncated")
+	}
+
+	authData := &webauthnAuthData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+		rest:      raw[37:],
+	}
+
+	expected := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(authData.rpIDHash, expected[:]) {
+		return nil, fmt.Errorf("authenticator data rp id mismatch")
+	}
+
+	if authData.flags&webauthnFlagUserPresent == 0 {
+		return nil, fmt.Errorf("webauthn user presence required")
+	}
+
+	if requireUserVerification && authData.flags&webauthnFlagUserVerified == 0 {
+		return nil, ErrWebAuthnUserNotVerified
+	}
+
+	return authData, nil
+}
+
+// coseKey is a credential public key with the algorithm it signs with
+type coseKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+func (k *coseKey) verify(message, signature []byte) bool {
+	switch k.alg {
+	case COSEAlgES256:
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(k.key.(*ecdsa.PublicKey), digest[:], signature)
+	case COSEAlgEdDSA:
+		return ed25519.Verify(k.key.(ed25519.PublicKey), message, signature)
+	case COSEAlgRS256:
+		digest := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(k.key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+// parseCOSEKey decodes a COSE_Key (RFC 9052) using one of the supported algorithms
+func parseCOSEKey(raw []byte) (*coseKey, error) {
+	decoded, rest, err := cborDecode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential public key: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("invalid credential public key: trailing data")
+	}
+
+	fields, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid credential public key")
+	}
+
+	kty, _ := fields[int64(1)].(int64)
+	alg, _ := fields[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == COSEAlgES256:
+		crv, _ := fields[int64(-1)].(int64)
+		x, _ := fields[int64(-2)].([]byte)
+		y, _ := fields[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("es256 key must be a P-256 point")
+		}
+		point := append(append([]byte{0x04}, x...), y...)
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, fmt.Errorf("invalid es256 key: %w", err)
+		}
+		return &coseKey{alg: alg, key: key}, nil
+
+	case kty == 1 && alg == COSEAlgEdDSA:
+		crv, _ := fields[int64(-1)].(int64)
+		x, _ := fields[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("eddsa key must be an Ed25519 key")
+		}
+		return &coseKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+
+	case kty == 3 && alg == COSEAlgRS256:
+		n, _ := fields[int64(-1)].([]byte)
+		e, _ := fields[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("rs256 key must be at least 2048 bits")
+		}
+		exponent := new(big.Int).SetBytes(e)
+		return &coseKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported credential key type %d with algorithm %d", kty, alg)
+	}
+}
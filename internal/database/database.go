@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/joho/godotenv/autoload"
 
@@ -18,6 +19,9 @@ type Service interface {
 	Health() map[string]string
 	Close() error
 	GetQueries() *sqlc.Queries
+	BeginTx(ctx context.Context) (pgx.Tx, error)
+	Notify(ctx context.Context, channel, payload string) error
+	Listen(ctx context.Context, channel string, handle func(payload string)) error
 }
 
 type service struct {
@@ -92,3 +96,38 @@ func (s *service) Close() error {
 func (s *service) GetQueries() *sqlc.Queries {
 	return s.queries
 }
+
+// BeginTx starts a transaction on the pool; use GetQueries().WithTx(tx) to run queries in it
+func (s *service) BeginTx(ctx context.Context) (pgx.Tx, error) {
+	return s.pool.Begin(ctx)
+}
+
+// Notify sends a NOTIFY on channel to every listening server instance
+func (s *service) Notify(ctx context.Context, channel, payload string) error {
+	_, err := s.pool.Exec(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	return err
+}
+
+// Listen runs LISTEN on a dedicated connection and calls handle for each
+// notification until ctx is cancelled or the connection fails
+func (s *service) Listen(ctx context.Context, channel string, handle func(payload string)) error {
+	poolConn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// Take the connection out of the pool so the LISTEN never leaks to other queries
+	conn := poolConn.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		handle(notification.Payload)
+	}
+}
@@ -0,0 +1,3 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (user_id, device_id, event_type, data)
+VALUES ($1, $2, $3, $4);
@@ -0,0 +1,17 @@
+-- name: CreateDeviceChallenge :one
+INSERT INTO device_challenges (device_id, challenge, expires_at)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: GetDeviceChallenge :one
+SELECT * FROM device_challenges
+WHERE device_id = $1
+LIMIT 1;
+
+-- name: DeleteDeviceChallenge :exec
+DELETE FROM device_challenges
+WHERE device_id = $1;
+
+-- name: DeleteExpiredDeviceChallenges :execrows
+DELETE FROM device_challenges
+WHERE expires_at < NOW();
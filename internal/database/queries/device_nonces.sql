@@ -0,0 +1,9 @@
+-- name: ClaimDeviceNonce :execrows
+-- Returns 0 rows affected when the device already used this nonce
+INSERT INTO device_nonces (device_id, nonce)
+VALUES ($1, $2)
+ON CONFLICT (device_id, nonce) DO NOTHING;
+
+-- name: DeleteDeviceNoncesBefore :execrows
+DELETE FROM device_nonces
+WHERE created_at < $1;
@@ -5,10 +5,11 @@ INSERT INTO devices (
     device_label,
     x25519_public,
     ed25519_public,
+    status,
     created_at,
     last_seen
 ) VALUES (
-    $1, $2, $3, $4, $5, NOW(), NOW()
+    $1, $2, $3, $4, $5, 'pending', NOW(), NOW()
 )
 RETURNING *;
 
@@ -17,9 +18,14 @@ SELECT * FROM devices
 WHERE id = $1 AND revoked_at IS NULL
 LIMIT 1;
 
+-- name: GetDeviceByIDIncludingRevoked :one
+SELECT * FROM devices
+WHERE id = $1
+LIMIT 1;
+
 -- name: GetDevicesByUserID :many
 SELECT * FROM devices
-WHERE user_id = $1 AND revoked_at IS NULL
+WHERE user_id = $1 AND revoked_at IS NULL AND status = 'active'
 ORDER BY created_at DESC;
 
 -- name: GetAllDevicesByUserID :many
@@ -32,6 +38,21 @@ UPDATE devices
 SET last_seen = NOW()
 WHERE id = $1;
 
+-- name: MarkDeviceVerified :execrows
+UPDATE devices
+SET status = $2, verified_at = NOW()
+WHERE id = $1 AND status = 'pending' AND revoked_at IS NULL;
+
+-- name: ApproveDevice :execrows
+UPDATE devices
+SET status = 'active', approved_by_device = $2, approved_at = NOW(), approval_method = $3
+WHERE id = $1 AND status = 'awaiting_approval' AND revoked_at IS NULL;
+
+-- name: CountTrustedDevicesByUserID :one
+-- Includes revoked devices, so losing every device does not reopen first-device bootstrap
+SELECT COUNT(*) FROM devices
+WHERE user_id = $1 AND status = 'active';
+
 -- name: RevokeDevice :exec
 UPDATE devices
 SET revoked_at = NOW()
@@ -40,11 +61,11 @@ WHERE id = $1;
 -- name: GetDevicePublicKeys :one
 SELECT x25519_public, ed25519_public
 FROM devices
-WHERE id = $1 AND revoked_at IS NULL
+WHERE id = $1 AND revoked_at IS NULL AND status = 'active'
 LIMIT 1;
 
 -- name: GetUserDevicePublicKeys :many
 SELECT id, device_label, x25519_public, ed25519_public, created_at
 FROM devices
-WHERE user_id = $1 AND revoked_at IS NULL
+WHERE user_id = $1 AND revoked_at IS NULL AND status = 'active'
 ORDER BY created_at DESC;
@@ -0,0 +1,18 @@
+-- name: CreateEmailVerification :exec
+INSERT INTO email_verifications (token_hash, user_id, email, expires_at)
+VALUES ($1, $2, $3, $4);
+
+-- name: ClaimEmailVerification :one
+-- Deletes the token so each link verifies at most once
+DELETE FROM email_verifications
+WHERE token_hash = $1 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteUserEmailVerifications :exec
+-- A new link replaces every link sent before it
+DELETE FROM email_verifications
+WHERE user_id = $1;
+
+-- name: DeleteExpiredEmailVerifications :execrows
+DELETE FROM email_verifications
+WHERE expires_at < NOW();
@@ -0,0 +1,16 @@
+-- name: CreateRecoveryCode :execrows
+-- Returns 0 rows affected when the user already has a recovery code
+INSERT INTO recovery_codes (user_id, code_hash)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO NOTHING;
+
+-- name: GetRecoveryCodeByUserID :one
+SELECT * FROM recovery_codes
+WHERE user_id = $1
+LIMIT 1;
+
+-- name: UpsertRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET code_hash = EXCLUDED.code_hash, created_at = NOW();
@@ -1,6 +1,6 @@
 -- name: CreateSession :one
-INSERT INTO sessions (user_id, session_token, expires_at)
-VALUES ($1, $2, $3)
+INSERT INTO sessions (user_id, session_token, expires_at, user_agent, ip_address)
+VALUES ($1, $2, $3, $4, $5)
 RETURNING *;
 
 -- name: GetSessionByToken :one
@@ -8,25 +8,59 @@ SELECT * FROM sessions
 WHERE session_token = $1;
 
 -- name: GetSessionsByUserID :many
-SELECT * FROM sessions
-WHERE user_id = $1
+-- Leaves the tokens out and only reports which row is the caller's session
+SELECT id, device_id, user_agent, ip_address, created_at, last_active_at, expires_at,
+    (session_token = sqlc.arg(current_token)::text) AS current
+FROM sessions
+WHERE user_id = sqlc.arg(user_id)
 ORDER BY created_at DESC;
 
 -- name: DeleteSession :exec
 DELETE FROM sessions
 WHERE id = $1;
 
--- name: DeleteExpiredSessions :exec
+-- name: DeleteUserSession :execrows
+DELETE FROM sessions
+WHERE id = $1 AND user_id = $2;
+
+-- name: DeleteOtherUserSessions :execrows
+DELETE FROM sessions
+WHERE user_id = $1 AND id <> $2;
+
+-- name: DeleteExpiredSessions :execrows
 DELETE FROM sessions
 WHERE expires_at < NOW();
 
+-- name: DeleteSessionsForRevokedDevice :execrows
+-- Ends the device's sessions and every session of its user not bound to a device,
+-- since an unbound session cannot be traced and may have been opened on it
+DELETE FROM sessions
+WHERE user_id = $1 AND (device_id = $2 OR device_id IS NULL);
+
 -- name: DeleteUserSessions :exec
 DELETE FROM sessions
 WHERE user_id = $1;
 
 
+-- name: BindSessionToDevice :execrows
+-- Returns 0 rows affected when the session is already bound to another device
+UPDATE sessions
+SET device_id = $2, updated_at = NOW()
+WHERE session_token = $1 AND (device_id IS NULL OR device_id = $2);
+
 -- name: UpdateSessionWithActivePage :one
 UPDATE sessions
 SET active_page_id = $2, updated_at = NOW()
 WHERE id = $1
 RETURNING *;
+
+-- name: TouchSession :exec
+-- Slides the expiry forward; callers cap it at the session's absolute max age
+UPDATE sessions
+SET last_active_at = NOW(), expires_at = $2, ip_address = $3
+WHERE id = $1;
+
+-- name: RotateSessionToken :execrows
+UPDATE sessions
+SET session_token = sqlc.arg(new_token), updated_at = NOW()
+WHERE session_token = sqlc.arg(old_token);
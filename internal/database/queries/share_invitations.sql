@@ -0,0 +1,43 @@
+-- name: CreateShareInvitation :one
+INSERT INTO share_invitations (vault_id, sender_user_id, email, role, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetShareInvitationByID :one
+SELECT * FROM share_invitations
+WHERE id = $1
+LIMIT 1;
+
+-- name: GetOpenShareInvitation :one
+-- Finds the unexpired pending or ready invitation of an email to a vault
+SELECT * FROM share_invitations
+WHERE vault_id = $1 AND email = $2 AND status IN ('pending', 'ready') AND expires_at > NOW()
+LIMIT 1;
+
+-- name: GetShareInvitationsByVaultID :many
+SELECT * FROM share_invitations
+WHERE vault_id = $1
+ORDER BY created_at DESC;
+
+-- name: MarkShareInvitationsReady :many
+-- Binds the user's unexpired pending invitations to them once they have an active
+-- device. Only a verified email proves the user owns the address invited
+UPDATE share_invitations si
+SET status = 'ready', recipient_user_id = u.id
+FROM users u
+WHERE u.id = $1 AND u.email_verified AND si.email = lower(u.email) AND si.status = 'pending' AND si.expires_at > NOW()
+RETURNING si.*;
+
+-- name: CompleteShareInvitation :execrows
+UPDATE share_invitations
+SET status = 'completed', sharing_record_id = $2, completed_at = NOW()
+WHERE id = $1 AND status = 'ready' AND expires_at > NOW();
+
+-- name: CancelShareInvitation :execrows
+UPDATE share_invitations
+SET status = 'cancelled', completed_at = NOW()
+WHERE id = $1 AND status IN ('pending', 'ready');
+
+-- name: DeleteExpiredShareInvitations :execrows
+DELETE FROM share_invitations
+WHERE status IN ('pending', 'ready') AND expires_at < NOW();
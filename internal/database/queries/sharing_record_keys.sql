@@ -0,0 +1,50 @@
+-- name: UpsertSharingRecordKey :exec
+INSERT INTO sharing_record_keys (sharing_record_id, recipient_device_id, sender_device_id, wrapped_key, wrap_iv, wrap_tag)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (sharing_record_id, recipient_device_id) DO UPDATE
+SET sender_device_id = EXCLUDED.sender_device_id,
+    wrapped_key = EXCLUDED.wrapped_key,
+    wrap_iv = EXCLUDED.wrap_iv,
+    wrap_tag = EXCLUDED.wrap_tag,
+    created_at = NOW();
+
+-- name: GetSharingRecordKey :one
+SELECT * FROM sharing_record_keys
+WHERE sharing_record_id = $1 AND recipient_device_id = $2
+LIMIT 1;
+
+-- name: CountSharingRecordKeys :one
+-- Shares made before keys were kept per device have none
+SELECT COUNT(*) FROM sharing_record_keys
+WHERE sharing_record_id = $1;
+
+-- name: GetMissingSharingRecordKeys :many
+-- Lists the active recipient devices that have no key for a live share sent by the user
+SELECT
+    sr.id AS sharing_record_id,
+    sr.vault_id,
+    sr.item_id,
+    sr.recipient_user_id,
+    d.id AS device_id,
+    d.device_label,
+    d.x25519_public
+FROM sharing_records sr
+JOIN devices d ON d.user_id = sr.recipient_user_id AND d.status = 'active' AND d.revoked_at IS NULL
+LEFT JOIN sharing_record_keys k ON k.sharing_record_id = sr.id AND k.recipient_device_id = d.id
+WHERE sr.sender_user_id = $1 AND sr.status IN ('pending', 'accepted') AND k.sharing_record_id IS NULL
+  AND (sr.expires_at IS NULL OR sr.expires_at > NOW())
+ORDER BY sr.created_at, d.created_at;
+
+-- name: DeleteSharingRecordKeysByDeviceID :exec
+DELETE FROM sharing_record_keys
+WHERE recipient_device_id = $1;
+
+-- name: DeleteSharingRecordKeysBySharingRecordID :exec
+DELETE FROM sharing_record_keys
+WHERE sharing_record_id = $1;
+
+-- name: DeleteSharingRecordKeysByVaultID :exec
+-- Drops the device keys of the vault's shares, item shares included, once a rotation re-encrypts what they open
+DELETE FROM sharing_record_keys k
+USING sharing_records sr
+WHERE k.sharing_record_id = sr.id AND sr.vault_id = $1;
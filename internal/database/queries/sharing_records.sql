@@ -7,72 +7,124 @@ INSERT INTO sharing_records (
     wrapped_key,
     wrap_iv,
     wrap_tag,
-    status
+    status,
+    role,
+    expires_at
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
 )
-RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at;
+RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at;
 
 -- name: GetSharingRecordByID :one
-SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at
+SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
 FROM sharing_records
 WHERE id = $1;
 
 -- name: GetSharingRecordsByVaultID :many
-SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at
+SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
 FROM sharing_records
-WHERE vault_id = $1 AND status = 'accepted'
+WHERE vault_id = $1 AND status = 'accepted' AND item_id IS NULL
+  AND (expires_at IS NULL OR expires_at > NOW())
 ORDER BY created_at DESC;
 
 -- name: GetSharingRecordsByRecipientID :many
-SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at
+SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
 FROM sharing_records
 WHERE recipient_user_id = $1
 ORDER BY created_at DESC;
 
 -- name: GetPendingSharingRecordsByRecipientID :many
-SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at
+SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
 FROM sharing_records
-WHERE recipient_user_id = $1 AND status = 'pending'
+WHERE recipient_user_id = $1 AND status = 'pending' AND (expires_at IS NULL OR expires_at > NOW())
 ORDER BY created_at DESC;
 
 -- name: GetSharingRecordByVaultAndRecipient :one
-SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at
+SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
 FROM sharing_records
-WHERE vault_id = $1 AND recipient_user_id = $2 AND status = 'accepted';
+WHERE vault_id = $1 AND recipient_user_id = $2 AND status = 'accepted' AND item_id IS NULL
+  AND (expires_at IS NULL OR expires_at > NOW());
 
 -- name: AcceptSharingRecord :one
 UPDATE sharing_records
 SET status = 'accepted', accepted_at = NOW()
-WHERE id = $1 AND status = 'pending'
-RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at;
+WHERE id = $1 AND status = 'pending' AND (expires_at IS NULL OR expires_at > NOW())
+RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at;
 
 -- name: RejectSharingRecord :one
 UPDATE sharing_records
 SET status = 'rejected'
 WHERE id = $1 AND status = 'pending'
-RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at;
+RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at;
 
--- name: RevokeSharingRecord :exec
+-- name: RevokeSharingRecord :execrows
+-- Only live shares can be revoked; others report no rows affected
 UPDATE sharing_records
 SET status = 'revoked'
-WHERE id = $1;
+WHERE id = $1 AND status IN ('pending', 'accepted');
+
+-- name: UpdateSharingRecordRole :one
+UPDATE sharing_records
+SET role = $2
+WHERE id = $1 AND item_id IS NULL AND status IN ('pending', 'accepted')
+RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at;
+
+-- name: UpdateSharingRecordExpiry :one
+-- A share that has already lapsed stays lapsed, even before ExpireSharingRecords marks it
+UPDATE sharing_records
+SET expires_at = $2
+WHERE id = $1 AND status IN ('pending', 'accepted')
+  AND (expires_at IS NULL OR expires_at > NOW())
+RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at;
+
+-- name: ExpireSharingRecords :many
+-- Marks lapsed shares expired; access checks already ignore them by time
+UPDATE sharing_records
+SET status = 'expired'
+WHERE status IN ('pending', 'accepted') AND expires_at <= NOW()
+RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at;
 
 -- name: GetSharedVaultsForUser :many
 SELECT DISTINCT v.id, v.user_id, v.name, v.created_at, v.updated_at
 FROM vaults v
 INNER JOIN sharing_records sr ON v.id = sr.vault_id
-WHERE sr.recipient_user_id = $1 AND sr.status = 'accepted'
+WHERE sr.recipient_user_id = $1 AND sr.status = 'accepted' AND sr.item_id IS NULL
+  AND (sr.expires_at IS NULL OR sr.expires_at > NOW())
 ORDER BY v.created_at DESC;
 
+-- name: GetSharedItemsForUser :many
+-- Item shares grant only the item, so these rows never imply vault access
+SELECT sr.id, sr.vault_id, sr.item_id, sr.sender_user_id, sr.status, sr.created_at, sr.accepted_at, sr.expires_at,
+       vi.item_type, vi.meta, vi.version, vi.updated_at
+FROM sharing_records sr
+INNER JOIN vault_items vi ON vi.id = sr.item_id
+WHERE sr.recipient_user_id = $1 AND sr.status IN ('pending', 'accepted')
+  AND (sr.expires_at IS NULL OR sr.expires_at > NOW())
+ORDER BY sr.created_at DESC;
+
 -- name: CheckUserVaultAccess :one
-SELECT 
-    CASE 
+-- Returns 'owner', the role of the user's accepted vault share, or NULL
+SELECT
+    CASE
         WHEN v.user_id = $2 THEN 'owner'::TEXT
-        WHEN sr.recipient_user_id = $2 AND sr.status = 'accepted' THEN 'shared'::TEXT
-        ELSE NULL
-    END as access_level
+        ELSE (
+            SELECT sr.role::TEXT FROM sharing_records sr
+            WHERE sr.vault_id = v.id AND sr.recipient_user_id = $2
+              AND sr.status = 'accepted' AND sr.item_id IS NULL
+              AND (sr.expires_at IS NULL OR sr.expires_at > NOW())
+            ORDER BY CASE sr.role WHEN 'manager' THEN 3 WHEN 'editor' THEN 2 ELSE 1 END DESC
+            LIMIT 1
+        )
+    END AS access_level
 FROM vaults v
-LEFT JOIN sharing_records sr ON v.id = sr.vault_id AND sr.recipient_user_id = $2
-WHERE v.id = $1
-LIMIT 1;
+WHERE v.id = $1;
+
+-- name: UserHasActiveShares :one
+-- Whether the user owns, sent or received any live vault or item share
+SELECT EXISTS (
+    SELECT 1 FROM sharing_records
+    WHERE (sender_user_id = $1 OR recipient_user_id = $1
+           OR vault_id IN (SELECT id FROM vaults WHERE user_id = $1))
+      AND status IN ('pending', 'accepted')
+      AND (expires_at IS NULL OR expires_at > NOW())
+) AS has_shares;
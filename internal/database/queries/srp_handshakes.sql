@@ -0,0 +1,14 @@
+-- name: CreateSrpHandshake :one
+INSERT INTO srp_handshakes (user_id, client_public, server_secret, server_public, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: ClaimSrpHandshake :one
+-- Deletes the handshake so each server ephemeral answers at most one proof
+DELETE FROM srp_handshakes
+WHERE id = $1 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredSrpHandshakes :execrows
+DELETE FROM srp_handshakes
+WHERE expires_at < NOW();
@@ -0,0 +1,84 @@
+-- name: UpsertTotpCredential :one
+-- Replaces an unconfirmed secret; returns no rows when 2FA is already confirmed
+INSERT INTO totp_credentials (user_id, secret)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+WHERE totp_credentials.confirmed_at IS NULL
+RETURNING *;
+
+-- name: GetTotpCredential :one
+SELECT * FROM totp_credentials
+WHERE user_id = $1
+LIMIT 1;
+
+-- name: ConfirmTotpCredential :execrows
+UPDATE totp_credentials
+SET confirmed_at = NOW(), last_used_step = $2
+WHERE user_id = $1 AND confirmed_at IS NULL;
+
+-- name: ClaimTotpStep :execrows
+-- Returns 0 rows affected when this or a later time step was already used
+UPDATE totp_credentials
+SET last_used_step = $2
+WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2;
+
+-- name: DeleteTotpCredential :exec
+DELETE FROM totp_credentials
+WHERE user_id = $1;
+
+-- name: CreateMfaRecoveryCode :exec
+INSERT INTO mfa_recovery_codes (user_id, code_hash)
+VALUES ($1, $2);
+
+-- name: UseMfaRecoveryCode :execrows
+UPDATE mfa_recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: CountUnusedMfaRecoveryCodes :one
+SELECT COUNT(*) FROM mfa_recovery_codes
+WHERE user_id = $1 AND used_at IS NULL;
+
+-- name: DeleteMfaRecoveryCodes :exec
+DELETE FROM mfa_recovery_codes
+WHERE user_id = $1;
+
+-- name: CreateMfaChallenge :one
+INSERT INTO mfa_challenges (token_hash, user_id, expires_at)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: GetMfaChallenge :one
+SELECT * FROM mfa_challenges
+WHERE token_hash = $1 AND expires_at > NOW()
+LIMIT 1;
+
+-- name: RecordMfaChallengeAttempt :one
+-- Counts a verification attempt against an unexpired challenge
+UPDATE mfa_challenges
+SET attempts = attempts + 1
+WHERE token_hash = $1 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteMfaChallenge :exec
+DELETE FROM mfa_challenges
+WHERE token_hash = $1;
+
+-- name: DeleteExpiredMfaChallenges :execrows
+DELETE FROM mfa_challenges
+WHERE expires_at < NOW();
+
+-- name: RecordMfaUserAttempt :one
+-- Counts a second-factor attempt for the user across all pending logins. The
+-- count starts over once its window began before window_start
+INSERT INTO mfa_user_attempts (user_id, attempts, window_started_at)
+VALUES (sqlc.arg(user_id), 1, NOW())
+ON CONFLICT (user_id) DO UPDATE
+SET attempts = CASE WHEN mfa_user_attempts.window_started_at < sqlc.arg(window_start) THEN 1 ELSE mfa_user_attempts.attempts + 1 END,
+    window_started_at = CASE WHEN mfa_user_attempts.window_started_at < sqlc.arg(window_start) THEN NOW() ELSE mfa_user_attempts.window_started_at END
+RETURNING attempts;
+
+-- name: ResetMfaUserAttempts :exec
+DELETE FROM mfa_user_attempts
+WHERE user_id = $1;
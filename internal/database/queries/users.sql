@@ -1,6 +1,6 @@
 -- name: CreateUser :one
-INSERT INTO users (username, email, password_hash, email_verified, image)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO users (username, email, password_hash, email_verified, image, srp_salt, srp_verifier, kdf_salt, kdf_params)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 RETURNING id, username, email, email_verified, image, created_at, updated_at;
 
 -- name: GetUserByEmail :one
@@ -13,7 +13,29 @@ SELECT id, username, email, email_verified, image, created_at, updated_at
 FROM users
 WHERE id = $1;
 
+-- name: GetUserSrpCredentials :one
+SELECT id, email, srp_salt, srp_verifier, kdf_salt, kdf_params
+FROM users
+WHERE email = $1;
+
 -- name: UpdateUserEmailVerified :exec
 UPDATE users
 SET email_verified = $2, updated_at = NOW()
 WHERE id = $1;
+
+-- name: MarkUserEmailVerified :execrows
+-- Only verifies the address the link was sent to, in case the email changed since
+UPDATE users
+SET email_verified = TRUE, updated_at = NOW()
+WHERE id = $1 AND email = $2;
+
+-- name: UpdateUserCredentials :execrows
+UPDATE users
+SET kdf_salt = $2, kdf_params = $3, srp_salt = $4, srp_verifier = $5, updated_at = NOW()
+WHERE id = $1;
+
+-- name: UpgradeUserToSrp :execrows
+-- Moves a password account to SRP login once, clearing the bcrypt hash in the same write
+UPDATE users
+SET kdf_salt = $2, kdf_params = $3, srp_salt = $4, srp_verifier = $5, password_hash = '', updated_at = NOW()
+WHERE id = $1 AND srp_verifier IS NULL AND password_hash <> '';
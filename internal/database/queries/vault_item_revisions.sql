@@ -0,0 +1,34 @@
+-- name: ArchiveVaultItemRevision :exec
+-- Copies the item's current ciphertext into its history before it is overwritten
+-- or deleted. Versions are never reused, so a conflict is an error
+INSERT INTO vault_item_revisions (
+    item_id,
+    vault_id,
+    version,
+    encrypted_blob,
+    iv,
+    tag,
+    meta,
+    created_by_device,
+    created_at,
+    key_version
+)
+SELECT i.id, i.vault_id, i.version, i.encrypted_blob, i.iv, i.tag, i.meta, v.created_by_device, i.updated_at, i.key_version
+FROM vault_items i
+LEFT JOIN vault_versions v ON v.id = i.vault_version_id
+WHERE i.id = $1;
+
+-- name: GetLatestVaultItemRevisionVersion :one
+-- Highest version archived for an item, or 0 when it has no history
+SELECT COALESCE(MAX(version), 0)::INTEGER FROM vault_item_revisions
+WHERE item_id = $1;
+
+-- name: GetVaultItemRevisions :many
+SELECT id, item_id, vault_id, version, created_by_device, created_at, key_version
+FROM vault_item_revisions
+WHERE item_id = $1 AND vault_id = $2
+ORDER BY version DESC;
+
+-- name: GetVaultItemRevision :one
+SELECT * FROM vault_item_revisions
+WHERE item_id = $1 AND vault_id = $2 AND version = $3;
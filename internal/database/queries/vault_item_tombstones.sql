@@ -0,0 +1,23 @@
+-- name: CreateVaultItemTombstone :one
+INSERT INTO vault_item_tombstones (
+    item_id,
+    vault_id,
+    deleted_by_device,
+    vault_version_id
+) VALUES (
+    $1, $2, $3, $4
+)
+RETURNING *;
+
+-- name: GetVaultItemTombstonesSinceVersion :many
+SELECT * FROM vault_item_tombstones
+WHERE vault_id = $1 AND vault_version_id > $2
+ORDER BY vault_version_id ASC;
+
+-- name: DeleteVaultItemTombstone :exec
+DELETE FROM vault_item_tombstones
+WHERE item_id = $1;
+
+-- name: DeleteVaultItemTombstonesBefore :execrows
+DELETE FROM vault_item_tombstones
+WHERE deleted_at < $1;
@@ -7,9 +7,11 @@ INSERT INTO vault_items (
     iv,
     tag,
     meta,
-    version
+    version,
+    vault_version_id,
+    key_version
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
 )
 RETURNING *;
 
@@ -22,21 +24,36 @@ SELECT * FROM vault_items
 WHERE vault_id = $1
 ORDER BY created_at DESC;
 
+-- name: GetVaultItemVersionsByVaultID :many
+SELECT id, version FROM vault_items
+WHERE vault_id = $1
+ORDER BY created_at DESC;
+
+-- name: GetVaultItemsChangedSince :many
+SELECT * FROM vault_items
+WHERE vault_id = $1
+  AND (COALESCE(vault_version_id, 0), id) > ($2::int, $3::uuid)
+ORDER BY COALESCE(vault_version_id, 0), id
+LIMIT $4;
+
 -- name: GetVaultItemsByVaultIDAndType :many
 SELECT * FROM vault_items
 WHERE vault_id = $1 AND item_type = $2
 ORDER BY created_at DESC;
 
 -- name: UpdateVaultItem :one
+-- Only applies while the item is still at expected_version; no row means another write won
 UPDATE vault_items
 SET 
-    encrypted_blob = $2,
-    iv = $3,
-    tag = $4,
-    meta = $5,
-    version = $6,
+    encrypted_blob = sqlc.arg(encrypted_blob),
+    iv = sqlc.arg(iv),
+    tag = sqlc.arg(tag),
+    meta = sqlc.arg(meta),
+    version = sqlc.arg(version),
+    vault_version_id = sqlc.arg(vault_version_id),
+    key_version = sqlc.arg(key_version),
     updated_at = NOW()
-WHERE id = $1
+WHERE id = sqlc.arg(id) AND version = sqlc.arg(expected_version)
 RETURNING *;
 
 -- name: DeleteVaultItem :exec
@@ -47,10 +64,23 @@ WHERE id = $1;
 SELECT * FROM vault_items
 WHERE id = ANY($1::uuid[]);
 
+-- name: LockVaultItemsForUpdate :many
+SELECT * FROM vault_items
+WHERE vault_id = $1 AND id = ANY($2::uuid[])
+ORDER BY id
+FOR UPDATE;
+
 -- name: CountVaultItems :one
 SELECT COUNT(*) FROM vault_items
 WHERE vault_id = $1;
 
+-- name: CountVaultItemsByKeyVersion :many
+-- Number of items still encrypted with each key version of the vault
+SELECT key_version, COUNT(*) AS item_count FROM vault_items
+WHERE vault_id = $1
+GROUP BY key_version
+ORDER BY key_version DESC;
+
 -- name: SearchVaultItemsByMeta :many
 SELECT * FROM vault_items
 WHERE vault_id = $1 
@@ -0,0 +1,124 @@
+-- name: CreateVaultKeyRotation :one
+INSERT INTO vault_key_rotations (
+    vault_id,
+    from_version,
+    to_version,
+    wrapped_vek,
+    wrap_iv,
+    wrap_tag,
+    kdf_salt,
+    kdf_params,
+    started_by_device
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9
+)
+RETURNING *;
+
+-- name: GetVaultKeyRotation :one
+SELECT * FROM vault_key_rotations
+WHERE id = $1 AND vault_id = $2
+LIMIT 1;
+
+-- name: GetActiveVaultKeyRotation :one
+SELECT * FROM vault_key_rotations
+WHERE vault_id = $1 AND status = 'in_progress'
+LIMIT 1;
+
+-- name: FinishVaultKeyRotation :execrows
+-- Moves an in-progress rotation to finalized or aborted
+UPDATE vault_key_rotations
+SET status = $2, finished_at = NOW()
+WHERE id = $1 AND status = 'in_progress';
+
+-- name: UpsertVaultKeyRotationItem :exec
+INSERT INTO vault_key_rotation_items (rotation_id, item_id, base_version, encrypted_blob, iv, tag)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (rotation_id, item_id) DO UPDATE
+SET base_version = EXCLUDED.base_version,
+    encrypted_blob = EXCLUDED.encrypted_blob,
+    iv = EXCLUDED.iv,
+    tag = EXCLUDED.tag,
+    created_at = NOW();
+
+-- name: UpsertVaultKeyRotationShare :exec
+INSERT INTO vault_key_rotation_shares (rotation_id, sharing_record_id, wrapped_key, wrap_iv, wrap_tag)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (rotation_id, sharing_record_id) DO UPDATE
+SET wrapped_key = EXCLUDED.wrapped_key,
+    wrap_iv = EXCLUDED.wrap_iv,
+    wrap_tag = EXCLUDED.wrap_tag,
+    created_at = NOW();
+
+-- name: UpsertVaultKeyRotationShareKey :exec
+INSERT INTO vault_key_rotation_share_keys (rotation_id, sharing_record_id, recipient_device_id, wrapped_key, wrap_iv, wrap_tag)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (rotation_id, sharing_record_id, recipient_device_id) DO UPDATE
+SET wrapped_key = EXCLUDED.wrapped_key,
+    wrap_iv = EXCLUDED.wrap_iv,
+    wrap_tag = EXCLUDED.wrap_tag,
+    created_at = NOW();
+
+-- name: GetVaultKeyRotationProgress :one
+-- Only staged items still at their re-encrypted version and pending or accepted vault shares with a staged key count as done
+SELECT
+    (SELECT COUNT(*) FROM vault_items vi WHERE vi.vault_id = $2)::int AS items_total,
+    (SELECT COUNT(*) FROM vault_key_rotation_items ri
+        JOIN vault_items vi ON vi.id = ri.item_id
+        WHERE ri.rotation_id = $1 AND ri.base_version = vi.version)::int AS items_staged,
+    (SELECT COUNT(*) FROM sharing_records sr
+        WHERE sr.vault_id = $2 AND sr.status IN ('pending', 'accepted') AND sr.item_id IS NULL)::int AS shares_total,
+    (SELECT COUNT(*) FROM sharing_records sr
+        WHERE sr.vault_id = $2 AND sr.status IN ('pending', 'accepted') AND sr.item_id IS NULL
+        AND (EXISTS (SELECT 1 FROM vault_key_rotation_shares rs WHERE rs.rotation_id = $1 AND rs.sharing_record_id = sr.id)
+            OR EXISTS (SELECT 1 FROM vault_key_rotation_share_keys rk WHERE rk.rotation_id = $1 AND rk.sharing_record_id = sr.id)))::int AS shares_staged;
+
+-- name: GetItemSharesMissingRotationKeys :many
+-- Lists the vault's live item shares that were not re-wrapped in the rotation
+SELECT sr.id, sr.item_id, sr.recipient_user_id
+FROM sharing_records sr
+WHERE sr.vault_id = $2 AND sr.item_id IS NOT NULL AND sr.status IN ('pending', 'accepted')
+  AND NOT EXISTS (SELECT 1 FROM vault_key_rotation_share_keys rk WHERE rk.rotation_id = $1 AND rk.sharing_record_id = sr.id)
+ORDER BY sr.created_at;
+
+-- name: ApplyVaultKeyRotationItems :many
+UPDATE vault_items vi
+SET encrypted_blob = ri.encrypted_blob,
+    iv = ri.iv,
+    tag = ri.tag,
+    version = vi.version + 1,
+    vault_version_id = $2,
+    key_version = r.to_version,
+    updated_at = NOW()
+FROM vault_key_rotation_items ri
+JOIN vault_key_rotations r ON r.id = ri.rotation_id
+WHERE ri.rotation_id = $1 AND ri.item_id = vi.id AND ri.base_version = vi.version
+RETURNING vi.*;
+
+-- name: ApplyVaultKeyRotationShares :execrows
+UPDATE sharing_records sr
+SET wrapped_key = rs.wrapped_key,
+    wrap_iv = rs.wrap_iv,
+    wrap_tag = rs.wrap_tag
+FROM vault_key_rotation_shares rs
+WHERE rs.rotation_id = $1 AND rs.sharing_record_id = sr.id AND sr.status IN ('pending', 'accepted');
+
+-- name: ApplyVaultKeyRotationShareKeys :execrows
+-- Run after DeleteSharingRecordKeysByVaultID; the rotating device is recorded as the sender
+INSERT INTO sharing_record_keys (sharing_record_id, recipient_device_id, sender_device_id, wrapped_key, wrap_iv, wrap_tag)
+SELECT rk.sharing_record_id, rk.recipient_device_id, r.started_by_device, rk.wrapped_key, rk.wrap_iv, rk.wrap_tag
+FROM vault_key_rotation_share_keys rk
+JOIN vault_key_rotations r ON r.id = rk.rotation_id
+JOIN sharing_records sr ON sr.id = rk.sharing_record_id
+WHERE rk.rotation_id = $1 AND sr.status IN ('pending', 'accepted');
+
+-- name: DeleteVaultKeyRotationItems :exec
+DELETE FROM vault_key_rotation_items
+WHERE rotation_id = $1;
+
+-- name: DeleteVaultKeyRotationShares :exec
+DELETE FROM vault_key_rotation_shares
+WHERE rotation_id = $1;
+
+-- name: DeleteVaultKeyRotationShareKeys :exec
+DELETE FROM vault_key_rotation_share_keys
+WHERE rotation_id = $1;
@@ -30,6 +30,13 @@ SELECT * FROM vault_keys
 WHERE vault_id = $1
 ORDER BY version DESC;
 
+-- name: GetOwnedVaultKeys :many
+-- Every key version of the vaults a user owns, all wrapped with their master key
+SELECT vk.* FROM vault_keys vk
+JOIN vaults v ON v.id = vk.vault_id
+WHERE v.user_id = $1
+ORDER BY vk.vault_id, vk.version;
+
 -- name: UpdateVaultKey :one
 UPDATE vault_keys
 SET 
@@ -46,3 +53,14 @@ RETURNING *;
 -- name: DeleteVaultKeys :exec
 DELETE FROM vault_keys
 WHERE vault_id = $1;
+
+-- name: UpdateVaultKeyWrap :execrows
+UPDATE vault_keys
+SET
+    wrapped_vek = $3,
+    wrap_iv = $4,
+    wrap_tag = $5,
+    kdf_salt = $6,
+    kdf_params = $7,
+    updated_at = NOW()
+WHERE vault_id = $1 AND version = $2;
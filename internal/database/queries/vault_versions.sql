@@ -9,6 +9,11 @@ INSERT INTO vault_versions (
 )
 RETURNING id, vault_id, object_key, mac, created_by_device, created_at;
 
+-- name: UpdateVaultVersionMac :exec
+UPDATE vault_versions
+SET mac = $2
+WHERE id = $1;
+
 -- name: GetVaultVersionByID :one
 SELECT id, vault_id, object_key, mac, created_by_device, created_at
 FROM vault_versions
@@ -14,6 +14,17 @@ INSERT INTO vaults (
 SELECT * FROM vaults
 WHERE id = $1;
 
+-- name: LockUserVaultsForUpdate :many
+SELECT id FROM vaults
+WHERE user_id = $1
+ORDER BY id
+FOR UPDATE;
+
+-- name: LockVaultForUpdate :one
+SELECT id FROM vaults
+WHERE id = $1
+FOR UPDATE;
+
 -- name: GetVaultByIDAndUserID :one
 SELECT * FROM vaults
 WHERE id = $1 AND user_id = $2;
@@ -40,6 +51,28 @@ SET
 WHERE id = $1 AND user_id = $7
 RETURNING *;
 
+-- name: FlagUserVaultsForRotation :many
+-- Flags every vault the user owns or has been offered in full, keeping the earliest flag time
+UPDATE vaults
+SET rotation_recommended_at = COALESCE(rotation_recommended_at, NOW())
+WHERE user_id = $1
+   OR id IN (
+       SELECT vault_id FROM sharing_records
+       WHERE recipient_user_id = $1 AND status IN ('pending', 'accepted')
+         AND item_id IS NULL
+   )
+RETURNING id;
+
+-- name: FlagVaultForRotation :exec
+UPDATE vaults
+SET rotation_recommended_at = COALESCE(rotation_recommended_at, NOW())
+WHERE id = $1;
+
+-- name: ClearVaultRotationRecommended :exec
+UPDATE vaults
+SET rotation_recommended_at = NULL
+WHERE id = $1;
+
 -- name: DeleteVault :exec
 DELETE FROM vaults
 WHERE id = $1 AND user_id = $2;
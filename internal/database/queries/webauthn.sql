@@ -0,0 +1,42 @@
+-- name: CreateWebauthnCredential :one
+INSERT INTO webauthn_credentials (user_id, credential_id, public_key, sign_count, aaguid, name)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: ListWebauthnCredentials :many
+SELECT * FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: GetWebauthnCredentialByCredentialID :one
+SELECT * FROM webauthn_credentials
+WHERE credential_id = $1
+LIMIT 1;
+
+-- name: CountWebauthnCredentials :one
+SELECT COUNT(*) FROM webauthn_credentials
+WHERE user_id = $1;
+
+-- name: UpdateWebauthnCredentialUsage :exec
+UPDATE webauthn_credentials
+SET sign_count = $2, last_used_at = NOW()
+WHERE id = $1;
+
+-- name: DeleteWebauthnCredential :execrows
+DELETE FROM webauthn_credentials
+WHERE id = $1 AND user_id = $2;
+
+-- name: CreateWebauthnChallenge :one
+INSERT INTO webauthn_challenges (user_id, ceremony, challenge, expires_at)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: ClaimWebauthnChallenge :one
+-- Deletes the challenge so each one answers at most one ceremony
+DELETE FROM webauthn_challenges
+WHERE id = $1 AND ceremony = $2 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredWebauthnChallenges :execrows
+DELETE FROM webauthn_challenges
+WHERE expires_at < NOW();
@@ -0,0 +1,17 @@
+-- +goose Up
+-- Create vault_item_tombstones table so deletions can be propagated to other devices on sync
+CREATE TABLE IF NOT EXISTS vault_item_tombstones (
+    item_id UUID PRIMARY KEY,
+    vault_id INTEGER NOT NULL REFERENCES vaults(id) ON DELETE CASCADE,
+    deleted_by_device UUID NULL REFERENCES devices(id) ON DELETE SET NULL,
+    vault_version_id INTEGER NOT NULL, -- vault version that recorded the deletion (kept after old versions are pruned)
+    deleted_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_vault_item_tombstones_vault_id_version ON vault_item_tombstones(vault_id, vault_version_id);
+CREATE INDEX idx_vault_item_tombstones_deleted_at ON vault_item_tombstones(deleted_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_vault_item_tombstones_deleted_at;
+DROP INDEX IF EXISTS idx_vault_item_tombstones_vault_id_version;
+DROP TABLE IF EXISTS vault_item_tombstones;
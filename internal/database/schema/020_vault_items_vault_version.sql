@@ -0,0 +1,9 @@
+-- +goose Up
+-- Record the vault version that last touched each item so sync can pull deltas
+ALTER TABLE vault_items ADD COLUMN IF NOT EXISTS vault_version_id INTEGER NULL; -- NULL for items written before versions were tracked
+
+CREATE INDEX idx_vault_items_vault_id_version ON vault_items(vault_id, vault_version_id, id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_vault_items_vault_id_version;
+ALTER TABLE vault_items DROP COLUMN IF EXISTS vault_version_id;
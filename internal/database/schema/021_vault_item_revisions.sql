@@ -0,0 +1,21 @@
+-- +goose Up
+-- Create vault_item_revisions table keeping every ciphertext an item update replaced
+CREATE TABLE IF NOT EXISTS vault_item_revisions (
+    id SERIAL PRIMARY KEY,
+    item_id UUID NOT NULL,            -- no FK so history outlives the item and returns with a restore
+    vault_id INTEGER NOT NULL REFERENCES vaults(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,         -- item version this ciphertext had
+    encrypted_blob BYTEA NOT NULL,
+    iv BYTEA NOT NULL,
+    tag BYTEA NOT NULL,
+    meta JSONB NULL,
+    created_by_device UUID NULL REFERENCES devices(id) ON DELETE SET NULL, -- device that wrote this ciphertext
+    created_at TIMESTAMP NOT NULL,    -- when this ciphertext was written
+    UNIQUE (item_id, version)
+);
+
+CREATE INDEX idx_vault_item_revisions_vault_id ON vault_item_revisions(vault_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_vault_item_revisions_vault_id;
+DROP TABLE IF EXISTS vault_item_revisions;
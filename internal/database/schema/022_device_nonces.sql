@@ -0,0 +1,14 @@
+-- +goose Up
+-- Create device_nonces table recording nonces of signed requests to reject replays
+CREATE TABLE IF NOT EXISTS device_nonces (
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    nonce TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (device_id, nonce)
+);
+
+CREATE INDEX idx_device_nonces_created_at ON device_nonces(created_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_device_nonces_created_at;
+DROP TABLE IF EXISTS device_nonces;
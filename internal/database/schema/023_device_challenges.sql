@@ -0,0 +1,20 @@
+-- +goose Up
+-- Devices start out pending until they prove possession of their signing key
+ALTER TABLE devices ADD COLUMN status VARCHAR(50) NOT NULL DEFAULT 'active';
+ALTER TABLE devices ADD COLUMN verified_at TIMESTAMP NULL;
+
+-- Create device_challenges table holding the registration challenge a pending device must sign
+CREATE TABLE IF NOT EXISTS device_challenges (
+    device_id UUID PRIMARY KEY REFERENCES devices(id) ON DELETE CASCADE,
+    challenge BYTEA NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_device_challenges_expires_at ON device_challenges(expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_device_challenges_expires_at;
+DROP TABLE IF EXISTS device_challenges;
+ALTER TABLE devices DROP COLUMN IF EXISTS verified_at;
+ALTER TABLE devices DROP COLUMN IF EXISTS status;
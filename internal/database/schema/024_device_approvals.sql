@@ -0,0 +1,18 @@
+-- +goose Up
+-- Record which trusted device (or recovery code) approved each new device
+ALTER TABLE devices ADD COLUMN approved_by_device UUID NULL REFERENCES devices(id) ON DELETE SET NULL;
+ALTER TABLE devices ADD COLUMN approved_at TIMESTAMP NULL;
+ALTER TABLE devices ADD COLUMN approval_method VARCHAR(50) NULL; -- first_device, device or recovery_code
+
+-- Create recovery_codes table holding the hash of each user's account recovery code
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    code_hash BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS recovery_codes;
+ALTER TABLE devices DROP COLUMN IF EXISTS approval_method;
+ALTER TABLE devices DROP COLUMN IF EXISTS approved_at;
+ALTER TABLE devices DROP COLUMN IF EXISTS approved_by_device;
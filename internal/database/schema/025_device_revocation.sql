@@ -0,0 +1,26 @@
+-- +goose Up
+-- Bind sessions to the device that uses them so revoking a device ends its sessions
+ALTER TABLE sessions ADD COLUMN device_id UUID NULL REFERENCES devices(id) ON DELETE SET NULL;
+CREATE INDEX idx_sessions_device_id ON sessions(device_id);
+
+-- Flag vaults whose keys were known to a revoked device until a new key version is uploaded
+ALTER TABLE vaults ADD COLUMN rotation_recommended_at TIMESTAMP NULL;
+
+-- Create audit_events table for security relevant account events
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id UUID NULL REFERENCES devices(id) ON DELETE SET NULL, -- device the event concerns
+    event_type VARCHAR(100) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_audit_events_user_id;
+DROP TABLE IF EXISTS audit_events;
+ALTER TABLE vaults DROP COLUMN IF EXISTS rotation_recommended_at;
+DROP INDEX IF EXISTS idx_sessions_device_id;
+ALTER TABLE sessions DROP COLUMN IF EXISTS device_id;
@@ -0,0 +1,51 @@
+-- +goose Up
+-- Create vault_key_rotations table tracking a key rotation from upload of the new wrapped VEK to finalization
+CREATE TABLE IF NOT EXISTS vault_key_rotations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    vault_id INTEGER NOT NULL REFERENCES vaults(id) ON DELETE CASCADE,
+    from_version INTEGER NOT NULL,     -- key version the items are encrypted with when the rotation starts
+    to_version INTEGER NOT NULL,       -- vault_keys version created on finalization
+    wrapped_vek BYTEA NOT NULL,        -- new VEK, wrapped like vault_keys.wrapped_vek
+    wrap_iv BYTEA NOT NULL,
+    wrap_tag BYTEA NOT NULL,
+    kdf_salt BYTEA NOT NULL,
+    kdf_params JSONB NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'in_progress', -- in_progress, finalized or aborted
+    started_by_device UUID NULL REFERENCES devices(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP NULL
+);
+
+CREATE INDEX idx_vault_key_rotations_vault_id ON vault_key_rotations(vault_id);
+-- At most one rotation per vault may be in progress
+CREATE UNIQUE INDEX idx_vault_key_rotations_in_progress ON vault_key_rotations(vault_id) WHERE status = 'in_progress';
+
+-- Items re-encrypted under the new key, staged until the rotation is finalized
+CREATE TABLE IF NOT EXISTS vault_key_rotation_items (
+    rotation_id UUID NOT NULL REFERENCES vault_key_rotations(id) ON DELETE CASCADE,
+    item_id UUID NOT NULL REFERENCES vault_items(id) ON DELETE CASCADE,
+    base_version INTEGER NOT NULL,     -- item version that was re-encrypted
+    encrypted_blob BYTEA NOT NULL,
+    iv BYTEA NOT NULL,
+    tag BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (rotation_id, item_id)
+);
+
+-- Recipient keys wrapped around the new VEK, staged until the rotation is finalized
+CREATE TABLE IF NOT EXISTS vault_key_rotation_shares (
+    rotation_id UUID NOT NULL REFERENCES vault_key_rotations(id) ON DELETE CASCADE,
+    sharing_record_id UUID NOT NULL REFERENCES sharing_records(id) ON DELETE CASCADE,
+    wrapped_key BYTEA NOT NULL,
+    wrap_iv BYTEA NOT NULL,
+    wrap_tag BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (rotation_id, sharing_record_id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS vault_key_rotation_shares;
+DROP TABLE IF EXISTS vault_key_rotation_items;
+DROP INDEX IF EXISTS idx_vault_key_rotations_in_progress;
+DROP INDEX IF EXISTS idx_vault_key_rotations_vault_id;
+DROP TABLE IF EXISTS vault_key_rotations;
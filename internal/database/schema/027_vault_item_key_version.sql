@@ -0,0 +1,22 @@
+-- +goose Up
+-- Record which vault_keys version encrypted each item and revision
+ALTER TABLE vault_items ADD COLUMN key_version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE vault_item_revisions ADD COLUMN key_version INTEGER NOT NULL DEFAULT 1;
+
+-- Clients have always encrypted with the latest key, the best guess for existing rows
+UPDATE vault_items vi
+SET key_version = k.version
+FROM (SELECT vault_id, MAX(version) AS version FROM vault_keys GROUP BY vault_id) k
+WHERE k.vault_id = vi.vault_id;
+
+UPDATE vault_item_revisions r
+SET key_version = k.version
+FROM (SELECT vault_id, MAX(version) AS version FROM vault_keys GROUP BY vault_id) k
+WHERE k.vault_id = r.vault_id;
+
+CREATE INDEX idx_vault_items_key_version ON vault_items(vault_id, key_version);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_vault_items_key_version;
+ALTER TABLE vault_item_revisions DROP COLUMN IF EXISTS key_version;
+ALTER TABLE vault_items DROP COLUMN IF EXISTS key_version;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- Give each vault member a role: viewer (read only), editor (read and write) or manager (editor who may also share)
+ALTER TABLE sharing_records ADD COLUMN role VARCHAR(50) NOT NULL DEFAULT 'viewer';
+
+-- Existing members could already commit through sync, so keep them writable
+UPDATE sharing_records SET role = 'editor';
+
+-- +goose Down
+ALTER TABLE sharing_records DROP COLUMN IF EXISTS role;
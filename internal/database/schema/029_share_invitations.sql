@@ -0,0 +1,23 @@
+-- +goose Up
+-- Create share_invitations table for vault shares addressed to an email that has no usable account yet
+CREATE TABLE IF NOT EXISTS share_invitations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    vault_id INTEGER NOT NULL REFERENCES vaults(id) ON DELETE CASCADE,
+    sender_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,        -- stored lowercased
+    role VARCHAR(50) NOT NULL DEFAULT 'viewer',
+    status VARCHAR(50) NOT NULL DEFAULT 'pending', -- pending, ready, completed or cancelled
+    recipient_user_id INTEGER NULL REFERENCES users(id) ON DELETE CASCADE, -- set once the invitee has an active device
+    sharing_record_id UUID NULL REFERENCES sharing_records(id) ON DELETE SET NULL, -- share created on completion
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP NULL
+);
+
+CREATE INDEX idx_share_invitations_vault_id ON share_invitations(vault_id);
+CREATE INDEX idx_share_invitations_email ON share_invitations(email);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_share_invitations_email;
+DROP INDEX IF EXISTS idx_share_invitations_vault_id;
+DROP TABLE IF EXISTS share_invitations;
@@ -0,0 +1,40 @@
+-- +goose Up
+-- Create sharing_record_keys table holding a share's key wrapped for each of the recipient's devices
+CREATE TABLE IF NOT EXISTS sharing_record_keys (
+    sharing_record_id UUID NOT NULL REFERENCES sharing_records(id) ON DELETE CASCADE,
+    recipient_device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE, -- device whose X25519 key unwraps it
+    sender_device_id UUID NULL REFERENCES devices(id) ON DELETE SET NULL,       -- device that wrapped it
+    wrapped_key BYTEA NOT NULL, -- VEK or IEK encrypted with the ECDH-derived symmetric key
+    wrap_iv BYTEA NOT NULL,
+    wrap_tag BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (sharing_record_id, recipient_device_id)
+);
+
+CREATE INDEX idx_sharing_record_keys_recipient_device_id ON sharing_record_keys(recipient_device_id);
+
+-- Recipient device keys wrapped around the new VEK, staged until the rotation is finalized
+CREATE TABLE IF NOT EXISTS vault_key_rotation_share_keys (
+    rotation_id UUID NOT NULL REFERENCES vault_key_rotations(id) ON DELETE CASCADE,
+    sharing_record_id UUID NOT NULL REFERENCES sharing_records(id) ON DELETE CASCADE,
+    recipient_device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    wrapped_key BYTEA NOT NULL,
+    wrap_iv BYTEA NOT NULL,
+    wrap_tag BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (rotation_id, sharing_record_id, recipient_device_id)
+);
+
+-- New shares carry their keys per device; the per-user key stays for shares made before this
+ALTER TABLE sharing_records ALTER COLUMN wrapped_key DROP NOT NULL;
+ALTER TABLE sharing_records ALTER COLUMN wrap_iv DROP NOT NULL;
+ALTER TABLE sharing_records ALTER COLUMN wrap_tag DROP NOT NULL;
+
+-- +goose Down
+DELETE FROM sharing_records WHERE wrapped_key IS NULL;
+ALTER TABLE sharing_records ALTER COLUMN wrap_tag SET NOT NULL;
+ALTER TABLE sharing_records ALTER COLUMN wrap_iv SET NOT NULL;
+ALTER TABLE sharing_records ALTER COLUMN wrapped_key SET NOT NULL;
+DROP TABLE IF EXISTS vault_key_rotation_share_keys;
+DROP INDEX IF EXISTS idx_sharing_record_keys_recipient_device_id;
+DROP TABLE IF EXISTS sharing_record_keys;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- Let shares lapse on their own; expired shares are treated as revoked and later marked 'expired'
+ALTER TABLE sharing_records ADD COLUMN expires_at TIMESTAMP NULL;
+
+CREATE INDEX idx_sharing_records_expires_at ON sharing_records(expires_at)
+    WHERE expires_at IS NOT NULL AND status IN ('pending', 'accepted');
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_sharing_records_expires_at;
+ALTER TABLE sharing_records DROP COLUMN IF EXISTS expires_at;
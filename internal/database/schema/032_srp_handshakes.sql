@@ -0,0 +1,17 @@
+-- +goose Up
+-- Create srp_handshakes table holding the server ephemeral between the two rounds of an SRP login
+CREATE TABLE IF NOT EXISTS srp_handshakes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_public BYTEA NOT NULL,
+    server_secret BYTEA NOT NULL,
+    server_public BYTEA NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_srp_handshakes_expires_at ON srp_handshakes(expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_srp_handshakes_expires_at;
+DROP TABLE IF EXISTS srp_handshakes;
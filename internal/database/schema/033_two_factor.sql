@@ -0,0 +1,36 @@
+-- +goose Up
+-- Create totp_credentials table holding each user's authenticator secret; 2FA is on once confirmed
+CREATE TABLE IF NOT EXISTS totp_credentials (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret BYTEA NOT NULL,
+    confirmed_at TIMESTAMP NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,  -- latest accepted time step, so a code cannot be replayed
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Create mfa_recovery_codes table holding hashes of the one-time codes that stand in for the authenticator
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash BYTEA NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE(user_id, code_hash)
+);
+
+-- Create mfa_challenges table holding logins that passed the first factor and wait for the second
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    token_hash BYTEA PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_mfa_challenges_expires_at ON mfa_challenges(expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_mfa_challenges_expires_at;
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS mfa_recovery_codes;
+DROP TABLE IF EXISTS totp_credentials;
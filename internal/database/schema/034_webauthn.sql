@@ -0,0 +1,33 @@
+-- +goose Up
+-- Create webauthn_credentials table holding each user's registered passkeys and security keys
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    credential_id BYTEA NOT NULL UNIQUE,
+    public_key BYTEA NOT NULL,  -- COSE_Key as sent by the authenticator
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    aaguid BYTEA NOT NULL,
+    name TEXT NOT NULL,
+    last_used_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+
+-- Create webauthn_challenges table holding open registration and authentication ceremonies
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id INTEGER NULL REFERENCES users(id) ON DELETE CASCADE,  -- NULL for a passwordless login that has not named a user yet
+    ceremony TEXT NOT NULL CHECK (ceremony IN ('registration', 'authentication')),
+    challenge BYTEA NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_webauthn_challenges_expires_at ON webauthn_challenges(expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_webauthn_challenges_expires_at;
+DROP TABLE IF EXISTS webauthn_challenges;
+DROP INDEX IF EXISTS idx_webauthn_credentials_user_id;
+DROP TABLE IF EXISTS webauthn_credentials;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- Create mfa_user_attempts table counting second-factor attempts per user across every pending login,
+-- so opening a new challenge does not buy more guesses
+CREATE TABLE IF NOT EXISTS mfa_user_attempts (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    window_started_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS mfa_user_attempts;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- Create email_verifications table holding hashes of the one-time links that prove a user owns their email
+CREATE TABLE IF NOT EXISTS email_verifications (
+    token_hash BYTEA PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,  -- the address the link was sent to
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_email_verifications_user_id ON email_verifications(user_id);
+CREATE INDEX idx_email_verifications_expires_at ON email_verifications(expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_email_verifications_expires_at;
+DROP INDEX IF EXISTS idx_email_verifications_user_id;
+DROP TABLE IF EXISTS email_verifications;
@@ -0,0 +1,210 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"yamony/internal/database/sqlc"
+)
+
+// newMigratedQueries connects to the test container on a fresh schema and
+// applies every migration's Up section to it
+func newMigratedQueries(t *testing.T) *sqlc.Queries {
+	t.Helper()
+	ctx := context.Background()
+
+	testSchema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", username, password, host, port, database)
+	admin, err := pgxpool.New(ctx, connStr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer admin.Close()
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+testSchema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+
+	pool, err := pgxpool.New(ctx, connStr+"&search_path="+testSchema)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	migrations, err := filepath.Glob("schema/*.sql")
+	if err != nil {
+		t.Fatalf("failed to list migrations: %v", err)
+	}
+	for _, migration := range migrations {
+		contents, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", migration, err)
+		}
+		up, _, _ := strings.Cut(string(contents), "-- +goose Down")
+		if _, err := pool.Exec(ctx, up); err != nil {
+			t.Fatalf("failed to apply %s: %v", migration, err)
+		}
+	}
+
+	return sqlc.New(pool)
+}
+
+func TestMarkShareInvitationsReadyRequiresVerifiedEmail(t *testing.T) {
+	ctx := context.Background()
+	queries := newMigratedQueries(t)
+
+	createUser := func(email string, verified bool) int32 {
+		user, err := queries.CreateUser(ctx, sqlc.CreateUserParams{
+			Username:      email,
+			Email:         email,
+			EmailVerified: verified,
+		})
+		if err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		return user.ID
+	}
+
+	ownerID := createUser("owner@example.com", true)
+	vault, err := queries.CreateVault(ctx, sqlc.CreateVaultParams{UserID: ownerID, Name: "Shared"})
+	if err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+
+	invite := func(email string) {
+		_, err := queries.CreateShareInvitation(ctx, sqlc.CreateShareInvitationParams{
+			VaultID:      vault.ID,
+			SenderUserID: ownerID,
+			Email:        email,
+			Role:         "viewer",
+			ExpiresAt:    pgtype.Timestamp{Time: time.Now().Add(time.Hour), Valid: true},
+		})
+		if err != nil {
+			t.Fatalf("failed to create invitation: %v", err)
+		}
+	}
+	invite("verified@example.com")
+	invite("unverified@example.com")
+
+	verifiedID := createUser("Verified@example.com", true)
+	unverifiedID := createUser("unverified@example.com", false)
+
+	released, err := queries.MarkShareInvitationsReady(ctx, verifiedID)
+	if err != nil {
+		t.Fatalf("failed to release invitations: %v", err)
+	}
+	if len(released) != 1 || released[0].Status != "ready" {
+		t.Fatalf("expected the verified account to get its invitation, got %+v", released)
+	}
+
+	// Anyone can register an unverified account for an invited address
+	released, err = queries.MarkShareInvitationsReady(ctx, unverifiedID)
+	if err != nil {
+		t.Fatalf("failed to release invitations: %v", err)
+	}
+	if len(released) != 0 {
+		t.Fatalf("expected no invitations for an unverified account, got %+v", released)
+	}
+}
+
+func TestEmailVerificationReleasesInvitations(t *testing.T) {
+	ctx := context.Background()
+	queries := newMigratedQueries(t)
+
+	owner, err := queries.CreateUser(ctx, sqlc.CreateUserParams{
+		Username:      "owner@example.com",
+		Email:         "owner@example.com",
+		EmailVerified: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create owner: %v", err)
+	}
+	vault, err := queries.CreateVault(ctx, sqlc.CreateVaultParams{UserID: owner.ID, Name: "Shared"})
+	if err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	if _, err := queries.CreateShareInvitation(ctx, sqlc.CreateShareInvitationParams{
+		VaultID:      vault.ID,
+		SenderUserID: owner.ID,
+		Email:        "invitee@example.com",
+		Role:         "viewer",
+		ExpiresAt:    pgtype.Timestamp{Time: time.Now().Add(24 * time.Hour), Valid: true},
+	}); err != nil {
+		t.Fatalf("failed to create invitation: %v", err)
+	}
+
+	// Registration leaves the email unverified
+	invitee, err := queries.CreateUser(ctx, sqlc.CreateUserParams{
+		Username: "invitee@example.com",
+		Email:    "invitee@example.com",
+	})
+	if err != nil {
+		t.Fatalf("failed to create invitee: %v", err)
+	}
+
+	// Verifying the first device alone releases nothing
+	deviceID := pgtype.UUID{Bytes: uuid.New(), Valid: true}
+	if _, err := queries.CreateDevice(ctx, sqlc.CreateDeviceParams{
+		ID:            deviceID,
+		UserID:        invitee.ID,
+		DeviceLabel:   pgtype.Text{String: "laptop", Valid: true},
+		X25519Public:  make([]byte, 32),
+		Ed25519Public: make([]byte, 32),
+	}); err != nil {
+		t.Fatalf("failed to create device: %v", err)
+	}
+	if _, err := queries.MarkDeviceVerified(ctx, sqlc.MarkDeviceVerifiedParams{ID: deviceID, Status: "active"}); err != nil {
+		t.Fatalf("failed to verify device: %v", err)
+	}
+	released, err := queries.MarkShareInvitationsReady(ctx, invitee.ID)
+	if err != nil {
+		t.Fatalf("failed to release invitations: %v", err)
+	}
+	if len(released) != 0 {
+		t.Fatalf("expected no invitations before the email is verified, got %+v", released)
+	}
+
+	tokenHash := []byte("verification-token-hash")
+	if err := queries.CreateEmailVerification(ctx, sqlc.CreateEmailVerificationParams{
+		TokenHash: tokenHash,
+		UserID:    invitee.ID,
+		Email:     invitee.Email,
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().Add(24 * time.Hour), Valid: true},
+	}); err != nil {
+		t.Fatalf("failed to create verification: %v", err)
+	}
+
+	verification, err := queries.ClaimEmailVerification(ctx, tokenHash)
+	if err != nil {
+		t.Fatalf("failed to claim verification: %v", err)
+	}
+	verified, err := queries.MarkUserEmailVerified(ctx, sqlc.MarkUserEmailVerifiedParams{
+		ID:    verification.UserID,
+		Email: verification.Email,
+	})
+	if err != nil || verified != 1 {
+		t.Fatalf("expected the email to be verified, got %d rows: %v", verified, err)
+	}
+
+	// A link verifies at most once
+	if _, err := queries.ClaimEmailVerification(ctx, tokenHash); err != pgx.ErrNoRows {
+		t.Fatalf("expected a used link to be gone, got %v", err)
+	}
+
+	released, err = queries.MarkShareInvitationsReady(ctx, invitee.ID)
+	if err != nil {
+		t.Fatalf("failed to release invitations: %v", err)
+	}
+	if len(released) != 1 || released[0].Status != "ready" || released[0].RecipientUserID.Int32 != invitee.ID {
+		t.Fatalf("expected the invitation to be released to the verified account, got %+v", released)
+	}
+}
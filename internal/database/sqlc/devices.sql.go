@@ -11,6 +11,39 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const approveDevice = `-- name: ApproveDevice :execrows
+UPDATE devices
+SET status = 'active', approved_by_device = $2, approved_at = NOW(), approval_method = $3
+WHERE id = $1 AND status = 'awaiting_approval' AND revoked_at IS NULL
+`
+
+type ApproveDeviceParams struct {
+	ID               pgtype.UUID `json:"id"`
+	ApprovedByDevice pgtype.UUID `json:"approved_by_device"`
+	ApprovalMethod   pgtype.Text `json:"approval_method"`
+}
+
+func (q *Queries) ApproveDevice(ctx context.Context, arg ApproveDeviceParams) (int64, error) {
+	result, err := q.db.Exec(ctx, approveDevice, arg.ID, arg.ApprovedByDevice, arg.ApprovalMethod)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const countTrustedDevicesByUserID = `-- name: CountTrustedDevicesByUserID :one
+SELECT COUNT(*) FROM devices
+WHERE user_id = $1 AND status = 'active'
+`
+
+// Includes revoked devices, so losing every device does not reopen first-device bootstrap
+func (q *Queries) CountTrustedDevicesByUserID(ctx context.Context, userID int32) (int64, error) {
+	row := q.db.QueryRow(ctx, countTrustedDevicesByUserID, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createDevice = `-- name: CreateDevice :one
 INSERT INTO devices (
     id,
@@ -18,12 +51,13 @@ INSERT INTO devices (
     device_label,
     x25519_public,
     ed25519_public,
+    status,
     created_at,
     last_seen
 ) VALUES (
-    $1, $2, $3, $4, $5, NOW(), NOW()
+    $1, $2, $3, $4, $5, 'pending', NOW(), NOW()
 )
-RETURNING id, user_id, device_label, x25519_public, ed25519_public, revoked_at, created_at, last_seen
+RETURNING id, user_id, device_label, x25519_public, ed25519_public, revoked_at, created_at, last_seen, status, verified_at, approved_by_device, approved_at, approval_method
 `
 
 type CreateDeviceParams struct {
@@ -52,12 +86,17 @@ func (q *Queries) CreateDevice(ctx context.Context, arg CreateDeviceParams) (Dev
 		&i.RevokedAt,
 		&i.CreatedAt,
 		&i.LastSeen,
+		&i.Status,
+		&i.VerifiedAt,
+		&i.ApprovedByDevice,
+		&i.ApprovedAt,
+		&i.ApprovalMethod,
 	)
 	return i, err
 }
 
 const getAllDevicesByUserID = `-- name: GetAllDevicesByUserID :many
-SELECT id, user_id, device_label, x25519_public, ed25519_public, revoked_at, created_at, last_seen FROM devices
+SELECT id, user_id, device_label, x25519_public, ed25519_public, revoked_at, created_at, last_seen, status, verified_at, approved_by_device, approved_at, approval_method FROM devices
 WHERE user_id = $1
 ORDER BY created_at DESC
 `
@@ -80,6 +119,11 @@ func (q *Queries) GetAllDevicesByUserID(ctx context.Context, userID int32) ([]De
 			&i.RevokedAt,
 			&i.CreatedAt,
 			&i.LastSeen,
+			&i.Status,
+			&i.VerifiedAt,
+			&i.ApprovedByDevice,
+			&i.ApprovedAt,
+			&i.ApprovalMethod,
 		); err != nil {
 			return nil, err
 		}
@@ -92,7 +136,7 @@ func (q *Queries) GetAllDevicesByUserID(ctx context.Context, userID int32) ([]De
 }
 
 const getDeviceByID = `-- name: GetDeviceByID :one
-SELECT id, user_id, device_label, x25519_public, ed25519_public, revoked_at, created_at, last_seen FROM devices
+SELECT id, user_id, device_label, x25519_public, ed25519_public, revoked_at, created_at, last_seen, status, verified_at, approved_by_device, approved_at, approval_method FROM devices
 WHERE id = $1 AND revoked_at IS NULL
 LIMIT 1
 `
@@ -109,6 +153,38 @@ func (q *Queries) GetDeviceByID(ctx context.Context, id pgtype.UUID) (Device, er
 		&i.RevokedAt,
 		&i.CreatedAt,
 		&i.LastSeen,
+		&i.Status,
+		&i.VerifiedAt,
+		&i.ApprovedByDevice,
+		&i.ApprovedAt,
+		&i.ApprovalMethod,
+	)
+	return i, err
+}
+
+const getDeviceByIDIncludingRevoked = `-- name: GetDeviceByIDIncludingRevoked :one
+SELECT id, user_id, device_label, x25519_public, ed25519_public, revoked_at, created_at, last_seen, status, verified_at, approved_by_device, approved_at, approval_method FROM devices
+WHERE id = $1
+LIMIT 1
+`
+
+func (q *Queries) GetDeviceByIDIncludingRevoked(ctx context.Context, id pgtype.UUID) (Device, error) {
+	row := q.db.QueryRow(ctx, getDeviceByIDIncludingRevoked, id)
+	var i Device
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.DeviceLabel,
+		&i.X25519Public,
+		&i.Ed25519Public,
+		&i.RevokedAt,
+		&i.CreatedAt,
+		&i.LastSeen,
+		&i.Status,
+		&i.VerifiedAt,
+		&i.ApprovedByDevice,
+		&i.ApprovedAt,
+		&i.ApprovalMethod,
 	)
 	return i, err
 }
@@ -116,7 +192,7 @@ func (q *Queries) GetDeviceByID(ctx context.Context, id pgtype.UUID) (Device, er
 const getDevicePublicKeys = `-- name: GetDevicePublicKeys :one
 SELECT x25519_public, ed25519_public
 FROM devices
-WHERE id = $1 AND revoked_at IS NULL
+WHERE id = $1 AND revoked_at IS NULL AND status = 'active'
 LIMIT 1
 `
 
@@ -133,7 +209,7 @@ func (q *Queries) GetDevicePublicKeys(ctx context.Context, id pgtype.UUID) (GetD
 }
 
 const getDevicesByUserID = `-- name: GetDevicesByUserID :many
-SELECT id, user_id, device_label, x25519_public, ed25519_public, revoked_at, created_at, last_seen FROM devices
+SELECT id, user_id, device_label, x25519_public, ed25519_public, revoked_at, created_at, last_seen, status, verified_at, approved_by_device, approved_at, approval_method FROM devices
 WHERE user_id = $1 AND revoked_at IS NULL
 ORDER BY created_at DESC
 `
@@ -156,6 +232,11 @@ func (q *Queries) GetDevicesByUserID(ctx context.Context, userID int32) ([]Devic
 			&i.RevokedAt,
 			&i.CreatedAt,
 			&i.LastSeen,
+			&i.Status,
+			&i.VerifiedAt,
+			&i.ApprovedByDevice,
+			&i.ApprovedAt,
+			&i.ApprovalMethod,
 		); err != nil {
 			return nil, err
 		}
@@ -170,7 +251,7 @@ func (q *Queries) GetDevicesByUserID(ctx context.Context, userID int32) ([]Devic
 const getUserDevicePublicKeys = `-- name: GetUserDevicePublicKeys :many
 SELECT id, device_label, x25519_public, ed25519_public, created_at
 FROM devices
-WHERE user_id = $1 AND revoked_at IS NULL
+WHERE user_id = $1 AND revoked_at IS NULL AND status = 'active'
 ORDER BY created_at DESC
 `
 
@@ -208,6 +289,25 @@ func (q *Queries) GetUserDevicePublicKeys(ctx context.Context, userID int32) ([]
 	return items, nil
 }
 
+const markDeviceVerified = `-- name: MarkDeviceVerified :execrows
+UPDATE devices
+SET status = $2, verified_at = NOW()
+WHERE id = $1 AND status = 'pending' AND revoked_at IS NULL
+`
+
+type MarkDeviceVerifiedParams struct {
+	ID     pgtype.UUID `json:"id"`
+	Status string      `json:"status"`
+}
+
+func (q *Queries) MarkDeviceVerified(ctx context.Context, arg MarkDeviceVerifiedParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markDeviceVerified, arg.ID, arg.Status)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const revokeDevice = `-- name: RevokeDevice :exec
 UPDATE devices
 SET revoked_at = NOW()
@@ -8,6 +8,15 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type AuditEvent struct {
+	ID        int64            `json:"id"`
+	UserID    int32            `json:"user_id"`
+	DeviceID  pgtype.UUID      `json:"device_id"`
+	EventType string           `json:"event_type"`
+	Data      []byte           `json:"data"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
 type Block struct {
 	ID          int32            `json:"id"`
 	PageID      int32            `json:"page_id"`
@@ -25,14 +34,62 @@ type Block struct {
 }
 
 type Device struct {
-	ID            pgtype.UUID      `json:"id"`
-	UserID        int32            `json:"user_id"`
-	DeviceLabel   pgtype.Text      `json:"device_label"`
-	X25519Public  []byte           `json:"x25519_public"`
-	Ed25519Public []byte           `json:"ed25519_public"`
-	RevokedAt     pgtype.Timestamp `json:"revoked_at"`
-	CreatedAt     pgtype.Timestamp `json:"created_at"`
-	LastSeen      pgtype.Timestamp `json:"last_seen"`
+	ID               pgtype.UUID      `json:"id"`
+	UserID           int32            `json:"user_id"`
+	DeviceLabel      pgtype.Text      `json:"device_label"`
+	X25519Public     []byte           `json:"x25519_public"`
+	Ed25519Public    []byte           `json:"ed25519_public"`
+	RevokedAt        pgtype.Timestamp `json:"revoked_at"`
+	CreatedAt        pgtype.Timestamp `json:"created_at"`
+	LastSeen         pgtype.Timestamp `json:"last_seen"`
+	Status           string           `json:"status"`
+	VerifiedAt       pgtype.Timestamp `json:"verified_at"`
+	ApprovedByDevice pgtype.UUID      `json:"approved_by_device"`
+	ApprovedAt       pgtype.Timestamp `json:"approved_at"`
+	ApprovalMethod   pgtype.Text      `json:"approval_method"`
+}
+
+type DeviceChallenge struct {
+	DeviceID  pgtype.UUID      `json:"device_id"`
+	Challenge []byte           `json:"challenge"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type DeviceNonce struct {
+	DeviceID  pgtype.UUID      `json:"device_id"`
+	Nonce     string           `json:"nonce"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type EmailVerification struct {
+	TokenHash []byte           `json:"token_hash"`
+	UserID    int32            `json:"user_id"`
+	Email     string           `json:"email"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type MfaChallenge struct {
+	TokenHash []byte           `json:"token_hash"`
+	UserID    int32            `json:"user_id"`
+	Attempts  int32            `json:"attempts"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type MfaRecoveryCode struct {
+	ID        int32            `json:"id"`
+	UserID    int32            `json:"user_id"`
+	CodeHash  []byte           `json:"code_hash"`
+	UsedAt    pgtype.Timestamp `json:"used_at"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type MfaUserAttempt struct {
+	UserID          int32            `json:"user_id"`
+	Attempts        int32            `json:"attempts"`
+	WindowStartedAt pgtype.Timestamp `json:"window_started_at"`
 }
 
 type Page struct {
@@ -63,6 +120,12 @@ type Preference struct {
 	UpdatedAt           pgtype.Timestamp `json:"updated_at"`
 }
 
+type RecoveryCode struct {
+	UserID    int32            `json:"user_id"`
+	CodeHash  []byte           `json:"code_hash"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
 type Session struct {
 	ID           int32            `json:"id"`
 	UserID       int32            `json:"user_id"`
@@ -71,6 +134,24 @@ type Session struct {
 	CreatedAt    pgtype.Timestamp `json:"created_at"`
 	UpdatedAt    pgtype.Timestamp `json:"updated_at"`
 	ActivePageID pgtype.Int4      `json:"active_page_id"`
+	DeviceID     pgtype.UUID      `json:"device_id"`
+	UserAgent    string           `json:"user_agent"`
+	IpAddress    string           `json:"ip_address"`
+	LastActiveAt pgtype.Timestamp `json:"last_active_at"`
+}
+
+type ShareInvitation struct {
+	ID              pgtype.UUID      `json:"id"`
+	VaultID         int32            `json:"vault_id"`
+	SenderUserID    int32            `json:"sender_user_id"`
+	Email           string           `json:"email"`
+	Role            string           `json:"role"`
+	Status          string           `json:"status"`
+	RecipientUserID pgtype.Int4      `json:"recipient_user_id"`
+	SharingRecordID pgtype.UUID      `json:"sharing_record_id"`
+	CreatedAt       pgtype.Timestamp `json:"created_at"`
+	ExpiresAt       pgtype.Timestamp `json:"expires_at"`
+	CompletedAt     pgtype.Timestamp `json:"completed_at"`
 }
 
 type SharingRecord struct {
@@ -85,6 +166,36 @@ type SharingRecord struct {
 	Status          string           `json:"status"`
 	CreatedAt       pgtype.Timestamp `json:"created_at"`
 	AcceptedAt      pgtype.Timestamp `json:"accepted_at"`
+	Role            string           `json:"role"`
+	ExpiresAt       pgtype.Timestamp `json:"expires_at"`
+}
+
+type SharingRecordKey struct {
+	SharingRecordID   pgtype.UUID      `json:"sharing_record_id"`
+	RecipientDeviceID pgtype.UUID      `json:"recipient_device_id"`
+	SenderDeviceID    pgtype.UUID      `json:"sender_device_id"`
+	WrappedKey        []byte           `json:"wrapped_key"`
+	WrapIv            []byte           `json:"wrap_iv"`
+	WrapTag           []byte           `json:"wrap_tag"`
+	CreatedAt         pgtype.Timestamp `json:"created_at"`
+}
+
+type SrpHandshake struct {
+	ID           pgtype.UUID      `json:"id"`
+	UserID       int32            `json:"user_id"`
+	ClientPublic []byte           `json:"client_public"`
+	ServerSecret []byte           `json:"server_secret"`
+	ServerPublic []byte           `json:"server_public"`
+	ExpiresAt    pgtype.Timestamp `json:"expires_at"`
+	CreatedAt    pgtype.Timestamp `json:"created_at"`
+}
+
+type TotpCredential struct {
+	UserID       int32            `json:"user_id"`
+	Secret       []byte           `json:"secret"`
+	ConfirmedAt  pgtype.Timestamp `json:"confirmed_at"`
+	LastUsedStep int64            `json:"last_used_step"`
+	CreatedAt    pgtype.Timestamp `json:"created_at"`
 }
 
 type User struct {
@@ -103,15 +214,16 @@ type User struct {
 }
 
 type Vault struct {
-	ID          int32            `json:"id"`
-	UserID      int32            `json:"user_id"`
-	Name        string           `json:"name"`
-	Description pgtype.Text      `json:"description"`
-	Icon        pgtype.Text      `json:"icon"`
-	Theme       pgtype.Text      `json:"theme"`
-	IsFavorite  bool             `json:"is_favorite"`
-	CreatedAt   pgtype.Timestamp `json:"created_at"`
-	UpdatedAt   pgtype.Timestamp `json:"updated_at"`
+	ID                    int32            `json:"id"`
+	UserID                int32            `json:"user_id"`
+	Name                  string           `json:"name"`
+	Description           pgtype.Text      `json:"description"`
+	Icon                  pgtype.Text      `json:"icon"`
+	Theme                 pgtype.Text      `json:"theme"`
+	IsFavorite            bool             `json:"is_favorite"`
+	CreatedAt             pgtype.Timestamp `json:"created_at"`
+	UpdatedAt             pgtype.Timestamp `json:"updated_at"`
+	RotationRecommendedAt pgtype.Timestamp `json:"rotation_recommended_at"`
 }
 
 type VaultAliasAttachment struct {
@@ -167,16 +279,40 @@ type VaultCardItem struct {
 }
 
 type VaultItem struct {
-	ID            pgtype.UUID      `json:"id"`
-	VaultID       int32            `json:"vault_id"`
-	ItemType      string           `json:"item_type"`
-	EncryptedBlob []byte           `json:"encrypted_blob"`
-	Iv            []byte           `json:"iv"`
-	Tag           []byte           `json:"tag"`
-	Meta          []byte           `json:"meta"`
-	Version       int32            `json:"version"`
-	CreatedAt     pgtype.Timestamp `json:"created_at"`
-	UpdatedAt     pgtype.Timestamp `json:"updated_at"`
+	ID             pgtype.UUID      `json:"id"`
+	VaultID        int32            `json:"vault_id"`
+	ItemType       string           `json:"item_type"`
+	EncryptedBlob  []byte           `json:"encrypted_blob"`
+	Iv             []byte           `json:"iv"`
+	Tag            []byte           `json:"tag"`
+	Meta           []byte           `json:"meta"`
+	Version        int32            `json:"version"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+	UpdatedAt      pgtype.Timestamp `json:"updated_at"`
+	VaultVersionID pgtype.Int4      `json:"vault_version_id"`
+	KeyVersion     int32            `json:"key_version"`
+}
+
+type VaultItemRevision struct {
+	ID              int32            `json:"id"`
+	ItemID          pgtype.UUID      `json:"item_id"`
+	VaultID         int32            `json:"vault_id"`
+	Version         int32            `json:"version"`
+	EncryptedBlob   []byte           `json:"encrypted_blob"`
+	Iv              []byte           `json:"iv"`
+	Tag             []byte           `json:"tag"`
+	Meta            []byte           `json:"meta"`
+	CreatedByDevice pgtype.UUID      `json:"created_by_device"`
+	CreatedAt       pgtype.Timestamp `json:"created_at"`
+	KeyVersion      int32            `json:"key_version"`
+}
+
+type VaultItemTombstone struct {
+	ItemID          pgtype.UUID      `json:"item_id"`
+	VaultID         int32            `json:"vault_id"`
+	DeletedByDevice pgtype.UUID      `json:"deleted_by_device"`
+	VaultVersionID  int32            `json:"vault_version_id"`
+	DeletedAt       pgtype.Timestamp `json:"deleted_at"`
 }
 
 type VaultKey struct {
@@ -192,6 +328,51 @@ type VaultKey struct {
 	UpdatedAt  pgtype.Timestamp `json:"updated_at"`
 }
 
+type VaultKeyRotation struct {
+	ID              pgtype.UUID      `json:"id"`
+	VaultID         int32            `json:"vault_id"`
+	FromVersion     int32            `json:"from_version"`
+	ToVersion       int32            `json:"to_version"`
+	WrappedVek      []byte           `json:"wrapped_vek"`
+	WrapIv          []byte           `json:"wrap_iv"`
+	WrapTag         []byte           `json:"wrap_tag"`
+	KdfSalt         []byte           `json:"kdf_salt"`
+	KdfParams       []byte           `json:"kdf_params"`
+	Status          string           `json:"status"`
+	StartedByDevice pgtype.UUID      `json:"started_by_device"`
+	CreatedAt       pgtype.Timestamp `json:"created_at"`
+	FinishedAt      pgtype.Timestamp `json:"finished_at"`
+}
+
+type VaultKeyRotationItem struct {
+	RotationID    pgtype.UUID      `json:"rotation_id"`
+	ItemID        pgtype.UUID      `json:"item_id"`
+	BaseVersion   int32            `json:"base_version"`
+	EncryptedBlob []byte           `json:"encrypted_blob"`
+	Iv            []byte           `json:"iv"`
+	Tag           []byte           `json:"tag"`
+	CreatedAt     pgtype.Timestamp `json:"created_at"`
+}
+
+type VaultKeyRotationShare struct {
+	RotationID      pgtype.UUID      `json:"rotation_id"`
+	SharingRecordID pgtype.UUID      `json:"sharing_record_id"`
+	WrappedKey      []byte           `json:"wrapped_key"`
+	WrapIv          []byte           `json:"wrap_iv"`
+	WrapTag         []byte           `json:"wrap_tag"`
+	CreatedAt       pgtype.Timestamp `json:"created_at"`
+}
+
+type VaultKeyRotationShareKey struct {
+	RotationID        pgtype.UUID      `json:"rotation_id"`
+	SharingRecordID   pgtype.UUID      `json:"sharing_record_id"`
+	RecipientDeviceID pgtype.UUID      `json:"recipient_device_id"`
+	WrappedKey        []byte           `json:"wrapped_key"`
+	WrapIv            []byte           `json:"wrap_iv"`
+	WrapTag           []byte           `json:"wrap_tag"`
+	CreatedAt         pgtype.Timestamp `json:"created_at"`
+}
+
 type VaultLoginAttachment struct {
 	ID          int32            `json:"id"`
 	LoginItemID int32            `json:"login_item_id"`
@@ -254,3 +435,24 @@ type VaultVersion struct {
 	CreatedByDevice pgtype.UUID      `json:"created_by_device"`
 	CreatedAt       pgtype.Timestamp `json:"created_at"`
 }
+
+type WebauthnChallenge struct {
+	ID        pgtype.UUID      `json:"id"`
+	UserID    pgtype.Int4      `json:"user_id"`
+	Ceremony  string           `json:"ceremony"`
+	Challenge []byte           `json:"challenge"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type WebauthnCredential struct {
+	ID           int32            `json:"id"`
+	UserID       int32            `json:"user_id"`
+	CredentialID []byte           `json:"credential_id"`
+	PublicKey    []byte           `json:"public_key"`
+	SignCount    int64            `json:"sign_count"`
+	Aaguid       []byte           `json:"aaguid"`
+	Name         string           `json:"name"`
+	LastUsedAt   pgtype.Timestamp `json:"last_used_at"`
+	CreatedAt    pgtype.Timestamp `json:"created_at"`
+}
@@ -20,28 +20,74 @@ type Querier interface {
 	AddLoginWebsite(ctx context.Context, arg AddLoginWebsiteParams) (VaultLoginWebsite, error)
 	// Note Attachments queries
 	AddNoteAttachment(ctx context.Context, arg AddNoteAttachmentParams) (VaultNoteAttachment, error)
+	ApplyVaultKeyRotationItems(ctx context.Context, arg ApplyVaultKeyRotationItemsParams) ([]VaultItem, error)
+	// Run after DeleteSharingRecordKeysByVaultID; the rotating device is recorded as the sender
+	ApplyVaultKeyRotationShareKeys(ctx context.Context, rotationID pgtype.UUID) (int64, error)
+	ApplyVaultKeyRotationShares(ctx context.Context, rotationID pgtype.UUID) (int64, error)
+	ApproveDevice(ctx context.Context, arg ApproveDeviceParams) (int64, error)
+	// Copies the item's current ciphertext into its history before it is overwritten
+	// or deleted. Versions are never reused, so a conflict is an error
+	ArchiveVaultItemRevision(ctx context.Context, id pgtype.UUID) error
+	// Returns 0 rows affected when the session is already bound to another device
+	BindSessionToDevice(ctx context.Context, arg BindSessionToDeviceParams) (int64, error)
+	CancelShareInvitation(ctx context.Context, id pgtype.UUID) (int64, error)
 	CheckHandleExists(ctx context.Context, arg CheckHandleExistsParams) (bool, error)
+	// Returns 'owner', the role of the user's accepted vault share, or NULL
 	CheckUserVaultAccess(ctx context.Context, arg CheckUserVaultAccessParams) (interface{}, error)
+	// Returns 0 rows affected when the device already used this nonce
+	ClaimDeviceNonce(ctx context.Context, arg ClaimDeviceNonceParams) (int64, error)
+	// Deletes the token so each link verifies at most once
+	ClaimEmailVerification(ctx context.Context, tokenHash []byte) (EmailVerification, error)
+	// Deletes the handshake so each server ephemeral answers at most one proof
+	ClaimSrpHandshake(ctx context.Context, id pgtype.UUID) (SrpHandshake, error)
+	// Returns 0 rows affected when this or a later time step was already used
+	ClaimTotpStep(ctx context.Context, arg ClaimTotpStepParams) (int64, error)
+	// Deletes the challenge so each one answers at most one ceremony
+	ClaimWebauthnChallenge(ctx context.Context, arg ClaimWebauthnChallengeParams) (WebauthnChallenge, error)
+	ClearVaultRotationRecommended(ctx context.Context, id int32) error
+	CompleteShareInvitation(ctx context.Context, arg CompleteShareInvitationParams) (int64, error)
+	ConfirmTotpCredential(ctx context.Context, arg ConfirmTotpCredentialParams) (int64, error)
 	CountActiveBlocksByPageID(ctx context.Context, pageID int32) (int64, error)
 	CountBlocksByPageID(ctx context.Context, pageID int32) (int64, error)
+	// Shares made before keys were kept per device have none
+	CountSharingRecordKeys(ctx context.Context, sharingRecordID pgtype.UUID) (int64, error)
+	// Includes revoked devices, so losing every device does not reopen first-device bootstrap
+	CountTrustedDevicesByUserID(ctx context.Context, userID int32) (int64, error)
+	CountUnusedMfaRecoveryCodes(ctx context.Context, userID int32) (int64, error)
 	CountUserPages(ctx context.Context, userID int32) (int64, error)
 	CountVaultItems(ctx context.Context, vaultID int32) (int64, error)
+	// Number of items still encrypted with each key version of the vault
+	CountVaultItemsByKeyVersion(ctx context.Context, vaultID int32) ([]CountVaultItemsByKeyVersionRow, error)
 	CountVaultVersions(ctx context.Context, vaultID int32) (int64, error)
+	CountWebauthnCredentials(ctx context.Context, userID int32) (int64, error)
 	CreateAliasItem(ctx context.Context, arg CreateAliasItemParams) (VaultAliasItem, error)
+	CreateAuditEvent(ctx context.Context, arg CreateAuditEventParams) error
 	CreateBlock(ctx context.Context, arg CreateBlockParams) (Block, error)
 	CreateCardItem(ctx context.Context, arg CreateCardItemParams) (VaultCardItem, error)
 	CreateDevice(ctx context.Context, arg CreateDeviceParams) (Device, error)
+	CreateDeviceChallenge(ctx context.Context, arg CreateDeviceChallengeParams) (DeviceChallenge, error)
+	CreateEmailVerification(ctx context.Context, arg CreateEmailVerificationParams) error
 	CreateLoginItem(ctx context.Context, arg CreateLoginItemParams) (VaultLoginItem, error)
+	CreateMfaChallenge(ctx context.Context, arg CreateMfaChallengeParams) (MfaChallenge, error)
+	CreateMfaRecoveryCode(ctx context.Context, arg CreateMfaRecoveryCodeParams) error
 	CreateNoteItem(ctx context.Context, arg CreateNoteItemParams) (VaultNoteItem, error)
 	CreatePage(ctx context.Context, arg CreatePageParams) (Page, error)
 	CreatePreferences(ctx context.Context, arg CreatePreferencesParams) (Preference, error)
+	// Returns 0 rows affected when the user already has a recovery code
+	CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) (int64, error)
 	CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error)
+	CreateShareInvitation(ctx context.Context, arg CreateShareInvitationParams) (ShareInvitation, error)
 	CreateSharingRecord(ctx context.Context, arg CreateSharingRecordParams) (SharingRecord, error)
+	CreateSrpHandshake(ctx context.Context, arg CreateSrpHandshakeParams) (SrpHandshake, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRow, error)
 	CreateVault(ctx context.Context, arg CreateVaultParams) (Vault, error)
 	CreateVaultItem(ctx context.Context, arg CreateVaultItemParams) (VaultItem, error)
+	CreateVaultItemTombstone(ctx context.Context, arg CreateVaultItemTombstoneParams) (VaultItemTombstone, error)
 	CreateVaultKey(ctx context.Context, arg CreateVaultKeyParams) (VaultKey, error)
+	CreateVaultKeyRotation(ctx context.Context, arg CreateVaultKeyRotationParams) (VaultKeyRotation, error)
 	CreateVaultVersion(ctx context.Context, arg CreateVaultVersionParams) (VaultVersion, error)
+	CreateWebauthnChallenge(ctx context.Context, arg CreateWebauthnChallengeParams) (WebauthnChallenge, error)
+	CreateWebauthnCredential(ctx context.Context, arg CreateWebauthnCredentialParams) (WebauthnCredential, error)
 	DeleteAliasAttachment(ctx context.Context, arg DeleteAliasAttachmentParams) error
 	DeleteAliasItem(ctx context.Context, arg DeleteAliasItemParams) error
 	DeleteAllAliasAttachments(ctx context.Context, aliasItemID int32) error
@@ -51,23 +97,59 @@ type Querier interface {
 	DeleteBlock(ctx context.Context, id int32) error
 	DeleteBlocksByPageID(ctx context.Context, pageID int32) error
 	DeleteCardItem(ctx context.Context, arg DeleteCardItemParams) error
-	DeleteExpiredSessions(ctx context.Context) error
+	DeleteDeviceChallenge(ctx context.Context, deviceID pgtype.UUID) error
+	DeleteDeviceNoncesBefore(ctx context.Context, createdAt pgtype.Timestamp) (int64, error)
+	DeleteExpiredDeviceChallenges(ctx context.Context) (int64, error)
+	DeleteExpiredEmailVerifications(ctx context.Context) (int64, error)
+	DeleteExpiredMfaChallenges(ctx context.Context) (int64, error)
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+	DeleteExpiredShareInvitations(ctx context.Context) (int64, error)
+	DeleteExpiredSrpHandshakes(ctx context.Context) (int64, error)
+	DeleteExpiredWebauthnChallenges(ctx context.Context) (int64, error)
 	DeleteLoginAttachment(ctx context.Context, arg DeleteLoginAttachmentParams) error
 	DeleteLoginItem(ctx context.Context, arg DeleteLoginItemParams) error
 	DeleteLoginWebsite(ctx context.Context, arg DeleteLoginWebsiteParams) error
+	DeleteMfaChallenge(ctx context.Context, tokenHash []byte) error
+	DeleteMfaRecoveryCodes(ctx context.Context, userID int32) error
 	DeleteNoteAttachment(ctx context.Context, arg DeleteNoteAttachmentParams) error
 	DeleteNoteItem(ctx context.Context, arg DeleteNoteItemParams) error
 	DeleteOldVaultVersions(ctx context.Context, arg DeleteOldVaultVersionsParams) error
+	DeleteOtherUserSessions(ctx context.Context, arg DeleteOtherUserSessionsParams) (int64, error)
 	DeletePage(ctx context.Context, id int32) error
 	DeletePreferences(ctx context.Context, id int32) error
 	DeletePreferencesByPageID(ctx context.Context, pageID int32) error
 	DeleteSession(ctx context.Context, id int32) error
+	// Ends the device's sessions and every session of its user not bound to a device,
+	// since an unbound session cannot be traced and may have been opened on it
+	DeleteSessionsForRevokedDevice(ctx context.Context, arg DeleteSessionsForRevokedDeviceParams) (int64, error)
+	DeleteSharingRecordKeysByDeviceID(ctx context.Context, recipientDeviceID pgtype.UUID) error
+	DeleteSharingRecordKeysBySharingRecordID(ctx context.Context, sharingRecordID pgtype.UUID) error
+	// Drops the device keys of the vault's shares, item shares included, once a rotation re-encrypts what they open
+	DeleteSharingRecordKeysByVaultID(ctx context.Context, vaultID int32) error
+	DeleteTotpCredential(ctx context.Context, userID int32) error
+	// A new link replaces every link sent before it
+	DeleteUserEmailVerifications(ctx context.Context, userID int32) error
+	DeleteUserSession(ctx context.Context, arg DeleteUserSessionParams) (int64, error)
 	DeleteUserSessions(ctx context.Context, userID int32) error
 	DeleteVault(ctx context.Context, arg DeleteVaultParams) error
 	DeleteVaultItem(ctx context.Context, id pgtype.UUID) error
+	DeleteVaultItemTombstone(ctx context.Context, itemID pgtype.UUID) error
+	DeleteVaultItemTombstonesBefore(ctx context.Context, deletedAt pgtype.Timestamp) (int64, error)
+	DeleteVaultKeyRotationItems(ctx context.Context, rotationID pgtype.UUID) error
+	DeleteVaultKeyRotationShareKeys(ctx context.Context, rotationID pgtype.UUID) error
+	DeleteVaultKeyRotationShares(ctx context.Context, rotationID pgtype.UUID) error
 	DeleteVaultKeys(ctx context.Context, vaultID int32) error
+	DeleteWebauthnCredential(ctx context.Context, arg DeleteWebauthnCredentialParams) (int64, error)
+	// Marks lapsed shares expired; access checks already ignore them by time
+	ExpireSharingRecords(ctx context.Context) ([]SharingRecord, error)
+	// Moves an in-progress rotation to finalized or aborted
+	FinishVaultKeyRotation(ctx context.Context, arg FinishVaultKeyRotationParams) (int64, error)
+	// Flags every vault the user owns or has been offered in full, keeping the earliest flag time
+	FlagUserVaultsForRotation(ctx context.Context, userID int32) ([]int32, error)
+	FlagVaultForRotation(ctx context.Context, id int32) error
 	GetActiveBlocksByPageID(ctx context.Context, pageID int32) ([]Block, error)
 	GetActivePagesByUserID(ctx context.Context, userID int32) ([]Page, error)
+	GetActiveVaultKeyRotation(ctx context.Context, vaultID int32) (VaultKeyRotation, error)
 	GetAliasAttachmentByID(ctx context.Context, arg GetAliasAttachmentByIDParams) (VaultAliasAttachment, error)
 	GetAliasAttachments(ctx context.Context, aliasItemID int32) ([]VaultAliasAttachment, error)
 	GetAliasItemByID(ctx context.Context, arg GetAliasItemByIDParams) (VaultAliasItem, error)
@@ -80,16 +162,29 @@ type Querier interface {
 	GetBlocksByUserID(ctx context.Context, userID int32) ([]Block, error)
 	GetCardItemByID(ctx context.Context, arg GetCardItemByIDParams) (VaultCardItem, error)
 	GetDeviceByID(ctx context.Context, id pgtype.UUID) (Device, error)
+	GetDeviceByIDIncludingRevoked(ctx context.Context, id pgtype.UUID) (Device, error)
+	GetDeviceChallenge(ctx context.Context, deviceID pgtype.UUID) (DeviceChallenge, error)
 	GetDevicePublicKeys(ctx context.Context, id pgtype.UUID) (GetDevicePublicKeysRow, error)
 	GetDevicesByUserID(ctx context.Context, userID int32) ([]Device, error)
+	// Lists the vault's live item shares that were not re-wrapped in the rotation
+	GetItemSharesMissingRotationKeys(ctx context.Context, arg GetItemSharesMissingRotationKeysParams) ([]GetItemSharesMissingRotationKeysRow, error)
+	// Highest version archived for an item, or 0 when it has no history
+	GetLatestVaultItemRevisionVersion(ctx context.Context, itemID pgtype.UUID) (int32, error)
 	GetLatestVaultVersion(ctx context.Context, vaultID int32) (VaultVersion, error)
 	GetLoginAttachmentByID(ctx context.Context, arg GetLoginAttachmentByIDParams) (VaultLoginAttachment, error)
 	GetLoginAttachments(ctx context.Context, loginItemID int32) ([]VaultLoginAttachment, error)
 	GetLoginItemByID(ctx context.Context, arg GetLoginItemByIDParams) (VaultLoginItem, error)
 	GetLoginWebsites(ctx context.Context, loginItemID int32) ([]VaultLoginWebsite, error)
+	GetMfaChallenge(ctx context.Context, tokenHash []byte) (MfaChallenge, error)
+	// Lists the active recipient devices that have no key for a live share sent by the user
+	GetMissingSharingRecordKeys(ctx context.Context, senderUserID int32) ([]GetMissingSharingRecordKeysRow, error)
 	GetNoteAttachmentByID(ctx context.Context, arg GetNoteAttachmentByIDParams) (VaultNoteAttachment, error)
 	GetNoteAttachments(ctx context.Context, noteItemID int32) ([]VaultNoteAttachment, error)
 	GetNoteItemByID(ctx context.Context, arg GetNoteItemByIDParams) (VaultNoteItem, error)
+	// Finds the unexpired pending or ready invitation of an email to a vault
+	GetOpenShareInvitation(ctx context.Context, arg GetOpenShareInvitationParams) (ShareInvitation, error)
+	// Every key version of the vaults a user owns, all wrapped with their master key
+	GetOwnedVaultKeys(ctx context.Context, userID int32) ([]VaultKey, error)
 	GetPageByHandle(ctx context.Context, handle string) (Page, error)
 	GetPageByID(ctx context.Context, id int32) (Page, error)
 	GetPagesByUserID(ctx context.Context, userID int32) ([]Page, error)
@@ -97,13 +192,21 @@ type Querier interface {
 	GetPreferencesByID(ctx context.Context, id int32) (Preference, error)
 	GetPreferencesByPageID(ctx context.Context, pageID int32) (Preference, error)
 	GetPreferencesByUserID(ctx context.Context, userID int32) ([]Preference, error)
+	GetRecoveryCodeByUserID(ctx context.Context, userID int32) (RecoveryCode, error)
 	GetSessionByToken(ctx context.Context, sessionToken string) (Session, error)
-	GetSessionsByUserID(ctx context.Context, userID int32) ([]Session, error)
+	// Leaves the tokens out and only reports which row is the caller's session
+	GetSessionsByUserID(ctx context.Context, arg GetSessionsByUserIDParams) ([]GetSessionsByUserIDRow, error)
+	GetShareInvitationByID(ctx context.Context, id pgtype.UUID) (ShareInvitation, error)
+	GetShareInvitationsByVaultID(ctx context.Context, vaultID int32) ([]ShareInvitation, error)
+	// Item shares grant only the item, so these rows never imply vault access
+	GetSharedItemsForUser(ctx context.Context, recipientUserID int32) ([]GetSharedItemsForUserRow, error)
 	GetSharedVaultsForUser(ctx context.Context, recipientUserID int32) ([]GetSharedVaultsForUserRow, error)
 	GetSharingRecordByID(ctx context.Context, id pgtype.UUID) (SharingRecord, error)
 	GetSharingRecordByVaultAndRecipient(ctx context.Context, arg GetSharingRecordByVaultAndRecipientParams) (SharingRecord, error)
+	GetSharingRecordKey(ctx context.Context, arg GetSharingRecordKeyParams) (SharingRecordKey, error)
 	GetSharingRecordsByRecipientID(ctx context.Context, recipientUserID int32) ([]SharingRecord, error)
 	GetSharingRecordsByVaultID(ctx context.Context, vaultID int32) ([]SharingRecord, error)
+	GetTotpCredential(ctx context.Context, userID int32) (TotpCredential, error)
 	GetUserAliasItems(ctx context.Context, userID int32) ([]VaultAliasItem, error)
 	GetUserByEmail(ctx context.Context, email string) (GetUserByEmailRow, error)
 	GetUserByID(ctx context.Context, id int32) (GetUserByIDRow, error)
@@ -112,27 +215,55 @@ type Querier interface {
 	GetUserLoginItems(ctx context.Context, userID int32) ([]VaultLoginItem, error)
 	GetUserMostRecentPage(ctx context.Context, userID int32) (Page, error)
 	GetUserNoteItems(ctx context.Context, userID int32) ([]VaultNoteItem, error)
+	GetUserSrpCredentials(ctx context.Context, email string) (GetUserSrpCredentialsRow, error)
 	GetUserVaults(ctx context.Context, userID int32) ([]GetUserVaultsRow, error)
 	GetVaultAliasItems(ctx context.Context, arg GetVaultAliasItemsParams) ([]VaultAliasItem, error)
 	GetVaultByID(ctx context.Context, id int32) (Vault, error)
 	GetVaultByIDAndUserID(ctx context.Context, arg GetVaultByIDAndUserIDParams) (Vault, error)
 	GetVaultCardItems(ctx context.Context, arg GetVaultCardItemsParams) ([]VaultCardItem, error)
 	GetVaultItemByID(ctx context.Context, id pgtype.UUID) (VaultItem, error)
+	GetVaultItemRevision(ctx context.Context, arg GetVaultItemRevisionParams) (VaultItemRevision, error)
+	GetVaultItemRevisions(ctx context.Context, arg GetVaultItemRevisionsParams) ([]GetVaultItemRevisionsRow, error)
+	GetVaultItemTombstonesSinceVersion(ctx context.Context, arg GetVaultItemTombstonesSinceVersionParams) ([]VaultItemTombstone, error)
+	GetVaultItemVersionsByVaultID(ctx context.Context, vaultID int32) ([]GetVaultItemVersionsByVaultIDRow, error)
 	GetVaultItemsByIDs(ctx context.Context, dollar_1 []pgtype.UUID) ([]VaultItem, error)
 	GetVaultItemsByVaultID(ctx context.Context, vaultID int32) ([]VaultItem, error)
 	GetVaultItemsByVaultIDAndType(ctx context.Context, arg GetVaultItemsByVaultIDAndTypeParams) ([]VaultItem, error)
+	GetVaultItemsChangedSince(ctx context.Context, arg GetVaultItemsChangedSinceParams) ([]VaultItem, error)
 	GetVaultKeyByVaultID(ctx context.Context, vaultID int32) (VaultKey, error)
 	GetVaultKeyByVaultIDAndVersion(ctx context.Context, arg GetVaultKeyByVaultIDAndVersionParams) (VaultKey, error)
+	GetVaultKeyRotation(ctx context.Context, arg GetVaultKeyRotationParams) (VaultKeyRotation, error)
+	// Only staged items still at their re-encrypted version and pending or accepted vault shares with a staged key count as done
+	GetVaultKeyRotationProgress(ctx context.Context, arg GetVaultKeyRotationProgressParams) (GetVaultKeyRotationProgressRow, error)
 	GetVaultLoginItems(ctx context.Context, arg GetVaultLoginItemsParams) ([]VaultLoginItem, error)
 	GetVaultNoteItems(ctx context.Context, arg GetVaultNoteItemsParams) ([]VaultNoteItem, error)
 	GetVaultVersionByID(ctx context.Context, id int32) (VaultVersion, error)
 	GetVaultVersionByIDAndVault(ctx context.Context, arg GetVaultVersionByIDAndVaultParams) (VaultVersion, error)
 	GetVaultVersionsByVaultID(ctx context.Context, arg GetVaultVersionsByVaultIDParams) ([]VaultVersion, error)
 	GetVaultVersionsSinceID(ctx context.Context, arg GetVaultVersionsSinceIDParams) ([]VaultVersion, error)
+	GetWebauthnCredentialByCredentialID(ctx context.Context, credentialID []byte) (WebauthnCredential, error)
+	ListWebauthnCredentials(ctx context.Context, userID int32) ([]WebauthnCredential, error)
+	LockUserVaultsForUpdate(ctx context.Context, userID int32) ([]int32, error)
+	LockVaultForUpdate(ctx context.Context, id int32) (int32, error)
+	LockVaultItemsForUpdate(ctx context.Context, arg LockVaultItemsForUpdateParams) ([]VaultItem, error)
+	MarkDeviceVerified(ctx context.Context, arg MarkDeviceVerifiedParams) (int64, error)
+	// Binds the user's unexpired pending invitations to them once they have an active
+	// device. Only a verified email proves the user owns the address invited
+	MarkShareInvitationsReady(ctx context.Context, id int32) ([]ShareInvitation, error)
+	// Only verifies the address the link was sent to, in case the email changed since
+	MarkUserEmailVerified(ctx context.Context, arg MarkUserEmailVerifiedParams) (int64, error)
+	// Counts a verification attempt against an unexpired challenge
+	RecordMfaChallengeAttempt(ctx context.Context, tokenHash []byte) (MfaChallenge, error)
+	// Counts a second-factor attempt for the user across all pending logins. The
+	// count starts over once its window began before window_start
+	RecordMfaUserAttempt(ctx context.Context, arg RecordMfaUserAttemptParams) (int32, error)
 	RejectSharingRecord(ctx context.Context, id pgtype.UUID) (SharingRecord, error)
 	ReorderBlocks(ctx context.Context, arg ReorderBlocksParams) error
+	ResetMfaUserAttempts(ctx context.Context, userID int32) error
 	RevokeDevice(ctx context.Context, id pgtype.UUID) error
-	RevokeSharingRecord(ctx context.Context, id pgtype.UUID) error
+	// Only live shares can be revoked; others report no rows affected
+	RevokeSharingRecord(ctx context.Context, id pgtype.UUID) (int64, error)
+	RotateSessionToken(ctx context.Context, arg RotateSessionTokenParams) (int64, error)
 	SearchAliasItems(ctx context.Context, arg SearchAliasItemsParams) ([]VaultAliasItem, error)
 	SearchCardItems(ctx context.Context, arg SearchCardItemsParams) ([]VaultCardItem, error)
 	SearchLoginItems(ctx context.Context, arg SearchLoginItemsParams) ([]VaultLoginItem, error)
@@ -143,6 +274,8 @@ type Querier interface {
 	ToggleLoginItemFavorite(ctx context.Context, arg ToggleLoginItemFavoriteParams) (VaultLoginItem, error)
 	ToggleNoteItemFavorite(ctx context.Context, arg ToggleNoteItemFavoriteParams) (VaultNoteItem, error)
 	ToggleVaultFavorite(ctx context.Context, arg ToggleVaultFavoriteParams) (Vault, error)
+	// Slides the expiry forward; callers cap it at the session's absolute max age
+	TouchSession(ctx context.Context, arg TouchSessionParams) error
 	UpdateAliasItem(ctx context.Context, arg UpdateAliasItemParams) (VaultAliasItem, error)
 	UpdateAliasItemLastUsed(ctx context.Context, arg UpdateAliasItemLastUsedParams) error
 	UpdateBlock(ctx context.Context, arg UpdateBlockParams) (Block, error)
@@ -158,11 +291,31 @@ type Querier interface {
 	UpdatePreferences(ctx context.Context, arg UpdatePreferencesParams) (Preference, error)
 	UpdatePreferencesByPageID(ctx context.Context, arg UpdatePreferencesByPageIDParams) (Preference, error)
 	UpdateSessionWithActivePage(ctx context.Context, arg UpdateSessionWithActivePageParams) (Session, error)
+	// A share that has already lapsed stays lapsed, even before ExpireSharingRecords marks it
+	UpdateSharingRecordExpiry(ctx context.Context, arg UpdateSharingRecordExpiryParams) (SharingRecord, error)
+	UpdateSharingRecordRole(ctx context.Context, arg UpdateSharingRecordRoleParams) (SharingRecord, error)
+	UpdateUserCredentials(ctx context.Context, arg UpdateUserCredentialsParams) (int64, error)
 	UpdateUserEmailVerified(ctx context.Context, arg UpdateUserEmailVerifiedParams) error
 	UpdateVault(ctx context.Context, arg UpdateVaultParams) (Vault, error)
+	// Only applies while the item is still at expected_version; no row means another write won
 	UpdateVaultItem(ctx context.Context, arg UpdateVaultItemParams) (VaultItem, error)
 	UpdateVaultKey(ctx context.Context, arg UpdateVaultKeyParams) (VaultKey, error)
+	UpdateVaultKeyWrap(ctx context.Context, arg UpdateVaultKeyWrapParams) (int64, error)
+	UpdateVaultVersionMac(ctx context.Context, arg UpdateVaultVersionMacParams) error
+	UpdateWebauthnCredentialUsage(ctx context.Context, arg UpdateWebauthnCredentialUsageParams) error
+	// Moves a password account to SRP login once, clearing the bcrypt hash in the same write
+	UpgradeUserToSrp(ctx context.Context, arg UpgradeUserToSrpParams) (int64, error)
 	UpsertPreferences(ctx context.Context, arg UpsertPreferencesParams) (Preference, error)
+	UpsertRecoveryCode(ctx context.Context, arg UpsertRecoveryCodeParams) error
+	UpsertSharingRecordKey(ctx context.Context, arg UpsertSharingRecordKeyParams) error
+	// Replaces an unconfirmed secret; returns no rows when 2FA is already confirmed
+	UpsertTotpCredential(ctx context.Context, arg UpsertTotpCredentialParams) (TotpCredential, error)
+	UpsertVaultKeyRotationItem(ctx context.Context, arg UpsertVaultKeyRotationItemParams) error
+	UpsertVaultKeyRotationShare(ctx context.Context, arg UpsertVaultKeyRotationShareParams) error
+	UpsertVaultKeyRotationShareKey(ctx context.Context, arg UpsertVaultKeyRotationShareKeyParams) error
+	UseMfaRecoveryCode(ctx context.Context, arg UseMfaRecoveryCodeParams) (int64, error)
+	// Whether the user owns, sent or received any live vault or item share
+	UserHasActiveShares(ctx context.Context, senderUserID int32) (bool, error)
 }
 
 var _ Querier = (*Queries)(nil)
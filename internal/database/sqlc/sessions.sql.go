@@ -11,20 +11,48 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const bindSessionToDevice = `-- name: BindSessionToDevice :execrows
+UPDATE sessions
+SET device_id = $2, updated_at = NOW()
+WHERE session_token = $1 AND (device_id IS NULL OR device_id = $2)
+`
+
+type BindSessionToDeviceParams struct {
+	SessionToken string      `json:"session_token"`
+	DeviceID     pgtype.UUID `json:"device_id"`
+}
+
+// Returns 0 rows affected when the session is already bound to another device
+func (q *Queries) BindSessionToDevice(ctx context.Context, arg BindSessionToDeviceParams) (int64, error) {
+	result, err := q.db.Exec(ctx, bindSessionToDevice, arg.SessionToken, arg.DeviceID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const createSession = `-- name: CreateSession :one
-INSERT INTO sessions (user_id, session_token, expires_at)
-VALUES ($1, $2, $3)
-RETURNING id, user_id, session_token, expires_at, created_at, updated_at, active_page_id
+INSERT INTO sessions (user_id, session_token, expires_at, user_agent, ip_address)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, user_id, session_token, expires_at, created_at, updated_at, active_page_id, device_id, user_agent, ip_address, last_active_at
 `
 
 type CreateSessionParams struct {
 	UserID       int32            `json:"user_id"`
 	SessionToken string           `json:"session_token"`
 	ExpiresAt    pgtype.Timestamp `json:"expires_at"`
+	UserAgent    string           `json:"user_agent"`
+	IpAddress    string           `json:"ip_address"`
 }
 
 func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error) {
-	row := q.db.QueryRow(ctx, createSession, arg.UserID, arg.SessionToken, arg.ExpiresAt)
+	row := q.db.QueryRow(ctx, createSession,
+		arg.UserID,
+		arg.SessionToken,
+		arg.ExpiresAt,
+		arg.UserAgent,
+		arg.IpAddress,
+	)
 	var i Session
 	err := row.Scan(
 		&i.ID,
@@ -34,18 +62,43 @@ func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (S
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ActivePageID,
+		&i.DeviceID,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastActiveAt,
 	)
 	return i, err
 }
 
-const deleteExpiredSessions = `-- name: DeleteExpiredSessions :exec
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :execrows
 DELETE FROM sessions
 WHERE expires_at < NOW()
 `
 
-func (q *Queries) DeleteExpiredSessions(ctx context.Context) error {
-	_, err := q.db.Exec(ctx, deleteExpiredSessions)
-	return err
+func (q *Queries) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredSessions)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteOtherUserSessions = `-- name: DeleteOtherUserSessions :execrows
+DELETE FROM sessions
+WHERE user_id = $1 AND id <> $2
+`
+
+type DeleteOtherUserSessionsParams struct {
+	UserID int32 `json:"user_id"`
+	ID     int32 `json:"id"`
+}
+
+func (q *Queries) DeleteOtherUserSessions(ctx context.Context, arg DeleteOtherUserSessionsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOtherUserSessions, arg.UserID, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
 const deleteSession = `-- name: DeleteSession :exec
@@ -58,6 +111,44 @@ func (q *Queries) DeleteSession(ctx context.Context, id int32) error {
 	return err
 }
 
+const deleteSessionsForRevokedDevice = `-- name: DeleteSessionsForRevokedDevice :execrows
+DELETE FROM sessions
+WHERE user_id = $1 AND (device_id = $2 OR device_id IS NULL)
+`
+
+type DeleteSessionsForRevokedDeviceParams struct {
+	UserID   int32       `json:"user_id"`
+	DeviceID pgtype.UUID `json:"device_id"`
+}
+
+// Ends the device's sessions and every session of its user not bound to a device,
+// since an unbound session cannot be traced and may have been opened on it
+func (q *Queries) DeleteSessionsForRevokedDevice(ctx context.Context, arg DeleteSessionsForRevokedDeviceParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteSessionsForRevokedDevice, arg.UserID, arg.DeviceID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteUserSession = `-- name: DeleteUserSession :execrows
+DELETE FROM sessions
+WHERE id = $1 AND user_id = $2
+`
+
+type DeleteUserSessionParams struct {
+	ID     int32 `json:"id"`
+	UserID int32 `json:"user_id"`
+}
+
+func (q *Queries) DeleteUserSession(ctx context.Context, arg DeleteUserSessionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUserSession, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteUserSessions = `-- name: DeleteUserSessions :exec
 DELETE FROM sessions
 WHERE user_id = $1
@@ -69,7 +160,7 @@ func (q *Queries) DeleteUserSessions(ctx context.Context, userID int32) error {
 }
 
 const getSessionByToken = `-- name: GetSessionByToken :one
-SELECT id, user_id, session_token, expires_at, created_at, updated_at, active_page_id FROM sessions
+SELECT id, user_id, session_token, expires_at, created_at, updated_at, active_page_id, device_id, user_agent, ip_address, last_active_at FROM sessions
 WHERE session_token = $1
 `
 
@@ -84,33 +175,57 @@ func (q *Queries) GetSessionByToken(ctx context.Context, sessionToken string) (S
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ActivePageID,
+		&i.DeviceID,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastActiveAt,
 	)
 	return i, err
 }
 
 const getSessionsByUserID = `-- name: GetSessionsByUserID :many
-SELECT id, user_id, session_token, expires_at, created_at, updated_at, active_page_id FROM sessions
-WHERE user_id = $1
+SELECT id, device_id, user_agent, ip_address, created_at, last_active_at, expires_at,
+    (session_token = $1::text) AS current
+FROM sessions
+WHERE user_id = $2
 ORDER BY created_at DESC
 `
 
-func (q *Queries) GetSessionsByUserID(ctx context.Context, userID int32) ([]Session, error) {
-	rows, err := q.db.Query(ctx, getSessionsByUserID, userID)
+type GetSessionsByUserIDParams struct {
+	CurrentToken string `json:"current_token"`
+	UserID       int32  `json:"user_id"`
+}
+
+type GetSessionsByUserIDRow struct {
+	ID           int32            `json:"id"`
+	DeviceID     pgtype.UUID      `json:"device_id"`
+	UserAgent    string           `json:"user_agent"`
+	IpAddress    string           `json:"ip_address"`
+	CreatedAt    pgtype.Timestamp `json:"created_at"`
+	LastActiveAt pgtype.Timestamp `json:"last_active_at"`
+	ExpiresAt    pgtype.Timestamp `json:"expires_at"`
+	Current      bool             `json:"current"`
+}
+
+// Leaves the tokens out and only reports which row is the caller's session
+func (q *Queries) GetSessionsByUserID(ctx context.Context, arg GetSessionsByUserIDParams) ([]GetSessionsByUserIDRow, error) {
+	rows, err := q.db.Query(ctx, getSessionsByUserID, arg.CurrentToken, arg.UserID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []Session{}
+	items := []GetSessionsByUserIDRow{}
 	for rows.Next() {
-		var i Session
+		var i GetSessionsByUserIDRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.UserID,
-			&i.SessionToken,
-			&i.ExpiresAt,
+			&i.DeviceID,
+			&i.UserAgent,
+			&i.IpAddress,
 			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.ActivePageID,
+			&i.LastActiveAt,
+			&i.ExpiresAt,
+			&i.Current,
 		); err != nil {
 			return nil, err
 		}
@@ -122,11 +237,48 @@ func (q *Queries) GetSessionsByUserID(ctx context.Context, userID int32) ([]Sess
 	return items, nil
 }
 
+const rotateSessionToken = `-- name: RotateSessionToken :execrows
+UPDATE sessions
+SET session_token = $1, updated_at = NOW()
+WHERE session_token = $2
+`
+
+type RotateSessionTokenParams struct {
+	NewToken string `json:"new_token"`
+	OldToken string `json:"old_token"`
+}
+
+func (q *Queries) RotateSessionToken(ctx context.Context, arg RotateSessionTokenParams) (int64, error) {
+	result, err := q.db.Exec(ctx, rotateSessionToken, arg.NewToken, arg.OldToken)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const touchSession = `-- name: TouchSession :exec
+UPDATE sessions
+SET last_active_at = NOW(), expires_at = $2, ip_address = $3
+WHERE id = $1
+`
+
+type TouchSessionParams struct {
+	ID        int32            `json:"id"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+	IpAddress string           `json:"ip_address"`
+}
+
+// Slides the expiry forward; callers cap it at the session's absolute max age
+func (q *Queries) TouchSession(ctx context.Context, arg TouchSessionParams) error {
+	_, err := q.db.Exec(ctx, touchSession, arg.ID, arg.ExpiresAt, arg.IpAddress)
+	return err
+}
+
 const updateSessionWithActivePage = `-- name: UpdateSessionWithActivePage :one
 UPDATE sessions
 SET active_page_id = $2, updated_at = NOW()
 WHERE id = $1
-RETURNING id, user_id, session_token, expires_at, created_at, updated_at, active_page_id
+RETURNING id, user_id, session_token, expires_at, created_at, updated_at, active_page_id, device_id, user_agent, ip_address, last_active_at
 `
 
 type UpdateSessionWithActivePageParams struct {
@@ -145,6 +297,10 @@ func (q *Queries) UpdateSessionWithActivePage(ctx context.Context, arg UpdateSes
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ActivePageID,
+		&i.DeviceID,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastActiveAt,
 	)
 	return i, err
 }
@@ -14,8 +14,8 @@ import (
 const acceptSharingRecord = `-- name: AcceptSharingRecord :one
 UPDATE sharing_records
 SET status = 'accepted', accepted_at = NOW()
-WHERE id = $1 AND status = 'pending'
-RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at
+WHERE id = $1 AND status = 'pending' AND (expires_at IS NULL OR expires_at > NOW())
+RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
 `
 
 func (q *Queries) AcceptSharingRecord(ctx context.Context, id pgtype.UUID) (SharingRecord, error) {
@@ -33,21 +33,27 @@ func (q *Queries) AcceptSharingRecord(ctx context.Context, id pgtype.UUID) (Shar
 		&i.Status,
 		&i.CreatedAt,
 		&i.AcceptedAt,
+		&i.Role,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
 const checkUserVaultAccess = `-- name: CheckUserVaultAccess :one
-SELECT 
-    CASE 
+SELECT
+    CASE
         WHEN v.user_id = $2 THEN 'owner'::TEXT
-        WHEN sr.recipient_user_id = $2 AND sr.status = 'accepted' THEN 'shared'::TEXT
-        ELSE NULL
-    END as access_level
+        ELSE (
+            SELECT sr.role::TEXT FROM sharing_records sr
+            WHERE sr.vault_id = v.id AND sr.recipient_user_id = $2
+              AND sr.status = 'accepted' AND sr.item_id IS NULL
+              AND (sr.expires_at IS NULL OR sr.expires_at > NOW())
+            ORDER BY CASE sr.role WHEN 'manager' THEN 3 WHEN 'editor' THEN 2 ELSE 1 END DESC
+            LIMIT 1
+        )
+    END AS access_level
 FROM vaults v
-LEFT JOIN sharing_records sr ON v.id = sr.vault_id AND sr.recipient_user_id = $2
 WHERE v.id = $1
-LIMIT 1
 `
 
 type CheckUserVaultAccessParams struct {
@@ -55,6 +61,7 @@ type CheckUserVaultAccessParams struct {
 	UserID int32 `json:"user_id"`
 }
 
+// Returns 'owner', the role of the user's accepted vault share, or NULL
 func (q *Queries) CheckUserVaultAccess(ctx context.Context, arg CheckUserVaultAccessParams) (interface{}, error) {
 	row := q.db.QueryRow(ctx, checkUserVaultAccess, arg.ID, arg.UserID)
 	var access_level interface{}
@@ -71,22 +78,26 @@ INSERT INTO sharing_records (
     wrapped_key,
     wrap_iv,
     wrap_tag,
-    status
+    status,
+    role,
+    expires_at
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
 )
-RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at
+RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
 `
 
 type CreateSharingRecordParams struct {
-	VaultID         int32       `json:"vault_id"`
-	ItemID          pgtype.UUID `json:"item_id"`
-	SenderUserID    int32       `json:"sender_user_id"`
-	RecipientUserID int32       `json:"recipient_user_id"`
-	WrappedKey      []byte      `json:"wrapped_key"`
-	WrapIv          []byte      `json:"wrap_iv"`
-	WrapTag         []byte      `json:"wrap_tag"`
-	Status          string      `json:"status"`
+	VaultID         int32            `json:"vault_id"`
+	ItemID          pgtype.UUID      `json:"item_id"`
+	SenderUserID    int32            `json:"sender_user_id"`
+	RecipientUserID int32            `json:"recipient_user_id"`
+	WrappedKey      []byte           `json:"wrapped_key"`
+	WrapIv          []byte           `json:"wrap_iv"`
+	WrapTag         []byte           `json:"wrap_tag"`
+	Status          string           `json:"status"`
+	Role            string           `json:"role"`
+	ExpiresAt       pgtype.Timestamp `json:"expires_at"`
 }
 
 func (q *Queries) CreateSharingRecord(ctx context.Context, arg CreateSharingRecordParams) (SharingRecord, error) {
@@ -99,6 +110,8 @@ func (q *Queries) CreateSharingRecord(ctx context.Context, arg CreateSharingReco
 		arg.WrapIv,
 		arg.WrapTag,
 		arg.Status,
+		arg.Role,
+		arg.ExpiresAt,
 	)
 	var i SharingRecord
 	err := row.Scan(
@@ -113,14 +126,58 @@ func (q *Queries) CreateSharingRecord(ctx context.Context, arg CreateSharingReco
 		&i.Status,
 		&i.CreatedAt,
 		&i.AcceptedAt,
+		&i.Role,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
+const expireSharingRecords = `-- name: ExpireSharingRecords :many
+UPDATE sharing_records
+SET status = 'expired'
+WHERE status IN ('pending', 'accepted') AND expires_at <= NOW()
+RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
+`
+
+// Marks lapsed shares expired; access checks already ignore them by time
+func (q *Queries) ExpireSharingRecords(ctx context.Context) ([]SharingRecord, error) {
+	rows, err := q.db.Query(ctx, expireSharingRecords)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []SharingRecord{}
+	for rows.Next() {
+		var i SharingRecord
+		if err := rows.Scan(
+			&i.ID,
+			&i.VaultID,
+			&i.ItemID,
+			&i.SenderUserID,
+			&i.RecipientUserID,
+			&i.WrappedKey,
+			&i.WrapIv,
+			&i.WrapTag,
+			&i.Status,
+			&i.CreatedAt,
+			&i.AcceptedAt,
+			&i.Role,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getPendingSharingRecordsByRecipientID = `-- name: GetPendingSharingRecordsByRecipientID :many
-SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at
+SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
 FROM sharing_records
-WHERE recipient_user_id = $1 AND status = 'pending'
+WHERE recipient_user_id = $1 AND status = 'pending' AND (expires_at IS NULL OR expires_at > NOW())
 ORDER BY created_at DESC
 `
 
@@ -145,6 +202,67 @@ func (q *Queries) GetPendingSharingRecordsByRecipientID(ctx context.Context, rec
 			&i.Status,
 			&i.CreatedAt,
 			&i.AcceptedAt,
+			&i.Role,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSharedItemsForUser = `-- name: GetSharedItemsForUser :many
+SELECT sr.id, sr.vault_id, sr.item_id, sr.sender_user_id, sr.status, sr.created_at, sr.accepted_at, sr.expires_at,
+       vi.item_type, vi.meta, vi.version, vi.updated_at
+FROM sharing_records sr
+INNER JOIN vault_items vi ON vi.id = sr.item_id
+WHERE sr.recipient_user_id = $1 AND sr.status IN ('pending', 'accepted')
+  AND (sr.expires_at IS NULL OR sr.expires_at > NOW())
+ORDER BY sr.created_at DESC
+`
+
+type GetSharedItemsForUserRow struct {
+	ID           pgtype.UUID      `json:"id"`
+	VaultID      int32            `json:"vault_id"`
+	ItemID       pgtype.UUID      `json:"item_id"`
+	SenderUserID int32            `json:"sender_user_id"`
+	Status       string           `json:"status"`
+	CreatedAt    pgtype.Timestamp `json:"created_at"`
+	AcceptedAt   pgtype.Timestamp `json:"accepted_at"`
+	ExpiresAt    pgtype.Timestamp `json:"expires_at"`
+	ItemType     string           `json:"item_type"`
+	Meta         []byte           `json:"meta"`
+	Version      int32            `json:"version"`
+	UpdatedAt    pgtype.Timestamp `json:"updated_at"`
+}
+
+// Item shares grant only the item, so these rows never imply vault access
+func (q *Queries) GetSharedItemsForUser(ctx context.Context, recipientUserID int32) ([]GetSharedItemsForUserRow, error) {
+	rows, err := q.db.Query(ctx, getSharedItemsForUser, recipientUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetSharedItemsForUserRow{}
+	for rows.Next() {
+		var i GetSharedItemsForUserRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.VaultID,
+			&i.ItemID,
+			&i.SenderUserID,
+			&i.Status,
+			&i.CreatedAt,
+			&i.AcceptedAt,
+			&i.ExpiresAt,
+			&i.ItemType,
+			&i.Meta,
+			&i.Version,
+			&i.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -160,7 +278,8 @@ const getSharedVaultsForUser = `-- name: GetSharedVaultsForUser :many
 SELECT DISTINCT v.id, v.user_id, v.name, v.created_at, v.updated_at
 FROM vaults v
 INNER JOIN sharing_records sr ON v.id = sr.vault_id
-WHERE sr.recipient_user_id = $1 AND sr.status = 'accepted'
+WHERE sr.recipient_user_id = $1 AND sr.status = 'accepted' AND sr.item_id IS NULL
+  AND (sr.expires_at IS NULL OR sr.expires_at > NOW())
 ORDER BY v.created_at DESC
 `
 
@@ -199,7 +318,7 @@ func (q *Queries) GetSharedVaultsForUser(ctx context.Context, recipientUserID in
 }
 
 const getSharingRecordByID = `-- name: GetSharingRecordByID :one
-SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at
+SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
 FROM sharing_records
 WHERE id = $1
 `
@@ -219,14 +338,17 @@ func (q *Queries) GetSharingRecordByID(ctx context.Context, id pgtype.UUID) (Sha
 		&i.Status,
 		&i.CreatedAt,
 		&i.AcceptedAt,
+		&i.Role,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
 const getSharingRecordByVaultAndRecipient = `-- name: GetSharingRecordByVaultAndRecipient :one
-SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at
+SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
 FROM sharing_records
-WHERE vault_id = $1 AND recipient_user_id = $2 AND status = 'accepted'
+WHERE vault_id = $1 AND recipient_user_id = $2 AND status = 'accepted' AND item_id IS NULL
+  AND (expires_at IS NULL OR expires_at > NOW())
 `
 
 type GetSharingRecordByVaultAndRecipientParams struct {
@@ -249,12 +371,14 @@ func (q *Queries) GetSharingRecordByVaultAndRecipient(ctx context.Context, arg G
 		&i.Status,
 		&i.CreatedAt,
 		&i.AcceptedAt,
+		&i.Role,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
 const getSharingRecordsByRecipientID = `-- name: GetSharingRecordsByRecipientID :many
-SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at
+SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
 FROM sharing_records
 WHERE recipient_user_id = $1
 ORDER BY created_at DESC
@@ -281,6 +405,8 @@ func (q *Queries) GetSharingRecordsByRecipientID(ctx context.Context, recipientU
 			&i.Status,
 			&i.CreatedAt,
 			&i.AcceptedAt,
+			&i.Role,
+			&i.ExpiresAt,
 		); err != nil {
 			return nil, err
 		}
@@ -293,9 +419,10 @@ func (q *Queries) GetSharingRecordsByRecipientID(ctx context.Context, recipientU
 }
 
 const getSharingRecordsByVaultID = `-- name: GetSharingRecordsByVaultID :many
-SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at
+SELECT id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
 FROM sharing_records
-WHERE vault_id = $1 AND status = 'accepted'
+WHERE vault_id = $1 AND status = 'accepted' AND item_id IS NULL
+  AND (expires_at IS NULL OR expires_at > NOW())
 ORDER BY created_at DESC
 `
 
@@ -320,6 +447,8 @@ func (q *Queries) GetSharingRecordsByVaultID(ctx context.Context, vaultID int32)
 			&i.Status,
 			&i.CreatedAt,
 			&i.AcceptedAt,
+			&i.Role,
+			&i.ExpiresAt,
 		); err != nil {
 			return nil, err
 		}
@@ -335,7 +464,7 @@ const rejectSharingRecord = `-- name: RejectSharingRecord :one
 UPDATE sharing_records
 SET status = 'rejected'
 WHERE id = $1 AND status = 'pending'
-RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at
+RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
 `
 
 func (q *Queries) RejectSharingRecord(ctx context.Context, id pgtype.UUID) (SharingRecord, error) {
@@ -353,17 +482,109 @@ func (q *Queries) RejectSharingRecord(ctx context.Context, id pgtype.UUID) (Shar
 		&i.Status,
 		&i.CreatedAt,
 		&i.AcceptedAt,
+		&i.Role,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
-const revokeSharingRecord = `-- name: RevokeSharingRecord :exec
+const revokeSharingRecord = `-- name: RevokeSharingRecord :execrows
 UPDATE sharing_records
 SET status = 'revoked'
-WHERE id = $1
+WHERE id = $1 AND status IN ('pending', 'accepted')
+`
+
+// Only live shares can be revoked; others report no rows affected
+func (q *Queries) RevokeSharingRecord(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeSharingRecord, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateSharingRecordExpiry = `-- name: UpdateSharingRecordExpiry :one
+UPDATE sharing_records
+SET expires_at = $2
+WHERE id = $1 AND status IN ('pending', 'accepted')
+  AND (expires_at IS NULL OR expires_at > NOW())
+RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
+`
+
+type UpdateSharingRecordExpiryParams struct {
+	ID        pgtype.UUID      `json:"id"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+}
+
+// A share that has already lapsed stays lapsed, even before ExpireSharingRecords marks it
+func (q *Queries) UpdateSharingRecordExpiry(ctx context.Context, arg UpdateSharingRecordExpiryParams) (SharingRecord, error) {
+	row := q.db.QueryRow(ctx, updateSharingRecordExpiry, arg.ID, arg.ExpiresAt)
+	var i SharingRecord
+	err := row.Scan(
+		&i.ID,
+		&i.VaultID,
+		&i.ItemID,
+		&i.SenderUserID,
+		&i.RecipientUserID,
+		&i.WrappedKey,
+		&i.WrapIv,
+		&i.WrapTag,
+		&i.Status,
+		&i.CreatedAt,
+		&i.AcceptedAt,
+		&i.Role,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const updateSharingRecordRole = `-- name: UpdateSharingRecordRole :one
+UPDATE sharing_records
+SET role = $2
+WHERE id = $1 AND item_id IS NULL AND status IN ('pending', 'accepted')
+RETURNING id, vault_id, item_id, sender_user_id, recipient_user_id, wrapped_key, wrap_iv, wrap_tag, status, created_at, accepted_at, role, expires_at
+`
+
+type UpdateSharingRecordRoleParams struct {
+	ID   pgtype.UUID `json:"id"`
+	Role string      `json:"role"`
+}
+
+func (q *Queries) UpdateSharingRecordRole(ctx context.Context, arg UpdateSharingRecordRoleParams) (SharingRecord, error) {
+	row := q.db.QueryRow(ctx, updateSharingRecordRole, arg.ID, arg.Role)
+	var i SharingRecord
+	err := row.Scan(
+		&i.ID,
+		&i.VaultID,
+		&i.ItemID,
+		&i.SenderUserID,
+		&i.RecipientUserID,
+		&i.WrappedKey,
+		&i.WrapIv,
+		&i.WrapTag,
+		&i.Status,
+		&i.CreatedAt,
+		&i.AcceptedAt,
+		&i.Role,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const userHasActiveShares = `-- name: UserHasActiveShares :one
+SELECT EXISTS (
+    SELECT 1 FROM sharing_records
+    WHERE (sender_user_id = $1 OR recipient_user_id = $1
+           OR vault_id IN (SELECT id FROM vaults WHERE user_id = $1))
+      AND status IN ('pending', 'accepted')
+      AND (expires_at IS NULL OR expires_at > NOW())
+) AS has_shares
 `
 
-func (q *Queries) RevokeSharingRecord(ctx context.Context, id pgtype.UUID) error {
-	_, err := q.db.Exec(ctx, revokeSharingRecord, id)
-	return err
+// Whether the user owns, sent or received any live vault or item share
+func (q *Queries) UserHasActiveShares(ctx context.Context, senderUserID int32) (bool, error) {
+	row := q.db.QueryRow(ctx, userHasActiveShares, senderUserID)
+	var has_shares bool
+	err := row.Scan(&has_shares)
+	return has_shares, err
 }
@@ -12,8 +12,8 @@ import (
 )
 
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (username, email, password_hash, email_verified, image)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO users (username, email, password_hash, email_verified, image, srp_salt, srp_verifier, kdf_salt, kdf_params)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 RETURNING id, username, email, email_verified, image, created_at, updated_at
 `
 
@@ -23,6 +23,10 @@ type CreateUserParams struct {
 	PasswordHash  string `json:"password_hash"`
 	EmailVerified bool   `json:"email_verified"`
 	Image         string `json:"image"`
+	SrpSalt       []byte `json:"srp_salt"`
+	SrpVerifier   []byte `json:"srp_verifier"`
+	KdfSalt       []byte `json:"kdf_salt"`
+	KdfParams     []byte `json:"kdf_params"`
 }
 
 type CreateUserRow struct {
@@ -42,6 +46,10 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateU
 		arg.PasswordHash,
 		arg.EmailVerified,
 		arg.Image,
+		arg.SrpSalt,
+		arg.SrpVerifier,
+		arg.KdfSalt,
+		arg.KdfParams,
 	)
 	var i CreateUserRow
 	err := row.Scan(
@@ -120,6 +128,83 @@ func (q *Queries) GetUserByID(ctx context.Context, id int32) (GetUserByIDRow, er
 	return i, err
 }
 
+const getUserSrpCredentials = `-- name: GetUserSrpCredentials :one
+SELECT id, email, srp_salt, srp_verifier, kdf_salt, kdf_params
+FROM users
+WHERE email = $1
+`
+
+type GetUserSrpCredentialsRow struct {
+	ID          int32  `json:"id"`
+	Email       string `json:"email"`
+	SrpSalt     []byte `json:"srp_salt"`
+	SrpVerifier []byte `json:"srp_verifier"`
+	KdfSalt     []byte `json:"kdf_salt"`
+	KdfParams   []byte `json:"kdf_params"`
+}
+
+func (q *Queries) GetUserSrpCredentials(ctx context.Context, email string) (GetUserSrpCredentialsRow, error) {
+	row := q.db.QueryRow(ctx, getUserSrpCredentials, email)
+	var i GetUserSrpCredentialsRow
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.SrpSalt,
+		&i.SrpVerifier,
+		&i.KdfSalt,
+		&i.KdfParams,
+	)
+	return i, err
+}
+
+const markUserEmailVerified = `-- name: MarkUserEmailVerified :execrows
+UPDATE users
+SET email_verified = TRUE, updated_at = NOW()
+WHERE id = $1 AND email = $2
+`
+
+type MarkUserEmailVerifiedParams struct {
+	ID    int32  `json:"id"`
+	Email string `json:"email"`
+}
+
+// Only verifies the address the link was sent to, in case the email changed since
+func (q *Queries) MarkUserEmailVerified(ctx context.Context, arg MarkUserEmailVerifiedParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markUserEmailVerified, arg.ID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateUserCredentials = `-- name: UpdateUserCredentials :execrows
+UPDATE users
+SET kdf_salt = $2, kdf_params = $3, srp_salt = $4, srp_verifier = $5, updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdateUserCredentialsParams struct {
+	ID          int32  `json:"id"`
+	KdfSalt     []byte `json:"kdf_salt"`
+	KdfParams   []byte `json:"kdf_params"`
+	SrpSalt     []byte `json:"srp_salt"`
+	SrpVerifier []byte `json:"srp_verifier"`
+}
+
+func (q *Queries) UpdateUserCredentials(ctx context.Context, arg UpdateUserCredentialsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateUserCredentials,
+		arg.ID,
+		arg.KdfSalt,
+		arg.KdfParams,
+		arg.SrpSalt,
+		arg.SrpVerifier,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const updateUserEmailVerified = `-- name: UpdateUserEmailVerified :exec
 UPDATE users
 SET email_verified = $2, updated_at = NOW()
@@ -135,3 +220,32 @@ func (q *Queries) UpdateUserEmailVerified(ctx context.Context, arg UpdateUserEma
 	_, err := q.db.Exec(ctx, updateUserEmailVerified, arg.ID, arg.EmailVerified)
 	return err
 }
+
+const upgradeUserToSrp = `-- name: UpgradeUserToSrp :execrows
+UPDATE users
+SET kdf_salt = $2, kdf_params = $3, srp_salt = $4, srp_verifier = $5, password_hash = '', updated_at = NOW()
+WHERE id = $1 AND srp_verifier IS NULL AND password_hash <> ''
+`
+
+type UpgradeUserToSrpParams struct {
+	ID          int32  `json:"id"`
+	KdfSalt     []byte `json:"kdf_salt"`
+	KdfParams   []byte `json:"kdf_params"`
+	SrpSalt     []byte `json:"srp_salt"`
+	SrpVerifier []byte `json:"srp_verifier"`
+}
+
+// Moves a password account to SRP login once, clearing the bcrypt hash in the same write
+func (q *Queries) UpgradeUserToSrp(ctx context.Context, arg UpgradeUserToSrpParams) (int64, error) {
+	result, err := q.db.Exec(ctx, upgradeUserToSrp,
+		arg.ID,
+		arg.KdfSalt,
+		arg.KdfParams,
+		arg.SrpSalt,
+		arg.SrpVerifier,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
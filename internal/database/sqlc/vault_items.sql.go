@@ -23,6 +23,39 @@ func (q *Queries) CountVaultItems(ctx context.Context, vaultID int32) (int64, er
 	return count, err
 }
 
+const countVaultItemsByKeyVersion = `-- name: CountVaultItemsByKeyVersion :many
+SELECT key_version, COUNT(*) AS item_count FROM vault_items
+WHERE vault_id = $1
+GROUP BY key_version
+ORDER BY key_version DESC
+`
+
+type CountVaultItemsByKeyVersionRow struct {
+	KeyVersion int32 `json:"key_version"`
+	ItemCount  int64 `json:"item_count"`
+}
+
+// Number of items still encrypted with each key version of the vault
+func (q *Queries) CountVaultItemsByKeyVersion(ctx context.Context, vaultID int32) ([]CountVaultItemsByKeyVersionRow, error) {
+	rows, err := q.db.Query(ctx, countVaultItemsByKeyVersion, vaultID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CountVaultItemsByKeyVersionRow{}
+	for rows.Next() {
+		var i CountVaultItemsByKeyVersionRow
+		if err := rows.Scan(&i.KeyVersion, &i.ItemCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const createVaultItem = `-- name: CreateVaultItem :one
 INSERT INTO vault_items (
     id,
@@ -32,22 +65,26 @@ INSERT INTO vault_items (
     iv,
     tag,
     meta,
-    version
+    version,
+    vault_version_id,
+    key_version
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
 )
-RETURNING id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at
+RETURNING id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at, vault_version_id, key_version
 `
 
 type CreateVaultItemParams struct {
-	ID            pgtype.UUID `json:"id"`
-	VaultID       int32       `json:"vault_id"`
-	ItemType      string      `json:"item_type"`
-	EncryptedBlob []byte      `json:"encrypted_blob"`
-	Iv            []byte      `json:"iv"`
-	Tag           []byte      `json:"tag"`
-	Meta          []byte      `json:"meta"`
-	Version       int32       `json:"version"`
+	ID             pgtype.UUID `json:"id"`
+	VaultID        int32       `json:"vault_id"`
+	ItemType       string      `json:"item_type"`
+	EncryptedBlob  []byte      `json:"encrypted_blob"`
+	Iv             []byte      `json:"iv"`
+	Tag            []byte      `json:"tag"`
+	Meta           []byte      `json:"meta"`
+	Version        int32       `json:"version"`
+	VaultVersionID pgtype.Int4 `json:"vault_version_id"`
+	KeyVersion     int32       `json:"key_version"`
 }
 
 func (q *Queries) CreateVaultItem(ctx context.Context, arg CreateVaultItemParams) (VaultItem, error) {
@@ -60,6 +97,8 @@ func (q *Queries) CreateVaultItem(ctx context.Context, arg CreateVaultItemParams
 		arg.Tag,
 		arg.Meta,
 		arg.Version,
+		arg.VaultVersionID,
+		arg.KeyVersion,
 	)
 	var i VaultItem
 	err := row.Scan(
@@ -73,6 +112,8 @@ func (q *Queries) CreateVaultItem(ctx context.Context, arg CreateVaultItemParams
 		&i.Version,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.VaultVersionID,
+		&i.KeyVersion,
 	)
 	return i, err
 }
@@ -88,7 +129,7 @@ func (q *Queries) DeleteVaultItem(ctx context.Context, id pgtype.UUID) error {
 }
 
 const getVaultItemByID = `-- name: GetVaultItemByID :one
-SELECT id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at FROM vault_items
+SELECT id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at, vault_version_id, key_version FROM vault_items
 WHERE id = $1
 `
 
@@ -106,12 +147,45 @@ func (q *Queries) GetVaultItemByID(ctx context.Context, id pgtype.UUID) (VaultIt
 		&i.Version,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.VaultVersionID,
+		&i.KeyVersion,
 	)
 	return i, err
 }
 
+const getVaultItemVersionsByVaultID = `-- name: GetVaultItemVersionsByVaultID :many
+SELECT id, version FROM vault_items
+WHERE vault_id = $1
+ORDER BY created_at DESC
+`
+
+type GetVaultItemVersionsByVaultIDRow struct {
+	ID      pgtype.UUID `json:"id"`
+	Version int32       `json:"version"`
+}
+
+func (q *Queries) GetVaultItemVersionsByVaultID(ctx context.Context, vaultID int32) ([]GetVaultItemVersionsByVaultIDRow, error) {
+	rows, err := q.db.Query(ctx, getVaultItemVersionsByVaultID, vaultID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetVaultItemVersionsByVaultIDRow{}
+	for rows.Next() {
+		var i GetVaultItemVersionsByVaultIDRow
+		if err := rows.Scan(&i.ID, &i.Version); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getVaultItemsByIDs = `-- name: GetVaultItemsByIDs :many
-SELECT id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at FROM vault_items
+SELECT id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at, vault_version_id, key_version FROM vault_items
 WHERE id = ANY($1::uuid[])
 `
 
@@ -135,6 +209,8 @@ func (q *Queries) GetVaultItemsByIDs(ctx context.Context, dollar_1 []pgtype.UUID
 			&i.Version,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.VaultVersionID,
+			&i.KeyVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -147,7 +223,7 @@ func (q *Queries) GetVaultItemsByIDs(ctx context.Context, dollar_1 []pgtype.UUID
 }
 
 const getVaultItemsByVaultID = `-- name: GetVaultItemsByVaultID :many
-SELECT id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at FROM vault_items
+SELECT id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at, vault_version_id, key_version FROM vault_items
 WHERE vault_id = $1
 ORDER BY created_at DESC
 `
@@ -172,6 +248,8 @@ func (q *Queries) GetVaultItemsByVaultID(ctx context.Context, vaultID int32) ([]
 			&i.Version,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.VaultVersionID,
+			&i.KeyVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -184,7 +262,7 @@ func (q *Queries) GetVaultItemsByVaultID(ctx context.Context, vaultID int32) ([]
 }
 
 const getVaultItemsByVaultIDAndType = `-- name: GetVaultItemsByVaultIDAndType :many
-SELECT id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at FROM vault_items
+SELECT id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at, vault_version_id, key_version FROM vault_items
 WHERE vault_id = $1 AND item_type = $2
 ORDER BY created_at DESC
 `
@@ -214,6 +292,106 @@ func (q *Queries) GetVaultItemsByVaultIDAndType(ctx context.Context, arg GetVaul
 			&i.Version,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.VaultVersionID,
+			&i.KeyVersion,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVaultItemsChangedSince = `-- name: GetVaultItemsChangedSince :many
+SELECT id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at, vault_version_id, key_version FROM vault_items
+WHERE vault_id = $1
+  AND (COALESCE(vault_version_id, 0), id) > ($2::int, $3::uuid)
+ORDER BY COALESCE(vault_version_id, 0), id
+LIMIT $4
+`
+
+type GetVaultItemsChangedSinceParams struct {
+	VaultID int32       `json:"vault_id"`
+	Column2 int32       `json:"column_2"`
+	Column3 pgtype.UUID `json:"column_3"`
+	Limit   int32       `json:"limit"`
+}
+
+func (q *Queries) GetVaultItemsChangedSince(ctx context.Context, arg GetVaultItemsChangedSinceParams) ([]VaultItem, error) {
+	rows, err := q.db.Query(ctx, getVaultItemsChangedSince,
+		arg.VaultID,
+		arg.Column2,
+		arg.Column3,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []VaultItem{}
+	for rows.Next() {
+		var i VaultItem
+		if err := rows.Scan(
+			&i.ID,
+			&i.VaultID,
+			&i.ItemType,
+			&i.EncryptedBlob,
+			&i.Iv,
+			&i.Tag,
+			&i.Meta,
+			&i.Version,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.VaultVersionID,
+			&i.KeyVersion,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockVaultItemsForUpdate = `-- name: LockVaultItemsForUpdate :many
+SELECT id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at, vault_version_id, key_version FROM vault_items
+WHERE vault_id = $1 AND id = ANY($2::uuid[])
+ORDER BY id
+FOR UPDATE
+`
+
+type LockVaultItemsForUpdateParams struct {
+	VaultID int32         `json:"vault_id"`
+	Column2 []pgtype.UUID `json:"column_2"`
+}
+
+func (q *Queries) LockVaultItemsForUpdate(ctx context.Context, arg LockVaultItemsForUpdateParams) ([]VaultItem, error) {
+	rows, err := q.db.Query(ctx, lockVaultItemsForUpdate, arg.VaultID, arg.Column2)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []VaultItem{}
+	for rows.Next() {
+		var i VaultItem
+		if err := rows.Scan(
+			&i.ID,
+			&i.VaultID,
+			&i.ItemType,
+			&i.EncryptedBlob,
+			&i.Iv,
+			&i.Tag,
+			&i.Meta,
+			&i.Version,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.VaultVersionID,
+			&i.KeyVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -226,7 +404,7 @@ func (q *Queries) GetVaultItemsByVaultIDAndType(ctx context.Context, arg GetVaul
 }
 
 const searchVaultItemsByMeta = `-- name: SearchVaultItemsByMeta :many
-SELECT id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at FROM vault_items
+SELECT id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at, vault_version_id, key_version FROM vault_items
 WHERE vault_id = $1 
   AND meta @> $2::jsonb
 ORDER BY created_at DESC
@@ -259,6 +437,8 @@ func (q *Queries) SearchVaultItemsByMeta(ctx context.Context, arg SearchVaultIte
 			&i.Version,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.VaultVersionID,
+			&i.KeyVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -273,33 +453,42 @@ func (q *Queries) SearchVaultItemsByMeta(ctx context.Context, arg SearchVaultIte
 const updateVaultItem = `-- name: UpdateVaultItem :one
 UPDATE vault_items
 SET 
-    encrypted_blob = $2,
-    iv = $3,
-    tag = $4,
-    meta = $5,
-    version = $6,
+    encrypted_blob = $1,
+    iv = $2,
+    tag = $3,
+    meta = $4,
+    version = $5,
+    vault_version_id = $6,
+    key_version = $7,
     updated_at = NOW()
-WHERE id = $1
-RETURNING id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at
+WHERE id = $8 AND version = $9
+RETURNING id, vault_id, item_type, encrypted_blob, iv, tag, meta, version, created_at, updated_at, vault_version_id, key_version
 `
 
 type UpdateVaultItemParams struct {
-	ID            pgtype.UUID `json:"id"`
-	EncryptedBlob []byte      `json:"encrypted_blob"`
-	Iv            []byte      `json:"iv"`
-	Tag           []byte      `json:"tag"`
-	Meta          []byte      `json:"meta"`
-	Version       int32       `json:"version"`
+	EncryptedBlob   []byte      `json:"encrypted_blob"`
+	Iv              []byte      `json:"iv"`
+	Tag             []byte      `json:"tag"`
+	Meta            []byte      `json:"meta"`
+	Version         int32       `json:"version"`
+	VaultVersionID  pgtype.Int4 `json:"vault_version_id"`
+	KeyVersion      int32       `json:"key_version"`
+	ID              pgtype.UUID `json:"id"`
+	ExpectedVersion int32       `json:"expected_version"`
 }
 
+// Only applies while the item is still at expected_version; no row means another write won
 func (q *Queries) UpdateVaultItem(ctx context.Context, arg UpdateVaultItemParams) (VaultItem, error) {
 	row := q.db.QueryRow(ctx, updateVaultItem,
-		arg.ID,
 		arg.EncryptedBlob,
 		arg.Iv,
 		arg.Tag,
 		arg.Meta,
 		arg.Version,
+		arg.VaultVersionID,
+		arg.KeyVersion,
+		arg.ID,
+		arg.ExpectedVersion,
 	)
 	var i VaultItem
 	err := row.Scan(
@@ -313,6 +502,8 @@ func (q *Queries) UpdateVaultItem(ctx context.Context, arg UpdateVaultItemParams
 		&i.Version,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.VaultVersionID,
+		&i.KeyVersion,
 	)
 	return i, err
 }
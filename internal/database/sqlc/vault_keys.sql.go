@@ -109,6 +109,45 @@ func (q *Queries) GetAllVaultKeyVersions(ctx context.Context, vaultID int32) ([]
 	return items, nil
 }
 
+const getOwnedVaultKeys = `-- name: GetOwnedVaultKeys :many
+SELECT vk.id, vk.vault_id, vk.wrapped_vek, vk.wrap_iv, vk.wrap_tag, vk.kdf_salt, vk.kdf_params, vk.version, vk.created_at, vk.updated_at FROM vault_keys vk
+JOIN vaults v ON v.id = vk.vault_id
+WHERE v.user_id = $1
+ORDER BY vk.vault_id, vk.version
+`
+
+// Every key version of the vaults a user owns, all wrapped with their master key
+func (q *Queries) GetOwnedVaultKeys(ctx context.Context, userID int32) ([]VaultKey, error) {
+	rows, err := q.db.Query(ctx, getOwnedVaultKeys, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []VaultKey{}
+	for rows.Next() {
+		var i VaultKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.VaultID,
+			&i.WrappedVek,
+			&i.WrapIv,
+			&i.WrapTag,
+			&i.KdfSalt,
+			&i.KdfParams,
+			&i.Version,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getVaultKeyByVaultID = `-- name: GetVaultKeyByVaultID :one
 SELECT id, vault_id, wrapped_vek, wrap_iv, wrap_tag, kdf_salt, kdf_params, version, created_at, updated_at FROM vault_keys
 WHERE vault_id = $1
@@ -212,3 +251,41 @@ func (q *Queries) UpdateVaultKey(ctx context.Context, arg UpdateVaultKeyParams)
 	)
 	return i, err
 }
+
+const updateVaultKeyWrap = `-- name: UpdateVaultKeyWrap :execrows
+UPDATE vault_keys
+SET
+    wrapped_vek = $3,
+    wrap_iv = $4,
+    wrap_tag = $5,
+    kdf_salt = $6,
+    kdf_params = $7,
+    updated_at = NOW()
+WHERE vault_id = $1 AND version = $2
+`
+
+type UpdateVaultKeyWrapParams struct {
+	VaultID    int32  `json:"vault_id"`
+	Version    int32  `json:"version"`
+	WrappedVek []byte `json:"wrapped_vek"`
+	WrapIv     []byte `json:"wrap_iv"`
+	WrapTag    []byte `json:"wrap_tag"`
+	KdfSalt    []byte `json:"kdf_salt"`
+	KdfParams  []byte `json:"kdf_params"`
+}
+
+func (q *Queries) UpdateVaultKeyWrap(ctx context.Context, arg UpdateVaultKeyWrapParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateVaultKeyWrap,
+		arg.VaultID,
+		arg.Version,
+		arg.WrappedVek,
+		arg.WrapIv,
+		arg.WrapTag,
+		arg.KdfSalt,
+		arg.KdfParams,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -226,3 +226,19 @@ func (q *Queries) GetVaultVersionsSinceID(ctx context.Context, arg GetVaultVersi
 	}
 	return items, nil
 }
+
+const updateVaultVersionMac = `-- name: UpdateVaultVersionMac :exec
+UPDATE vault_versions
+SET mac = $2
+WHERE id = $1
+`
+
+type UpdateVaultVersionMacParams struct {
+	ID  int32  `json:"id"`
+	Mac []byte `json:"mac"`
+}
+
+func (q *Queries) UpdateVaultVersionMac(ctx context.Context, arg UpdateVaultVersionMacParams) error {
+	_, err := q.db.Exec(ctx, updateVaultVersionMac, arg.ID, arg.Mac)
+	return err
+}
@@ -11,6 +11,17 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const clearVaultRotationRecommended = `-- name: ClearVaultRotationRecommended :exec
+UPDATE vaults
+SET rotation_recommended_at = NULL
+WHERE id = $1
+`
+
+func (q *Queries) ClearVaultRotationRecommended(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, clearVaultRotationRecommended, id)
+	return err
+}
+
 const createVault = `-- name: CreateVault :one
 INSERT INTO vaults (
     user_id,
@@ -21,7 +32,7 @@ INSERT INTO vaults (
     is_favorite
 ) VALUES (
     $1, $2, $3, $4, $5, $6
-) RETURNING id, user_id, name, description, icon, theme, is_favorite, created_at, updated_at
+) RETURNING id, user_id, name, description, icon, theme, is_favorite, created_at, updated_at, rotation_recommended_at
 `
 
 type CreateVaultParams struct {
@@ -53,6 +64,7 @@ func (q *Queries) CreateVault(ctx context.Context, arg CreateVaultParams) (Vault
 		&i.IsFavorite,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.RotationRecommendedAt,
 	)
 	return i, err
 }
@@ -72,9 +84,53 @@ func (q *Queries) DeleteVault(ctx context.Context, arg DeleteVaultParams) error
 	return err
 }
 
+const flagUserVaultsForRotation = `-- name: FlagUserVaultsForRotation :many
+UPDATE vaults
+SET rotation_recommended_at = COALESCE(rotation_recommended_at, NOW())
+WHERE user_id = $1
+   OR id IN (
+       SELECT vault_id FROM sharing_records
+       WHERE recipient_user_id = $1 AND status IN ('pending', 'accepted')
+         AND item_id IS NULL
+   )
+RETURNING id
+`
+
+// Flags every vault the user owns or has been offered in full, keeping the earliest flag time
+func (q *Queries) FlagUserVaultsForRotation(ctx context.Context, userID int32) ([]int32, error) {
+	rows, err := q.db.Query(ctx, flagUserVaultsForRotation, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []int32{}
+	for rows.Next() {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const flagVaultForRotation = `-- name: FlagVaultForRotation :exec
+UPDATE vaults
+SET rotation_recommended_at = COALESCE(rotation_recommended_at, NOW())
+WHERE id = $1
+`
+
+func (q *Queries) FlagVaultForRotation(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, flagVaultForRotation, id)
+	return err
+}
+
 const getUserVaults = `-- name: GetUserVaults :many
 SELECT 
-    v.id, v.user_id, v.name, v.description, v.icon, v.theme, v.is_favorite, v.created_at, v.updated_at,
+    v.id, v.user_id, v.name, v.description, v.icon, v.theme, v.is_favorite, v.created_at, v.updated_at, v.rotation_recommended_at,
     COALESCE(COUNT(vi.id), 0)::int AS item_count
 FROM vaults v
 LEFT JOIN vault_items vi ON v.id = vi.vault_id
@@ -84,16 +140,17 @@ ORDER BY v.is_favorite DESC, v.updated_at DESC
 `
 
 type GetUserVaultsRow struct {
-	ID          int32            `json:"id"`
-	UserID      int32            `json:"user_id"`
-	Name        string           `json:"name"`
-	Description pgtype.Text      `json:"description"`
-	Icon        pgtype.Text      `json:"icon"`
-	Theme       pgtype.Text      `json:"theme"`
-	IsFavorite  bool             `json:"is_favorite"`
-	CreatedAt   pgtype.Timestamp `json:"created_at"`
-	UpdatedAt   pgtype.Timestamp `json:"updated_at"`
-	ItemCount   int32            `json:"item_count"`
+	ID                    int32            `json:"id"`
+	UserID                int32            `json:"user_id"`
+	Name                  string           `json:"name"`
+	Description           pgtype.Text      `json:"description"`
+	Icon                  pgtype.Text      `json:"icon"`
+	Theme                 pgtype.Text      `json:"theme"`
+	IsFavorite            bool             `json:"is_favorite"`
+	CreatedAt             pgtype.Timestamp `json:"created_at"`
+	UpdatedAt             pgtype.Timestamp `json:"updated_at"`
+	RotationRecommendedAt pgtype.Timestamp `json:"rotation_recommended_at"`
+	ItemCount             int32            `json:"item_count"`
 }
 
 func (q *Queries) GetUserVaults(ctx context.Context, userID int32) ([]GetUserVaultsRow, error) {
@@ -115,6 +172,7 @@ func (q *Queries) GetUserVaults(ctx context.Context, userID int32) ([]GetUserVau
 			&i.IsFavorite,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.RotationRecommendedAt,
 			&i.ItemCount,
 		); err != nil {
 			return nil, err
@@ -128,7 +186,7 @@ func (q *Queries) GetUserVaults(ctx context.Context, userID int32) ([]GetUserVau
 }
 
 const getVaultByID = `-- name: GetVaultByID :one
-SELECT id, user_id, name, description, icon, theme, is_favorite, created_at, updated_at FROM vaults
+SELECT id, user_id, name, description, icon, theme, is_favorite, created_at, updated_at, rotation_recommended_at FROM vaults
 WHERE id = $1
 `
 
@@ -145,12 +203,13 @@ func (q *Queries) GetVaultByID(ctx context.Context, id int32) (Vault, error) {
 		&i.IsFavorite,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.RotationRecommendedAt,
 	)
 	return i, err
 }
 
 const getVaultByIDAndUserID = `-- name: GetVaultByIDAndUserID :one
-SELECT id, user_id, name, description, icon, theme, is_favorite, created_at, updated_at FROM vaults
+SELECT id, user_id, name, description, icon, theme, is_favorite, created_at, updated_at, rotation_recommended_at FROM vaults
 WHERE id = $1 AND user_id = $2
 `
 
@@ -172,17 +231,57 @@ func (q *Queries) GetVaultByIDAndUserID(ctx context.Context, arg GetVaultByIDAnd
 		&i.IsFavorite,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.RotationRecommendedAt,
 	)
 	return i, err
 }
 
+const lockUserVaultsForUpdate = `-- name: LockUserVaultsForUpdate :many
+SELECT id FROM vaults
+WHERE user_id = $1
+ORDER BY id
+FOR UPDATE
+`
+
+func (q *Queries) LockUserVaultsForUpdate(ctx context.Context, userID int32) ([]int32, error) {
+	rows, err := q.db.Query(ctx, lockUserVaultsForUpdate, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []int32{}
+	for rows.Next() {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockVaultForUpdate = `-- name: LockVaultForUpdate :one
+SELECT id FROM vaults
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) LockVaultForUpdate(ctx context.Context, id int32) (int32, error) {
+	row := q.db.QueryRow(ctx, lockVaultForUpdate, id)
+	err := row.Scan(&id)
+	return id, err
+}
+
 const toggleVaultFavorite = `-- name: ToggleVaultFavorite :one
 UPDATE vaults
 SET 
     is_favorite = NOT is_favorite,
     updated_at = NOW()
 WHERE id = $1 AND user_id = $2
-RETURNING id, user_id, name, description, icon, theme, is_favorite, created_at, updated_at
+RETURNING id, user_id, name, description, icon, theme, is_favorite, created_at, updated_at, rotation_recommended_at
 `
 
 type ToggleVaultFavoriteParams struct {
@@ -203,6 +302,7 @@ func (q *Queries) ToggleVaultFavorite(ctx context.Context, arg ToggleVaultFavori
 		&i.IsFavorite,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.RotationRecommendedAt,
 	)
 	return i, err
 }
@@ -217,7 +317,7 @@ SET
     is_favorite = $6,
     updated_at = NOW()
 WHERE id = $1 AND user_id = $7
-RETURNING id, user_id, name, description, icon, theme, is_favorite, created_at, updated_at
+RETURNING id, user_id, name, description, icon, theme, is_favorite, created_at, updated_at, rotation_recommended_at
 `
 
 type UpdateVaultParams struct {
@@ -251,6 +351,7 @@ func (q *Queries) UpdateVault(ctx context.Context, arg UpdateVaultParams) (Vault
 		&i.IsFavorite,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.RotationRecommendedAt,
 	)
 	return i, err
 }
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"yamony/internal/database/sqlc"
+)
+
+func TestRecordMfaUserAttemptWindow(t *testing.T) {
+	ctx := context.Background()
+	queries := newMigratedQueries(t)
+
+	user, err := queries.CreateUser(ctx, sqlc.CreateUserParams{
+		Username: "mfa@example.com",
+		Email:    "mfa@example.com",
+	})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	record := func(windowStart time.Time) int32 {
+		attempts, err := queries.RecordMfaUserAttempt(ctx, sqlc.RecordMfaUserAttemptParams{
+			UserID:      user.ID,
+			WindowStart: pgtype.Timestamp{Time: windowStart, Valid: true},
+		})
+		if err != nil {
+			t.Fatalf("failed to record attempt: %v", err)
+		}
+		return attempts
+	}
+
+	past := time.Now().Add(-24 * time.Hour)
+	for want := int32(1); want <= 3; want++ {
+		if got := record(past); got != want {
+			t.Fatalf("expected attempt %d, got %d", want, got)
+		}
+	}
+
+	// A window that began before window_start starts over
+	if got := record(time.Now().Add(24 * time.Hour)); got != 1 {
+		t.Fatalf("expected the count to restart, got %d", got)
+	}
+
+	if err := queries.ResetMfaUserAttempts(ctx, user.ID); err != nil {
+		t.Fatalf("failed to reset attempts: %v", err)
+	}
+	if got := record(past); got != 1 {
+		t.Fatalf("expected the count to restart after a reset, got %d", got)
+	}
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"yamony/internal/database/sqlc"
+)
+
+func TestUpgradeUserToSrpOnce(t *testing.T) {
+	ctx := context.Background()
+	queries := newMigratedQueries(t)
+
+	user, err := queries.CreateUser(ctx, sqlc.CreateUserParams{
+		Username:     "legacy@example.com",
+		Email:        "legacy@example.com",
+		PasswordHash: "$2a$10$legacyhash",
+	})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	upgrade := func() int64 {
+		rows, err := queries.UpgradeUserToSrp(ctx, sqlc.UpgradeUserToSrpParams{
+			ID:          user.ID,
+			KdfSalt:     []byte("kdf-salt"),
+			KdfParams:   []byte(`{"time":3,"memory":65536,"parallelism":4,"keyLen":32}`),
+			SrpSalt:     []byte("srp-salt"),
+			SrpVerifier: []byte{0x02},
+		})
+		if err != nil {
+			t.Fatalf("failed to upgrade user: %v", err)
+		}
+		return rows
+	}
+
+	if got := upgrade(); got != 1 {
+		t.Fatalf("expected the first upgrade to apply, got %d rows", got)
+	}
+
+	account, err := queries.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if account.PasswordHash != "" {
+		t.Fatalf("expected the password hash to be cleared, got %q", account.PasswordHash)
+	}
+
+	credentials, err := queries.GetUserSrpCredentials(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("failed to get credentials: %v", err)
+	}
+	if len(credentials.SrpVerifier) == 0 {
+		t.Fatal("expected the verifier to be stored")
+	}
+
+	// An account that already has a verifier is never upgraded again
+	if got := upgrade(); got != 0 {
+		t.Fatalf("expected a second upgrade to be refused, got %d rows", got)
+	}
+}
@@ -0,0 +1,354 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"yamony/internal/database/sqlc"
+)
+
+// rotationFixture is a vault on key version 1 with two items, a vault share
+// and an item share, and a rotation to version 2 started by the owner's device
+type rotationFixture struct {
+	queries         *sqlc.Queries
+	vaultID         int32
+	items           []sqlc.VaultItem
+	vaultShare      sqlc.SharingRecord
+	itemShare       sqlc.SharingRecord
+	recipientDevice pgtype.UUID
+	rotation        sqlc.VaultKeyRotation
+}
+
+func newRotationFixture(t *testing.T) *rotationFixture {
+	t.Helper()
+	ctx := context.Background()
+	queries := newMigratedQueries(t)
+
+	createUser := func(email string) int32 {
+		user, err := queries.CreateUser(ctx, sqlc.CreateUserParams{
+			Username:      email,
+			Email:         email,
+			EmailVerified: true,
+		})
+		if err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		return user.ID
+	}
+	createDevice := func(userID int32) pgtype.UUID {
+		id := pgtype.UUID{Bytes: uuid.New(), Valid: true}
+		if _, err := queries.CreateDevice(ctx, sqlc.CreateDeviceParams{
+			ID:            id,
+			UserID:        userID,
+			X25519Public:  []byte(uuid.NewString()),
+			Ed25519Public: []byte(uuid.NewString()),
+		}); err != nil {
+			t.Fatalf("failed to create device: %v", err)
+		}
+		if _, err := queries.MarkDeviceVerified(ctx, sqlc.MarkDeviceVerifiedParams{ID: id, Status: "active"}); err != nil {
+			t.Fatalf("failed to verify device: %v", err)
+		}
+		return id
+	}
+
+	ownerID := createUser("owner@example.com")
+	recipientID := createUser("recipient@example.com")
+	ownerDevice := createDevice(ownerID)
+	recipientDevice := createDevice(recipientID)
+
+	vault, err := queries.CreateVault(ctx, sqlc.CreateVaultParams{UserID: ownerID, Name: "Rotating"})
+	if err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	if _, err := queries.CreateVaultKey(ctx, sqlc.CreateVaultKeyParams{
+		VaultID:    vault.ID,
+		WrappedVek: []byte("vek-1"),
+		WrapIv:     []byte("iv"),
+		WrapTag:    []byte("tag"),
+		KdfSalt:    []byte("salt"),
+		KdfParams:  []byte(`{}`),
+		Version:    1,
+	}); err != nil {
+		t.Fatalf("failed to create vault key: %v", err)
+	}
+
+	var items []sqlc.VaultItem
+	for i := 0; i < 2; i++ {
+		item, err := queries.CreateVaultItem(ctx, sqlc.CreateVaultItemParams{
+			ID:            pgtype.UUID{Bytes: uuid.New(), Valid: true},
+			VaultID:       vault.ID,
+			ItemType:      "note",
+			EncryptedBlob: []byte("old-blob"),
+			Iv:            []byte("iv"),
+			Tag:           []byte("tag"),
+			Version:       1,
+			KeyVersion:    1,
+		})
+		if err != nil {
+			t.Fatalf("failed to create item: %v", err)
+		}
+		items = append(items, item)
+	}
+
+	share := func(itemID pgtype.UUID) sqlc.SharingRecord {
+		record, err := queries.CreateSharingRecord(ctx, sqlc.CreateSharingRecordParams{
+			VaultID:         vault.ID,
+			ItemID:          itemID,
+			SenderUserID:    ownerID,
+			RecipientUserID: recipientID,
+			WrappedKey:      []byte("old-key"),
+			WrapIv:          []byte("iv"),
+			WrapTag:         []byte("tag"),
+			Status:          "accepted",
+			Role:            "viewer",
+		})
+		if err != nil {
+			t.Fatalf("failed to create share: %v", err)
+		}
+		if err := queries.UpsertSharingRecordKey(ctx, sqlc.UpsertSharingRecordKeyParams{
+			SharingRecordID:   record.ID,
+			RecipientDeviceID: recipientDevice,
+			SenderDeviceID:    ownerDevice,
+			WrappedKey:        []byte("old-device-key"),
+			WrapIv:            []byte("iv"),
+			WrapTag:           []byte("tag"),
+		}); err != nil {
+			t.Fatalf("failed to create share key: %v", err)
+		}
+		return record
+	}
+
+	rotation, err := queries.CreateVaultKeyRotation(ctx, sqlc.CreateVaultKeyRotationParams{
+		VaultID:         vault.ID,
+		FromVersion:     1,
+		ToVersion:       2,
+		WrappedVek:      []byte("vek-2"),
+		WrapIv:          []byte("iv"),
+		WrapTag:         []byte("tag"),
+		KdfSalt:         []byte("salt"),
+		KdfParams:       []byte(`{}`),
+		StartedByDevice: ownerDevice,
+	})
+	if err != nil {
+		t.Fatalf("failed to start rotation: %v", err)
+	}
+
+	return &rotationFixture{
+		queries:         queries,
+		vaultID:         vault.ID,
+		items:           items,
+		vaultShare:      share(pgtype.UUID{}),
+		itemShare:       share(items[0].ID),
+		recipientDevice: recipientDevice,
+		rotation:        rotation,
+	}
+}
+
+func (f *rotationFixture) stageItem(t *testing.T, item sqlc.VaultItem) {
+	t.Helper()
+	if err := f.queries.UpsertVaultKeyRotationItem(context.Background(), sqlc.UpsertVaultKeyRotationItemParams{
+		RotationID:    f.rotation.ID,
+		ItemID:        item.ID,
+		BaseVersion:   item.Version,
+		EncryptedBlob: []byte("new-blob"),
+		Iv:            []byte("iv"),
+		Tag:           []byte("tag"),
+	}); err != nil {
+		t.Fatalf("failed to stage item: %v", err)
+	}
+}
+
+func (f *rotationFixture) stageShares(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	if err := f.queries.UpsertVaultKeyRotationShare(ctx, sqlc.UpsertVaultKeyRotationShareParams{
+		RotationID:      f.rotation.ID,
+		SharingRecordID: f.vaultShare.ID,
+		WrappedKey:      []byte("new-key"),
+		WrapIv:          []byte("iv"),
+		WrapTag:         []byte("tag"),
+	}); err != nil {
+		t.Fatalf("failed to stage vault share: %v", err)
+	}
+	for _, record := range []sqlc.SharingRecord{f.vaultShare, f.itemShare} {
+		if err := f.queries.UpsertVaultKeyRotationShareKey(ctx, sqlc.UpsertVaultKeyRotationShareKeyParams{
+			RotationID:        f.rotation.ID,
+			SharingRecordID:   record.ID,
+			RecipientDeviceID: f.recipientDevice,
+			WrappedKey:        []byte("new-device-key"),
+			WrapIv:            []byte("iv"),
+			WrapTag:           []byte("tag"),
+		}); err != nil {
+			t.Fatalf("failed to stage share key: %v", err)
+		}
+	}
+}
+
+func (f *rotationFixture) progress(t *testing.T) sqlc.GetVaultKeyRotationProgressRow {
+	t.Helper()
+	progress, err := f.queries.GetVaultKeyRotationProgress(context.Background(), sqlc.GetVaultKeyRotationProgressParams{
+		RotationID: f.rotation.ID,
+		VaultID:    f.vaultID,
+	})
+	if err != nil {
+		t.Fatalf("failed to get progress: %v", err)
+	}
+	return progress
+}
+
+// commitItem stands in for a sync commit writing the item with the current key
+func (f *rotationFixture) commitItem(t *testing.T, item sqlc.VaultItem) sqlc.VaultItem {
+	t.Helper()
+	updated, err := f.queries.UpdateVaultItem(context.Background(), sqlc.UpdateVaultItemParams{
+		EncryptedBlob:   []byte("edited-blob"),
+		Iv:              item.Iv,
+		Tag:             item.Tag,
+		Version:         item.Version + 1,
+		KeyVersion:      item.KeyVersion,
+		ID:              item.ID,
+		ExpectedVersion: item.Version,
+	})
+	if err != nil {
+		t.Fatalf("failed to update item: %v", err)
+	}
+	return updated
+}
+
+func TestVaultKeyRotationFinalize(t *testing.T) {
+	ctx := context.Background()
+	f := newRotationFixture(t)
+	queries := f.queries
+
+	// A missing item keeps the rotation from finalizing
+	f.stageItem(t, f.items[0])
+	f.stageShares(t)
+	progress := f.progress(t)
+	if progress.ItemsTotal != 2 || progress.ItemsStaged != 1 {
+		t.Fatalf("expected 1 of 2 items staged, got %+v", progress)
+	}
+	if progress.SharesTotal != 1 || progress.SharesStaged != 1 {
+		t.Fatalf("expected the vault share staged, got %+v", progress)
+	}
+
+	// A commit landing after an item was staged makes the staged copy stale
+	f.stageItem(t, f.items[1])
+	edited := f.commitItem(t, f.items[1])
+	if progress := f.progress(t); progress.ItemsStaged != 1 {
+		t.Fatalf("expected the edited item to need staging again, got %+v", progress)
+	}
+
+	f.stageItem(t, edited)
+	if progress := f.progress(t); progress.ItemsStaged != progress.ItemsTotal || progress.SharesStaged != progress.SharesTotal {
+		t.Fatalf("expected the rotation to be complete, got %+v", progress)
+	}
+
+	finished, err := queries.FinishVaultKeyRotation(ctx, sqlc.FinishVaultKeyRotationParams{ID: f.rotation.ID, Status: "finalized"})
+	if err != nil || finished != 1 {
+		t.Fatalf("expected the rotation to finish, got %d rows: %v", finished, err)
+	}
+	if _, err := queries.GetActiveVaultKeyRotation(ctx, f.vaultID); err != pgx.ErrNoRows {
+		t.Fatalf("expected no active rotation, got %v", err)
+	}
+
+	applied, err := queries.ApplyVaultKeyRotationItems(ctx, sqlc.ApplyVaultKeyRotationItemsParams{RotationID: f.rotation.ID})
+	if err != nil {
+		t.Fatalf("failed to apply items: %v", err)
+	}
+	if len(applied) != 2 {
+		t.Fatalf("expected both items re-encrypted, got %d", len(applied))
+	}
+	for _, item := range applied {
+		if item.KeyVersion != 2 || string(item.EncryptedBlob) != "new-blob" {
+			t.Fatalf("expected item %v on key version 2, got %+v", item.ID, item)
+		}
+	}
+
+	shares, err := queries.ApplyVaultKeyRotationShares(ctx, f.rotation.ID)
+	if err != nil || shares != 1 {
+		t.Fatalf("expected the vault share rewrapped, got %d rows: %v", shares, err)
+	}
+
+	missing, err := queries.GetItemSharesMissingRotationKeys(ctx, sqlc.GetItemSharesMissingRotationKeysParams{
+		RotationID: f.rotation.ID,
+		VaultID:    f.vaultID,
+	})
+	if err != nil || len(missing) != 0 {
+		t.Fatalf("expected every item share rewrapped, got %+v: %v", missing, err)
+	}
+
+	if err := queries.DeleteSharingRecordKeysByVaultID(ctx, f.vaultID); err != nil {
+		t.Fatalf("failed to delete share keys: %v", err)
+	}
+	keys, err := queries.ApplyVaultKeyRotationShareKeys(ctx, f.rotation.ID)
+	if err != nil || keys != 2 {
+		t.Fatalf("expected both share keys rewrapped, got %d rows: %v", keys, err)
+	}
+	for _, record := range []sqlc.SharingRecord{f.vaultShare, f.itemShare} {
+		key, err := queries.GetSharingRecordKey(ctx, sqlc.GetSharingRecordKeyParams{
+			SharingRecordID:   record.ID,
+			RecipientDeviceID: f.recipientDevice,
+		})
+		if err != nil || string(key.WrappedKey) != "new-device-key" {
+			t.Fatalf("expected share %v to hold the new key, got %q: %v", record.ID, key.WrappedKey, err)
+		}
+	}
+
+	if _, err := queries.CreateVaultKey(ctx, sqlc.CreateVaultKeyParams{
+		VaultID:    f.vaultID,
+		WrappedVek: f.rotation.WrappedVek,
+		WrapIv:     f.rotation.WrapIv,
+		WrapTag:    f.rotation.WrapTag,
+		KdfSalt:    f.rotation.KdfSalt,
+		KdfParams:  f.rotation.KdfParams,
+		Version:    f.rotation.ToVersion,
+	}); err != nil {
+		t.Fatalf("failed to create vault key: %v", err)
+	}
+
+	// Writes are checked against the current key, so version 1 is now stale
+	current, err := queries.GetVaultKeyByVaultID(ctx, f.vaultID)
+	if err != nil || current.Version != 2 {
+		t.Fatalf("expected key version 2 to be current, got %d: %v", current.Version, err)
+	}
+	counts, err := queries.CountVaultItemsByKeyVersion(ctx, f.vaultID)
+	if err != nil {
+		t.Fatalf("failed to count items: %v", err)
+	}
+	if len(counts) != 1 || counts[0].KeyVersion != 2 || counts[0].ItemCount != 2 {
+		t.Fatalf("expected every item on key version 2, got %+v", counts)
+	}
+}
+
+func TestVaultKeyRotationApplySkipsItemsChangedAfterStaging(t *testing.T) {
+	ctx := context.Background()
+	f := newRotationFixture(t)
+
+	for _, item := range f.items {
+		f.stageItem(t, item)
+	}
+	f.stageShares(t)
+
+	// A commit still in flight when the progress was read lands before the apply
+	f.commitItem(t, f.items[1])
+
+	applied, err := f.queries.ApplyVaultKeyRotationItems(ctx, sqlc.ApplyVaultKeyRotationItemsParams{RotationID: f.rotation.ID})
+	if err != nil {
+		t.Fatalf("failed to apply items: %v", err)
+	}
+	// Finalize compares this with the vault's item count and refuses with 409
+	if len(applied) != 1 {
+		t.Fatalf("expected only the unchanged item to be applied, got %d", len(applied))
+	}
+
+	item, err := f.queries.GetVaultItemByID(ctx, f.items[1].ID)
+	if err != nil {
+		t.Fatalf("failed to get item: %v", err)
+	}
+	if item.KeyVersion != 1 || string(item.EncryptedBlob) != "edited-blob" {
+		t.Fatalf("expected the edited item to keep the commit's write, got %+v", item)
+	}
+}
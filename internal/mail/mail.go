@@ -0,0 +1,40 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Sender delivers plain text email
+type Sender interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// NewFromEnv builds the sender selected by MAIL_SENDER ("log" or "smtp").
+// The log sender only writes messages to the server log and is the default,
+// which suits development; smtp needs SMTP_HOST and MAIL_FROM.
+func NewFromEnv() (Sender, error) {
+	switch backend := os.Getenv("MAIL_SENDER"); backend {
+	case "", "log":
+		return LogSender{}, nil
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		from := os.Getenv("MAIL_FROM")
+		if host == "" || from == "" {
+			return nil, fmt.Errorf("SMTP_HOST and MAIL_FROM are required for the smtp sender")
+		}
+		port := 587
+		if raw := os.Getenv("SMTP_PORT"); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SMTP_PORT %q", raw)
+			}
+			port = parsed
+		}
+		return NewSMTPSender(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_SENDER %q", backend)
+	}
+}
@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"yamony/internal/crypto"
 	"yamony/internal/database/sqlc"
 	"yamony/internal/server/middleware"
 	"yamony/internal/server/services"
@@ -12,8 +15,14 @@ import (
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+// mfaTokenKey keeps the pending-login token in the cookie session for flows
+// that end in a redirect, such as Google sign-in
+const mfaTokenKey = "mfa_token"
+
 type AuthHandler struct {
 	service services.Service
 }
@@ -24,10 +33,15 @@ func NewAuthHandler(service services.Service) *AuthHandler {
 	}
 }
 
+// RegisterRequest carries the SRP salt and verifier computed on the client and
+// the Argon2id settings it chose; the master password never leaves the client
 type RegisterRequest struct {
-	Username string `json:"username" binding:"required"`
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"`
+	Username    string          `json:"username" binding:"required"`
+	Email       string          `json:"email" binding:"required,email"`
+	SrpSalt     string          `json:"srp_salt" binding:"required"`
+	SrpVerifier string          `json:"srp_verifier" binding:"required"`
+	KDFSalt     string          `json:"kdf_salt" binding:"required"`
+	KDFParams   json.RawMessage `json:"kdf_params" binding:"required"`
 }
 
 type LoginRequest struct {
@@ -35,6 +49,54 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// UpgradeToSrpRequest moves an account that logged in with its password to SRP.
+// The KDF settings must be the ones /api/auth/kdf-params returns for the email
+type UpgradeToSrpRequest struct {
+	Password    string          `json:"password" binding:"required"`
+	SrpSalt     string          `json:"srp_salt" binding:"required"`
+	SrpVerifier string          `json:"srp_verifier" binding:"required"`
+	KDFSalt     string          `json:"kdf_salt" binding:"required"`
+	KDFParams   json.RawMessage `json:"kdf_params" binding:"required"`
+}
+
+// VerifyEmailRequest carries the token from an email verification link
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type SrpLoginInitRequest struct {
+	Email        string `json:"email" binding:"required,email"`
+	ClientPublic string `json:"client_public" binding:"required"`
+}
+
+type SrpLoginInitResponse struct {
+	HandshakeID  string          `json:"handshake_id"`
+	SrpSalt      string          `json:"srp_salt"`
+	ServerPublic string          `json:"server_public"`
+	KDFSalt      string          `json:"kdf_salt"`
+	KDFParams    json.RawMessage `json:"kdf_params"`
+}
+
+type SrpLoginVerifyRequest struct {
+	HandshakeID string `json:"handshake_id" binding:"required"`
+	ClientProof string `json:"client_proof" binding:"required"`
+}
+
+// SrpProof re-proves the password inside a signed-in request. The handshake
+// comes from /api/auth/login/srp/init with the account's email
+type SrpProof struct {
+	HandshakeID string `json:"handshake_id" binding:"required"`
+	ClientProof string `json:"client_proof" binding:"required"`
+}
+
+// MFALoginRequest completes a pending login with a TOTP code or a recovery code.
+// MFAToken may be omitted when the pending login is held in the cookie session
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
 type UserResponse struct {
 	ID            int32  `json:"id"`
 	Username      string `json:"username"`
@@ -52,11 +114,24 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	srpSalt, srpVerifier, ok := decodeSrpCredentials(c, req.SrpSalt, req.SrpVerifier)
+	if !ok {
+		return
+	}
+
+	kdfSalt, ok := decodeKDFSettings(c, req.KDFSalt, req.KDFParams)
+	if !ok {
+		return
+	}
+
 	user, sessionToken, activePageID, err := h.service.RegisterUser(
 		c.Request.Context(),
 		req.Username,
 		req.Email,
-		req.Password,
+		srpSalt,
+		srpVerifier,
+		kdfSalt,
+		req.KDFParams,
 	)
 	if err != nil {
 		if err == services.ErrEmailAlreadyExists {
@@ -100,6 +175,8 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	})
 }
 
+// Login authenticates accounts created before SRP login with their password.
+// The response asks the client to move the account to SRP with UpgradeToSrp
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -115,6 +192,10 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		req.Password,
 	)
 	if err != nil {
+		if respondMFARequired(c, err, gin.H{"srp_upgrade_required": true}) {
+			return
+		}
+
 		if err == services.ErrInvalidCredentials {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "invalid email or password",
@@ -143,6 +224,260 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{
+		"message":              "login successful",
+		"srp_upgrade_required": true,
+		"user": UserResponse{
+			ID:            user.ID,
+			Username:      user.Username,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			Image:         user.Image,
+		},
+	})
+}
+
+// UpgradeToSrp stores the SRP verifier for an account that still logs in with
+// its password, and clears the password hash so /api/login stops accepting it.
+// It works once per account
+// POST /api/me/srp
+func (h *AuthHandler) UpgradeToSrp(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req UpgradeToSrpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	srpSalt, srpVerifier, ok := decodeSrpCredentials(c, req.SrpSalt, req.SrpVerifier)
+	if !ok {
+		return
+	}
+
+	kdfSalt, ok := decodeKDFSettings(c, req.KDFSalt, req.KDFParams)
+	if !ok {
+		return
+	}
+
+	err := h.service.UpgradeToSrp(c.Request.Context(), userID.(int32), req.Password, srpSalt, srpVerifier, kdfSalt, req.KDFParams)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidCredentials:
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid password"})
+		case services.ErrSrpAlreadyEnabled:
+			c.JSON(http.StatusConflict, gin.H{"error": "account already logs in with srp"})
+		case services.ErrKDFParamsMismatch:
+			c.JSON(http.StatusConflict, gin.H{"error": "kdf settings must match /api/auth/kdf-params"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upgrade credentials"})
+		}
+		return
+	}
+
+	rotateSessionToken(c, h.service)
+
+	c.JSON(http.StatusOK, gin.H{"message": "account upgraded to srp login"})
+}
+
+// VerifyEmail redeems the link sent to the user's email. It needs no session,
+// since the link may be opened on another device
+// POST /api/auth/verify-email
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := h.service.VerifyEmail(c.Request.Context(), req.Token); err != nil {
+		if err == services.ErrInvalidEmailVerification {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email verified"})
+}
+
+// ResendEmailVerification mails a fresh verification link, replacing any sent before
+// POST /api/me/email/verification
+func (h *AuthHandler) ResendEmailVerification(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	if err := h.service.SendEmailVerification(c.Request.Context(), userID.(int32)); err != nil {
+		if err == services.ErrEmailAlreadyVerified {
+			c.JSON(http.StatusConflict, gin.H{"error": "email already verified"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "verification email sent"})
+}
+
+// SrpLoginInit runs the first round of an SRP-6a login: the client sends its
+// ephemeral A and receives the salt, KDF params and the server ephemeral B.
+// Unknown emails get a stand-in challenge whose proof always fails
+func (h *AuthHandler) SrpLoginInit(c *gin.Context) {
+	var req SrpLoginInitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	clientPublic, err := crypto.DecodeBase64(req.ClientPublic)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid client_public format"})
+		return
+	}
+
+	if err := crypto.ValidateSRPPublic(clientPublic); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid client_public"})
+		return
+	}
+
+	challenge, err := h.service.BeginSrpLogin(c.Request.Context(), req.Email, clientPublic)
+	if err != nil {
+		fmt.Println("SRP login error ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SrpLoginInitResponse{
+		HandshakeID:  uuidToString(challenge.HandshakeID),
+		SrpSalt:      crypto.EncodeBase64(challenge.Salt),
+		ServerPublic: crypto.EncodeBase64(challenge.ServerPublic),
+		KDFSalt:      crypto.EncodeBase64(challenge.KdfSalt),
+		KDFParams:    challenge.KdfParams,
+	})
+}
+
+// SrpLoginVerify runs the second round of an SRP-6a login. A valid client proof
+// creates the same session as Login and is answered with the server proof.
+func (h *AuthHandler) SrpLoginVerify(c *gin.Context) {
+	var req SrpLoginVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	handshakeID, err := uuid.Parse(req.HandshakeID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid handshake_id"})
+		return
+	}
+
+	pgHandshakeID := pgtype.UUID{}
+	_ = pgHandshakeID.Scan(handshakeID.String())
+
+	clientProof, err := crypto.DecodeBase64(req.ClientProof)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid client_proof format"})
+		return
+	}
+
+	user, serverProof, sessionToken, activePageID, err := h.service.FinishSrpLogin(c.Request.Context(), pgHandshakeID, clientProof)
+	if err != nil {
+		if respondMFARequired(c, err, gin.H{"server_proof": crypto.EncodeBase64(serverProof)}) {
+			return
+		}
+
+		if err == services.ErrInvalidCredentials {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid email or password"})
+			return
+		}
+
+		fmt.Println("SRP login error ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to login"})
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Set(middleware.SessionTokenKey, sessionToken)
+
+	if activePageID > 0 {
+		session.Set(middleware.ActivePageID, activePageID)
+	}
+
+	if err := session.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "login successful",
+		"server_proof": crypto.EncodeBase64(serverProof),
+		"user": UserResponse{
+			ID:            user.ID,
+			Username:      user.Username,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			Image:         user.Image,
+		},
+	})
+}
+
+// VerifyMFALogin completes a login that is waiting for its second factor and
+// creates the session the first factor would have
+func (h *AuthHandler) VerifyMFALogin(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code or recovery_code is required"})
+		return
+	}
+
+	session := sessions.Default(c)
+	mfaToken := pendingMFAToken(c, req.MFAToken)
+	if mfaToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mfa_token is required"})
+		return
+	}
+
+	user, sessionToken, activePageID, err := h.service.VerifyMFALogin(c.Request.Context(), mfaToken, req.Code, req.RecoveryCode)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidMFACode:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+		case services.ErrMFATooManyAttempts:
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many two-factor attempts, try again later"})
+		case services.ErrInvalidCredentials, services.ErrMFANotEnabled:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login expired, sign in again"})
+		default:
+			fmt.Println("MFA login error ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to login"})
+		}
+		return
+	}
+
+	session.Delete(mfaTokenKey)
+	session.Set(middleware.SessionTokenKey, sessionToken)
+
+	if activePageID > 0 {
+		session.Set(middleware.ActivePageID, activePageID)
+	}
+
+	if err := session.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save session"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "login successful",
 		"user": UserResponse{
@@ -197,30 +532,6 @@ func (h *AuthHandler) Me(c *gin.Context) {
 	})
 }
 
-func (h *AuthHandler) GetSessionByUserID(c *gin.Context) {
-	userValue, exists := c.Get(middleware.UserKey)
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "unauthorized",
-		})
-		return
-	}
-
-	user := userValue.(*sqlc.GetUserByIDRow)
-
-	sessions, err := h.service.GetSessionByUserID(c.Request.Context(), user.ID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "failed to get sessions",
-		})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"sessions": sessions,
-	})
-}
-
 func (h *AuthHandler) GoogleLogin(c *gin.Context) {
 	oauthConfig := h.service.GetGoogleOAuthConfig()
 
@@ -264,6 +575,19 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 
 	// Exchange code for user info and create/login user
 	_, sessionToken, activePageID, err := h.service.GoogleOAuthLogin(c.Request.Context(), code)
+	var mfaErr *services.MFARequiredError
+	if errors.As(err, &mfaErr) {
+		session.Set(mfaTokenKey, mfaErr.Token)
+		if err := session.Save(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "failed to save session",
+			})
+			return
+		}
+
+		c.Redirect(http.StatusTemporaryRedirect, "http://localhost:3001/login/mfa")
+		return
+	}
 	if err != nil {
 		fmt.Println("Google OAuth error:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -288,6 +612,105 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 	c.Redirect(http.StatusTemporaryRedirect, "http://localhost:3001/")
 }
 
+// respondMFARequired answers a login whose first factor passed but which still
+// needs a second one. It reports false when err is any other error
+func respondMFARequired(c *gin.Context, err error, extra gin.H) bool {
+	var mfaErr *services.MFARequiredError
+	if !errors.As(err, &mfaErr) {
+		return false
+	}
+
+	session := sessions.Default(c)
+	session.Set(mfaTokenKey, mfaErr.Token)
+	if err := session.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save session"})
+		return true
+	}
+
+	response := gin.H{
+		"mfa_required": true,
+		"mfa_token":    mfaErr.Token,
+		"methods":      mfaErr.Methods,
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+
+	c.JSON(http.StatusAccepted, response)
+	return true
+}
+
+// pendingMFAToken returns the pending-login token from the request, falling
+// back to the one kept in the cookie session
+func pendingMFAToken(c *gin.Context, token string) string {
+	if token != "" {
+		return token
+	}
+
+	if stored, ok := sessions.Default(c).Get(mfaTokenKey).(string); ok {
+		return stored
+	}
+
+	return ""
+}
+
+// decodeSrpCredentials decodes and checks the SRP salt and verifier sent at registration
+func decodeSrpCredentials(c *gin.Context, encodedSalt, encodedVerifier string) ([]byte, []byte, bool) {
+	salt, err := crypto.DecodeBase64(encodedSalt)
+	if err != nil || len(salt) != services.AccountSaltLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("srp_salt must be %d bytes of base64", services.AccountSaltLength)})
+		return nil, nil, false
+	}
+
+	verifier, err := crypto.DecodeBase64(encodedVerifier)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid srp_verifier format"})
+		return nil, nil, false
+	}
+
+	if err := crypto.ValidateSRPVerifier(verifier); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid srp_verifier"})
+		return nil, nil, false
+	}
+
+	return salt, verifier, true
+}
+
+// requireSrpProof checks a fresh SRP proof for the signed-in user and writes
+// the error response when it is missing or wrong
+func requireSrpProof(c *gin.Context, svc services.Service, userID int32, proof *SrpProof) bool {
+	if proof == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "srp_proof is required", "code": "password_required"})
+		return false
+	}
+
+	handshakeID, err := uuid.Parse(proof.HandshakeID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid handshake_id"})
+		return false
+	}
+
+	pgHandshakeID := pgtype.UUID{}
+	_ = pgHandshakeID.Scan(handshakeID.String())
+
+	clientProof, err := crypto.DecodeBase64(proof.ClientProof)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid client_proof format"})
+		return false
+	}
+
+	if err := svc.VerifySrpProof(c.Request.Context(), userID, pgHandshakeID, clientProof); err != nil {
+		if err == services.ErrInvalidCredentials {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid password", "code": "password_required"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify password"})
+		return false
+	}
+
+	return true
+}
+
 func generateRandomState() string {
 	b := make([]byte, 16)
 	rand.Read(b)
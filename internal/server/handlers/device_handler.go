@@ -1,18 +1,26 @@
 package handlers
 
 import (
+	"bytes"
 	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 
 	"yamony/internal/crypto"
 	"yamony/internal/database/sqlc"
+	"yamony/internal/server/middleware"
 	"yamony/internal/server/services"
 )
 
@@ -20,6 +28,33 @@ type DeviceHandler struct {
 	services services.Service
 }
 
+const (
+	// deviceStatusPending marks a registered device that has not yet signed its challenge
+	deviceStatusPending = "pending"
+	// deviceStatusAwaitingApproval marks a verified device waiting for a trusted device or recovery code
+	deviceStatusAwaitingApproval = "awaiting_approval"
+	deviceStatusActive           = "active"
+
+	deviceChallengeTTL = 5 * time.Minute
+)
+
+// How a device became trusted
+const (
+	approvalMethodFirstDevice  = "first_device"
+	approvalMethodDevice       = "device"
+	approvalMethodRecoveryCode = "recovery_code"
+)
+
+// Audit event types recorded for device lifecycle changes
+const (
+	auditDeviceRevoked = "device_revoked"
+)
+
+// recoveryCodeBytes gives recovery codes 160 bits of entropy
+const recoveryCodeBytes = 20
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
 func NewDeviceHandler(services services.Service) *DeviceHandler {
 	return &DeviceHandler{services: services}
 }
@@ -34,6 +69,7 @@ type RegisterDeviceRequest struct {
 // RegisterDeviceChallengeResponse is returned after initial registration
 type RegisterDeviceChallengeResponse struct {
 	DeviceID  string `json:"device_id"`
+	Status    string `json:"status"`
 	Challenge string `json:"challenge"` // base64 encoded challenge to sign
 	ExpiresAt int64  `json:"expires_at"`
 }
@@ -44,15 +80,38 @@ type VerifyDeviceRequest struct {
 	Signature string `json:"signature" binding:"required"` // base64 encoded signature
 }
 
+// VerifyDeviceResponse reports whether the device still needs approval
+type VerifyDeviceResponse struct {
+	Message      string `json:"message"`
+	DeviceID     string `json:"device_id"`
+	Status       string `json:"status"`
+	RecoveryCode string `json:"recovery_code,omitempty"` // only returned when the user's first device is trusted
+}
+
+// RecoverDeviceRequest approves a device with the account recovery code
+type RecoverDeviceRequest struct {
+	RecoveryCode string `json:"recovery_code" binding:"required"`
+}
+
+// RecoveryCodeResponse carries a freshly issued recovery code, shown once
+type RecoveryCodeResponse struct {
+	RecoveryCode string `json:"recovery_code"`
+}
+
 // DeviceResponse represents a device
 type DeviceResponse struct {
-	ID            string     `json:"id"`
-	DeviceLabel   string     `json:"device_label"`
-	X25519Public  string     `json:"x25519_public"`
-	Ed25519Public string     `json:"ed25519_public"`
-	CreatedAt     time.Time  `json:"created_at"`
-	LastSeen      *time.Time `json:"last_seen,omitempty"`
-	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	ID             string     `json:"id"`
+	DeviceLabel    string     `json:"device_label"`
+	X25519Public   string     `json:"x25519_public"`
+	Ed25519Public  string     `json:"ed25519_public"`
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastSeen       *time.Time `json:"last_seen,omitempty"`
+	VerifiedAt     *time.Time `json:"verified_at,omitempty"`
+	ApprovedBy     *string    `json:"approved_by,omitempty"`
+	ApprovedAt     *time.Time `json:"approved_at,omitempty"`
+	ApprovalMethod string     `json:"approval_method,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
 }
 
 // PublicKeysResponse for sharing
@@ -64,7 +123,8 @@ type PublicKeysResponse struct {
 	CreatedAt     time.Time `json:"created_at"`
 }
 
-// RegisterDevice initiates device registration and returns a challenge
+// RegisterDevice creates a pending device and returns a challenge it must sign
+// with its Ed25519 key before it can be used
 // POST /api/devices/register
 func (h *DeviceHandler) RegisterDevice(c *gin.Context) {
 	var req RegisterDeviceRequest
@@ -108,9 +168,26 @@ func (h *DeviceHandler) RegisterDevice(c *gin.Context) {
 	pgDeviceLabel := pgtype.Text{}
 	_ = pgDeviceLabel.Scan(req.DeviceLabel)
 
-	// Create device in database
-	queries := h.services.GetDB().GetQueries()
-	device, err := queries.CreateDevice(c.Request.Context(), sqlc.CreateDeviceParams{
+	// Generate challenge for proof-of-possession
+	challenge, err := crypto.GenerateRandomBytes(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate challenge"})
+		return
+	}
+	expiresAt := time.Now().Add(deviceChallengeTTL)
+
+	ctx := c.Request.Context()
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register device"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := h.services.GetDB().GetQueries().WithTx(tx)
+
+	// Create device in database; it stays pending until VerifyDevice
+	device, err := qtx.CreateDevice(ctx, sqlc.CreateDeviceParams{
 		ID:            pgUUID,
 		UserID:        userID.(int32),
 		DeviceLabel:   pgDeviceLabel,
@@ -122,29 +199,32 @@ func (h *DeviceHandler) RegisterDevice(c *gin.Context) {
 		return
 	}
 
-	// Generate challenge for proof-of-possession
-	challenge, err := crypto.GenerateRandomBytes(32)
+	_, err = qtx.CreateDeviceChallenge(ctx, sqlc.CreateDeviceChallengeParams{
+		DeviceID:  device.ID,
+		Challenge: challenge,
+		ExpiresAt: pgtype.Timestamp{Time: expiresAt, Valid: true},
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate challenge"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store challenge"})
 		return
 	}
 
-	// Store challenge in session/cache with expiry (5 minutes)
-	challengeKey := fmt.Sprintf("device_challenge:%s", deviceID.String())
-	expiresAt := time.Now().Add(5 * time.Minute).Unix()
-
-	// TODO: Store in Redis/cache. For now, we'll accept without challenge verification
-	// In production, store challenge and verify in VerifyDevice endpoint
-	_ = challengeKey // placeholder
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register device"})
+		return
+	}
 
 	c.JSON(http.StatusCreated, RegisterDeviceChallengeResponse{
 		DeviceID:  uuidToString(device.ID),
+		Status:    device.Status,
 		Challenge: crypto.EncodeBase64(challenge),
-		ExpiresAt: expiresAt,
+		ExpiresAt: expiresAt.Unix(),
 	})
 }
 
-// VerifyDevice verifies the signed challenge (optional additional security)
+// VerifyDevice checks the device's signature over its registration challenge.
+// The user's first device is trusted straight away and receives a recovery code;
+// any later device waits for approval by a trusted device or the recovery code
 // POST /api/devices/verify
 func (h *DeviceHandler) VerifyDevice(c *gin.Context) {
 	var req VerifyDeviceRequest
@@ -153,6 +233,12 @@ func (h *DeviceHandler) VerifyDevice(c *gin.Context) {
 		return
 	}
 
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
 	// Parse device ID
 	deviceID, err := uuid.Parse(req.DeviceID)
 	if err != nil {
@@ -164,39 +250,342 @@ func (h *DeviceHandler) VerifyDevice(c *gin.Context) {
 	_ = pgUUID.Scan(deviceID.String())
 
 	// Get device from database
+	ctx := c.Request.Context()
 	queries := h.services.GetDB().GetQueries()
-	device, err := queries.GetDeviceByID(c.Request.Context(), pgUUID)
-	if err != nil {
+	device, err := queries.GetDeviceByID(ctx, pgUUID)
+	if err != nil || device.UserID != userID.(int32) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
 		return
 	}
 
+	if device.Status != deviceStatusPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "device is already verified"})
+		return
+	}
+
 	// Decode signature
 	signature, err := crypto.DecodeBase64(req.Signature)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid signature format"})
 		return
 	}
-
-	// TODO: Retrieve challenge from cache
-	// For now, we'll skip challenge verification and just verify signature format
 	if err := crypto.ValidateEd25519Signature(signature); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid signature"})
 		return
 	}
 
-	// In production: verify signature against challenge
-	// message := []byte(challenge)
-	// valid := crypto.VerifySignature(device.Ed25519Public, message, signature)
-	// if !valid {
-	//     c.JSON(http.StatusUnauthorized, gin.H{"error": "signature verification failed"})
-	//     return
-	// }
+	challenge, err := queries.GetDeviceChallenge(ctx, pgUUID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no pending challenge for device, register it again"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch challenge"})
+		return
+	}
+
+	if time.Now().After(challenge.ExpiresAt.Time) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "challenge has expired, register the device again"})
+		return
+	}
+
+	if !crypto.VerifySignature(device.Ed25519Public, challenge.Challenge, signature) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "signature verification failed"})
+		return
+	}
+
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify device"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
+
+	verified, err := qtx.MarkDeviceVerified(ctx, sqlc.MarkDeviceVerifiedParams{
+		ID:     pgUUID,
+		Status: deviceStatusAwaitingApproval,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify device"})
+		return
+	}
+	if verified == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "device is no longer pending"})
+		return
+	}
+
+	// Challenges are single use
+	if err := qtx.DeleteDeviceChallenge(ctx, pgUUID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify device"})
+		return
+	}
+
+	response := VerifyDeviceResponse{
+		Message:  "device verified, waiting for approval by a trusted device",
+		DeviceID: uuidToString(device.ID),
+		Status:   deviceStatusAwaitingApproval,
+	}
+
+	// Only an account that has never trusted a device may bootstrap one without
+	// approval. Claiming the recovery code row makes concurrent attempts race safely
+	trusted, err := qtx.CountTrustedDevicesByUserID(ctx, device.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify device"})
+		return
+	}
+	if trusted == 0 {
+		code, codeHash, err := generateRecoveryCode()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery code"})
+			return
+		}
+
+		claimed, err := qtx.CreateRecoveryCode(ctx, sqlc.CreateRecoveryCodeParams{
+			UserID:   device.UserID,
+			CodeHash: codeHash,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store recovery code"})
+			return
+		}
+
+		if claimed > 0 {
+			_, err = qtx.ApproveDevice(ctx, sqlc.ApproveDeviceParams{
+				ID:             pgUUID,
+				ApprovalMethod: pgtype.Text{String: approvalMethodFirstDevice, Valid: true},
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify device"})
+				return
+			}
+
+			response.Message = "device verified"
+			response.Status = deviceStatusActive
+			response.RecoveryCode = code
+		}
+	}
+
+	// The session that proved possession of the key belongs to this device
+	if sessionToken := c.GetString(middleware.SessionTokenKey); sessionToken != "" {
+		_, err = qtx.BindSessionToDevice(ctx, sqlc.BindSessionToDeviceParams{
+			SessionToken: sessionToken,
+			DeviceID:     pgUUID,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify device"})
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify device"})
+		return
+	}
+
+	if response.Status == deviceStatusAwaitingApproval {
+		h.services.PublishUserEvent(ctx, device.UserID, services.EventDeviceApprovalRequested, map[string]interface{}{
+			"device_id":    response.DeviceID,
+			"device_label": device.DeviceLabel.String,
+		})
+	}
+
+	// A first device is active straight away and can receive waiting invitations
+	if response.Status == deviceStatusActive {
+		h.services.ReleaseShareInvitations(ctx, device.UserID)
+	}
+
+	// The session is now bound to a verified device
+	rotateSessionToken(c, h.services)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ApproveDevice lets a trusted device of the same user approve a new device
+// POST /api/devices/:id/approve
+func (h *DeviceHandler) ApproveDevice(c *gin.Context) {
+	approver, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device signature required"})
+		return
+	}
+
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
+		return
+	}
+	pgUUID := pgtype.UUID{Bytes: deviceID, Valid: true}
+
+	ctx := c.Request.Context()
+	queries := h.services.GetDB().GetQueries()
+	device, err := queries.GetDeviceByID(ctx, pgUUID)
+	if err != nil || device.UserID != approver.UserID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	if device.Status != deviceStatusAwaitingApproval {
+		c.JSON(http.StatusConflict, gin.H{"error": "device is not awaiting approval"})
+		return
+	}
+
+	approved, err := queries.ApproveDevice(ctx, sqlc.ApproveDeviceParams{
+		ID:               pgUUID,
+		ApprovedByDevice: approver.ID,
+		ApprovalMethod:   pgtype.Text{String: approvalMethodDevice, Valid: true},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to approve device"})
+		return
+	}
+	if approved == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "device is not awaiting approval"})
+		return
+	}
+
+	h.services.PublishUserEvent(ctx, device.UserID, services.EventDeviceApproved, map[string]interface{}{
+		"device_id":   deviceID.String(),
+		"approved_by": uuidToString(approver.ID),
+	})
+	h.services.ReleaseShareInvitations(ctx, device.UserID)
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":   "device verified",
-		"device_id": uuidToString(device.ID),
+		"message":     "device approved",
+		"device_id":   deviceID.String(),
+		"approved_by": uuidToString(approver.ID),
+	})
+}
+
+// RecoverDevice approves a device with the account recovery code when no
+// trusted device is available. The code is consumed and a new one is issued
+// POST /api/devices/:id/recover
+func (h *DeviceHandler) RecoverDevice(c *gin.Context) {
+	var req RecoverDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
+		return
+	}
+	pgUUID := pgtype.UUID{Bytes: deviceID, Valid: true}
+
+	ctx := c.Request.Context()
+	queries := h.services.GetDB().GetQueries()
+	device, err := queries.GetDeviceByID(ctx, pgUUID)
+	if err != nil || device.UserID != userID.(int32) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	if device.Status != deviceStatusAwaitingApproval {
+		c.JSON(http.StatusConflict, gin.H{"error": "device is not awaiting approval"})
+		return
+	}
+
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to recover device"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
+
+	stored, err := qtx.GetRecoveryCodeByUserID(ctx, device.UserID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no recovery code has been set up for this account"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to recover device"})
+		return
+	}
+
+	if subtle.ConstantTimeCompare(stored.CodeHash, hashRecoveryCode(req.RecoveryCode)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid recovery code"})
+		return
+	}
+
+	approved, err := qtx.ApproveDevice(ctx, sqlc.ApproveDeviceParams{
+		ID:             pgUUID,
+		ApprovalMethod: pgtype.Text{String: approvalMethodRecoveryCode, Valid: true},
 	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to recover device"})
+		return
+	}
+	if approved == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "device is not awaiting approval"})
+		return
+	}
+
+	code, codeHash, err := generateRecoveryCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery code"})
+		return
+	}
+
+	err = qtx.UpsertRecoveryCode(ctx, sqlc.UpsertRecoveryCodeParams{
+		UserID:   device.UserID,
+		CodeHash: codeHash,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store recovery code"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to recover device"})
+		return
+	}
+
+	h.services.PublishUserEvent(ctx, device.UserID, services.EventDeviceApproved, map[string]interface{}{
+		"device_id":       deviceID.String(),
+		"approval_method": approvalMethodRecoveryCode,
+	})
+	h.services.ReleaseShareInvitations(ctx, device.UserID)
+
+	c.JSON(http.StatusOK, RecoveryCodeResponse{RecoveryCode: code})
+}
+
+// RegenerateRecoveryCode replaces the account recovery code; requires a trusted device
+// POST /api/devices/recovery-code
+func (h *DeviceHandler) RegenerateRecoveryCode(c *gin.Context) {
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device signature required"})
+		return
+	}
+
+	code, codeHash, err := generateRecoveryCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery code"})
+		return
+	}
+
+	queries := h.services.GetDB().GetQueries()
+	err = queries.UpsertRecoveryCode(c.Request.Context(), sqlc.UpsertRecoveryCodeParams{
+		UserID:   device.UserID,
+		CodeHash: codeHash,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store recovery code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, RecoveryCodeResponse{RecoveryCode: code})
 }
 
 // GetDevices returns all active devices for the authenticated user
@@ -228,16 +617,25 @@ func (h *DeviceHandler) GetDevices(c *gin.Context) {
 			DeviceLabel:   deviceLabel,
 			X25519Public:  crypto.EncodeBase64(device.X25519Public),
 			Ed25519Public: crypto.EncodeBase64(device.Ed25519Public),
+			Status:        device.Status,
 			CreatedAt:     timestampToTime(device.CreatedAt),
 			LastSeen:      timestampToTimePtr(device.LastSeen),
+			VerifiedAt:    timestampToTimePtr(device.VerifiedAt),
+			ApprovedBy:    uuidToStringPtr(device.ApprovedByDevice),
+			ApprovedAt:    timestampToTimePtr(device.ApprovedAt),
 			RevokedAt:     timestampToTimePtr(device.RevokedAt),
 		}
+		if device.ApprovalMethod.Valid {
+			response[i].ApprovalMethod = device.ApprovalMethod.String
+		}
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-// RevokeDevice revokes a device
+// RevokeDevice revokes a device, ends every session that may have come from
+// it, records an audit event and flags every vault the device could read for
+// key rotation
 // DELETE /api/devices/:id
 func (h *DeviceHandler) RevokeDevice(c *gin.Context) {
 	deviceIDParam := c.Param("id")
@@ -257,8 +655,9 @@ func (h *DeviceHandler) RevokeDevice(c *gin.Context) {
 	}
 
 	// Verify device belongs to user
+	ctx := c.Request.Context()
 	queries := h.services.GetDB().GetQueries()
-	device, err := queries.GetDeviceByID(c.Request.Context(), pgUUID)
+	device, err := queries.GetDeviceByID(ctx, pgUUID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
 		return
@@ -269,14 +668,92 @@ func (h *DeviceHandler) RevokeDevice(c *gin.Context) {
 		return
 	}
 
-	// Revoke device
-	err = queries.RevokeDevice(c.Request.Context(), pgUUID)
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke device"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
+
+	// Revoke device; the signature middleware checks revoked_at on every request
+	if err := qtx.RevokeDevice(ctx, pgUUID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke device"})
+		return
+	}
+
+	// The request is signed, so the caller's own session is bound to the signing
+	// device and survives unless that is the device being revoked
+	sessionsEnded, err := qtx.DeleteSessionsForRevokedDevice(ctx, sqlc.DeleteSessionsForRevokedDeviceParams{
+		UserID:   device.UserID,
+		DeviceID: pgUUID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to end device sessions"})
+		return
+	}
+
+	if err := qtx.DeleteDeviceChallenge(ctx, pgUUID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke device"})
+		return
+	}
+
+	// Keys wrapped for the device are useless to anyone else
+	if err := qtx.DeleteSharingRecordKeysByDeviceID(ctx, pgUUID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke device"})
+		return
+	}
+
+	// The device could unwrap the key of every vault its user owns or was offered
+	vaultIDs, err := qtx.FlagUserVaultsForRotation(ctx, device.UserID)
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to flag vaults for rotation"})
+		return
+	}
+
+	auditData, err := json.Marshal(map[string]interface{}{
+		"device_label":   device.DeviceLabel.String,
+		"sessions_ended": sessionsEnded,
+		"vault_ids":      vaultIDs,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke device"})
+		return
+	}
+
+	err = qtx.CreateAuditEvent(ctx, sqlc.CreateAuditEventParams{
+		UserID:    device.UserID,
+		DeviceID:  pgUUID,
+		EventType: auditDeviceRevoked,
+		Data:      auditData,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record audit event"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke device"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "device revoked successfully"})
+	h.services.PublishUserEvent(ctx, device.UserID, services.EventDeviceRevoked, map[string]interface{}{
+		"device_id": deviceID.String(),
+		"vault_ids": vaultIDs,
+	})
+	for _, vaultID := range vaultIDs {
+		h.services.PublishVaultEvent(ctx, vaultID, services.EventRotationRecommended, map[string]interface{}{
+			"vault_id":  vaultID,
+			"device_id": deviceID.String(),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":                     "device revoked successfully",
+		"sessions_ended":              sessionsEnded,
+		"rotation_recommended_vaults": vaultIDs,
+	})
 }
 
 // GetUserPublicKeys returns public keys for a user's devices (for sharing)
@@ -324,80 +801,181 @@ func VerifyDeviceSignature(devicePubKey ed25519.PublicKey, message, signature []
 }
 
 // CreateCanonicalMessage creates a canonical message for signing
-// Format: METHOD|PATH|TIMESTAMP|BODY_HASH
-func CreateCanonicalMessage(method, path string, timestamp int64, bodyHash []byte) []byte {
-	msg := fmt.Sprintf("%s|%s|%d|%s", method, path, timestamp, crypto.EncodeBase64(bodyHash))
+// Format: METHOD|PATH|TIMESTAMP|NONCE|BODY_HASH
+func CreateCanonicalMessage(method, path string, timestamp int64, nonce string, bodyHash []byte) []byte {
+	msg := fmt.Sprintf("%s|%s|%d|%s|%s", method, path, timestamp, nonce, crypto.EncodeBase64(bodyHash))
 	return []byte(msg)
 }
 
-// SignatureVerificationMiddleware verifies device signatures on write operations
-func SignatureVerificationMiddleware() gin.HandlerFunc {
+// DeviceContextKey holds the sqlc.Device that signed the request
+const DeviceContextKey = "device"
+
+// SignatureVerificationMiddleware verifies device signatures on write operations.
+// It must run after AuthMiddleware. The raw body is kept under "raw_body" and the
+// verified device under DeviceContextKey.
+func SignatureVerificationMiddleware(service services.Service) gin.HandlerFunc {
+	verify := DeviceSignatureMiddleware(service)
 	return func(c *gin.Context) {
 		// Only verify signatures on write operations
-		if c.Request.Method == "GET" || c.Request.Method == "OPTIONS" {
+		if c.Request.Method == "GET" || c.Request.Method == "HEAD" || c.Request.Method == "OPTIONS" {
 			c.Next()
 			return
 		}
 
-		// Get device ID and signature from headers
-		deviceIDHeader := c.GetHeader("X-Device-Id")
-		signatureHeader := c.GetHeader("X-Device-Signature")
-		timestampHeader := c.GetHeader("X-Device-Timestamp")
+		verify(c)
+	}
+}
 
-		if deviceIDHeader == "" || signatureHeader == "" || timestampHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required"})
+// DeviceSignatureMiddleware verifies the device signature on every request it
+// guards, reads included. Reads that return key material use it so the key is
+// picked for the device that proved itself rather than the one a session names
+func DeviceSignatureMiddleware(service services.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
 			c.Abort()
 			return
 		}
 
-		// Parse device ID
-		deviceID, err := uuid.Parse(deviceIDHeader)
+		sigData, err := ExtractDeviceSignature(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required: " + err.Error()})
 			c.Abort()
 			return
 		}
 
-		// Get device and verify not revoked
-		// TODO: Implement database query here
-		_ = deviceID
+		// Buffer the body so it can be hashed here and still bound by the handler
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Set("raw_body", body)
+
+		queries := service.GetDB().GetQueries()
+		pgDeviceID := pgtype.UUID{Bytes: sigData.DeviceID, Valid: true}
 
-		// Decode signature
-		signature, err := crypto.DecodeBase64(signatureHeader)
+		device, err := queries.GetDeviceByIDIncludingRevoked(c.Request.Context(), pgDeviceID)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid signature format"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "device not found"})
+			c.Abort()
+			return
+		}
+
+		if device.RevokedAt.Valid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "device has been revoked"})
 			c.Abort()
 			return
 		}
 
-		// TODO: Get device public key from database and verify signature
-		// For now, just validate signature format
-		if err := crypto.ValidateEd25519Signature(signature); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid signature"})
+		if device.UserID != userID.(int32) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "device does not belong to user"})
 			c.Abort()
 			return
 		}
 
+		if device.Status != deviceStatusActive {
+			c.JSON(http.StatusForbidden, gin.H{"error": "device has not been verified"})
+			c.Abort()
+			return
+		}
+
+		bodyHash := sha256.Sum256(body)
+		canonicalMsg := CreateCanonicalMessage(c.Request.Method, c.Request.URL.Path, sigData.Timestamp, sigData.Nonce, bodyHash[:])
+
+		if !VerifyDeviceSignature(device.Ed25519Public, canonicalMsg, sigData.Signature) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid device signature"})
+			c.Abort()
+			return
+		}
+
+		// Claim the nonce only after the signature checks out, so forged requests
+		// cannot burn nonces of a real device
+		claimed, err := queries.ClaimDeviceNonce(c.Request.Context(), sqlc.ClaimDeviceNonceParams{
+			DeviceID: pgDeviceID,
+			Nonce:    sigData.Nonce,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record request nonce"})
+			c.Abort()
+			return
+		}
+		if claimed == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "request replayed: nonce already used"})
+			c.Abort()
+			return
+		}
+
+		// Bind the session to the first device that signs with it, so revoking
+		// the device also ends the session
+		if sessionToken := c.GetString(middleware.SessionTokenKey); sessionToken != "" {
+			bound, err := queries.BindSessionToDevice(c.Request.Context(), sqlc.BindSessionToDeviceParams{
+				SessionToken: sessionToken,
+				DeviceID:     device.ID,
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to bind session to device"})
+				c.Abort()
+				return
+			}
+			if bound == 0 {
+				c.JSON(http.StatusForbidden, gin.H{"error": "session is bound to another device"})
+				c.Abort()
+				return
+			}
+		}
+
+		// last_seen is informational, so a failed update does not reject the request
+		_ = queries.UpdateDeviceLastSeen(c.Request.Context(), pgDeviceID)
+
+		c.Set(DeviceContextKey, device)
 		c.Next()
 	}
 }
 
+// verifiedDevice returns the device SignatureVerificationMiddleware or
+// DeviceSignatureMiddleware verified for this request
+func verifiedDevice(c *gin.Context) (sqlc.Device, bool) {
+	value, exists := c.Get(DeviceContextKey)
+	if !exists {
+		return sqlc.Device{}, false
+	}
+	device, ok := value.(sqlc.Device)
+	return device, ok
+}
+
 // ExtractDeviceSignature extracts and validates device signature from request
 type DeviceSignatureData struct {
 	DeviceID  uuid.UUID
 	Timestamp int64
+	Nonce     string
 	Signature []byte
 }
 
+const (
+	// signatureMaxSkew is how far X-Device-Timestamp may be from the server clock, in seconds
+	signatureMaxSkew = 300
+	minNonceLength   = 16
+	maxNonceLength   = 128
+)
+
 func ExtractDeviceSignature(c *gin.Context) (*DeviceSignatureData, error) {
 	deviceIDHeader := c.GetHeader("X-Device-Id")
 	signatureHeader := c.GetHeader("X-Device-Signature")
 	timestampHeader := c.GetHeader("X-Device-Timestamp")
+	nonce := c.GetHeader("X-Device-Nonce")
 
-	if deviceIDHeader == "" || signatureHeader == "" || timestampHeader == "" {
+	if deviceIDHeader == "" || signatureHeader == "" || timestampHeader == "" || nonce == "" {
 		return nil, fmt.Errorf("missing device authentication headers")
 	}
 
+	if len(nonce) < minNonceLength || len(nonce) > maxNonceLength {
+		return nil, fmt.Errorf("nonce must be %d to %d characters", minNonceLength, maxNonceLength)
+	}
+
 	deviceID, err := uuid.Parse(deviceIDHeader)
 	if err != nil {
 		return nil, fmt.Errorf("invalid device_id")
@@ -415,19 +993,47 @@ func ExtractDeviceSignature(c *gin.Context) (*DeviceSignatureData, error) {
 
 	// Check timestamp is within acceptable window (5 minutes)
 	now := time.Now().Unix()
-	if now-timestamp > 300 || timestamp > now+300 {
+	if now-timestamp > signatureMaxSkew || timestamp > now+signatureMaxSkew {
 		return nil, fmt.Errorf("timestamp out of acceptable range")
 	}
 
 	return &DeviceSignatureData{
 		DeviceID:  deviceID,
 		Timestamp: timestamp,
+		Nonce:     nonce,
 		Signature: signature,
 	}, nil
 }
 
 // Helper functions
 
+// generateRecoveryCode returns a new recovery code, formatted for display,
+// together with the hash to store
+func generateRecoveryCode() (string, []byte, error) {
+	raw, err := crypto.GenerateRandomBytes(recoveryCodeBytes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	encoded := recoveryCodeEncoding.EncodeToString(raw)
+	groups := make([]string, 0, len(encoded)/4)
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:min(i+4, len(encoded))])
+	}
+	code := strings.Join(groups, "-")
+
+	return code, hashRecoveryCode(code), nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case, dashes and spaces
+// so users can type it back loosely. The code is random enough that a plain
+// SHA-256 is sufficient
+func hashRecoveryCode(code string) []byte {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	digest := sha256.Sum256([]byte(normalized))
+	return digest[:]
+}
+
 // uuidToString converts pgtype.UUID to string
 func uuidToString(pgUUID pgtype.UUID) string {
 	if !pgUUID.Valid {
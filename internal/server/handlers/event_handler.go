@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"yamony/internal/server/middleware"
+	"yamony/internal/server/services"
+)
+
+// eventHeartbeatInterval keeps idle streams from being closed by proxies. The
+// session is checked again on every heartbeat
+const eventHeartbeatInterval = 25 * time.Second
+
+type EventHandler struct {
+	services services.Service
+}
+
+func NewEventHandler(services services.Service) *EventHandler {
+	return &EventHandler{services: services}
+}
+
+// StreamEvents pushes vault, share, device and key events for the current user
+// as server-sent events until the client disconnects or the session ends
+// GET /api/events
+func (h *EventHandler) StreamEvents(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "streaming not supported"})
+		return
+	}
+
+	events, unsubscribe := h.services.SubscribeEvents(userID.(int32))
+	defer unsubscribe()
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event.Data)
+			return true
+		case <-heartbeat.C:
+			// Logout and session or device revocation must also end open streams
+			if !eventSessionValid(c, h.services, userID.(int32)) {
+				c.SSEvent("session_ended", gin.H{})
+				return false
+			}
+			c.SSEvent("ping", gin.H{"time": time.Now().UTC().Format("2006-01-02T15:04:05Z07:00")})
+			return true
+		}
+	})
+}
+
+// eventSessionValid reports whether the session that opened a stream still
+// belongs to userID
+func eventSessionValid(c *gin.Context, svc services.Service, userID int32) bool {
+	user, err := svc.ValidateSession(c.Request.Context(), c.GetString(middleware.SessionTokenKey))
+	return err == nil && user.ID == userID
+}
@@ -2,20 +2,34 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
 	"yamony/internal/crypto"
+	"yamony/internal/database"
+	"yamony/internal/database/sqlc"
+	"yamony/internal/server/middleware"
+	"yamony/internal/server/services"
+	"yamony/internal/storage"
 )
 
 // MockService is a mock implementation of the Service interface for testing
@@ -42,8 +56,9 @@ func TestDeviceSignatureGeneration(t *testing.T) {
 
 	// Create canonical message
 	bodyHash := sha256.Sum256(bodyData)
-	canonicalMsg := CreateCanonicalMessage(method, path, timestamp, bodyHash[:])
-	expectedMsg := []byte(fmt.Sprintf("%s|%s|%d|%s", method, path, timestamp, crypto.EncodeBase64(bodyHash[:])))
+	nonce := "3f2b8c1e9a7d4e6f"
+	canonicalMsg := CreateCanonicalMessage(method, path, timestamp, nonce, bodyHash[:])
+	expectedMsg := []byte(fmt.Sprintf("%s|%s|%d|%s|%s", method, path, timestamp, nonce, crypto.EncodeBase64(bodyHash[:])))
 	assert.Equal(t, expectedMsg, canonicalMsg)
 
 	// Sign message
@@ -55,11 +70,47 @@ func TestDeviceSignatureGeneration(t *testing.T) {
 	assert.True(t, valid)
 
 	// Test with modified message (should fail)
-	modifiedMsg := CreateCanonicalMessage("PUT", path, timestamp, bodyHash[:])
+	modifiedMsg := CreateCanonicalMessage("PUT", path, timestamp, nonce, bodyHash[:])
+	valid = ed25519.Verify(publicKey, modifiedMsg, signature)
+	assert.False(t, valid)
+
+	// A different nonce (as in a replay with a fresh nonce) also fails
+	modifiedMsg = CreateCanonicalMessage(method, path, timestamp, "0000000000000000", bodyHash[:])
 	valid = ed25519.Verify(publicKey, modifiedMsg, signature)
 	assert.False(t, valid)
 }
 
+// TestSignatureVerificationMiddlewareRejectsUnsigned tests that writes need device headers and reads do not
+func TestSignatureVerificationMiddlewareRejectsUnsigned(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", int32(1))
+	})
+	router.Use(SignatureVerificationMiddleware(nil))
+	router.GET("/api/vaults/1", func(c *gin.Context) { c.Status(200) })
+	router.POST("/api/vaults/1/items", func(c *gin.Context) { c.Status(200) })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/vaults/1", nil))
+	assert.Equal(t, 200, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/vaults/1/items", bytes.NewReader([]byte(`{}`))))
+	assert.Equal(t, 401, w.Code)
+
+	// A stale timestamp is rejected before the device is looked up
+	req := httptest.NewRequest("POST", "/api/vaults/1/items", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("X-Device-Id", uuid.New().String())
+	req.Header.Set("X-Device-Signature", crypto.EncodeBase64(make([]byte, ed25519.SignatureSize)))
+	req.Header.Set("X-Device-Timestamp", fmt.Sprintf("%d", time.Now().Add(-time.Hour).Unix()))
+	req.Header.Set("X-Device-Nonce", "3f2b8c1e9a7d4e6f")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+}
+
 // TestVaultKeyHandlerUpload tests the UploadVaultKey handler
 func TestVaultKeyHandlerUpload(t *testing.T) {
 	gin.SetMode(gin.TestMode)
@@ -262,6 +313,947 @@ func TestETagGeneration(t *testing.T) {
 	assert.NotEqual(t, etag1, etag3)
 }
 
+// TestSyncCursorRoundTrip tests that delta pull cursors decode to the item they were built from
+func TestSyncCursorRoundTrip(t *testing.T) {
+	itemID := uuid.New()
+	item := sqlc.VaultItem{
+		ID:             pgtype.UUID{Bytes: itemID, Valid: true},
+		VaultVersionID: pgtype.Int4{Int32: 42, Valid: true},
+	}
+
+	versionID, cursorItemID, err := decodeSyncCursor(encodeSyncCursor(item))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(42), versionID)
+	assert.Equal(t, itemID, uuid.UUID(cursorItemID.Bytes))
+
+	// Items written before versions were tracked sort as version 0
+	item.VaultVersionID = pgtype.Int4{}
+	versionID, _, err = decodeSyncCursor(encodeSyncCursor(item))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), versionID)
+
+	_, _, err = decodeSyncCursor("not-a-cursor")
+	assert.Error(t, err)
+}
+
+// TestSnapshotManifestDigest tests that the manifest ignores item order and ids but not contents
+func TestSnapshotManifestDigest(t *testing.T) {
+	items := []sqlc.VaultItem{
+		{ID: pgtype.UUID{Bytes: uuid.New(), Valid: true}, EncryptedBlob: []byte("blob-a"), Iv: []byte("iv-a"), Tag: []byte("tag-a"), Version: 1},
+		{ID: pgtype.UUID{Bytes: uuid.New(), Valid: true}, EncryptedBlob: []byte("blob-b"), Iv: []byte("iv-b"), Tag: []byte("tag-b"), Version: 3},
+	}
+	digest := snapshotManifestDigest(1, items)
+
+	// Order and ids do not matter, so clients can sign before ids are assigned
+	reordered := []sqlc.VaultItem{items[1], items[0]}
+	reordered[0].ID = pgtype.UUID{}
+	assert.Equal(t, digest, snapshotManifestDigest(1, reordered))
+
+	// Contents, versions and the vault do
+	changed := []sqlc.VaultItem{items[0], items[1]}
+	changed[1].Version = 4
+	assert.NotEqual(t, digest, snapshotManifestDigest(1, changed))
+	assert.NotEqual(t, digest, snapshotManifestDigest(2, items))
+}
+
+// TestVaultSnapshotStoredAfterCommit tests that building a snapshot leaves the
+// object store untouched until it is stored
+func TestVaultSnapshotStoredAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	version := sqlc.VaultVersion{ID: 5, VaultID: 1, ObjectKey: "vaults/1/versions/5.json"}
+	items := []sqlc.VaultItem{
+		{ID: pgtype.UUID{Bytes: uuid.New(), Valid: true}, VaultID: 1, EncryptedBlob: []byte("blob"), Iv: []byte("iv"), Tag: []byte("tag"), Version: 2},
+	}
+
+	// Without a MAC nothing is written inside the transaction
+	data, err := buildVaultSnapshot(ctx, nil, version, items, nil)
+	require.NoError(t, err)
+
+	_, err = readVaultSnapshot(ctx, store, version)
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+
+	storeVaultSnapshot(ctx, store, version, data)
+	snapshot, err := readVaultSnapshot(ctx, store, version)
+	require.NoError(t, err)
+	assert.Equal(t, int32(5), snapshot.VersionID)
+	require.Len(t, snapshot.Items, 1)
+	assert.Equal(t, int32(2), snapshot.Items[0].Version)
+}
+
+// TestRecoveryCode tests that recovery codes are grouped for display and hashed loosely
+func TestRecoveryCode(t *testing.T) {
+	code, codeHash, err := generateRecoveryCode()
+	require.NoError(t, err)
+	assert.Len(t, code, 39) // 32 base32 characters in groups of 4
+	assert.Equal(t, codeHash, hashRecoveryCode(code))
+
+	// Case, dashes and spaces are ignored when the user types it back
+	loose := strings.ToLower(strings.ReplaceAll(code, "-", " "))
+	assert.Equal(t, codeHash, hashRecoveryCode(loose))
+
+	other, _, err := generateRecoveryCode()
+	require.NoError(t, err)
+	assert.NotEqual(t, codeHash, hashRecoveryCode(other))
+}
+
+// TestVaultRoles tests that vault roles are ordered and only member roles can be granted
+func TestVaultRoles(t *testing.T) {
+	assert.True(t, vaultRoleAtLeast(vaultRoleOwner, vaultRoleManager))
+	assert.True(t, vaultRoleAtLeast(vaultRoleManager, vaultRoleEditor))
+	assert.True(t, vaultRoleAtLeast(vaultRoleEditor, vaultRoleEditor))
+	assert.False(t, vaultRoleAtLeast(vaultRoleViewer, vaultRoleEditor))
+	assert.False(t, vaultRoleAtLeast(vaultRoleEditor, vaultRoleManager))
+	assert.False(t, vaultRoleAtLeast("", vaultRoleViewer))
+
+	assert.True(t, validMemberRole(vaultRoleViewer))
+	assert.True(t, validMemberRole(vaultRoleManager))
+	assert.False(t, validMemberRole(vaultRoleOwner))
+	assert.False(t, validMemberRole("admin"))
+}
+
+// TestShareInvitationExpiry tests email normalisation and when invitations lapse
+func TestShareInvitationExpiry(t *testing.T) {
+	assert.Equal(t, "new.teammate@example.com", normalizeEmail("  New.Teammate@Example.com "))
+
+	now := time.Now()
+	invitation := sqlc.ShareInvitation{
+		Status:    invitationStatusPending,
+		ExpiresAt: pgtype.Timestamp{Time: now.Add(-time.Minute), Valid: true},
+	}
+	assert.True(t, invitationExpired(invitation, now))
+
+	invitation.Status = invitationStatusReady
+	invitation.ExpiresAt.Time = now.Add(shareInvitationTTL)
+	assert.False(t, invitationExpired(invitation, now))
+
+	// Finished invitations are never reported as expired
+	invitation.Status = invitationStatusCompleted
+	invitation.ExpiresAt.Time = now.Add(-time.Minute)
+	assert.False(t, invitationExpired(invitation, now))
+}
+
+// TestDecodeShareDeviceKeys tests that per-device keys only target the recipient's devices
+func TestDecodeShareDeviceKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	deviceID := uuid.New()
+	recipientDevices := []sqlc.GetUserDevicePublicKeysRow{{ID: pgtype.UUID{Bytes: deviceID, Valid: true}}}
+	key := ShareDeviceKey{
+		DeviceID:   deviceID.String(),
+		WrappedKey: crypto.EncodeBase64([]byte("wrapped")),
+		WrapIV:     crypto.EncodeBase64([]byte("iv")),
+		WrapTag:    crypto.EncodeBase64([]byte("tag")),
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	keys, ok := decodeShareDeviceKeys(c, recipientDevices, []ShareDeviceKey{key})
+	require.True(t, ok)
+	require.Len(t, keys, 1)
+	assert.Equal(t, deviceID, uuid.UUID(keys[0].RecipientDeviceID.Bytes))
+	assert.Equal(t, []byte("wrapped"), keys[0].WrappedKey)
+
+	// Keys can only target the recipient's active devices, once each
+	other := key
+	other.DeviceID = uuid.New().String()
+	w := httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	_, ok = decodeShareDeviceKeys(c, recipientDevices, []ShareDeviceKey{other})
+	assert.False(t, ok)
+	assert.Equal(t, 400, w.Code)
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	_, ok = decodeShareDeviceKeys(c, recipientDevices, []ShareDeviceKey{key, key})
+	assert.False(t, ok)
+}
+
+// TestShareExpiry tests share expiry checks and parsing
+func TestShareExpiry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+
+	record := sqlc.SharingRecord{}
+	assert.False(t, shareExpired(record, now), "shares without expiry never lapse")
+
+	record.ExpiresAt = pgtype.Timestamp{Time: now.Add(time.Hour), Valid: true}
+	assert.False(t, shareExpired(record, now))
+	assert.True(t, shareExpired(record, now.Add(2*time.Hour)))
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	expiresAt, ok := parseShareExpiry(c, nil)
+	assert.True(t, ok)
+	assert.False(t, expiresAt.Valid)
+
+	past := now.Add(-time.Minute)
+	w := httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	_, ok = parseShareExpiry(c, &past)
+	assert.False(t, ok)
+	assert.Equal(t, 400, w.Code)
+}
+
+// TestDecodeSrpCredentials tests that registration only accepts usable SRP salts and verifiers
+func TestDecodeSrpCredentials(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	salt, err := crypto.GenerateSalt(services.AccountSaltLength)
+	require.NoError(t, err)
+	verifier := crypto.ComputeSRPVerifier("alice@example.com", []byte("login-secret"), salt)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	decodedSalt, decodedVerifier, ok := decodeSrpCredentials(c, crypto.EncodeBase64(salt), crypto.EncodeBase64(verifier))
+	require.True(t, ok)
+	assert.Equal(t, salt, decodedSalt)
+	assert.Equal(t, verifier, decodedVerifier)
+
+	w := httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	_, _, ok = decodeSrpCredentials(c, crypto.EncodeBase64(salt[:8]), crypto.EncodeBase64(verifier))
+	assert.False(t, ok)
+	assert.Equal(t, 400, w.Code)
+
+	// Stand-in salts for unknown emails are AccountSaltLength long, so real ones must be too
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	_, _, ok = decodeSrpCredentials(c, crypto.EncodeBase64(append(salt, 0)), crypto.EncodeBase64(verifier))
+	assert.False(t, ok)
+	assert.Equal(t, 400, w.Code)
+
+	// A verifier of 1 would let anyone log in
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	_, _, ok = decodeSrpCredentials(c, crypto.EncodeBase64(salt), crypto.EncodeBase64([]byte{1}))
+	assert.False(t, ok)
+	assert.Equal(t, 400, w.Code)
+}
+
+// TestDecodeRewrappedVaultKeys tests that a KDF upgrade must re-wrap every owned key version once
+func TestDecodeRewrappedVaultKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	existing := []sqlc.VaultKey{
+		{VaultID: 1, Version: 1},
+		{VaultID: 1, Version: 2},
+	}
+	key := func(version int32) RewrappedVaultKey {
+		return RewrappedVaultKey{
+			VaultID:    1,
+			Version:    version,
+			WrappedVEK: crypto.EncodeBase64([]byte("wrapped")),
+			WrapIV:     crypto.EncodeBase64([]byte("iv")),
+			WrapTag:    crypto.EncodeBase64([]byte("tag")),
+		}
+	}
+	kdfSalt := []byte("new-salt")
+	kdfParams := []byte(`{"time":4}`)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	updates, ok := decodeRewrappedVaultKeys(c, existing, []RewrappedVaultKey{key(2), key(1)}, kdfSalt, kdfParams)
+	require.True(t, ok)
+	require.Len(t, updates, 2)
+	assert.Equal(t, int32(2), updates[0].Version)
+	assert.Equal(t, kdfSalt, updates[0].KdfSalt)
+
+	// A version left out would stay wrapped with the old master key
+	w := httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	_, ok = decodeRewrappedVaultKeys(c, existing, []RewrappedVaultKey{key(1)}, kdfSalt, kdfParams)
+	assert.False(t, ok)
+	assert.Equal(t, 400, w.Code)
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	_, ok = decodeRewrappedVaultKeys(c, existing, []RewrappedVaultKey{key(1), key(1), key(2)}, kdfSalt, kdfParams)
+	assert.False(t, ok)
+	assert.Equal(t, 400, w.Code)
+}
+
+// TestRespondMFARequired tests that a login needing a second factor gets a pending token instead of a session
+func TestRespondMFARequired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(sessions.Sessions("yamony", cookie.NewStore([]byte("test-secret"))))
+	router.POST("/login", func(c *gin.Context) {
+		err := &services.MFARequiredError{
+			Token:   "pending-token",
+			Methods: []string{services.MFAMethodTOTP, services.MFAMethodRecoveryCode},
+		}
+		if !respondMFARequired(c, err, gin.H{"server_proof": "proof"}) {
+			c.Status(500)
+		}
+	})
+	router.POST("/other", func(c *gin.Context) {
+		if !respondMFARequired(c, services.ErrInvalidCredentials, nil) {
+			c.Status(401)
+		}
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/login", nil))
+	assert.Equal(t, 202, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Set-Cookie"))
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, true, body["mfa_required"])
+	assert.Equal(t, "pending-token", body["mfa_token"])
+	assert.Equal(t, "proof", body["server_proof"])
+	assert.Equal(t, []interface{}{"totp", "recovery_code"}, body["methods"])
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/other", nil))
+	assert.Equal(t, 401, w.Code)
+}
+
+// TestDecodeWebAuthnAssertion tests decoding of base64url passkey login responses
+func TestDecodeWebAuthnAssertion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	req := PasskeyLoginRequest{
+		CredentialID:      encodeWebAuthnBytes([]byte("credential")),
+		ClientDataJSON:    encodeWebAuthnBytes([]byte(`{"type":"webauthn.get"}`)),
+		AuthenticatorData: "YXV0aGRhdGE=", // padded input is accepted too
+		Signature:         encodeWebAuthnBytes([]byte{0xff, 0xfe, 0xfd}),
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	credentialID, assertion, ok := decodeWebAuthnAssertion(c, req)
+	require.True(t, ok)
+	assert.Equal(t, []byte("credential"), credentialID)
+	assert.Equal(t, []byte(`{"type":"webauthn.get"}`), assertion.ClientDataJSON)
+	assert.Equal(t, []byte("authdata"), assertion.AuthenticatorData)
+	assert.Equal(t, []byte{0xff, 0xfe, 0xfd}, assertion.Signature)
+	assert.Equal(t, "__79", req.Signature)
+	assert.Nil(t, assertion.UserHandle)
+
+	req.UserHandle = encodeWebAuthnBytes([]byte{0, 0, 0, 0, 0, 0, 0, 7})
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	_, assertion, ok = decodeWebAuthnAssertion(c, req)
+	require.True(t, ok)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 7}, assertion.UserHandle)
+
+	// Standard base64 is rejected, as browsers always send base64url
+	req.Signature = "//79"
+	w := httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	_, _, ok = decodeWebAuthnAssertion(c, req)
+	assert.False(t, ok)
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "signature")
+}
+
+// TestSessionResponse tests that listed sessions hide their tokens and mark the current one
+func TestSessionResponse(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	deviceID := uuid.New()
+
+	session := sqlc.GetSessionsByUserIDRow{
+		ID:           7,
+		Current:      true,
+		CreatedAt:    pgtype.Timestamp{Time: created, Valid: true},
+		LastActiveAt: pgtype.Timestamp{Time: created.Add(time.Hour), Valid: true},
+		ExpiresAt:    pgtype.Timestamp{Time: created.Add(7 * 24 * time.Hour), Valid: true},
+		DeviceID:     pgtype.UUID{Bytes: deviceID, Valid: true},
+		UserAgent:    "Mozilla/5.0",
+		IpAddress:    "203.0.113.9",
+	}
+
+	response := sessionResponse(session)
+	assert.True(t, response.Current)
+	assert.Equal(t, int32(7), response.ID)
+	require.NotNil(t, response.DeviceID)
+	assert.Equal(t, deviceID.String(), *response.DeviceID)
+	assert.Equal(t, created.Add(time.Hour), response.LastActiveAt)
+
+	encoded, err := json.Marshal(response)
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"ip_address":"203.0.113.9"`)
+	assert.Contains(t, string(encoded), `"current":true`)
+
+	assert.False(t, sessionResponse(sqlc.GetSessionsByUserIDRow{}).Current)
+	assert.Nil(t, sessionResponse(sqlc.GetSessionsByUserIDRow{}).DeviceID)
+}
+
+// fakeQueryDB answers sqlc queries by name with canned rows. Each row is a
+// struct whose fields are in the order sqlc scans them, or a single value.
+// Query arguments are ignored and anything else panics
+type fakeQueryDB struct {
+	database.Service
+	rows map[string][]interface{}
+}
+
+func (db *fakeQueryDB) GetQueries() *sqlc.Queries {
+	return sqlc.New(db)
+}
+
+func (db *fakeQueryDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, fmt.Errorf("unexpected exec of %s", fakeQueryName(sql))
+}
+
+func (db *fakeQueryDB) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return &fakeRows{rows: db.rows[fakeQueryName(sql)], next: -1}, nil
+}
+
+func (db *fakeQueryDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	rows := db.rows[fakeQueryName(sql)]
+	if len(rows) == 0 {
+		return &fakeRows{err: pgx.ErrNoRows}
+	}
+	return &fakeRows{rows: rows[:1]}
+}
+
+func fakeQueryName(sql string) string {
+	return strings.Fields(strings.TrimPrefix(sql, "-- name: "))[0]
+}
+
+// fakeRows serves fakeQueryDB rows as both pgx.Rows and pgx.Row
+type fakeRows struct {
+	pgx.Rows
+	rows []interface{}
+	next int
+	err  error
+}
+
+func (r *fakeRows) Next() bool {
+	r.next++
+	return r.next < len(r.rows)
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	row := reflect.ValueOf(r.rows[max(r.next, 0)])
+	if row.Kind() != reflect.Struct {
+		reflect.ValueOf(dest[0]).Elem().Set(row)
+		return nil
+	}
+	for i := range dest {
+		reflect.ValueOf(dest[i]).Elem().Set(row.Field(i))
+	}
+	return nil
+}
+
+func (r *fakeRows) Err() error { return nil }
+func (r *fakeRows) Close()     {}
+
+// shareStubService serves a fakeQueryDB; every other method panics
+type shareStubService struct {
+	services.Service
+	db *fakeQueryDB
+}
+
+func (s *shareStubService) GetDB() database.Service {
+	return s.db
+}
+
+// serveAs runs handler for one request made by userID
+func serveAs(userID int32, method, route, target string, body []byte, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(method, route, func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Set(middleware.SessionTokenKey, "session-token")
+		handler(c)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, target, bytes.NewReader(body)))
+	return w
+}
+
+// TestShareItem tests the checks made before an item share is created
+func TestShareItem(t *testing.T) {
+	itemID := uuid.New()
+	recipientDevice := uuid.New()
+	newDB := func() *fakeQueryDB {
+		return &fakeQueryDB{rows: map[string][]interface{}{
+			"CheckUserVaultAccess": {vaultRoleManager},
+			"GetVaultItemByID":     {sqlc.VaultItem{ID: pgtype.UUID{Bytes: itemID, Valid: true}, VaultID: 1}},
+			"GetUserDevicePublicKeys": {sqlc.GetUserDevicePublicKeysRow{
+				ID: pgtype.UUID{Bytes: recipientDevice, Valid: true},
+			}},
+		}}
+	}
+	shareItem := func(db *fakeQueryDB, req ShareItemRequest) int {
+		body, _ := json.Marshal(req)
+		h := NewShareHandler(&shareStubService{db: db})
+		return serveAs(7, "POST", "/api/vaults/:id/share/items", "/api/vaults/1/share/items", body, h.ShareItem).Code
+	}
+	req := ShareItemRequest{
+		RecipientUserID: 8,
+		ItemID:          itemID.String(),
+		DeviceKeys: []ShareDeviceKey{{
+			DeviceID:   recipientDevice.String(),
+			WrappedKey: crypto.EncodeBase64([]byte("wrapped")),
+			WrapIV:     crypto.EncodeBase64([]byte("iv")),
+			WrapTag:    crypto.EncodeBase64([]byte("tag")),
+		}},
+	}
+
+	// Everything checks out, but creating the share needs a signed request
+	assert.Equal(t, 401, shareItem(newDB(), req))
+
+	bad := req
+	bad.ItemID = "not-a-uuid"
+	assert.Equal(t, 400, shareItem(newDB(), bad))
+
+	bad = req
+	bad.RecipientUserID = 7
+	assert.Equal(t, 400, shareItem(newDB(), bad))
+
+	bad = req
+	bad.DeviceKeys = []ShareDeviceKey{req.DeviceKeys[0]}
+	bad.DeviceKeys[0].DeviceID = uuid.New().String()
+	assert.Equal(t, 400, shareItem(newDB(), bad))
+
+	// Sharing is share management
+	db := newDB()
+	db.rows["CheckUserVaultAccess"] = []interface{}{vaultRoleEditor}
+	assert.Equal(t, 403, shareItem(db, req))
+
+	// The item must be in the vault named in the path
+	db = newDB()
+	db.rows["GetVaultItemByID"] = []interface{}{sqlc.VaultItem{ID: pgtype.UUID{Bytes: itemID, Valid: true}, VaultID: 2}}
+	assert.Equal(t, 404, shareItem(db, req))
+
+	db = newDB()
+	delete(db.rows, "GetUserDevicePublicKeys")
+	assert.Equal(t, 404, shareItem(db, req))
+}
+
+// TestShareVaultRejectsSelf tests that a vault cannot be shared with the sharer
+func TestShareVaultRejectsSelf(t *testing.T) {
+	body, _ := json.Marshal(ShareVaultRequest{
+		RecipientUserID: 7,
+		DeviceKeys: []ShareDeviceKey{{
+			DeviceID:   uuid.New().String(),
+			WrappedKey: crypto.EncodeBase64([]byte("wrapped")),
+			WrapIV:     crypto.EncodeBase64([]byte("iv")),
+			WrapTag:    crypto.EncodeBase64([]byte("tag")),
+		}},
+	})
+	// The check comes before any query, so the service is never used
+	h := NewShareHandler(&shareStubService{})
+	w := serveAs(7, "POST", "/api/vaults/:id/share", "/api/vaults/1/share", body, h.ShareVault)
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "yourself")
+}
+
+// TestGetSharedItems tests listing the items shared with the current user
+func TestGetSharedItems(t *testing.T) {
+	shareID, itemID := uuid.New(), uuid.New()
+	db := &fakeQueryDB{rows: map[string][]interface{}{
+		"GetSharedItemsForUser": {sqlc.GetSharedItemsForUserRow{
+			ID:           pgtype.UUID{Bytes: shareID, Valid: true},
+			VaultID:      1,
+			ItemID:       pgtype.UUID{Bytes: itemID, Valid: true},
+			SenderUserID: 8,
+			Status:       "accepted",
+			CreatedAt:    pgtype.Timestamp{Time: time.Now(), Valid: true},
+			AcceptedAt:   pgtype.Timestamp{Time: time.Now(), Valid: true},
+			ItemType:     "login",
+			Meta:         []byte(`{"title":"Bank"}`),
+			Version:      3,
+			UpdatedAt:    pgtype.Timestamp{Time: time.Now(), Valid: true},
+		}},
+	}}
+	h := NewShareHandler(&shareStubService{db: db})
+
+	w := serveAs(7, "GET", "/api/shares/items", "/api/shares/items", nil, h.GetSharedItems)
+	require.Equal(t, 200, w.Code)
+
+	var items []SharedItemListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &items))
+	require.Len(t, items, 1)
+	assert.Equal(t, shareID.String(), items[0].ShareID)
+	assert.Equal(t, itemID.String(), items[0].ItemID)
+	assert.Equal(t, "login", items[0].ItemType)
+	assert.JSONEq(t, `{"title":"Bank"}`, string(items[0].Meta))
+	assert.NotNil(t, items[0].AcceptedAt)
+	assert.Nil(t, items[0].ExpiresAt)
+
+	// An empty list is an empty array, not null
+	delete(db.rows, "GetSharedItemsForUser")
+	w = serveAs(7, "GET", "/api/shares/items", "/api/shares/items", nil, h.GetSharedItems)
+	require.Equal(t, 200, w.Code)
+	assert.Equal(t, "[]", w.Body.String())
+}
+
+// TestRejectShareNotPending tests answering a share that is no longer pending
+func TestRejectShareNotPending(t *testing.T) {
+	shareID := uuid.New()
+	reject := func(record sqlc.SharingRecord) *httptest.ResponseRecorder {
+		db := &fakeQueryDB{rows: map[string][]interface{}{
+			"GetSharingRecordByID": {record},
+		}}
+		h := NewShareHandler(&shareStubService{db: db})
+		return serveAs(8, "POST", "/api/shares/:id/reject", "/api/shares/"+shareID.String()+"/reject", nil, h.RejectShare)
+	}
+	record := sqlc.SharingRecord{ID: pgtype.UUID{Bytes: shareID, Valid: true}, VaultID: 1, SenderUserID: 7, RecipientUserID: 8}
+
+	record.Status = "accepted"
+	w := reject(record)
+	assert.Equal(t, 409, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"accepted"`)
+
+	record.Status = "expired"
+	assert.Equal(t, 410, reject(record).Code)
+
+	record.Status = "pending"
+	record.ExpiresAt = pgtype.Timestamp{Time: time.Now().Add(-time.Minute), Valid: true}
+	assert.Equal(t, 410, reject(record).Code)
+}
+
+// TestGetSharedItem tests fetching one shared item with its wrapped IEK
+func TestGetSharedItem(t *testing.T) {
+	shareID, itemID, deviceID := uuid.New(), uuid.New(), uuid.New()
+	record := sqlc.SharingRecord{
+		ID:              pgtype.UUID{Bytes: shareID, Valid: true},
+		VaultID:         1,
+		ItemID:          pgtype.UUID{Bytes: itemID, Valid: true},
+		SenderUserID:    8,
+		RecipientUserID: 7,
+		WrappedKey:      []byte("user-key"),
+		Status:          "accepted",
+		Role:            vaultRoleViewer,
+	}
+	newDB := func(record sqlc.SharingRecord) *fakeQueryDB {
+		return &fakeQueryDB{rows: map[string][]interface{}{
+			"GetSharingRecordByID": {record},
+			"GetVaultItemByID":     {sqlc.VaultItem{ID: record.ItemID, VaultID: 1, ItemType: "login", EncryptedBlob: []byte("blob"), Version: 3}},
+			"GetSessionByToken":    {sqlc.Session{UserID: 7, DeviceID: pgtype.UUID{Bytes: deviceID, Valid: true}}},
+			"GetSharingRecordKey": {sqlc.SharingRecordKey{
+				SharingRecordID:   record.ID,
+				RecipientDeviceID: pgtype.UUID{Bytes: deviceID, Valid: true},
+				WrappedKey:        []byte("device-key"),
+			}},
+		}}
+	}
+	getSharedItem := func(userID int32, record sqlc.SharingRecord) *httptest.ResponseRecorder {
+		h := NewShareHandler(&shareStubService{db: newDB(record)})
+		return serveAs(userID, "GET", "/api/shares/:id/item", "/api/shares/"+shareID.String()+"/item", nil, h.GetSharedItem)
+	}
+
+	w := getSharedItem(7, record)
+	require.Equal(t, 200, w.Code)
+
+	var response SharedItemResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, itemID.String(), response.Item.ID)
+	assert.Equal(t, crypto.EncodeBase64([]byte("blob")), response.Item.EncryptedBlob)
+	// The IEK comes wrapped for the device the session belongs to
+	assert.Equal(t, crypto.EncodeBase64([]byte("device-key")), response.Share.WrappedKey)
+	require.NotNil(t, response.Share.RecipientDeviceID)
+	assert.Equal(t, deviceID.String(), *response.Share.RecipientDeviceID)
+
+	// Someone else's share looks like no share at all
+	assert.Equal(t, 404, getSharedItem(8, record).Code)
+
+	// So does a vault share
+	vaultShare := record
+	vaultShare.ItemID = pgtype.UUID{}
+	assert.Equal(t, 404, getSharedItem(7, vaultShare).Code)
+
+	pending := record
+	pending.Status = "pending"
+	assert.Equal(t, 403, getSharedItem(7, pending).Code)
+
+	expired := record
+	expired.ExpiresAt = pgtype.Timestamp{Time: time.Now().Add(-time.Minute), Valid: true}
+	assert.Equal(t, 403, getSharedItem(7, expired).Code)
+
+	// Not a share ID
+	h := NewShareHandler(&shareStubService{db: newDB(record)})
+	assert.Equal(t, 400, serveAs(7, "GET", "/api/shares/:id/item", "/api/shares/nope/item", nil, h.GetSharedItem).Code)
+}
+
+// TestDeviceSignatureMiddlewareRejectsUnsignedReads tests that guarded reads need device headers
+func TestDeviceSignatureMiddlewareRejectsUnsignedReads(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", int32(1))
+	})
+	router.GET("/api/vaults/1/keys", DeviceSignatureMiddleware(nil), func(c *gin.Context) { c.Status(200) })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/vaults/1/keys", nil))
+	assert.Equal(t, 401, w.Code)
+}
+
+// TestWithDeviceKey tests which wrapped key a device gets for a share
+func TestWithDeviceKey(t *testing.T) {
+	ctx := context.Background()
+	deviceID := pgtype.UUID{Bytes: uuid.New(), Valid: true}
+	record := sqlc.SharingRecord{ID: pgtype.UUID{Bytes: uuid.New(), Valid: true}, WrappedKey: []byte("user-key")}
+
+	db := &fakeQueryDB{rows: map[string][]interface{}{
+		"GetSharingRecordKey": {sqlc.SharingRecordKey{RecipientDeviceID: deviceID, WrappedKey: []byte("device-key")}},
+	}}
+	got, keyDeviceID, err := withDeviceKey(ctx, db.GetQueries(), record, deviceID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("device-key"), got.WrappedKey)
+	assert.Equal(t, deviceID, keyDeviceID)
+
+	// Shares made before device keys still hand out the per-user key
+	db = &fakeQueryDB{rows: map[string][]interface{}{"CountSharingRecordKeys": {int64(0)}}}
+	got, keyDeviceID, err = withDeviceKey(ctx, db.GetQueries(), record, deviceID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("user-key"), got.WrappedKey)
+	assert.False(t, keyDeviceID.Valid)
+
+	// Once other devices have their own wraps, a device without one gets nothing
+	db = &fakeQueryDB{rows: map[string][]interface{}{"CountSharingRecordKeys": {int64(2)}}}
+	got, keyDeviceID, err = withDeviceKey(ctx, db.GetQueries(), record, deviceID)
+	require.NoError(t, err)
+	assert.Empty(t, got.WrappedKey)
+	assert.False(t, keyDeviceID.Valid)
+
+	got, _, err = withDeviceKey(ctx, db.GetQueries(), record, pgtype.UUID{})
+	require.NoError(t, err)
+	assert.Empty(t, got.WrappedKey)
+}
+
+// sessionStubService answers ValidateSession; every other method panics
+type sessionStubService struct {
+	services.Service
+	user *sqlc.GetUserByIDRow
+	err  error
+}
+
+func (s *sessionStubService) ValidateSession(ctx context.Context, sessionToken string) (*sqlc.GetUserByIDRow, error) {
+	if sessionToken != "live-token" {
+		return nil, services.ErrUserNotFound
+	}
+	return s.user, s.err
+}
+
+// TestEventSessionValid tests that event streams stop once their session ends
+func TestEventSessionValid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/events", nil)
+	c.Set(middleware.SessionTokenKey, "live-token")
+
+	svc := &sessionStubService{user: &sqlc.GetUserByIDRow{ID: 7}}
+	assert.True(t, eventSessionValid(c, svc, 7))
+
+	// A session that now resolves to someone else does not keep the stream
+	assert.False(t, eventSessionValid(c, svc, 8))
+
+	// Logged out, revoked or expired
+	svc.err = services.ErrSessionExpired
+	assert.False(t, eventSessionValid(c, svc, 7))
+
+	c.Set(middleware.SessionTokenKey, "rotated-away")
+	svc.err = nil
+	assert.False(t, eventSessionValid(c, svc, 7))
+}
+
+// TestKeyVersionErrorStatus tests how rejected item key versions are reported
+func TestKeyVersionErrorStatus(t *testing.T) {
+	assert.Equal(t, 400, keyVersionErrorStatus(errKeyVersionRequired))
+	assert.Equal(t, 400, keyVersionErrorStatus(errUnknownKeyVersion))
+	// A client still on a retired key needs to refetch it, not fix its request
+	assert.Equal(t, 409, keyVersionErrorStatus(errStaleKeyVersion))
+	assert.Equal(t, 0, keyVersionErrorStatus(nil))
+	assert.Equal(t, 0, keyVersionErrorStatus(fmt.Errorf("connection reset")))
+}
+
+// srpProofStubService accepts one client proof for user 7; every other method panics
+type srpProofStubService struct {
+	services.Service
+}
+
+func (s *srpProofStubService) VerifySrpProof(ctx context.Context, userID int32, handshakeID pgtype.UUID, clientProof []byte) error {
+	if userID != 7 || string(clientProof) != "good" {
+		return services.ErrInvalidCredentials
+	}
+	return nil
+}
+
+func (s *srpProofStubService) UpgradeToSrp(ctx context.Context, userID int32, password string, srpSalt, srpVerifier, kdfSalt, kdfParams []byte) error {
+	switch password {
+	case "upgraded":
+		return services.ErrSrpAlreadyEnabled
+	case "other-kdf":
+		return services.ErrKDFParamsMismatch
+	}
+	return services.ErrInvalidCredentials
+}
+
+// TestUpgradeToSrp tests that a password account is moved to SRP only with valid credentials and settings
+func TestUpgradeToSrp(t *testing.T) {
+	h := NewAuthHandler(&srpProofStubService{})
+	salt := crypto.EncodeBase64(make([]byte, services.AccountSaltLength))
+	verifier := crypto.EncodeBase64([]byte{0x02})
+	params := `{"time":3,"memory":65536,"parallelism":4,"keyLen":32}`
+	upgrade := func(password string) int {
+		body := `{"password":"` + password + `","srp_salt":"` + salt + `","srp_verifier":"` + verifier + `","kdf_salt":"` + salt + `","kdf_params":` + params + `}`
+		return serveAs(7, "POST", "/api/me/srp", "/api/me/srp", []byte(body), h.UpgradeToSrp).Code
+	}
+
+	assert.Equal(t, 400, serveAs(7, "POST", "/api/me/srp", "/api/me/srp", []byte(`{"password":"secret"}`), h.UpgradeToSrp).Code)
+	assert.Equal(t, 403, upgrade("wrong"))
+	assert.Equal(t, 409, upgrade("upgraded"))
+	assert.Equal(t, 409, upgrade("other-kdf"))
+}
+
+type emailVerificationStubService struct {
+	services.Service
+}
+
+func (s *emailVerificationStubService) VerifyEmail(ctx context.Context, token string) (int32, error) {
+	if token != "good" {
+		return 0, services.ErrInvalidEmailVerification
+	}
+	return 7, nil
+}
+
+func (s *emailVerificationStubService) SendEmailVerification(ctx context.Context, userID int32) error {
+	if userID == 7 {
+		return services.ErrEmailAlreadyVerified
+	}
+	return nil
+}
+
+// TestEmailVerification tests redeeming and resending email verification links
+func TestEmailVerification(t *testing.T) {
+	h := NewAuthHandler(&emailVerificationStubService{})
+	verify := func(body string) int {
+		return serveAs(7, "POST", "/api/auth/verify-email", "/api/auth/verify-email", []byte(body), h.VerifyEmail).Code
+	}
+
+	assert.Equal(t, 400, verify(`{}`))
+	assert.Equal(t, 400, verify(`{"token":"bad"}`))
+	assert.Equal(t, 200, verify(`{"token":"good"}`))
+
+	assert.Equal(t, 409, serveAs(7, "POST", "/api/me/email/verification", "/api/me/email/verification", nil, h.ResendEmailVerification).Code)
+	assert.Equal(t, 202, serveAs(8, "POST", "/api/me/email/verification", "/api/me/email/verification", nil, h.ResendEmailVerification).Code)
+}
+
+// TestRequireSrpProof tests the password re-check in front of sensitive account changes
+func TestRequireSrpProof(t *testing.T) {
+	svc := &srpProofStubService{}
+	check := func(userID int32, proof *SrpProof) int {
+		return serveAs(userID, "POST", "/check", "/check", nil, func(c *gin.Context) {
+			if requireSrpProof(c, svc, userID, proof) {
+				c.Status(204)
+			}
+		}).Code
+	}
+	handshakeID := uuid.New().String()
+
+	assert.Equal(t, 403, check(7, nil))
+	assert.Equal(t, 400, check(7, &SrpProof{HandshakeID: "nope", ClientProof: crypto.EncodeBase64([]byte("good"))}))
+	assert.Equal(t, 400, check(7, &SrpProof{HandshakeID: handshakeID, ClientProof: "%%"}))
+	assert.Equal(t, 403, check(7, &SrpProof{HandshakeID: handshakeID, ClientProof: crypto.EncodeBase64([]byte("bad"))}))
+	assert.Equal(t, 403, check(8, &SrpProof{HandshakeID: handshakeID, ClientProof: crypto.EncodeBase64([]byte("good"))}))
+	assert.Equal(t, 204, check(7, &SrpProof{HandshakeID: handshakeID, ClientProof: crypto.EncodeBase64([]byte("good"))}))
+}
+
+// TestDisableTwoFactorRequiresSrpProof tests that a code alone cannot switch 2FA off
+func TestDisableTwoFactorRequiresSrpProof(t *testing.T) {
+	h := NewTwoFactorHandler(&srpProofStubService{})
+	disable := func(body string) int {
+		return serveAs(7, "POST", "/api/2fa/disable", "/api/2fa/disable", []byte(body), h.DisableTwoFactor).Code
+	}
+	handshakeID := uuid.New().String()
+
+	assert.Equal(t, 400, disable(`{"code":"123456"}`))
+	assert.Equal(t, 400, disable(`{"srp_proof":{"handshake_id":"`+handshakeID+`","client_proof":"`+crypto.EncodeBase64([]byte("good"))+`"}}`))
+	assert.Equal(t, 403, disable(`{"code":"123456","srp_proof":{"handshake_id":"`+handshakeID+`","client_proof":"`+crypto.EncodeBase64([]byte("bad"))+`"}}`))
+}
+
+// TestBeginPasskeyRegistrationRequiresReauthentication tests that a session alone cannot add a passkey
+func TestBeginPasskeyRegistrationRequiresReauthentication(t *testing.T) {
+	h := NewWebAuthnHandler(&srpProofStubService{})
+	begin := func(body string) int {
+		return serveAs(7, "POST", "/api/devices/passkeys/options", "/api/devices/passkeys/options", []byte(body), func(c *gin.Context) {
+			c.Set(middleware.UserKey, &sqlc.GetUserByIDRow{ID: 7})
+			h.BeginRegistration(c)
+		}).Code
+	}
+
+	assert.Equal(t, 400, begin(``))
+	assert.Equal(t, 403, begin(`{}`))
+	assert.Equal(t, 403, begin(`{"srp_proof":{"handshake_id":"`+uuid.New().String()+`","client_proof":"`+crypto.EncodeBase64([]byte("bad"))+`"}}`))
+}
+
+// TestDeletePasskeyRequiresSrpProof tests that a session alone cannot remove a passkey
+func TestDeletePasskeyRequiresSrpProof(t *testing.T) {
+	h := NewWebAuthnHandler(&srpProofStubService{})
+	remove := func(body string) int {
+		return serveAs(7, "DELETE", "/api/devices/passkeys/:id", "/api/devices/passkeys/3", []byte(body), h.DeletePasskey).Code
+	}
+
+	assert.Equal(t, 400, remove(``))
+	assert.Equal(t, 400, remove(`{}`))
+	assert.Equal(t, 403, remove(`{"srp_proof":{"handshake_id":"`+uuid.New().String()+`","client_proof":"`+crypto.EncodeBase64([]byte("bad"))+`"}}`))
+}
+
+// TestApplySyncCommitRejectsDuplicateItems tests that an item can appear only once per commit
+func TestApplySyncCommitRejectsDuplicateItems(t *testing.T) {
+	itemID := uuid.New().String()
+	commit := func(items []SyncItemCommit, deleted []string) *SyncCommitFailure {
+		// Duplicates are caught before the transaction is used
+		_, _, err := applySyncCommit(context.Background(), nil, 1, sqlc.VaultVersion{}, &SyncCommitRequest{Items: items, DeletedItems: deleted})
+		var commitErr *syncCommitError
+		require.ErrorAs(t, err, &commitErr)
+		assert.Equal(t, 400, commitErr.status)
+		return &commitErr.entry
+	}
+
+	failure := commit([]SyncItemCommit{{ID: &itemID}, {ID: &itemID}}, nil)
+	assert.Equal(t, 1, failure.Index)
+	assert.Equal(t, "update", failure.AttemptedAction)
+	assert.Equal(t, "duplicate item id", failure.Reason)
+
+	failure = commit([]SyncItemCommit{{ID: &itemID}}, []string{itemID})
+	assert.Equal(t, 0, failure.Index)
+	assert.Equal(t, "delete", failure.AttemptedAction)
+
+	failure = commit(nil, []string{itemID, itemID})
+	assert.Equal(t, 1, failure.Index)
+	assert.Equal(t, "duplicate item id", failure.Reason)
+}
+
+// TestApplyVaultRestoreRefusesRetiredKeys tests that a restore never brings back
+// ciphertext under a key a rotation has retired
+func TestApplyVaultRestoreRefusesRetiredKeys(t *testing.T) {
+	db := &fakeQueryDB{rows: map[string][]interface{}{
+		"GetVaultKeyByVaultID": {sqlc.VaultKey{VaultID: 1, Version: 2}},
+	}}
+	oldItem, unversionedItem := uuid.New(), uuid.New()
+	snapshot := &VaultSnapshot{VaultID: 1, Items: []VaultItemResponse{
+		{ID: oldItem.String(), KeyVersion: 1},
+		{ID: unversionedItem.String(), KeyVersion: 0},
+	}}
+	restore := func(reencrypted map[[16]byte]restoreCiphertext) error {
+		_, _, err := applyVaultRestore(context.Background(), db.GetQueries(), sqlc.VaultVersion{ID: 5, VaultID: 1}, snapshot, reencrypted)
+		return err
+	}
+
+	// Items without a key version are not assumed to use the current key
+	var staleErr *staleSnapshotItemsError
+	require.ErrorAs(t, restore(nil), &staleErr)
+	assert.Equal(t, []string{oldItem.String(), unversionedItem.String()}, staleErr.itemIDs)
+
+	require.ErrorAs(t, restore(map[[16]byte]restoreCiphertext{oldItem: {keyVersion: 2}}), &staleErr)
+	assert.Equal(t, []string{unversionedItem.String()}, staleErr.itemIDs)
+
+	assert.Equal(t, errStaleKeyVersion, restore(map[[16]byte]restoreCiphertext{oldItem: {keyVersion: 1}}))
+	assert.Equal(t, errUnknownSnapshotItem, restore(map[[16]byte]restoreCiphertext{
+		oldItem: {keyVersion: 2}, unversionedItem: {keyVersion: 2}, uuid.New(): {keyVersion: 2},
+	}))
+}
+
 // TestKDFParamsValidation tests KDF parameter validation
 func TestKDFParamsValidation(t *testing.T) {
 	validParams := crypto.KDFParams{
@@ -347,3 +1339,21 @@ func BenchmarkEd25519Signing(b *testing.B) {
 		_ = ed25519.Verify(publicKey, message, signature)
 	}
 }
+
+// TestWriteKeyVersionAfterRotation tests that writes under the key a finalized rotation retired are refused
+func TestWriteKeyVersionAfterRotation(t *testing.T) {
+	queries := (&fakeQueryDB{rows: map[string][]interface{}{
+		"GetVaultKeyByVaultID": {sqlc.VaultKey{VaultID: 1, Version: 2}},
+	}}).GetQueries()
+	status := func(keyVersion int32) int {
+		if _, err := writeKeyVersion(context.Background(), queries, 1, keyVersion); err != nil {
+			return keyVersionErrorStatus(err)
+		}
+		return 200
+	}
+
+	assert.Equal(t, 409, status(1))
+	assert.Equal(t, 200, status(2))
+	assert.Equal(t, 400, status(3))
+	assert.Equal(t, 400, status(0))
+}
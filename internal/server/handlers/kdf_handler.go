@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+
+	"yamony/internal/crypto"
+	"yamony/internal/database/sqlc"
+	"yamony/internal/server/middleware"
+	"yamony/internal/server/services"
+)
+
+// KDFHandler serves the account's Argon2id settings, from which every client
+// derives the same master key
+type KDFHandler struct {
+	services services.Service
+}
+
+func NewKDFHandler(services services.Service) *KDFHandler {
+	return &KDFHandler{services: services}
+}
+
+// KDFParamsResponse carries what a client needs to derive the master key
+type KDFParamsResponse struct {
+	KDFSalt   string          `json:"kdf_salt"`
+	KDFParams json.RawMessage `json:"kdf_params"`
+}
+
+// RewrappedVaultKey is an existing vault key version wrapped with the new master key
+type RewrappedVaultKey struct {
+	VaultID    int32  `json:"vault_id" binding:"required"`
+	Version    int32  `json:"version" binding:"required"`
+	WrappedVEK string `json:"wrapped_vek" binding:"required"`
+	WrapIV     string `json:"wrap_iv" binding:"required"`
+	WrapTag    string `json:"wrap_tag" binding:"required"`
+}
+
+// UpgradeKDFParamsRequest replaces the account's KDF settings. Since the master
+// key changes, the SRP verifier and every owned vault key are replaced with it.
+// SrpProof must be made with the current password and verifier
+type UpgradeKDFParamsRequest struct {
+	SrpProof    *SrpProof           `json:"srp_proof" binding:"required"`
+	KDFSalt     string              `json:"kdf_salt" binding:"required"`
+	KDFParams   json.RawMessage     `json:"kdf_params" binding:"required"`
+	SrpSalt     string              `json:"srp_salt" binding:"required"`
+	SrpVerifier string              `json:"srp_verifier" binding:"required"`
+	VaultKeys   []RewrappedVaultKey `json:"vault_keys" binding:"dive"`
+}
+
+// GetKDFParams returns the KDF salt and params for an email before login.
+// Unknown emails get stable stand-in values instead of an error
+// GET /api/auth/kdf-params?email=
+func (h *KDFHandler) GetKDFParams(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+
+	kdfSalt, kdfParams, err := h.services.GetKDFParams(c.Request.Context(), email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get kdf params"})
+		return
+	}
+
+	c.JSON(http.StatusOK, KDFParamsResponse{
+		KDFSalt:   crypto.EncodeBase64(kdfSalt),
+		KDFParams: kdfParams,
+	})
+}
+
+// UpgradeKDFParams switches the account to new KDF settings, replacing the SRP
+// verifier and re-wrapping every owned vault key in one transaction. A stolen
+// session alone is not enough: the request has to prove the current password,
+// and every other session is signed out afterwards
+// PUT /api/me/kdf-params
+func (h *KDFHandler) UpgradeKDFParams(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req UpgradeKDFParamsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	kdfSalt, ok := decodeKDFSettings(c, req.KDFSalt, req.KDFParams)
+	if !ok {
+		return
+	}
+
+	srpSalt, srpVerifier, ok := decodeSrpCredentials(c, req.SrpSalt, req.SrpVerifier)
+	if !ok {
+		return
+	}
+
+	if !requireSrpProof(c, h.services, userID.(int32), req.SrpProof) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	queries := h.services.GetDB().GetQueries()
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upgrade kdf params"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
+
+	// A staged rotation key is wrapped with the old master key and would become
+	// unreadable once applied
+	vaultIDs, err := qtx.LockUserVaultsForUpdate(ctx, userID.(int32))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upgrade kdf params"})
+		return
+	}
+	for _, vaultID := range vaultIDs {
+		if _, err := qtx.GetActiveVaultKeyRotation(ctx, vaultID); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "vault key rotation in progress", "vault_id": vaultID})
+			return
+		} else if err != pgx.ErrNoRows {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upgrade kdf params"})
+			return
+		}
+	}
+
+	existing, err := qtx.GetOwnedVaultKeys(ctx, userID.(int32))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upgrade kdf params"})
+		return
+	}
+
+	updates, ok := decodeRewrappedVaultKeys(c, existing, req.VaultKeys, kdfSalt, req.KDFParams)
+	if !ok {
+		return
+	}
+
+	for _, update := range updates {
+		if _, err := qtx.UpdateVaultKeyWrap(ctx, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upgrade kdf params"})
+			return
+		}
+	}
+
+	if _, err := qtx.UpdateUserCredentials(ctx, sqlc.UpdateUserCredentialsParams{
+		ID:          userID.(int32),
+		KdfSalt:     kdfSalt,
+		KdfParams:   req.KDFParams,
+		SrpSalt:     srpSalt,
+		SrpVerifier: srpVerifier,
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upgrade kdf params"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upgrade kdf params"})
+		return
+	}
+
+	// Other devices must re-derive the master key before unwrapping vault keys again
+	h.services.PublishUserEvent(ctx, userID.(int32), services.EventKDFParamsChanged, map[string]interface{}{
+		"vault_key_count": len(updates),
+	})
+
+	// Sessions elsewhere were signed in with the old password
+	if _, err := h.services.RevokeOtherSessions(ctx, userID.(int32), c.GetString(middleware.SessionTokenKey)); err != nil {
+		log.Printf("failed to revoke other sessions for user %d after kdf upgrade: %v", userID.(int32), err)
+	}
+
+	// The SRP verifier changed, so a token captured before the upgrade stops working
+	rotateSessionToken(c, h.services)
+
+	c.JSON(http.StatusOK, gin.H{
+		"kdf_salt":           crypto.EncodeBase64(kdfSalt),
+		"kdf_params":         req.KDFParams,
+		"vault_keys_updated": len(updates),
+	})
+}
+
+// decodeKDFSettings decodes the account KDF salt and validates the Argon2id params
+func decodeKDFSettings(c *gin.Context, encodedSalt string, params json.RawMessage) ([]byte, bool) {
+	salt, err := crypto.DecodeBase64(encodedSalt)
+	if err != nil || len(salt) != services.AccountSaltLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("kdf_salt must be %d bytes of base64", services.AccountSaltLength)})
+		return nil, false
+	}
+
+	var kdfParams crypto.KDFParams
+	if err := json.Unmarshal(params, &kdfParams); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid kdf_params format"})
+		return nil, false
+	}
+
+	if err := crypto.ValidateKDFParams(kdfParams); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return salt, true
+}
+
+// decodeRewrappedVaultKeys matches the re-wrapped keys against the owned key
+// versions. Every version must be covered exactly once, otherwise vault keys
+// would be left wrapped with a master key the client can no longer derive
+func decodeRewrappedVaultKeys(c *gin.Context, existing []sqlc.VaultKey, keys []RewrappedVaultKey, kdfSalt, kdfParams []byte) ([]sqlc.UpdateVaultKeyWrapParams, bool) {
+	type keyVersion struct {
+		vaultID int32
+		version int32
+	}
+
+	pending := make(map[keyVersion]bool, len(existing))
+	for _, key := range existing {
+		pending[keyVersion{key.VaultID, key.Version}] = true
+	}
+
+	updates := make([]sqlc.UpdateVaultKeyWrapParams, 0, len(keys))
+	for _, key := range keys {
+		id := keyVersion{key.VaultID, key.Version}
+		if !pending[id] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("vault %d has no key version %d to re-wrap, or it is listed twice", key.VaultID, key.Version)})
+			return nil, false
+		}
+		delete(pending, id)
+
+		wrappedVEK, err := crypto.DecodeBase64(key.WrappedVEK)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrapped_vek format"})
+			return nil, false
+		}
+
+		wrapIV, err := crypto.DecodeBase64(key.WrapIV)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrap_iv format"})
+			return nil, false
+		}
+
+		wrapTag, err := crypto.DecodeBase64(key.WrapTag)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrap_tag format"})
+			return nil, false
+		}
+
+		updates = append(updates, sqlc.UpdateVaultKeyWrapParams{
+			VaultID:    key.VaultID,
+			Version:    key.Version,
+			WrappedVek: wrappedVEK,
+			WrapIv:     wrapIV,
+			WrapTag:    wrapTag,
+			KdfSalt:    kdfSalt,
+			KdfParams:  kdfParams,
+		})
+	}
+
+	if len(pending) > 0 {
+		missing := make([]gin.H, 0, len(pending))
+		for _, key := range existing {
+			if pending[keyVersion{key.VaultID, key.Version}] {
+				missing = append(missing, gin.H{"vault_id": key.VaultID, "version": key.Version})
+			}
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "every vault key version must be re-wrapped", "missing": missing})
+		return nil, false
+	}
+
+	return updates, true
+}
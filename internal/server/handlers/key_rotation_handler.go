@@ -0,0 +1,830 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"yamony/internal/crypto"
+	"yamony/internal/database/sqlc"
+	"yamony/internal/server/services"
+)
+
+// KeyRotationHandler drives vault key rotations: the client starts a rotation
+// with a new wrapped VEK, uploads every item re-encrypted under it and a freshly
+// wrapped key for every recipient, then finalizes. Item writes are refused while
+// a rotation is in progress, so the vault never mixes key versions.
+type KeyRotationHandler struct {
+	services services.Service
+}
+
+func NewKeyRotationHandler(services services.Service) *KeyRotationHandler {
+	return &KeyRotationHandler{services: services}
+}
+
+const (
+	rotationStatusInProgress = "in_progress"
+	rotationStatusFinalized  = "finalized"
+	rotationStatusAborted    = "aborted"
+
+	maxRotationBatchSize = 500
+)
+
+// StartKeyRotationRequest carries the new VEK, wrapped like UploadVaultKeyRequest
+type StartKeyRotationRequest struct {
+	WrappedVEK string          `json:"wrapped_vek" binding:"required"`
+	WrapIV     string          `json:"wrap_iv" binding:"required"`
+	WrapTag    string          `json:"wrap_tag" binding:"required"`
+	KDFSalt    string          `json:"kdf_salt" binding:"required"`
+	KDFParams  json.RawMessage `json:"kdf_params" binding:"required"`
+}
+
+// RotationItemUpload is one item re-encrypted under the new VEK
+type RotationItemUpload struct {
+	ItemID        string `json:"item_id" binding:"required"`
+	BaseVersion   int32  `json:"base_version" binding:"required"` // item version that was re-encrypted
+	EncryptedBlob string `json:"encrypted_blob" binding:"required"`
+	IV            string `json:"iv" binding:"required"`
+	Tag           string `json:"tag" binding:"required"`
+}
+
+// UploadRotationItemsRequest is a batch of re-encrypted items
+type UploadRotationItemsRequest struct {
+	Items []RotationItemUpload `json:"items" binding:"required,min=1,dive"`
+}
+
+// RotationShareUpload is a recipient's key wrapped around the new VEK, or the
+// new IEK for an item share, for one of their devices or, on vault shares made
+// before device keys, for the recipient
+type RotationShareUpload struct {
+	SharingRecordID   string `json:"sharing_record_id" binding:"required"`
+	RecipientDeviceID string `json:"recipient_device_id"`
+	WrappedKey        string `json:"wrapped_key" binding:"required"`
+	WrapIV            string `json:"wrap_iv" binding:"required"`
+	WrapTag           string `json:"wrap_tag" binding:"required"`
+}
+
+// UploadRotationSharesRequest is a batch of re-wrapped recipient keys
+type UploadRotationSharesRequest struct {
+	Shares []RotationShareUpload `json:"shares" binding:"required,min=1,dive"`
+}
+
+// KeyRotationResponse describes a rotation and how much of it has been uploaded
+type KeyRotationResponse struct {
+	ID              string  `json:"id"`
+	VaultID         int32   `json:"vault_id"`
+	FromVersion     int32   `json:"from_version"`
+	ToVersion       int32   `json:"to_version"`
+	Status          string  `json:"status"`
+	StartedByDevice *string `json:"started_by_device,omitempty"`
+	ItemsTotal      int32   `json:"items_total"`
+	ItemsStaged     int32   `json:"items_staged"`
+	SharesTotal     int32   `json:"shares_total"`
+	SharesStaged    int32   `json:"shares_staged"`
+	ReadyToFinalize bool    `json:"ready_to_finalize"`
+	VaultVersionID  *int32  `json:"vault_version_id,omitempty"` // set once finalized
+	CreatedAt       string  `json:"created_at"`
+	FinishedAt      *string `json:"finished_at,omitempty"`
+}
+
+// StartKeyRotation begins a rotation to the next key version
+// POST /api/vaults/:id/rotations
+func (h *KeyRotationHandler) StartKeyRotation(c *gin.Context) {
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device signature required"})
+		return
+	}
+
+	vaultID, ok := h.ownedVaultID(c)
+	if !ok {
+		return
+	}
+
+	var req StartKeyRotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	wrappedVEK, err := crypto.DecodeBase64(req.WrappedVEK)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrapped_vek format"})
+		return
+	}
+
+	wrapIV, err := crypto.DecodeBase64(req.WrapIV)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrap_iv format"})
+		return
+	}
+
+	wrapTag, err := crypto.DecodeBase64(req.WrapTag)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrap_tag format"})
+		return
+	}
+
+	kdfSalt, err := crypto.DecodeBase64(req.KDFSalt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid kdf_salt format"})
+		return
+	}
+
+	var kdfParams crypto.KDFParams
+	if err := json.Unmarshal(req.KDFParams, &kdfParams); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid kdf_params format"})
+		return
+	}
+
+	if err := crypto.ValidateKDFParams(kdfParams); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start key rotation"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := h.services.GetDB().GetQueries().WithTx(tx)
+
+	if _, err := qtx.LockVaultForUpdate(ctx, vaultID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start key rotation"})
+		return
+	}
+
+	if _, err := qtx.GetActiveVaultKeyRotation(ctx, vaultID); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "a key rotation is already in progress for this vault"})
+		return
+	} else if err != pgx.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start key rotation"})
+		return
+	}
+
+	currentKey, err := qtx.GetVaultKeyByVaultID(ctx, vaultID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusConflict, gin.H{"error": "vault has no key to rotate"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start key rotation"})
+		return
+	}
+
+	rotation, err := qtx.CreateVaultKeyRotation(ctx, sqlc.CreateVaultKeyRotationParams{
+		VaultID:         vaultID,
+		FromVersion:     currentKey.Version,
+		ToVersion:       currentKey.Version + 1,
+		WrappedVek:      wrappedVEK,
+		WrapIv:          wrapIV,
+		WrapTag:         wrapTag,
+		KdfSalt:         kdfSalt,
+		KdfParams:       req.KDFParams,
+		StartedByDevice: device.ID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start key rotation"})
+		return
+	}
+
+	progress, err := qtx.GetVaultKeyRotationProgress(ctx, sqlc.GetVaultKeyRotationProgressParams{
+		RotationID: rotation.ID,
+		VaultID:    vaultID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start key rotation"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start key rotation"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, newKeyRotationResponse(rotation, progress))
+}
+
+// GetKeyRotation reports a rotation's progress
+// GET /api/vaults/:id/rotations/:rotation_id
+func (h *KeyRotationHandler) GetKeyRotation(c *gin.Context) {
+	vaultID, ok := h.ownedVaultID(c)
+	if !ok {
+		return
+	}
+
+	rotationID, ok := parseRotationID(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	queries := h.services.GetDB().GetQueries()
+	rotation, err := queries.GetVaultKeyRotation(ctx, sqlc.GetVaultKeyRotationParams{
+		ID:      rotationID,
+		VaultID: vaultID,
+	})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key rotation not found"})
+		return
+	}
+
+	progress, err := queries.GetVaultKeyRotationProgress(ctx, sqlc.GetVaultKeyRotationProgressParams{
+		RotationID: rotation.ID,
+		VaultID:    vaultID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch rotation progress"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newKeyRotationResponse(rotation, progress))
+}
+
+// UploadRotationItems stages a batch of items re-encrypted under the new key.
+// Uploading an item again replaces its staged ciphertext
+// POST /api/vaults/:id/rotations/:rotation_id/items
+func (h *KeyRotationHandler) UploadRotationItems(c *gin.Context) {
+	vaultID, ok := h.ownedVaultID(c)
+	if !ok {
+		return
+	}
+
+	rotationID, ok := parseRotationID(c)
+	if !ok {
+		return
+	}
+
+	var req UploadRotationItemsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Items) > maxRotationBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many items in one batch"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stage items"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := h.services.GetDB().GetQueries().WithTx(tx)
+
+	rotation, ok := activeRotation(c, qtx, rotationID, vaultID)
+	if !ok {
+		return
+	}
+
+	itemIDs := make([]pgtype.UUID, len(req.Items))
+	for i, item := range req.Items {
+		parsed, err := uuid.Parse(item.ItemID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item_id", "index": i})
+			return
+		}
+		itemIDs[i] = pgtype.UUID{Bytes: parsed, Valid: true}
+	}
+
+	current, err := qtx.GetVaultItemsByIDs(ctx, itemIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch items"})
+		return
+	}
+	versions := make(map[[16]byte]int32, len(current))
+	for _, item := range current {
+		if item.VaultID == vaultID {
+			versions[item.ID.Bytes] = item.Version
+		}
+	}
+
+	for i, item := range req.Items {
+		version, found := versions[itemIDs[i].Bytes]
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "item not found in vault", "index": i})
+			return
+		}
+		if version != item.BaseVersion {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":           "item changed since it was re-encrypted",
+				"index":           i,
+				"current_version": version,
+			})
+			return
+		}
+
+		encryptedBlob, err := crypto.DecodeBase64(item.EncryptedBlob)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid encrypted_blob format", "index": i})
+			return
+		}
+
+		iv, err := crypto.DecodeBase64(item.IV)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid iv format", "index": i})
+			return
+		}
+
+		tag, err := crypto.DecodeBase64(item.Tag)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tag format", "index": i})
+			return
+		}
+
+		err = qtx.UpsertVaultKeyRotationItem(ctx, sqlc.UpsertVaultKeyRotationItemParams{
+			RotationID:    rotation.ID,
+			ItemID:        itemIDs[i],
+			BaseVersion:   item.BaseVersion,
+			EncryptedBlob: encryptedBlob,
+			Iv:            iv,
+			Tag:           tag,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stage items"})
+			return
+		}
+	}
+
+	h.respondWithProgress(c, qtx, tx, rotation)
+}
+
+// UploadRotationShares stages a batch of recipient keys wrapped around the new
+// key. Item shares are staged with the item's new IEK
+// POST /api/vaults/:id/rotations/:rotation_id/shares
+func (h *KeyRotationHandler) UploadRotationShares(c *gin.Context) {
+	vaultID, ok := h.ownedVaultID(c)
+	if !ok {
+		return
+	}
+
+	rotationID, ok := parseRotationID(c)
+	if !ok {
+		return
+	}
+
+	var req UploadRotationSharesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Shares) > maxRotationBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many shares in one batch"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stage shares"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := h.services.GetDB().GetQueries().WithTx(tx)
+
+	rotation, ok := activeRotation(c, qtx, rotationID, vaultID)
+	if !ok {
+		return
+	}
+
+	for i, share := range req.Shares {
+		parsed, err := uuid.Parse(share.SharingRecordID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sharing_record_id", "index": i})
+			return
+		}
+		recordID := pgtype.UUID{Bytes: parsed, Valid: true}
+
+		record, err := qtx.GetSharingRecordByID(ctx, recordID)
+		if err != nil || record.VaultID != vaultID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "sharing record not found in vault", "index": i})
+			return
+		}
+		// Item shares only ever had per-device keys
+		if record.ItemID.Valid && share.RecipientDeviceID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "item shares must be re-wrapped for a recipient device", "index": i})
+			return
+		}
+		if record.Status != "pending" && record.Status != "accepted" {
+			c.JSON(http.StatusConflict, gin.H{"error": "sharing record is no longer active", "index": i})
+			return
+		}
+
+		wrappedKey, err := crypto.DecodeBase64(share.WrappedKey)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrapped_key format", "index": i})
+			return
+		}
+
+		wrapIV, err := crypto.DecodeBase64(share.WrapIV)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrap_iv format", "index": i})
+			return
+		}
+
+		wrapTag, err := crypto.DecodeBase64(share.WrapTag)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrap_tag format", "index": i})
+			return
+		}
+
+		if share.RecipientDeviceID != "" {
+			deviceID, err := uuid.Parse(share.RecipientDeviceID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid recipient_device_id", "index": i})
+				return
+			}
+
+			device, err := qtx.GetDeviceByID(ctx, pgtype.UUID{Bytes: deviceID, Valid: true})
+			if err != nil || device.UserID != record.RecipientUserID || device.Status != deviceStatusActive {
+				c.JSON(http.StatusNotFound, gin.H{"error": "recipient device not found", "index": i})
+				return
+			}
+
+			err = qtx.UpsertVaultKeyRotationShareKey(ctx, sqlc.UpsertVaultKeyRotationShareKeyParams{
+				RotationID:        rotation.ID,
+				SharingRecordID:   recordID,
+				RecipientDeviceID: device.ID,
+				WrappedKey:        wrappedKey,
+				WrapIv:            wrapIV,
+				WrapTag:           wrapTag,
+			})
+		} else {
+			err = qtx.UpsertVaultKeyRotationShare(ctx, sqlc.UpsertVaultKeyRotationShareParams{
+				RotationID:      rotation.ID,
+				SharingRecordID: recordID,
+				WrappedKey:      wrappedKey,
+				WrapIv:          wrapIV,
+				WrapTag:         wrapTag,
+			})
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stage shares"})
+			return
+		}
+	}
+
+	h.respondWithProgress(c, qtx, tx, rotation)
+}
+
+// FinalizeKeyRotation atomically swaps in every staged ciphertext and recipient
+// key, records the new vault key version and clears any rotation recommendation.
+// Item shares are re-wrapped when their keys were staged; recipients of the rest
+// lose their keys and are notified.
+// It fails unless every item and every pending or accepted share has been
+// staged, since a share left out would keep a key that no longer opens the vault
+// POST /api/vaults/:id/rotations/:rotation_id/finalize
+func (h *KeyRotationHandler) FinalizeKeyRotation(c *gin.Context) {
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device signature required"})
+		return
+	}
+
+	vaultID, ok := h.ownedVaultID(c)
+	if !ok {
+		return
+	}
+
+	rotationID, ok := parseRotationID(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to finalize key rotation"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := h.services.GetDB().GetQueries().WithTx(tx)
+
+	// Lock the vault before checking progress so nothing moves underneath us
+	if _, err := qtx.LockVaultForUpdate(ctx, vaultID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to finalize key rotation"})
+		return
+	}
+
+	rotation, ok := activeRotation(c, qtx, rotationID, vaultID)
+	if !ok {
+		return
+	}
+
+	progress, err := qtx.GetVaultKeyRotationProgress(ctx, sqlc.GetVaultKeyRotationProgressParams{
+		RotationID: rotation.ID,
+		VaultID:    vaultID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to finalize key rotation"})
+		return
+	}
+
+	if !rotationReady(progress) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":    "rotation is incomplete: every item and pending or accepted share must be uploaded",
+			"rotation": newKeyRotationResponse(rotation, progress),
+		})
+		return
+	}
+
+	// Finish the rotation first so createVaultVersion accepts the write
+	if _, err := qtx.FinishVaultKeyRotation(ctx, sqlc.FinishVaultKeyRotationParams{
+		ID:     rotation.ID,
+		Status: rotationStatusFinalized,
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to finalize key rotation"})
+		return
+	}
+
+	vaultVersion, err := createVaultVersion(ctx, qtx, vaultID, device.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create version"})
+		return
+	}
+
+	items, err := qtx.GetVaultItemsByVaultID(ctx, vaultID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to finalize key rotation"})
+		return
+	}
+	for _, item := range items {
+		if err := qtx.ArchiveVaultItemRevision(ctx, item.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to archive item revision"})
+			return
+		}
+	}
+
+	applied, err := qtx.ApplyVaultKeyRotationItems(ctx, sqlc.ApplyVaultKeyRotationItemsParams{
+		RotationID:     rotation.ID,
+		VaultVersionID: pgtype.Int4{Int32: vaultVersion.ID, Valid: true},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply re-encrypted items"})
+		return
+	}
+	if len(applied) != len(items) {
+		c.JSON(http.StatusConflict, gin.H{"error": "items changed while finalizing, upload them again"})
+		return
+	}
+
+	if _, err := qtx.ApplyVaultKeyRotationShares(ctx, rotation.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply re-wrapped shares"})
+		return
+	}
+
+	// Item shares left out keep no key for the re-encrypted item; their
+	// recipients are told so they can ask the sender for a new one
+	staleItemShares, err := qtx.GetItemSharesMissingRotationKeys(ctx, sqlc.GetItemSharesMissingRotationKeysParams{
+		RotationID: rotation.ID,
+		VaultID:    vaultID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply re-wrapped shares"})
+		return
+	}
+
+	// Device keys still wrap the old VEK or IEKs; devices left out ask for a new one
+	if err := qtx.DeleteSharingRecordKeysByVaultID(ctx, vaultID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply re-wrapped shares"})
+		return
+	}
+	if _, err := qtx.ApplyVaultKeyRotationShareKeys(ctx, rotation.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply re-wrapped shares"})
+		return
+	}
+
+	_, err = qtx.CreateVaultKey(ctx, sqlc.CreateVaultKeyParams{
+		VaultID:    vaultID,
+		WrappedVek: rotation.WrappedVek,
+		WrapIv:     rotation.WrapIv,
+		WrapTag:    rotation.WrapTag,
+		KdfSalt:    rotation.KdfSalt,
+		KdfParams:  rotation.KdfParams,
+		Version:    rotation.ToVersion,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create vault key"})
+		return
+	}
+
+	if err := qtx.ClearVaultRotationRecommended(ctx, vaultID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to finalize key rotation"})
+		return
+	}
+
+	if err := deleteRotationStaging(ctx, qtx, rotation.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to finalize key rotation"})
+		return
+	}
+
+	snapshotData, err := snapshotVaultVersion(ctx, qtx, vaultVersion)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build vault snapshot"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to finalize key rotation"})
+		return
+	}
+
+	h.services.PublishVaultEvent(ctx, vaultID, services.EventKeyRotated, map[string]interface{}{
+		"vault_id":    vaultID,
+		"key_version": rotation.ToVersion,
+	})
+	for _, share := range staleItemShares {
+		h.services.PublishUserEvent(ctx, share.RecipientUserID, services.EventShareKeysStale, map[string]interface{}{
+			"share_id": uuidToString(share.ID),
+			"vault_id": vaultID,
+			"item_id":  uuidToString(share.ItemID),
+		})
+	}
+	storeVaultSnapshot(ctx, h.services.ObjectStore(), vaultVersion, snapshotData)
+	publishVaultVersion(ctx, h.services, vaultVersion)
+
+	rotation.Status = rotationStatusFinalized
+	response := newKeyRotationResponse(rotation, progress)
+	response.VaultVersionID = &vaultVersion.ID
+
+	c.JSON(http.StatusOK, response)
+}
+
+// AbortKeyRotation abandons a rotation and discards everything staged for it
+// DELETE /api/vaults/:id/rotations/:rotation_id
+func (h *KeyRotationHandler) AbortKeyRotation(c *gin.Context) {
+	vaultID, ok := h.ownedVaultID(c)
+	if !ok {
+		return
+	}
+
+	rotationID, ok := parseRotationID(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to abort key rotation"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := h.services.GetDB().GetQueries().WithTx(tx)
+
+	rotation, ok := activeRotation(c, qtx, rotationID, vaultID)
+	if !ok {
+		return
+	}
+
+	if _, err := qtx.FinishVaultKeyRotation(ctx, sqlc.FinishVaultKeyRotationParams{
+		ID:     rotation.ID,
+		Status: rotationStatusAborted,
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to abort key rotation"})
+		return
+	}
+
+	if err := deleteRotationStaging(ctx, qtx, rotation.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to abort key rotation"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to abort key rotation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "key rotation aborted"})
+}
+
+// ownedVaultID parses the vault id and checks the user owns the vault;
+// only owners may rotate a vault's key
+func (h *KeyRotationHandler) ownedVaultID(c *gin.Context) (int32, bool) {
+	vaultID, err := parseIntParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault_id"})
+		return 0, false
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return 0, false
+	}
+
+	vault, err := h.services.GetDB().GetQueries().GetVaultByID(c.Request.Context(), vaultID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
+		return 0, false
+	}
+
+	if vault.UserID != userID.(int32) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the vault owner can rotate its key"})
+		return 0, false
+	}
+
+	return vaultID, true
+}
+
+// respondWithProgress commits a staging batch and returns the updated progress
+func (h *KeyRotationHandler) respondWithProgress(c *gin.Context, qtx *sqlc.Queries, tx pgx.Tx, rotation sqlc.VaultKeyRotation) {
+	ctx := c.Request.Context()
+	progress, err := qtx.GetVaultKeyRotationProgress(ctx, sqlc.GetVaultKeyRotationProgressParams{
+		RotationID: rotation.ID,
+		VaultID:    rotation.VaultID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch rotation progress"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stage upload"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newKeyRotationResponse(rotation, progress))
+}
+
+// activeRotation loads a rotation that can still take uploads, writing the
+// error response when it cannot
+func activeRotation(c *gin.Context, qtx *sqlc.Queries, rotationID pgtype.UUID, vaultID int32) (sqlc.VaultKeyRotation, bool) {
+	rotation, err := qtx.GetVaultKeyRotation(c.Request.Context(), sqlc.GetVaultKeyRotationParams{
+		ID:      rotationID,
+		VaultID: vaultID,
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "key rotation not found"})
+			return sqlc.VaultKeyRotation{}, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch key rotation"})
+		return sqlc.VaultKeyRotation{}, false
+	}
+
+	if rotation.Status != rotationStatusInProgress {
+		c.JSON(http.StatusConflict, gin.H{"error": "key rotation is already " + rotation.Status})
+		return sqlc.VaultKeyRotation{}, false
+	}
+
+	return rotation, true
+}
+
+func parseRotationID(c *gin.Context) (pgtype.UUID, bool) {
+	parsed, err := uuid.Parse(c.Param("rotation_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rotation_id"})
+		return pgtype.UUID{}, false
+	}
+	return pgtype.UUID{Bytes: parsed, Valid: true}, true
+}
+
+func deleteRotationStaging(ctx context.Context, qtx *sqlc.Queries, rotationID pgtype.UUID) error {
+	if err := qtx.DeleteVaultKeyRotationItems(ctx, rotationID); err != nil {
+		return err
+	}
+	if err := qtx.DeleteVaultKeyRotationShares(ctx, rotationID); err != nil {
+		return err
+	}
+	return qtx.DeleteVaultKeyRotationShareKeys(ctx, rotationID)
+}
+
+// rotationReady reports whether every item and pending or accepted share has been staged
+func rotationReady(progress sqlc.GetVaultKeyRotationProgressRow) bool {
+	return progress.ItemsStaged == progress.ItemsTotal && progress.SharesStaged == progress.SharesTotal
+}
+
+func newKeyRotationResponse(rotation sqlc.VaultKeyRotation, progress sqlc.GetVaultKeyRotationProgressRow) KeyRotationResponse {
+	return KeyRotationResponse{
+		ID:              uuidToString(rotation.ID),
+		VaultID:         rotation.VaultID,
+		FromVersion:     rotation.FromVersion,
+		ToVersion:       rotation.ToVersion,
+		Status:          rotation.Status,
+		StartedByDevice: uuidToStringPtr(rotation.StartedByDevice),
+		ItemsTotal:      progress.ItemsTotal,
+		ItemsStaged:     progress.ItemsStaged,
+		SharesTotal:     progress.SharesTotal,
+		SharesStaged:    progress.SharesStaged,
+		ReadyToFinalize: rotation.Status == rotationStatusInProgress && rotationReady(progress),
+		CreatedAt:       rotation.CreatedAt.Time.Format("2006-01-02T15:04:05Z07:00"),
+		FinishedAt:      timestampToStringPtr(rotation.FinishedAt),
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"yamony/internal/database/sqlc"
+	"yamony/internal/server/middleware"
+	"yamony/internal/server/services"
+)
+
+// SessionHandler lists the user's signed-in sessions and signs them out remotely
+type SessionHandler struct {
+	services services.Service
+}
+
+func NewSessionHandler(services services.Service) *SessionHandler {
+	return &SessionHandler{services: services}
+}
+
+// SessionResponse describes a session without its token
+type SessionResponse struct {
+	ID           int32     `json:"id"`
+	DeviceID     *string   `json:"device_id,omitempty"`
+	UserAgent    string    `json:"user_agent"`
+	IPAddress    string    `json:"ip_address"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Current      bool      `json:"current"`
+}
+
+// GetSessions lists the user's sessions, marking the one making the request
+// GET /api/sessions
+func (h *SessionHandler) GetSessions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	userSessions, err := h.services.ListSessions(c.Request.Context(), userID.(int32), c.GetString(middleware.SessionTokenKey))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get sessions"})
+		return
+	}
+
+	response := make([]SessionResponse, len(userSessions))
+	for i, session := range userSessions {
+		response[i] = sessionResponse(session)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": response})
+}
+
+// RevokeSession signs one session out. Revoking the current session logs out
+// DELETE /api/sessions/:id
+func (h *SessionHandler) RevokeSession(c *gin.Context) {
+	sessionID, err := parseIntParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session id"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	current, err := h.services.RevokeSession(c.Request.Context(), userID.(int32), sessionID, c.GetString(middleware.SessionTokenKey))
+	if err != nil {
+		if err == services.ErrSessionNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+		return
+	}
+
+	if current {
+		session := sessions.Default(c)
+		session.Clear()
+		if err := session.Save(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to clear session"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked", "current": current})
+}
+
+// RevokeOtherSessions signs out every session except the one making the request
+// POST /api/sessions/revoke-others
+func (h *SessionHandler) RevokeOtherSessions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	revoked, err := h.services.RevokeOtherSessions(c.Request.Context(), userID.(int32), c.GetString(middleware.SessionTokenKey))
+	if err != nil {
+		if err == services.ErrSessionNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized: invalid or expired session"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "other sessions revoked", "revoked": revoked})
+}
+
+// rotateSessionToken gives the current session a fresh token after a privilege
+// change and stores it in the cookie. The change has already been made, so a
+// failure is logged rather than returned
+func rotateSessionToken(c *gin.Context, svc services.Service) {
+	sessionToken := c.GetString(middleware.SessionTokenKey)
+	if sessionToken == "" {
+		return
+	}
+
+	newToken, err := svc.RotateSession(c.Request.Context(), sessionToken)
+	if err != nil {
+		log.Printf("failed to rotate session token: %v", err)
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Set(middleware.SessionTokenKey, newToken)
+	if err := session.Save(); err != nil {
+		log.Printf("failed to save rotated session token: %v", err)
+		return
+	}
+	c.Set(middleware.SessionTokenKey, newToken)
+}
+
+func sessionResponse(session sqlc.GetSessionsByUserIDRow) SessionResponse {
+	return SessionResponse{
+		ID:           session.ID,
+		DeviceID:     uuidToStringPtr(session.DeviceID),
+		UserAgent:    session.UserAgent,
+		IPAddress:    session.IpAddress,
+		CreatedAt:    timestampToTime(session.CreatedAt),
+		LastActiveAt: timestampToTime(session.LastActiveAt),
+		ExpiresAt:    timestampToTime(session.ExpiresAt),
+		Current:      session.Current,
+	}
+}
@@ -1,11 +1,13 @@
 package handlers
 
 import (
-	"crypto/sha256"
+	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 
 	"yamony/internal/crypto"
@@ -23,19 +25,28 @@ func NewShareHandler(services services.Service) *ShareHandler {
 
 // ShareVaultRequest represents a request to share a vault with another user
 type ShareVaultRequest struct {
-	RecipientUserID int32  `json:"recipient_user_id" binding:"required"`
-	WrappedVEK      string `json:"wrapped_vek" binding:"required"` // base64 encoded VEK wrapped with ECDH
-	WrapIV          string `json:"wrap_iv" binding:"required"`     // base64 encoded
-	WrapTag         string `json:"wrap_tag" binding:"required"`    // base64 encoded
+	RecipientUserID int32            `json:"recipient_user_id" binding:"required"`
+	DeviceKeys      []ShareDeviceKey `json:"device_keys" binding:"required,min=1,dive"` // VEK wrapped for each of the recipient's devices
+	Role            string           `json:"role"`                                      // viewer, editor or manager; defaults to viewer
+	ExpiresAt       *time.Time       `json:"expires_at"`                                // optional; the share lapses at this time
+}
+
+// UpdateShareRoleRequest represents a request to change a vault member's role
+type UpdateShareRoleRequest struct {
+	Role string `json:"role" binding:"required"` // viewer, editor or manager
 }
 
 // ShareItemRequest represents a request to share a specific vault item
 type ShareItemRequest struct {
-	RecipientUserID int32  `json:"recipient_user_id" binding:"required"`
-	ItemID          string `json:"item_id" binding:"required"`
-	WrappedIEK      string `json:"wrapped_iek" binding:"required"` // base64 encoded IEK wrapped with ECDH
-	WrapIV          string `json:"wrap_iv" binding:"required"`     // base64 encoded
-	WrapTag         string `json:"wrap_tag" binding:"required"`    // base64 encoded
+	RecipientUserID int32            `json:"recipient_user_id" binding:"required"`
+	ItemID          string           `json:"item_id" binding:"required"`
+	DeviceKeys      []ShareDeviceKey `json:"device_keys" binding:"required,min=1,dive"` // IEK wrapped for each of the recipient's devices
+	ExpiresAt       *time.Time       `json:"expires_at"`                                // optional; the share lapses at this time
+}
+
+// UpdateShareExpiryRequest moves a share's expiry; null removes it
+type UpdateShareExpiryRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
 }
 
 // SharingRecordResponse represents a sharing record
@@ -49,8 +60,35 @@ type SharingRecordResponse struct {
 	WrapIV          string  `json:"wrap_iv"`
 	WrapTag         string  `json:"wrap_tag"`
 	Status          string  `json:"status"`
+	Role            string  `json:"role"`
 	CreatedAt       string  `json:"created_at"`
 	AcceptedAt      *string `json:"accepted_at,omitempty"`
+	ExpiresAt       *string `json:"expires_at,omitempty"`
+
+	// Device the wrapped key is for; absent on shares keyed per user before devices
+	RecipientDeviceID *string `json:"recipient_device_id,omitempty"`
+}
+
+// SharedItemListResponse describes an item shared with the user, without its ciphertext
+type SharedItemListResponse struct {
+	ShareID      string          `json:"share_id"`
+	VaultID      int32           `json:"vault_id"`
+	ItemID       string          `json:"item_id"`
+	SenderUserID int32           `json:"sender_user_id"`
+	ItemType     string          `json:"item_type"`
+	Meta         json.RawMessage `json:"meta,omitempty"`
+	Version      int32           `json:"version"`
+	Status       string          `json:"status"`
+	CreatedAt    string          `json:"created_at"`
+	AcceptedAt   *string         `json:"accepted_at,omitempty"`
+	ExpiresAt    *string         `json:"expires_at,omitempty"`
+	UpdatedAt    string          `json:"updated_at"` // when the item last changed
+}
+
+// SharedItemResponse carries a shared item's ciphertext and the IEK wrapped for the recipient
+type SharedItemResponse struct {
+	Share SharingRecordResponse `json:"share"`
+	Item  VaultItemResponse     `json:"item"`
 }
 
 // SharedVaultResponse represents a vault shared with the user
@@ -83,94 +121,171 @@ func (h *ShareHandler) ShareVault(c *gin.Context) {
 		return
 	}
 
-	// Verify device signature
-	sigData, err := ExtractDeviceSignature(c)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required: " + err.Error()})
+	if req.RecipientUserID == userID.(int32) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot share a vault with yourself"})
+		return
+	}
+
+	role := req.Role
+	if role == "" {
+		role = vaultRoleViewer
+	}
+	if !validMemberRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be viewer, editor or manager"})
+		return
+	}
+
+	expiresAt, ok := parseShareExpiry(c, req.ExpiresAt)
+	if !ok {
 		return
 	}
 
 	queries := h.services.GetDB().GetQueries()
 
-	// Verify vault belongs to user
-	vault, err := queries.GetVaultByID(c.Request.Context(), vaultID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
+	// Owners and managers share the vault; only owners appoint managers
+	sharerRole, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleManager)
+	if !ok {
+		return
+	}
+	if role == vaultRoleManager && sharerRole != vaultRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the vault owner can grant the manager role"})
+		return
+	}
+
+	// Verify recipient user exists (get their public keys)
+	recipientKeys, err := queries.GetUserDevicePublicKeys(c.Request.Context(), req.RecipientUserID)
+	if err != nil || len(recipientKeys) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "recipient user not found or has no devices"})
 		return
 	}
 
-	if vault.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "only vault owner can share"})
+	deviceKeys, ok := decodeShareDeviceKeys(c, recipientKeys, req.DeviceKeys)
+	if !ok {
 		return
 	}
 
-	// Verify device
-	pgDeviceID := pgtype.UUID{}
-	_ = pgDeviceID.Scan(sigData.DeviceID.String())
+	sharingRecord, ok := h.createShare(c, sqlc.CreateSharingRecordParams{
+		VaultID:         vaultID,
+		ItemID:          pgtype.UUID{Valid: false}, // NULL for vault sharing
+		SenderUserID:    userID.(int32),
+		RecipientUserID: req.RecipientUserID,
+		Status:          "pending",
+		Role:            role,
+		ExpiresAt:       expiresAt,
+	}, deviceKeys)
+	if !ok {
+		return
+	}
 
-	device, err := queries.GetDeviceByID(c.Request.Context(), pgDeviceID)
+	h.services.PublishUserEvent(c.Request.Context(), sharingRecord.RecipientUserID, services.EventShareInvitation, map[string]interface{}{
+		"share_id":       uuidToString(sharingRecord.ID),
+		"vault_id":       sharingRecord.VaultID,
+		"sender_user_id": sharingRecord.SenderUserID,
+		"role":           sharingRecord.Role,
+	})
+
+	response := SharingRecordResponse{
+		ID:              uuidToString(sharingRecord.ID),
+		VaultID:         sharingRecord.VaultID,
+		ItemID:          uuidToStringPtr(sharingRecord.ItemID),
+		SenderUserID:    sharingRecord.SenderUserID,
+		RecipientUserID: sharingRecord.RecipientUserID,
+		WrappedKey:      crypto.EncodeBase64(sharingRecord.WrappedKey),
+		WrapIV:          crypto.EncodeBase64(sharingRecord.WrapIv),
+		WrapTag:         crypto.EncodeBase64(sharingRecord.WrapTag),
+		Status:          sharingRecord.Status,
+		Role:            sharingRecord.Role,
+		CreatedAt:       timestampToTime(sharingRecord.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		AcceptedAt:      timestampToStringPtr(sharingRecord.AcceptedAt),
+		ExpiresAt:       timestampToStringPtr(sharingRecord.ExpiresAt),
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// ShareItem shares a single vault item with another user. The recipient gets
+// the item's IEK wrapped for them and no access to the rest of the vault
+// POST /api/vaults/:id/share/items
+func (h *ShareHandler) ShareItem(c *gin.Context) {
+	vaultID, err := parseIntParam(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "device not found"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault_id"})
 		return
 	}
 
-	if device.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "device does not belong to user"})
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
 		return
 	}
 
-	// Verify signature
-	bodyBytes, _ := c.Get("raw_body")
-	bodyHash := sha256.Sum256(bodyBytes.([]byte))
-	canonicalMsg := CreateCanonicalMessage(c.Request.Method, c.Request.URL.Path, sigData.Timestamp, bodyHash[:])
+	var req ShareItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	if !VerifyDeviceSignature(device.Ed25519Public, canonicalMsg, sigData.Signature) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid device signature"})
+	itemID, err := uuid.Parse(req.ItemID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item_id"})
 		return
 	}
 
-	// Verify recipient user exists (get their public keys)
-	recipientKeys, err := queries.GetUserDevicePublicKeys(c.Request.Context(), req.RecipientUserID)
-	if err != nil || len(recipientKeys) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "recipient user not found or has no devices"})
+	if req.RecipientUserID == userID.(int32) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot share an item with yourself"})
 		return
 	}
 
-	// Decode wrapped key
-	wrappedKey, err := crypto.DecodeBase64(req.WrappedVEK)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrapped_vek format"})
+	expiresAt, ok := parseShareExpiry(c, req.ExpiresAt)
+	if !ok {
 		return
 	}
 
-	wrapIV, err := crypto.DecodeBase64(req.WrapIV)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrap_iv format"})
+	queries := h.services.GetDB().GetQueries()
+
+	// Item shares are share management, like sharing the whole vault
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleManager); !ok {
 		return
 	}
 
-	wrapTag, err := crypto.DecodeBase64(req.WrapTag)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrap_tag format"})
+	item, err := queries.GetVaultItemByID(c.Request.Context(), pgtype.UUID{Bytes: itemID, Valid: true})
+	if err != nil || item.VaultID != vaultID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "vault item not found"})
+		return
+	}
+
+	// Verify recipient user exists (get their public keys)
+	recipientKeys, err := queries.GetUserDevicePublicKeys(c.Request.Context(), req.RecipientUserID)
+	if err != nil || len(recipientKeys) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "recipient user not found or has no devices"})
 		return
 	}
 
-	// Create sharing record
-	sharingRecord, err := queries.CreateSharingRecord(c.Request.Context(), sqlc.CreateSharingRecordParams{
+	deviceKeys, ok := decodeShareDeviceKeys(c, recipientKeys, req.DeviceKeys)
+	if !ok {
+		return
+	}
+
+	sharingRecord, ok := h.createShare(c, sqlc.CreateSharingRecordParams{
 		VaultID:         vaultID,
-		ItemID:          pgtype.UUID{Valid: false}, // NULL for vault sharing
+		ItemID:          item.ID,
 		SenderUserID:    userID.(int32),
 		RecipientUserID: req.RecipientUserID,
-		WrappedKey:      wrappedKey,
-		WrapIv:          wrapIV,
-		WrapTag:         wrapTag,
 		Status:          "pending",
-	})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create sharing record"})
+		Role:            vaultRoleViewer, // item shares are read only
+		ExpiresAt:       expiresAt,
+	}, deviceKeys)
+	if !ok {
 		return
 	}
 
+	h.services.PublishUserEvent(c.Request.Context(), sharingRecord.RecipientUserID, services.EventShareInvitation, map[string]interface{}{
+		"share_id":       uuidToString(sharingRecord.ID),
+		"vault_id":       sharingRecord.VaultID,
+		"item_id":        uuidToString(sharingRecord.ItemID),
+		"sender_user_id": sharingRecord.SenderUserID,
+	})
+
 	response := SharingRecordResponse{
 		ID:              uuidToString(sharingRecord.ID),
 		VaultID:         sharingRecord.VaultID,
@@ -181,13 +296,134 @@ func (h *ShareHandler) ShareVault(c *gin.Context) {
 		WrapIV:          crypto.EncodeBase64(sharingRecord.WrapIv),
 		WrapTag:         crypto.EncodeBase64(sharingRecord.WrapTag),
 		Status:          sharingRecord.Status,
+		Role:            sharingRecord.Role,
 		CreatedAt:       timestampToTime(sharingRecord.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
 		AcceptedAt:      timestampToStringPtr(sharingRecord.AcceptedAt),
+		ExpiresAt:       timestampToStringPtr(sharingRecord.ExpiresAt),
 	}
 
 	c.JSON(http.StatusCreated, response)
 }
 
+// GetSharedItems lists the items shared with the current user, pending or accepted
+// GET /api/shares/items
+func (h *ShareHandler) GetSharedItems(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	queries := h.services.GetDB().GetQueries()
+
+	items, err := queries.GetSharedItemsForUser(c.Request.Context(), userID.(int32))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch shared items"})
+		return
+	}
+
+	response := make([]SharedItemListResponse, len(items))
+	for i, item := range items {
+		response[i] = SharedItemListResponse{
+			ShareID:      uuidToString(item.ID),
+			VaultID:      item.VaultID,
+			ItemID:       uuidToString(item.ItemID),
+			SenderUserID: item.SenderUserID,
+			ItemType:     item.ItemType,
+			Meta:         item.Meta,
+			Version:      item.Version,
+			Status:       item.Status,
+			CreatedAt:    timestampToTime(item.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+			AcceptedAt:   timestampToStringPtr(item.AcceptedAt),
+			ExpiresAt:    timestampToStringPtr(item.ExpiresAt),
+			UpdatedAt:    timestampToTime(item.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetSharedItem returns the ciphertext of an item shared with the current user
+// together with its wrapped IEK. The share must have been accepted
+// GET /api/shares/:id/item
+func (h *ShareHandler) GetSharedItem(c *gin.Context) {
+	shareID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share_id"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	queries := h.services.GetDB().GetQueries()
+
+	record, err := queries.GetSharingRecordByID(c.Request.Context(), pgtype.UUID{Bytes: shareID, Valid: true})
+	if err != nil || record.RecipientUserID != userID.(int32) || !record.ItemID.Valid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "shared item not found"})
+		return
+	}
+
+	if record.Status != "accepted" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "share has not been accepted", "status": record.Status})
+		return
+	}
+	if shareExpired(record, time.Now()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "share has expired"})
+		return
+	}
+
+	item, err := queries.GetVaultItemByID(c.Request.Context(), record.ItemID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "shared item not found"})
+		return
+	}
+
+	record, keyDeviceID, err := withSessionDeviceKey(c, queries, record)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get share key"})
+		return
+	}
+
+	response := SharedItemResponse{
+		Share: SharingRecordResponse{
+			ID:              uuidToString(record.ID),
+			VaultID:         record.VaultID,
+			ItemID:          uuidToStringPtr(record.ItemID),
+			SenderUserID:    record.SenderUserID,
+			RecipientUserID: record.RecipientUserID,
+			WrappedKey:      crypto.EncodeBase64(record.WrappedKey),
+			WrapIV:          crypto.EncodeBase64(record.WrapIv),
+			WrapTag:         crypto.EncodeBase64(record.WrapTag),
+			Status:          record.Status,
+			Role:            record.Role,
+			CreatedAt:       timestampToTime(record.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+			AcceptedAt:      timestampToStringPtr(record.AcceptedAt),
+			ExpiresAt:       timestampToStringPtr(record.ExpiresAt),
+
+			RecipientDeviceID: uuidToStringPtr(keyDeviceID),
+		},
+		Item: VaultItemResponse{
+			ID:            uuidToString(item.ID),
+			VaultID:       item.VaultID,
+			ItemType:      item.ItemType,
+			EncryptedBlob: crypto.EncodeBase64(item.EncryptedBlob),
+			IV:            crypto.EncodeBase64(item.Iv),
+			Tag:           crypto.EncodeBase64(item.Tag),
+			Meta:          item.Meta,
+			Version:       item.Version,
+			KeyVersion:    item.KeyVersion,
+			CreatedAt:     timestampToTime(item.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+			UpdatedAt:     timestampToTime(item.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		},
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 // GetPendingShares retrieves pending sharing invitations for the current user
 func (h *ShareHandler) GetPendingShares(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -206,6 +442,12 @@ func (h *ShareHandler) GetPendingShares(c *gin.Context) {
 
 	response := make([]SharingRecordResponse, len(records))
 	for i, record := range records {
+		record, keyDeviceID, err := withSessionDeviceKey(c, queries, record)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get share key"})
+			return
+		}
+
 		response[i] = SharingRecordResponse{
 			ID:              uuidToString(record.ID),
 			VaultID:         record.VaultID,
@@ -216,8 +458,12 @@ func (h *ShareHandler) GetPendingShares(c *gin.Context) {
 			WrapIV:          crypto.EncodeBase64(record.WrapIv),
 			WrapTag:         crypto.EncodeBase64(record.WrapTag),
 			Status:          record.Status,
+			Role:            record.Role,
 			CreatedAt:       timestampToTime(record.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
 			AcceptedAt:      timestampToStringPtr(record.AcceptedAt),
+			ExpiresAt:       timestampToStringPtr(record.ExpiresAt),
+
+			RecipientDeviceID: uuidToStringPtr(keyDeviceID),
 		}
 	}
 
@@ -256,13 +502,27 @@ func (h *ShareHandler) AcceptShare(c *gin.Context) {
 		return
 	}
 
+	if !requireTwoFactor(c, h.services, userID.(int32)) {
+		return
+	}
+
 	// Accept the share
 	updatedRecord, err := queries.AcceptSharingRecord(c.Request.Context(), pgShareID)
+	if err == pgx.ErrNoRows {
+		respondShareNotPending(c, sharingRecord, "accepted")
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to accept share"})
 		return
 	}
 
+	updatedRecord, keyDeviceID, err := withSessionDeviceKey(c, queries, updatedRecord)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get share key"})
+		return
+	}
+
 	response := SharingRecordResponse{
 		ID:              uuidToString(updatedRecord.ID),
 		VaultID:         updatedRecord.VaultID,
@@ -273,8 +533,12 @@ func (h *ShareHandler) AcceptShare(c *gin.Context) {
 		WrapIV:          crypto.EncodeBase64(updatedRecord.WrapIv),
 		WrapTag:         crypto.EncodeBase64(updatedRecord.WrapTag),
 		Status:          updatedRecord.Status,
+		Role:            updatedRecord.Role,
 		CreatedAt:       timestampToTime(updatedRecord.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
 		AcceptedAt:      timestampToStringPtr(updatedRecord.AcceptedAt),
+		ExpiresAt:       timestampToStringPtr(updatedRecord.ExpiresAt),
+
+		RecipientDeviceID: uuidToStringPtr(keyDeviceID),
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -315,6 +579,10 @@ func (h *ShareHandler) RejectShare(c *gin.Context) {
 
 	// Reject the share
 	updatedRecord, err := queries.RejectSharingRecord(c.Request.Context(), pgShareID)
+	if err == pgx.ErrNoRows {
+		respondShareNotPending(c, sharingRecord, "rejected")
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reject share"})
 		return
@@ -330,13 +598,32 @@ func (h *ShareHandler) RejectShare(c *gin.Context) {
 		WrapIV:          crypto.EncodeBase64(updatedRecord.WrapIv),
 		WrapTag:         crypto.EncodeBase64(updatedRecord.WrapTag),
 		Status:          updatedRecord.Status,
+		Role:            updatedRecord.Role,
 		CreatedAt:       timestampToTime(updatedRecord.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
 		AcceptedAt:      timestampToStringPtr(updatedRecord.AcceptedAt),
+		ExpiresAt:       timestampToStringPtr(updatedRecord.ExpiresAt),
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+// respondShareNotPending answers an accept or reject that found the share no
+// longer pending: 410 once it has expired, 409 when it was already answered
+// or revoked
+func respondShareNotPending(c *gin.Context, record sqlc.SharingRecord, action string) {
+	expired := record.Status == "expired" ||
+		(record.Status == "pending" && record.ExpiresAt.Valid && !time.Now().Before(record.ExpiresAt.Time))
+	if expired {
+		c.JSON(http.StatusGone, gin.H{"error": "share has expired"})
+		return
+	}
+
+	c.JSON(http.StatusConflict, gin.H{
+		"error":  "only pending shares can be " + action,
+		"status": record.Status,
+	})
+}
+
 // GetSharedVaults retrieves all vaults shared with the current user
 func (h *ShareHandler) GetSharedVaults(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -367,7 +654,8 @@ func (h *ShareHandler) GetSharedVaults(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-// RevokeShare revokes a sharing record (vault owner only)
+// RevokeShare revokes a sharing record. Owners revoke any share, managers
+// revoke viewer and editor shares
 func (h *ShareHandler) RevokeShare(c *gin.Context) {
 	shareID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
@@ -381,13 +669,6 @@ func (h *ShareHandler) RevokeShare(c *gin.Context) {
 		return
 	}
 
-	// Verify device signature
-	sigData, err := ExtractDeviceSignature(c)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required: " + err.Error()})
-		return
-	}
-
 	queries := h.services.GetDB().GetQueries()
 
 	pgShareID := pgtype.UUID{}
@@ -400,52 +681,285 @@ func (h *ShareHandler) RevokeShare(c *gin.Context) {
 		return
 	}
 
-	// Get vault to verify ownership
-	vault, err := queries.GetVaultByID(c.Request.Context(), sharingRecord.VaultID)
+	revokerRole, ok := requireVaultRole(c, queries, sharingRecord.VaultID, userID.(int32), vaultRoleManager)
+	if !ok {
+		return
+	}
+	if sharingRecord.Role == vaultRoleManager && revokerRole != vaultRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the vault owner can revoke a manager"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := h.services.GetDB().BeginTx(ctx)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke share"})
 		return
 	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
 
-	// Only vault owner can revoke shares
-	if vault.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "only vault owner can revoke shares"})
+	revoked, err := qtx.RevokeSharingRecord(ctx, pgShareID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke share"})
+		return
+	}
+	if revoked == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "only pending or accepted shares can be revoked"})
 		return
 	}
 
-	// Verify device
-	pgDeviceID := pgtype.UUID{}
-	_ = pgDeviceID.Scan(sigData.DeviceID.String())
+	if err := qtx.DeleteSharingRecordKeysBySharingRecordID(ctx, pgShareID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke share"})
+		return
+	}
+
+	// The former recipient may still hold the vault key, or for an item share
+	// the item's IEK, which only a rotation replaces
+	if err := qtx.FlagVaultForRotation(ctx, sharingRecord.VaultID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to flag vault for key rotation"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke share"})
+		return
+	}
+
+	payload := map[string]interface{}{
+		"vault_id": sharingRecord.VaultID,
+		"reason":   "share_revoked",
+	}
+	if sharingRecord.ItemID.Valid {
+		payload["item_id"] = uuidToString(sharingRecord.ItemID)
+	}
+	h.services.PublishVaultEvent(ctx, sharingRecord.VaultID, services.EventRotationRecommended, payload)
+
+	c.JSON(http.StatusOK, gin.H{"message": "share revoked successfully"})
+}
 
-	device, err := queries.GetDeviceByID(c.Request.Context(), pgDeviceID)
+// UpdateShareRole changes the role a vault share grants (vault owner only)
+// PUT /api/shares/:id/role
+func (h *ShareHandler) UpdateShareRole(c *gin.Context) {
+	shareID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "device not found"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share_id"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
 		return
 	}
 
-	if device.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "device does not belong to user"})
+	var req UpdateShareRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Verify signature
-	bodyBytes, _ := c.Get("raw_body")
-	bodyHash := sha256.Sum256(bodyBytes.([]byte))
-	canonicalMsg := CreateCanonicalMessage(c.Request.Method, c.Request.URL.Path, sigData.Timestamp, bodyHash[:])
+	if !validMemberRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be viewer, editor or manager"})
+		return
+	}
+
+	queries := h.services.GetDB().GetQueries()
 
-	if !VerifyDeviceSignature(device.Ed25519Public, canonicalMsg, sigData.Signature) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid device signature"})
+	pgShareID := pgtype.UUID{Bytes: shareID, Valid: true}
+
+	sharingRecord, err := queries.GetSharingRecordByID(c.Request.Context(), pgShareID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sharing record not found"})
+		return
+	}
+
+	if _, ok := requireVaultRole(c, queries, sharingRecord.VaultID, userID.(int32), vaultRoleOwner); !ok {
 		return
 	}
 
-	// Revoke the share
-	err = queries.RevokeSharingRecord(c.Request.Context(), pgShareID)
+	updatedRecord, err := queries.UpdateSharingRecordRole(c.Request.Context(), sqlc.UpdateSharingRecordRoleParams{
+		ID:   pgShareID,
+		Role: req.Role,
+	})
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "only pending or accepted vault shares have a role"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke share"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update share role"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "share revoked successfully"})
+	h.services.PublishUserEvent(c.Request.Context(), updatedRecord.RecipientUserID, services.EventShareRoleChanged, map[string]interface{}{
+		"share_id": uuidToString(updatedRecord.ID),
+		"vault_id": updatedRecord.VaultID,
+		"role":     updatedRecord.Role,
+	})
+
+	response := SharingRecordResponse{
+		ID:              uuidToString(updatedRecord.ID),
+		VaultID:         updatedRecord.VaultID,
+		ItemID:          uuidToStringPtr(updatedRecord.ItemID),
+		SenderUserID:    updatedRecord.SenderUserID,
+		RecipientUserID: updatedRecord.RecipientUserID,
+		WrappedKey:      crypto.EncodeBase64(updatedRecord.WrappedKey),
+		WrapIV:          crypto.EncodeBase64(updatedRecord.WrapIv),
+		WrapTag:         crypto.EncodeBase64(updatedRecord.WrapTag),
+		Status:          updatedRecord.Status,
+		Role:            updatedRecord.Role,
+		CreatedAt:       timestampToTime(updatedRecord.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		AcceptedAt:      timestampToStringPtr(updatedRecord.AcceptedAt),
+		ExpiresAt:       timestampToStringPtr(updatedRecord.ExpiresAt),
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// UpdateShareExpiry extends, shortens or removes a share's expiry. Same rules
+// as revoking: managers change viewer and editor shares, owners any share
+// PUT /api/shares/:id/expiry
+func (h *ShareHandler) UpdateShareExpiry(c *gin.Context) {
+	shareID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share_id"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req UpdateShareExpiryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	expiresAt, ok := parseShareExpiry(c, req.ExpiresAt)
+	if !ok {
+		return
+	}
+
+	queries := h.services.GetDB().GetQueries()
+
+	pgShareID := pgtype.UUID{Bytes: shareID, Valid: true}
+
+	sharingRecord, err := queries.GetSharingRecordByID(c.Request.Context(), pgShareID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sharing record not found"})
+		return
+	}
+
+	changerRole, ok := requireVaultRole(c, queries, sharingRecord.VaultID, userID.(int32), vaultRoleManager)
+	if !ok {
+		return
+	}
+	if sharingRecord.Role == vaultRoleManager && !sharingRecord.ItemID.Valid && changerRole != vaultRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the vault owner can change a manager's share"})
+		return
+	}
+
+	updatedRecord, err := queries.UpdateSharingRecordExpiry(c.Request.Context(), sqlc.UpdateSharingRecordExpiryParams{
+		ID:        pgShareID,
+		ExpiresAt: expiresAt,
+	})
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "only pending or accepted shares that have not expired can change expiry", "status": sharingRecord.Status})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update share expiry"})
+		return
+	}
+
+	h.services.PublishUserEvent(c.Request.Context(), updatedRecord.RecipientUserID, services.EventShareExpiryChanged, map[string]interface{}{
+		"share_id":   uuidToString(updatedRecord.ID),
+		"vault_id":   updatedRecord.VaultID,
+		"expires_at": timestampToStringPtr(updatedRecord.ExpiresAt),
+	})
+
+	response := SharingRecordResponse{
+		ID:              uuidToString(updatedRecord.ID),
+		VaultID:         updatedRecord.VaultID,
+		ItemID:          uuidToStringPtr(updatedRecord.ItemID),
+		SenderUserID:    updatedRecord.SenderUserID,
+		RecipientUserID: updatedRecord.RecipientUserID,
+		WrappedKey:      crypto.EncodeBase64(updatedRecord.WrappedKey),
+		WrapIV:          crypto.EncodeBase64(updatedRecord.WrapIv),
+		WrapTag:         crypto.EncodeBase64(updatedRecord.WrapTag),
+		Status:          updatedRecord.Status,
+		Role:            updatedRecord.Role,
+		CreatedAt:       timestampToTime(updatedRecord.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		AcceptedAt:      timestampToStringPtr(updatedRecord.AcceptedAt),
+		ExpiresAt:       timestampToStringPtr(updatedRecord.ExpiresAt),
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// parseShareExpiry turns an optional expiry into a nullable timestamp,
+// rejecting times that have already passed
+func parseShareExpiry(c *gin.Context, expiresAt *time.Time) (pgtype.Timestamp, bool) {
+	if expiresAt == nil {
+		return pgtype.Timestamp{}, true
+	}
+	if !expiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return pgtype.Timestamp{}, false
+	}
+	return pgtype.Timestamp{Time: expiresAt.Local(), Valid: true}, true
+}
+
+// shareExpired reports whether a share's expiry has passed, even if the
+// expiry job has not marked it yet
+func shareExpired(sharingRecord sqlc.SharingRecord, now time.Time) bool {
+	return sharingRecord.ExpiresAt.Valid && !timestampToTime(sharingRecord.ExpiresAt).After(now)
+}
+
+// createShare creates a sharing record together with its key wrapped for
+// each of the recipient's devices, signed off by the calling device
+func (h *ShareHandler) createShare(c *gin.Context, params sqlc.CreateSharingRecordParams, deviceKeys []sqlc.UpsertSharingRecordKeyParams) (sqlc.SharingRecord, bool) {
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device signature required"})
+		return sqlc.SharingRecord{}, false
+	}
+
+	if !requireTwoFactor(c, h.services, params.SenderUserID) {
+		return sqlc.SharingRecord{}, false
+	}
+
+	ctx := c.Request.Context()
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start transaction"})
+		return sqlc.SharingRecord{}, false
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := h.services.GetDB().GetQueries().WithTx(tx)
+
+	sharingRecord, err := qtx.CreateSharingRecord(ctx, params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create sharing record"})
+		return sqlc.SharingRecord{}, false
+	}
+
+	if err := storeShareDeviceKeys(ctx, qtx, sharingRecord.ID, device.ID, deviceKeys); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store share keys"})
+		return sqlc.SharingRecord{}, false
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create sharing record"})
+		return sqlc.SharingRecord{}, false
+	}
+
+	return sharingRecord, true
 }
 
 // Helper function to convert pgtype.UUID to string pointer (for nullable UUID)
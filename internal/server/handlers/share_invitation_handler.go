@@ -0,0 +1,410 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"yamony/internal/crypto"
+	"yamony/internal/database/sqlc"
+	"yamony/internal/server/services"
+)
+
+// Share invitation lifecycle. An invitation waits as pending until the invitee
+// has an active device, then as ready until the sender wraps the VEK for them
+const (
+	invitationStatusPending   = "pending"
+	invitationStatusReady     = "ready"
+	invitationStatusCompleted = "completed"
+	invitationStatusCancelled = "cancelled"
+)
+
+// shareInvitationTTL is how long an invitee has to register and verify a device
+const shareInvitationTTL = 7 * 24 * time.Hour
+
+type ShareInvitationHandler struct {
+	services services.Service
+}
+
+func NewShareInvitationHandler(services services.Service) *ShareInvitationHandler {
+	return &ShareInvitationHandler{services: services}
+}
+
+// CreateShareInvitationRequest represents a request to share a vault with an email address
+type CreateShareInvitationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role"` // viewer, editor or manager; defaults to viewer
+}
+
+// CompleteShareInvitationRequest carries the VEK wrapped for the invitee's devices
+type CompleteShareInvitationRequest struct {
+	DeviceKeys []ShareDeviceKey `json:"device_keys" binding:"required,min=1,dive"`
+}
+
+// ShareInvitationResponse represents an invitation to a vault
+type ShareInvitationResponse struct {
+	ID              string  `json:"id"`
+	VaultID         int32   `json:"vault_id"`
+	SenderUserID    int32   `json:"sender_user_id"`
+	Email           string  `json:"email"`
+	Role            string  `json:"role"`
+	Status          string  `json:"status"`
+	Expired         bool    `json:"expired"`
+	RecipientUserID *int32  `json:"recipient_user_id,omitempty"`
+	SharingRecordID *string `json:"sharing_record_id,omitempty"`
+	CreatedAt       string  `json:"created_at"`
+	ExpiresAt       string  `json:"expires_at"`
+	CompletedAt     *string `json:"completed_at,omitempty"`
+}
+
+// CreateShareInvitation invites an email address that has no usable account to a vault
+// POST /api/vaults/:id/invitations
+func (h *ShareInvitationHandler) CreateShareInvitation(c *gin.Context) {
+	vaultID, err := parseIntParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault_id"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req CreateShareInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !requireTwoFactor(c, h.services, userID.(int32)) {
+		return
+	}
+
+	role := req.Role
+	if role == "" {
+		role = vaultRoleViewer
+	}
+	if !validMemberRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be viewer, editor or manager"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	queries := h.services.GetDB().GetQueries()
+
+	// Same rules as sharing directly: managers invite, only owners appoint managers
+	inviterRole, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleManager)
+	if !ok {
+		return
+	}
+	if role == vaultRoleManager && inviterRole != vaultRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the vault owner can grant the manager role"})
+		return
+	}
+
+	email := normalizeEmail(req.Email)
+
+	// Users who can already receive a share are shared with directly
+	user, err := queries.GetUserByEmail(ctx, email)
+	if err == nil {
+		if user.ID == userID.(int32) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot invite yourself"})
+			return
+		}
+
+		devices, err := queries.GetUserDevicePublicKeys(ctx, user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check recipient devices"})
+			return
+		}
+		if len(devices) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":             "user already has an active device; share the vault directly",
+				"recipient_user_id": user.ID,
+			})
+			return
+		}
+	} else if err != pgx.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to look up recipient"})
+		return
+	}
+
+	_, err = queries.GetOpenShareInvitation(ctx, sqlc.GetOpenShareInvitationParams{
+		VaultID: vaultID,
+		Email:   email,
+	})
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "email already has an open invitation to this vault"})
+		return
+	}
+	if err != pgx.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check invitations"})
+		return
+	}
+
+	invitation, err := queries.CreateShareInvitation(ctx, sqlc.CreateShareInvitationParams{
+		VaultID:      vaultID,
+		SenderUserID: userID.(int32),
+		Email:        email,
+		Role:         role,
+		ExpiresAt:    pgtype.Timestamp{Time: time.Now().Add(shareInvitationTTL), Valid: true},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invitation"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, newShareInvitationResponse(invitation))
+}
+
+// GetShareInvitations lists a vault's invitations, including expired and finished ones
+// GET /api/vaults/:id/invitations
+func (h *ShareInvitationHandler) GetShareInvitations(c *gin.Context) {
+	vaultID, err := parseIntParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault_id"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	queries := h.services.GetDB().GetQueries()
+
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleManager); !ok {
+		return
+	}
+
+	invitations, err := queries.GetShareInvitationsByVaultID(c.Request.Context(), vaultID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get invitations"})
+		return
+	}
+
+	response := make([]ShareInvitationResponse, len(invitations))
+	for i, invitation := range invitations {
+		response[i] = newShareInvitationResponse(invitation)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// CancelShareInvitation withdraws an invitation that has not been completed
+// DELETE /api/invitations/:id
+func (h *ShareInvitationHandler) CancelShareInvitation(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	queries := h.services.GetDB().GetQueries()
+
+	invitation, ok := h.invitationForManager(c, queries, userID.(int32))
+	if !ok {
+		return
+	}
+
+	cancelled, err := queries.CancelShareInvitation(ctx, invitation.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel invitation"})
+		return
+	}
+	if cancelled == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "invitation is already " + invitation.Status})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "invitation cancelled"})
+}
+
+// CompleteShareInvitation turns a ready invitation into a pending vault share
+// once the sender has wrapped the VEK for each of the invitee's devices
+// POST /api/invitations/:id/complete
+func (h *ShareInvitationHandler) CompleteShareInvitation(c *gin.Context) {
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device signature required"})
+		return
+	}
+	userID := device.UserID
+
+	var req CompleteShareInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	queries := h.services.GetDB().GetQueries()
+
+	invitation, ok := h.invitationForManager(c, queries, userID)
+	if !ok {
+		return
+	}
+
+	if invitation.Status != invitationStatusReady || !invitation.RecipientUserID.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "invitation is not ready", "status": invitation.Status})
+		return
+	}
+	if invitationExpired(invitation, time.Now()) {
+		c.JSON(http.StatusGone, gin.H{"error": "invitation has expired"})
+		return
+	}
+
+	recipientDevices, err := queries.GetUserDevicePublicKeys(ctx, invitation.RecipientUserID.Int32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get recipient devices"})
+		return
+	}
+
+	deviceKeys, ok := decodeShareDeviceKeys(c, recipientDevices, req.DeviceKeys)
+	if !ok {
+		return
+	}
+
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
+
+	sharingRecord, err := qtx.CreateSharingRecord(ctx, sqlc.CreateSharingRecordParams{
+		VaultID:         invitation.VaultID,
+		ItemID:          pgtype.UUID{Valid: false}, // NULL for vault sharing
+		SenderUserID:    userID,
+		RecipientUserID: invitation.RecipientUserID.Int32,
+		Status:          "pending",
+		Role:            invitation.Role,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create sharing record"})
+		return
+	}
+
+	if err := storeShareDeviceKeys(ctx, qtx, sharingRecord.ID, device.ID, deviceKeys); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store share keys"})
+		return
+	}
+
+	completed, err := qtx.CompleteShareInvitation(ctx, sqlc.CompleteShareInvitationParams{
+		ID:              invitation.ID,
+		SharingRecordID: sharingRecord.ID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete invitation"})
+		return
+	}
+	if completed == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "invitation is no longer ready"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete invitation"})
+		return
+	}
+
+	h.services.PublishUserEvent(ctx, sharingRecord.RecipientUserID, services.EventShareInvitation, map[string]interface{}{
+		"share_id":       uuidToString(sharingRecord.ID),
+		"vault_id":       sharingRecord.VaultID,
+		"sender_user_id": sharingRecord.SenderUserID,
+		"role":           sharingRecord.Role,
+	})
+
+	response := SharingRecordResponse{
+		ID:              uuidToString(sharingRecord.ID),
+		VaultID:         sharingRecord.VaultID,
+		ItemID:          uuidToStringPtr(sharingRecord.ItemID),
+		SenderUserID:    sharingRecord.SenderUserID,
+		RecipientUserID: sharingRecord.RecipientUserID,
+		WrappedKey:      crypto.EncodeBase64(sharingRecord.WrappedKey),
+		WrapIV:          crypto.EncodeBase64(sharingRecord.WrapIv),
+		WrapTag:         crypto.EncodeBase64(sharingRecord.WrapTag),
+		Status:          sharingRecord.Status,
+		Role:            sharingRecord.Role,
+		CreatedAt:       timestampToTime(sharingRecord.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		AcceptedAt:      timestampToStringPtr(sharingRecord.AcceptedAt),
+		ExpiresAt:       timestampToStringPtr(sharingRecord.ExpiresAt),
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// invitationForManager loads the invitation named by the :id param and checks
+// the user may act on it: its sender while still a manager, or the vault owner.
+// Only the owner acts on invitations to the manager role
+func (h *ShareInvitationHandler) invitationForManager(c *gin.Context, queries *sqlc.Queries, userID int32) (sqlc.ShareInvitation, bool) {
+	invitationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invitation_id"})
+		return sqlc.ShareInvitation{}, false
+	}
+
+	invitation, err := queries.GetShareInvitationByID(c.Request.Context(), pgtype.UUID{Bytes: invitationID, Valid: true})
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found"})
+		return sqlc.ShareInvitation{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get invitation"})
+		return sqlc.ShareInvitation{}, false
+	}
+
+	role, ok := requireVaultRole(c, queries, invitation.VaultID, userID, vaultRoleManager)
+	if !ok {
+		return sqlc.ShareInvitation{}, false
+	}
+	if role != vaultRoleOwner && (invitation.SenderUserID != userID || invitation.Role == vaultRoleManager) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the sender or the vault owner can manage this invitation"})
+		return sqlc.ShareInvitation{}, false
+	}
+
+	return invitation, true
+}
+
+// normalizeEmail is the form invitations are stored and matched in
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// invitationExpired reports whether an open invitation can no longer be completed
+func invitationExpired(invitation sqlc.ShareInvitation, now time.Time) bool {
+	open := invitation.Status == invitationStatusPending || invitation.Status == invitationStatusReady
+	return open && !timestampToTime(invitation.ExpiresAt).After(now)
+}
+
+func newShareInvitationResponse(invitation sqlc.ShareInvitation) ShareInvitationResponse {
+	response := ShareInvitationResponse{
+		ID:              uuidToString(invitation.ID),
+		VaultID:         invitation.VaultID,
+		SenderUserID:    invitation.SenderUserID,
+		Email:           invitation.Email,
+		Role:            invitation.Role,
+		Status:          invitation.Status,
+		Expired:         invitationExpired(invitation, time.Now()),
+		SharingRecordID: uuidToStringPtr(invitation.SharingRecordID),
+		CreatedAt:       timestampToTime(invitation.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		ExpiresAt:       timestampToTime(invitation.ExpiresAt).Format("2006-01-02T15:04:05Z07:00"),
+		CompletedAt:     timestampToStringPtr(invitation.CompletedAt),
+	}
+	if invitation.RecipientUserID.Valid {
+		recipientUserID := invitation.RecipientUserID.Int32
+		response.RecipientUserID = &recipientUserID
+	}
+	return response
+}
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"yamony/internal/crypto"
+	"yamony/internal/database/sqlc"
+	"yamony/internal/server/middleware"
+	"yamony/internal/server/services"
+)
+
+// ShareKeyHandler manages a share's key material, which is wrapped separately
+// for each of the recipient's devices since X25519 keys are per device
+type ShareKeyHandler struct {
+	services services.Service
+}
+
+func NewShareKeyHandler(services services.Service) *ShareKeyHandler {
+	return &ShareKeyHandler{services: services}
+}
+
+// ShareDeviceKey is a share's key wrapped for one of the recipient's devices
+type ShareDeviceKey struct {
+	DeviceID   string `json:"device_id" binding:"required"`
+	WrappedKey string `json:"wrapped_key" binding:"required"` // base64 encoded VEK or IEK wrapped with ECDH
+	WrapIV     string `json:"wrap_iv" binding:"required"`     // base64 encoded
+	WrapTag    string `json:"wrap_tag" binding:"required"`    // base64 encoded
+}
+
+// AddShareKeysRequest carries keys wrapped for recipient devices that lack one
+type AddShareKeysRequest struct {
+	DeviceKeys []ShareDeviceKey `json:"device_keys" binding:"required,min=1,dive"`
+}
+
+// MissingShareKeyResponse is a recipient device still waiting for a share's key
+type MissingShareKeyResponse struct {
+	ShareID         string  `json:"share_id"`
+	VaultID         int32   `json:"vault_id"`
+	ItemID          *string `json:"item_id,omitempty"`
+	RecipientUserID int32   `json:"recipient_user_id"`
+	DeviceID        string  `json:"device_id"`
+	DeviceLabel     string  `json:"device_label"`
+	X25519Public    string  `json:"x25519_public"` // base64 encoded key to wrap for
+}
+
+// RequestShareKey asks the sender to wrap a share's key for the calling device,
+// typically a device the recipient added after the share was made
+// POST /api/shares/:id/keys/request
+func (h *ShareKeyHandler) RequestShareKey(c *gin.Context) {
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device signature required"})
+		return
+	}
+
+	shareID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share_id"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	queries := h.services.GetDB().GetQueries()
+
+	sharingRecord, err := queries.GetSharingRecordByID(ctx, pgtype.UUID{Bytes: shareID, Valid: true})
+	if err != nil || sharingRecord.RecipientUserID != device.UserID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sharing record not found"})
+		return
+	}
+
+	if sharingRecord.Status != "pending" && sharingRecord.Status != "accepted" || shareExpired(sharingRecord, time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "share is no longer active"})
+		return
+	}
+
+	_, err = queries.GetSharingRecordKey(ctx, sqlc.GetSharingRecordKeyParams{
+		SharingRecordID:   sharingRecord.ID,
+		RecipientDeviceID: device.ID,
+	})
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "share key is already wrapped for this device"})
+		return
+	}
+	if err != pgx.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check share keys"})
+		return
+	}
+
+	h.services.PublishUserEvent(ctx, sharingRecord.SenderUserID, services.EventShareKeyRequested, map[string]interface{}{
+		"share_id":          uuidToString(sharingRecord.ID),
+		"vault_id":          sharingRecord.VaultID,
+		"item_id":           uuidToStringPtr(sharingRecord.ItemID),
+		"recipient_user_id": sharingRecord.RecipientUserID,
+		"device_id":         uuidToString(device.ID),
+		"x25519_public":     crypto.EncodeBase64(device.X25519Public),
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "share key requested"})
+}
+
+// GetShareKeyRequests lists recipient devices without a key for the shares the
+// user sent, so a sender that was offline can catch up on requests
+// GET /api/shares/key-requests
+func (h *ShareKeyHandler) GetShareKeyRequests(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	missing, err := h.services.GetDB().GetQueries().GetMissingSharingRecordKeys(c.Request.Context(), userID.(int32))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get share key requests"})
+		return
+	}
+
+	response := make([]MissingShareKeyResponse, len(missing))
+	for i, m := range missing {
+		response[i] = MissingShareKeyResponse{
+			ShareID:         uuidToString(m.SharingRecordID),
+			VaultID:         m.VaultID,
+			ItemID:          uuidToStringPtr(m.ItemID),
+			RecipientUserID: m.RecipientUserID,
+			DeviceID:        uuidToString(m.DeviceID),
+			DeviceLabel:     m.DeviceLabel.String,
+			X25519Public:    crypto.EncodeBase64(m.X25519Public),
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// AddShareKeys stores a share's key wrapped for more of the recipient's devices.
+// The sender fulfils requests; vault managers may too, as they hold the VEK
+// POST /api/shares/:id/keys
+func (h *ShareKeyHandler) AddShareKeys(c *gin.Context) {
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device signature required"})
+		return
+	}
+
+	shareID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share_id"})
+		return
+	}
+
+	var req AddShareKeysRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	queries := h.services.GetDB().GetQueries()
+
+	sharingRecord, err := queries.GetSharingRecordByID(ctx, pgtype.UUID{Bytes: shareID, Valid: true})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sharing record not found"})
+		return
+	}
+
+	if sharingRecord.SenderUserID != device.UserID {
+		if _, ok := requireVaultRole(c, queries, sharingRecord.VaultID, device.UserID, vaultRoleManager); !ok {
+			return
+		}
+	}
+
+	if sharingRecord.Status != "pending" && sharingRecord.Status != "accepted" || shareExpired(sharingRecord, time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "share is no longer active"})
+		return
+	}
+
+	recipientDevices, err := queries.GetUserDevicePublicKeys(ctx, sharingRecord.RecipientUserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get recipient devices"})
+		return
+	}
+
+	keys, ok := decodeShareDeviceKeys(c, recipientDevices, req.DeviceKeys)
+	if !ok {
+		return
+	}
+
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if err := storeShareDeviceKeys(ctx, queries.WithTx(tx), sharingRecord.ID, device.ID, keys); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store share keys"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store share keys"})
+		return
+	}
+
+	deviceIDs := make([]string, len(keys))
+	for i, key := range keys {
+		deviceIDs[i] = uuidToString(key.RecipientDeviceID)
+	}
+
+	h.services.PublishUserEvent(ctx, sharingRecord.RecipientUserID, services.EventShareKeysAdded, map[string]interface{}{
+		"share_id":   uuidToString(sharingRecord.ID),
+		"vault_id":   sharingRecord.VaultID,
+		"device_ids": deviceIDs,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"share_id":   uuidToString(sharingRecord.ID),
+		"device_ids": deviceIDs,
+	})
+}
+
+// decodeShareDeviceKeys decodes wrapped keys and checks each one targets an
+// active device of the recipient, writing the error response otherwise
+func decodeShareDeviceKeys(c *gin.Context, recipientDevices []sqlc.GetUserDevicePublicKeysRow, deviceKeys []ShareDeviceKey) ([]sqlc.UpsertSharingRecordKeyParams, bool) {
+	active := make(map[uuid.UUID]bool, len(recipientDevices))
+	for _, d := range recipientDevices {
+		active[d.ID.Bytes] = true
+	}
+
+	keys := make([]sqlc.UpsertSharingRecordKeyParams, len(deviceKeys))
+	seen := make(map[uuid.UUID]bool, len(deviceKeys))
+	for i, deviceKey := range deviceKeys {
+		deviceID, err := uuid.Parse(deviceKey.DeviceID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id", "index": i})
+			return nil, false
+		}
+		if !active[deviceID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "device_id is not an active device of the recipient", "index": i})
+			return nil, false
+		}
+		if seen[deviceID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "duplicate device_id", "index": i})
+			return nil, false
+		}
+		seen[deviceID] = true
+
+		wrappedKey, err := crypto.DecodeBase64(deviceKey.WrappedKey)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrapped_key format", "index": i})
+			return nil, false
+		}
+
+		wrapIV, err := crypto.DecodeBase64(deviceKey.WrapIV)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrap_iv format", "index": i})
+			return nil, false
+		}
+
+		wrapTag, err := crypto.DecodeBase64(deviceKey.WrapTag)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrap_tag format", "index": i})
+			return nil, false
+		}
+
+		keys[i] = sqlc.UpsertSharingRecordKeyParams{
+			RecipientDeviceID: pgtype.UUID{Bytes: deviceID, Valid: true},
+			WrappedKey:        wrappedKey,
+			WrapIv:            wrapIV,
+			WrapTag:           wrapTag,
+		}
+	}
+
+	return keys, true
+}
+
+// storeShareDeviceKeys saves keys from decodeShareDeviceKeys against a share
+func storeShareDeviceKeys(ctx context.Context, qtx *sqlc.Queries, sharingRecordID, senderDeviceID pgtype.UUID, keys []sqlc.UpsertSharingRecordKeyParams) error {
+	for _, key := range keys {
+		key.SharingRecordID = sharingRecordID
+		key.SenderDeviceID = senderDeviceID
+		if err := qtx.UpsertSharingRecordKey(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withSessionDeviceKey swaps in the share's key wrapped for the device the
+// session is bound to, as withDeviceKey does
+func withSessionDeviceKey(c *gin.Context, queries *sqlc.Queries, sharingRecord sqlc.SharingRecord) (sqlc.SharingRecord, pgtype.UUID, error) {
+	session, err := queries.GetSessionByToken(c.Request.Context(), c.GetString(middleware.SessionTokenKey))
+	if err != nil {
+		return sharingRecord, pgtype.UUID{}, err
+	}
+
+	return withDeviceKey(c.Request.Context(), queries, sharingRecord, session.DeviceID)
+}
+
+// withDeviceKey swaps in the share's key wrapped for deviceID. Only shares made
+// before keys were kept per device fall back to the per-user key; once any
+// device has its own wrap, a device without one gets no key and has to request
+// it. The returned device ID is invalid unless a device key was used
+func withDeviceKey(ctx context.Context, queries *sqlc.Queries, sharingRecord sqlc.SharingRecord, deviceID pgtype.UUID) (sqlc.SharingRecord, pgtype.UUID, error) {
+	if deviceID.Valid {
+		key, err := queries.GetSharingRecordKey(ctx, sqlc.GetSharingRecordKeyParams{
+			SharingRecordID:   sharingRecord.ID,
+			RecipientDeviceID: deviceID,
+		})
+		if err == nil {
+			sharingRecord.WrappedKey = key.WrappedKey
+			sharingRecord.WrapIv = key.WrapIv
+			sharingRecord.WrapTag = key.WrapTag
+			return sharingRecord, key.RecipientDeviceID, nil
+		}
+		if err != pgx.ErrNoRows {
+			return sharingRecord, pgtype.UUID{}, err
+		}
+	}
+
+	deviceKeys, err := queries.CountSharingRecordKeys(ctx, sharingRecord.ID)
+	if err != nil {
+		return sharingRecord, pgtype.UUID{}, err
+	}
+	if deviceKeys > 0 {
+		sharingRecord.WrappedKey = nil
+		sharingRecord.WrapIv = nil
+		sharingRecord.WrapTag = nil
+	}
+	return sharingRecord, pgtype.UUID{}, nil
+}
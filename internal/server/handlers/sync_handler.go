@@ -1,20 +1,31 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 
 	"yamony/internal/crypto"
 	"yamony/internal/database/sqlc"
 	"yamony/internal/server/services"
+	"yamony/internal/storage"
 )
 
 type SyncHandler struct {
@@ -25,9 +36,16 @@ func NewSyncHandler(services services.Service) *SyncHandler {
 	return &SyncHandler{services: services}
 }
 
+const (
+	defaultSyncPageSize int32 = 500
+	maxSyncPageSize     int32 = 1000
+)
+
 // SyncPullRequest represents a request to pull vault changes
 type SyncPullRequest struct {
 	LastSyncedVersionID *int32 `json:"last_synced_version_id,omitempty"`
+	Cursor              string `json:"cursor,omitempty"` // next_cursor from the previous page
+	Limit               int32  `json:"limit,omitempty"`
 }
 
 // SyncPullResponse represents the response with vault changes
@@ -36,8 +54,10 @@ type SyncPullResponse struct {
 	CurrentVersion  int32               `json:"current_version"`
 	Items           []VaultItemResponse `json:"items"`
 	DeletedItemIDs  []string            `json:"deleted_item_ids,omitempty"`
+	FullResync      bool                `json:"full_resync"` // client must replace local state with Items
 	ETag            string              `json:"etag"`
 	HasMoreVersions bool                `json:"has_more_versions"`
+	NextCursor      string              `json:"next_cursor,omitempty"`
 }
 
 // SyncCommitRequest represents a request to commit vault changes
@@ -45,6 +65,9 @@ type SyncCommitRequest struct {
 	BaseVersionID *int32           `json:"base_version_id,omitempty"` // for optimistic concurrency
 	Items         []SyncItemCommit `json:"items" binding:"required"`
 	DeletedItems  []string         `json:"deleted_items,omitempty"`
+	// Base64 Ed25519 signature by the committing device over the manifest
+	// digest of the vault state after this commit (see snapshotManifestDigest)
+	SnapshotMAC string `json:"snapshot_mac,omitempty"`
 }
 
 // SyncItemCommit represents an item being committed
@@ -56,6 +79,16 @@ type SyncItemCommit struct {
 	Tag           string  `json:"tag" binding:"required"`
 	Meta          []byte  `json:"meta,omitempty"`
 	BaseVersion   *int32  `json:"base_version,omitempty"` // for optimistic concurrency on updates
+	KeyVersion    int32   `json:"key_version,omitempty"`  // vault key version that encrypted the blob; must be the current key
+	// Set when the blob is a client-side merge after a version_mismatch conflict;
+	// replaces the base_version check
+	MergeParents *SyncMergeParents `json:"merge_parents,omitempty"`
+}
+
+// SyncMergeParents names the two versions a resolved item was merged from
+type SyncMergeParents struct {
+	BaseVersion  int32 `json:"base_version"`  // common ancestor the client edited
+	TheirVersion int32 `json:"their_version"` // server version merged in; must still be current
 }
 
 // SyncCommitResponse represents the response after committing changes
@@ -67,12 +100,25 @@ type SyncCommitResponse struct {
 	ETag           string              `json:"etag"`
 }
 
+// SyncCommitFailure identifies the entry that caused a commit to be rolled back
+type SyncCommitFailure struct {
+	Index           int    `json:"index"` // position in items or deleted_items
+	ItemID          string `json:"item_id,omitempty"`
+	AttemptedAction string `json:"attempted_action"` // "create", "update", "delete"
+	Reason          string `json:"reason"`
+}
+
 // SyncConflict represents a conflict detected during commit
 type SyncConflict struct {
 	ItemID          string `json:"item_id"`
 	ConflictType    string `json:"conflict_type"` // "version_mismatch", "concurrent_edit"
 	CurrentVersion  int32  `json:"current_version"`
-	AttemptedAction string `json:"attempted_action"` // "create", "update", "delete"
+	AttemptedAction string `json:"attempted_action"` // "create", "update", "resolve", "delete"
+	// Three-way merge inputs for version_mismatch: the server's current item and
+	// the client's base version from revision history (nil if it is not kept)
+	BaseVersion *int32                     `json:"base_version,omitempty"`
+	Current     *VaultItemResponse         `json:"current,omitempty"`
+	Base        *VaultItemRevisionResponse `json:"base,omitempty"`
 }
 
 // VaultVersionResponse represents a vault version/snapshot
@@ -85,6 +131,68 @@ type VaultVersionResponse struct {
 	CreatedAt       string  `json:"created_at"`
 }
 
+// VaultSnapshot is the document stored at a vault version's object_key: the
+// encrypted vault contents as of that version
+type VaultSnapshot struct {
+	VaultID        int32               `json:"vault_id"`
+	VersionID      int32               `json:"version_id"`
+	ManifestDigest string              `json:"manifest_digest"`
+	Items          []VaultItemResponse `json:"items"`
+}
+
+// VaultSnapshotResponse is a downloaded snapshot with its version metadata
+type VaultSnapshotResponse struct {
+	VaultSnapshot
+	MAC             *string `json:"mac,omitempty"`
+	CreatedByDevice *string `json:"created_by_device,omitempty"`
+	CreatedAt       string  `json:"created_at"`
+}
+
+// RestoreVaultVersionRequest optionally carries a MAC over the restored state.
+// Snapshot items encrypted with a retired key must be sent re-encrypted with the
+// current key in ReencryptedItems
+type RestoreVaultVersionRequest struct {
+	SnapshotMAC      string                    `json:"snapshot_mac,omitempty"`
+	ReencryptedItems []ReencryptedSnapshotItem `json:"reencrypted_items,omitempty" binding:"dive"`
+}
+
+// ReencryptedSnapshotItem replaces the ciphertext of one snapshot item
+type ReencryptedSnapshotItem struct {
+	ID            string `json:"id" binding:"required"`
+	EncryptedBlob string `json:"encrypted_blob" binding:"required"`
+	IV            string `json:"iv" binding:"required"`
+	Tag           string `json:"tag" binding:"required"`
+	KeyVersion    int32  `json:"key_version" binding:"required"`
+}
+
+// restoreCiphertext is the ciphertext an item is restored with
+type restoreCiphertext struct {
+	encryptedBlob []byte
+	iv            []byte
+	tag           []byte
+	keyVersion    int32
+}
+
+// staleSnapshotItemsError lists snapshot items that are encrypted with a retired
+// key, or predate key versions, and were not sent re-encrypted
+type staleSnapshotItemsError struct {
+	itemIDs []string
+}
+
+func (e *staleSnapshotItemsError) Error() string {
+	return fmt.Sprintf("%d snapshot items must be re-encrypted with the current key", len(e.itemIDs))
+}
+
+// RestoreVaultVersionResponse describes the changes a restore made
+type RestoreVaultVersionResponse struct {
+	VaultID             int32               `json:"vault_id"`
+	RestoredFromVersion int32               `json:"restored_from_version"`
+	NewVersionID        int32               `json:"new_version_id"`
+	RestoredItems       []VaultItemResponse `json:"restored_items"`
+	DeletedItemIDs      []string            `json:"deleted_item_ids"`
+	ETag                string              `json:"etag"`
+}
+
 // PullVaultChanges retrieves changes since the last sync
 // POST /api/vaults/:id/sync/pull
 func (h *SyncHandler) PullVaultChanges(c *gin.Context) {
@@ -103,33 +211,104 @@ func (h *SyncHandler) PullVaultChanges(c *gin.Context) {
 	var req SyncPullRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		// No body is also acceptable - sync from beginning
-		req.LastSyncedVersionID = nil
+		req = SyncPullRequest{}
 	}
 
 	queries := h.services.GetDB().GetQueries()
 
-	// Check if user has access to vault (owner or shared)
-	accessLevel, err := queries.CheckUserVaultAccess(c.Request.Context(), sqlc.CheckUserVaultAccessParams{
-		ID:     vaultID,
-		UserID: userID.(int32),
-	})
-	if err != nil || accessLevel == nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": "no access to this vault"})
+	// Members of any role may pull
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleViewer); !ok {
 		return
 	}
 
-	// Get all vault items (in a real system with large vaults, implement pagination)
-	items, err := queries.GetVaultItemsByVaultID(c.Request.Context(), vaultID)
+	ctx := c.Request.Context()
+
+	// Read the latest version before any items so the delta never misses a change at or below it
+	latestVersion, err := queries.GetLatestVaultVersion(ctx, vaultID)
+	var versionID int32 = 0
+	if err == nil {
+		versionID = latestVersion.ID
+	}
+
+	// Generate ETag based on vault state
+	itemVersions, err := queries.GetVaultItemVersionsByVaultID(ctx, vaultID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch vault items"})
 		return
 	}
+	etag := generateVaultETag(vaultID, versionID, itemVersions)
 
-	// Get latest version for ETag generation
-	latestVersion, err := queries.GetLatestVaultVersion(c.Request.Context(), vaultID)
-	var versionID int32 = 0
-	if err == nil {
-		versionID = latestVersion.ID
+	// Check If-None-Match header
+	if match := c.GetHeader("If-None-Match"); match != "" && match == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	// Deletions since the client's last sync come from tombstones. If the client's
+	// version predates the tombstone retention window some deletions may already be
+	// purged, so it has to replace its local state with the full item list instead.
+	deletedItemIDs := []string{}
+	fullResync := req.LastSyncedVersionID == nil
+	if req.LastSyncedVersionID != nil {
+		lastSynced, err := queries.GetVaultVersionByIDAndVault(ctx, sqlc.GetVaultVersionByIDAndVaultParams{
+			VaultID: vaultID,
+			ID:      *req.LastSyncedVersionID,
+		})
+		if err != nil || time.Since(timestampToTime(lastSynced.CreatedAt)) > h.services.TombstoneRetention() {
+			fullResync = true
+		} else if req.Cursor == "" {
+			// Tombstones are small, so they are all returned with the first page
+			tombstones, err := queries.GetVaultItemTombstonesSinceVersion(ctx, sqlc.GetVaultItemTombstonesSinceVersionParams{
+				VaultID:        vaultID,
+				VaultVersionID: *req.LastSyncedVersionID,
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch deleted items"})
+				return
+			}
+			for _, tombstone := range tombstones {
+				deletedItemIDs = append(deletedItemIDs, uuidToString(tombstone.ItemID))
+			}
+		}
+	}
+
+	limit := req.Limit
+	if limit < 1 || limit > maxSyncPageSize {
+		limit = defaultSyncPageSize
+	}
+
+	// Items are paged in (vault version, id) order. A full sync starts below version 0
+	// so items written before versions were tracked are included.
+	afterVersion := int32(-1)
+	if !fullResync {
+		afterVersion = *req.LastSyncedVersionID
+	}
+	afterItem := syncCursorStart
+	if req.Cursor != "" {
+		afterVersion, afterItem, err = decodeSyncCursor(req.Cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	items, err := queries.GetVaultItemsChangedSince(ctx, sqlc.GetVaultItemsChangedSinceParams{
+		VaultID: vaultID,
+		Column2: afterVersion,
+		Column3: afterItem,
+		Limit:   limit + 1,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch vault items"})
+		return
+	}
+
+	hasMore := len(items) > int(limit)
+	nextCursor := ""
+	if hasMore {
+		items = items[:limit]
+		nextCursor = encodeSyncCursor(items[len(items)-1])
 	}
 
 	// Convert items to response format
@@ -144,27 +323,21 @@ func (h *SyncHandler) PullVaultChanges(c *gin.Context) {
 			Tag:           crypto.EncodeBase64(item.Tag),
 			Meta:          item.Meta,
 			Version:       item.Version,
+			KeyVersion:    item.KeyVersion,
 			CreatedAt:     timestampToTime(item.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
 			UpdatedAt:     timestampToTime(item.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
 		}
 	}
 
-	// Generate ETag based on vault state
-	etag := generateVaultETag(vaultID, versionID, items)
-
-	// Check If-None-Match header
-	if match := c.GetHeader("If-None-Match"); match != "" && match == etag {
-		c.Status(http.StatusNotModified)
-		return
-	}
-
 	response := SyncPullResponse{
 		VaultID:         vaultID,
 		CurrentVersion:  versionID,
 		Items:           itemResponses,
-		DeletedItemIDs:  []string{}, // Would track deletions in production
+		DeletedItemIDs:  deletedItemIDs,
+		FullResync:      fullResync,
 		ETag:            etag,
-		HasMoreVersions: false,
+		HasMoreVersions: hasMore,
+		NextCursor:      nextCursor,
 	}
 
 	c.Header("ETag", etag)
@@ -192,56 +365,46 @@ func (h *SyncHandler) CommitVaultChanges(c *gin.Context) {
 		return
 	}
 
-	// Verify device signature
-	sigData, err := ExtractDeviceSignature(c)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required: " + err.Error()})
+	// The request signature was checked by SignatureVerificationMiddleware
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required"})
 		return
 	}
 
 	queries := h.services.GetDB().GetQueries()
 
-	// Check vault access
-	accessLevel, err := queries.CheckUserVaultAccess(c.Request.Context(), sqlc.CheckUserVaultAccessParams{
-		ID:     vaultID,
-		UserID: userID.(int32),
-	})
-	if err != nil || accessLevel == nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": "no access to this vault"})
+	// Viewers are read-only
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleEditor); !ok {
 		return
 	}
 
-	// Verify device
-	pgDeviceID := pgtype.UUID{}
-	_ = pgDeviceID.Scan(sigData.DeviceID.String())
+	ctx := c.Request.Context()
 
-	device, err := queries.GetDeviceByID(c.Request.Context(), pgDeviceID)
+	// The whole commit (items, deletions and the new version) is applied atomically
+	tx, err := h.services.GetDB().BeginTx(ctx)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "device not found"})
-		return
-	}
-
-	if device.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "device does not belong to user"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start commit"})
 		return
 	}
+	defer tx.Rollback(ctx)
 
-	// Verify signature
-	bodyBytes, _ := c.Get("raw_body")
-	bodyHash := sha256.Sum256(bodyBytes.([]byte))
-	canonicalMsg := CreateCanonicalMessage(c.Request.Method, c.Request.URL.Path, sigData.Timestamp, bodyHash[:])
+	qtx := queries.WithTx(tx)
 
-	if !VerifyDeviceSignature(device.Ed25519Public, canonicalMsg, sigData.Signature) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid device signature"})
+	// Lock the vault before checking If-Match, so no other write can land
+	// between the check and this commit
+	if _, err := qtx.LockVaultForUpdate(ctx, vaultID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start commit"})
 		return
 	}
 
-	// Check If-Match header for optimistic concurrency
-	ifMatch := c.GetHeader("If-Match")
-	if ifMatch != "" {
-		// Get current vault state
-		items, _ := queries.GetVaultItemsByVaultID(c.Request.Context(), vaultID)
-		latestVersion, err := queries.GetLatestVaultVersion(c.Request.Context(), vaultID)
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		items, err := qtx.GetVaultItemVersionsByVaultID(ctx, vaultID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch vault items"})
+			return
+		}
+		latestVersion, err := qtx.GetLatestVaultVersion(ctx, vaultID)
 		var versionID int32 = 0
 		if err == nil {
 			versionID = latestVersion.ID
@@ -257,246 +420,1126 @@ func (h *SyncHandler) CommitVaultChanges(c *gin.Context) {
 		}
 	}
 
-	// Process commits
-	var committedItems []VaultItemResponse
-	var conflicts []SyncConflict
-
-	// Process new items and updates
-	for _, itemCommit := range req.Items {
-		if itemCommit.ID == nil || *itemCommit.ID == "" {
-			// Create new item
-			encryptedBlob, err := crypto.DecodeBase64(itemCommit.EncryptedBlob)
-			if err != nil {
-				continue
-			}
-			iv, err := crypto.DecodeBase64(itemCommit.IV)
-			if err != nil {
-				continue
-			}
-			tag, err := crypto.DecodeBase64(itemCommit.Tag)
-			if err != nil {
-				continue
-			}
-
-			itemID := uuid.New()
-			pgItemID := pgtype.UUID{}
-			_ = pgItemID.Scan(itemID.String())
-
-			createdItem, err := queries.CreateVaultItem(c.Request.Context(), sqlc.CreateVaultItemParams{
-				ID:            pgItemID,
-				VaultID:       vaultID,
-				ItemType:      itemCommit.ItemType,
-				EncryptedBlob: encryptedBlob,
-				Iv:            iv,
-				Tag:           tag,
-				Meta:          itemCommit.Meta,
-				Version:       1,
-			})
-			if err != nil {
-				continue
-			}
-
-			committedItems = append(committedItems, VaultItemResponse{
-				ID:            uuidToString(createdItem.ID),
-				VaultID:       createdItem.VaultID,
-				ItemType:      createdItem.ItemType,
-				EncryptedBlob: crypto.EncodeBase64(createdItem.EncryptedBlob),
-				IV:            crypto.EncodeBase64(createdItem.Iv),
-				Tag:           crypto.EncodeBase64(createdItem.Tag),
-				Meta:          createdItem.Meta,
-				Version:       createdItem.Version,
-				CreatedAt:     timestampToTime(createdItem.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
-				UpdatedAt:     timestampToTime(createdItem.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
-			})
-		} else {
-			// Update existing item
-			itemID, err := uuid.Parse(*itemCommit.ID)
-			if err != nil {
-				continue
-			}
-
-			pgItemID := pgtype.UUID{}
-			_ = pgItemID.Scan(itemID.String())
-
-			// Get current item for version check
-			currentItem, err := queries.GetVaultItemByID(c.Request.Context(), pgItemID)
-			if err != nil {
-				conflicts = append(conflicts, SyncConflict{
-					ItemID:          *itemCommit.ID,
-					ConflictType:    "not_found",
-					AttemptedAction: "update",
-				})
-				continue
-			}
-
-			// Check optimistic concurrency
-			if itemCommit.BaseVersion != nil && currentItem.Version != *itemCommit.BaseVersion {
-				conflicts = append(conflicts, SyncConflict{
-					ItemID:          *itemCommit.ID,
-					ConflictType:    "version_mismatch",
-					CurrentVersion:  currentItem.Version,
-					AttemptedAction: "update",
-				})
-				continue
-			}
-
-			encryptedBlob, err := crypto.DecodeBase64(itemCommit.EncryptedBlob)
-			if err != nil {
-				continue
-			}
-			iv, err := crypto.DecodeBase64(itemCommit.IV)
-			if err != nil {
-				continue
-			}
-			tag, err := crypto.DecodeBase64(itemCommit.Tag)
-			if err != nil {
-				continue
-			}
-
-			updatedItem, err := queries.UpdateVaultItem(c.Request.Context(), sqlc.UpdateVaultItemParams{
-				ID:            pgItemID,
-				EncryptedBlob: encryptedBlob,
-				Iv:            iv,
-				Tag:           tag,
-				Meta:          itemCommit.Meta,
-				Version:       currentItem.Version + 1,
-			})
-			if err != nil {
-				continue
-			}
+	// Create the version first so items and deletions can be stamped with it
+	vaultVersion, err := createVaultVersion(ctx, qtx, vaultID, device.ID)
+	if err == errKeyRotationInProgress {
+		c.JSON(http.StatusConflict, gin.H{"error": "vault key rotation in progress"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create version"})
+		return
+	}
 
-			committedItems = append(committedItems, VaultItemResponse{
-				ID:            uuidToString(updatedItem.ID),
-				VaultID:       updatedItem.VaultID,
-				ItemType:      updatedItem.ItemType,
-				EncryptedBlob: crypto.EncodeBase64(updatedItem.EncryptedBlob),
-				IV:            crypto.EncodeBase64(updatedItem.Iv),
-				Tag:           crypto.EncodeBase64(updatedItem.Tag),
-				Meta:          updatedItem.Meta,
-				Version:       updatedItem.Version,
-				CreatedAt:     timestampToTime(updatedItem.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
-				UpdatedAt:     timestampToTime(updatedItem.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
+	committedItems, conflicts, err := applySyncCommit(ctx, qtx, vaultID, vaultVersion, &req)
+	if err != nil {
+		var commitErr *syncCommitError
+		if errors.As(err, &commitErr) {
+			c.JSON(commitErr.status, gin.H{
+				"error":        "commit rolled back: " + commitErr.entry.Reason,
+				"failed_entry": commitErr.entry,
 			})
+			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply commit"})
+		return
 	}
 
-	// Process deletions
-	for _, itemIDStr := range req.DeletedItems {
-		itemID, err := uuid.Parse(itemIDStr)
-		if err != nil {
-			continue
+	// Any conflict rejects the whole commit; the state outside the transaction is unchanged
+	if len(conflicts) > 0 {
+		items, _ := queries.GetVaultItemVersionsByVaultID(ctx, vaultID)
+		latestVersion, err := queries.GetLatestVaultVersion(ctx, vaultID)
+		var versionID int32 = 0
+		if err == nil {
+			versionID = latestVersion.ID
 		}
 
-		pgItemID := pgtype.UUID{}
-		_ = pgItemID.Scan(itemID.String())
+		c.JSON(http.StatusConflict, SyncCommitResponse{
+			VaultID:        vaultID,
+			CommittedItems: []VaultItemResponse{},
+			Conflicts:      conflicts,
+			ETag:           generateVaultETag(vaultID, versionID, items),
+		})
+		return
+	}
 
-		err = queries.DeleteVaultItem(c.Request.Context(), pgItemID)
-		if err != nil {
-			conflicts = append(conflicts, SyncConflict{
-				ItemID:          itemIDStr,
-				ConflictType:    "not_found",
-				AttemptedAction: "delete",
-			})
-		}
+	// Snapshot the committed state; a client-supplied MAC must cover exactly this state
+	snapshotItems, err := qtx.GetVaultItemsByVaultID(ctx, vaultID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch vault items"})
+		return
 	}
+	manifestDigest := snapshotManifestDigest(vaultID, snapshotItems)
 
-	// Create vault version snapshot (simplified - just storing metadata)
-	objectKey := fmt.Sprintf("vaults/%d/versions/%d.snapshot", vaultID, time.Now().Unix())
-	vaultVersion, err := queries.CreateVaultVersion(c.Request.Context(), sqlc.CreateVaultVersionParams{
-		VaultID:   vaultID,
-		ObjectKey: objectKey,
-		Mac:       nil, // Would compute MAC in production
-		CreatedByDevice: pgtype.UUID{
-			Bytes: sigData.DeviceID,
-			Valid: true,
-		},
-	})
+	mac, err := verifySnapshotMAC(device.Ed25519Public, manifestDigest, req.SnapshotMAC)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create version"})
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":           "commit rolled back: " + err.Error(),
+			"manifest_digest": hex.EncodeToString(manifestDigest),
+		})
+		return
+	}
+
+	snapshotData, err := buildVaultSnapshot(ctx, qtx, vaultVersion, snapshotItems, mac)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build vault snapshot"})
 		return
 	}
 
 	// Generate new ETag
-	items, _ := queries.GetVaultItemsByVaultID(c.Request.Context(), vaultID)
+	items, err := qtx.GetVaultItemVersionsByVaultID(ctx, vaultID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch vault items"})
+		return
+	}
 	newETag := generateVaultETag(vaultID, vaultVersion.ID, items)
 
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to commit changes"})
+		return
+	}
+
+	storeVaultSnapshot(ctx, h.services.ObjectStore(), vaultVersion, snapshotData)
+	publishVaultVersion(ctx, h.services, vaultVersion)
+
 	response := SyncCommitResponse{
 		VaultID:        vaultID,
 		NewVersionID:   vaultVersion.ID,
 		CommittedItems: committedItems,
-		Conflicts:      conflicts,
 		ETag:           newETag,
 	}
 
 	c.Header("ETag", newETag)
-	if len(conflicts) > 0 {
-		c.JSON(http.StatusConflict, response)
-	} else {
-		c.JSON(http.StatusOK, response)
-	}
+	c.JSON(http.StatusOK, response)
 }
 
-// GetVaultVersions retrieves version history for a vault
-// GET /api/vaults/:id/versions
-func (h *SyncHandler) GetVaultVersions(c *gin.Context) {
-	vaultID, err := parseIntParam(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault_id"})
-		return
-	}
+// syncCommitError aborts a commit and identifies the entry responsible
+type syncCommitError struct {
+	status int
+	entry  SyncCommitFailure
+}
 
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
-		return
-	}
+func (e *syncCommitError) Error() string {
+	return fmt.Sprintf("%s entry %d: %s", e.entry.AttemptedAction, e.entry.Index, e.entry.Reason)
+}
 
-	// Parse limit parameter
-	limitStr := c.DefaultQuery("limit", "10")
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit < 1 || limit > 100 {
-		limit = 10
+func newSyncCommitError(status int, index int, itemID, action, reason string) *syncCommitError {
+	return &syncCommitError{
+		status: status,
+		entry: SyncCommitFailure{
+			Index:           index,
+			ItemID:          itemID,
+			AttemptedAction: action,
+			Reason:          reason,
+		},
 	}
+}
 
-	queries := h.services.GetDB().GetQueries()
+// pendingSyncItem is a validated, decoded item commit waiting to be written
+type pendingSyncItem struct {
+	index         int
+	commit        SyncItemCommit
+	current       *sqlc.VaultItem // nil for new items
+	encryptedBlob []byte
+	iv            []byte
+	tag           []byte
+	keyVersion    int32
+}
 
-	// Check vault access
-	accessLevel, err := queries.CheckUserVaultAccess(c.Request.Context(), sqlc.CheckUserVaultAccessParams{
-		ID:     vaultID,
-		UserID: userID.(int32),
-	})
-	if err != nil || accessLevel == nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": "no access to this vault"})
-		return
+// applySyncCommit validates and applies a commit inside the caller's transaction.
+// Touched items are locked first; if any entry conflicts nothing is written and
+// the conflicts are returned. Any other failure is reported as a *syncCommitError.
+// Deleted items are tombstoned against version so other devices learn about them.
+func applySyncCommit(ctx context.Context, qtx *sqlc.Queries, vaultID int32, version sqlc.VaultVersion, req *SyncCommitRequest) ([]VaultItemResponse, []SyncConflict, error) {
+	// Collect every existing item touched by the commit. Each item may appear
+	// once, as a later write to the same row would fail after the first
+	var touchedIDs []pgtype.UUID
+	seen := make(map[uuid.UUID]bool)
+	itemIDs := make([]pgtype.UUID, len(req.Items))
+	for i, itemCommit := range req.Items {
+		if itemCommit.ID == nil || *itemCommit.ID == "" {
+			continue
+		}
+		itemID, err := uuid.Parse(*itemCommit.ID)
+		if err != nil {
+			return nil, nil, newSyncCommitError(http.StatusBadRequest, i, *itemCommit.ID, "update", "invalid item id")
+		}
+		if seen[itemID] {
+			return nil, nil, newSyncCommitError(http.StatusBadRequest, i, *itemCommit.ID, "update", "duplicate item id")
+		}
+		seen[itemID] = true
+		itemIDs[i] = pgtype.UUID{Bytes: itemID, Valid: true}
+		touchedIDs = append(touchedIDs, itemIDs[i])
 	}
 
-	// Get versions
-	versions, err := queries.GetVaultVersionsByVaultID(c.Request.Context(), sqlc.GetVaultVersionsByVaultIDParams{
+	deletedIDs := make([]pgtype.UUID, len(req.DeletedItems))
+	for i, itemIDStr := range req.DeletedItems {
+		itemID, err := uuid.Parse(itemIDStr)
+		if err != nil {
+			return nil, nil, newSyncCommitError(http.StatusBadRequest, i, itemIDStr, "delete", "invalid item id")
+		}
+		if seen[itemID] {
+			return nil, nil, newSyncCommitError(http.StatusBadRequest, i, itemIDStr, "delete", "duplicate item id")
+		}
+		seen[itemID] = true
+		deletedIDs[i] = pgtype.UUID{Bytes: itemID, Valid: true}
+		touchedIDs = append(touchedIDs, deletedIDs[i])
+	}
+
+	// Lock the touched rows so concurrent commits on the same items serialize
+	lockedItems, err := qtx.LockVaultItemsForUpdate(ctx, sqlc.LockVaultItemsForUpdateParams{
 		VaultID: vaultID,
-		Limit:   int32(limit),
+		Column2: touchedIDs,
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch versions"})
-		return
+		return nil, nil, fmt.Errorf("failed to lock vault items: %w", err)
 	}
 
-	response := make([]VaultVersionResponse, len(versions))
-	for i, version := range versions {
-		response[i] = VaultVersionResponse{
-			ID:              version.ID,
-			VaultID:         version.VaultID,
-			ObjectKey:       version.ObjectKey,
-			MAC:             bytesToStringPtr(version.Mac),
-			CreatedByDevice: uuidToStringPtr(version.CreatedByDevice),
-			CreatedAt:       timestampToTime(version.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
-		}
+	currentItems := make(map[[16]byte]sqlc.VaultItem, len(lockedItems))
+	for _, item := range lockedItems {
+		currentItems[item.ID.Bytes] = item
 	}
 
-	c.JSON(http.StatusOK, response)
+	// Validate every entry before writing anything
+	var conflicts []SyncConflict
+	keyVersions := make(map[int32]int32) // requested key version -> resolved
+	pending := make([]pendingSyncItem, 0, len(req.Items))
+	for i, itemCommit := range req.Items {
+		action := "create"
+		var current *sqlc.VaultItem
+		if itemIDs[i].Valid {
+			action = "update"
+			item, ok := currentItems[itemIDs[i].Bytes]
+			if !ok {
+				conflicts = append(conflicts, SyncConflict{
+					ItemID:          *itemCommit.ID,
+					ConflictType:    "not_found",
+					AttemptedAction: action,
+				})
+				continue
+			}
+
+			// Check optimistic concurrency. A resolved merge applies only while the
+			// server is still at the version the client merged in.
+			expectedVersion, baseVersion := itemCommit.BaseVersion, itemCommit.BaseVersion
+			if parents := itemCommit.MergeParents; parents != nil {
+				if parents.BaseVersion >= parents.TheirVersion {
+					return nil, nil, newSyncCommitError(http.StatusBadRequest, i, *itemCommit.ID, "resolve", "merge base_version must be older than their_version")
+				}
+				expectedVersion, baseVersion = &parents.TheirVersion, &parents.BaseVersion
+				action = "resolve"
+			}
+			if expectedVersion != nil && item.Version != *expectedVersion {
+				conflict, err := newVersionConflict(ctx, qtx, item, *baseVersion, action)
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to load conflict metadata: %w", err)
+				}
+				conflicts = append(conflicts, conflict)
+				continue
+			}
+			if parents := itemCommit.MergeParents; parents != nil {
+				_, err := qtx.GetVaultItemRevision(ctx, sqlc.GetVaultItemRevisionParams{
+					ItemID:  item.ID,
+					VaultID: vaultID,
+					Version: parents.BaseVersion,
+				})
+				if err == pgx.ErrNoRows {
+					return nil, nil, newSyncCommitError(http.StatusBadRequest, i, *itemCommit.ID, "resolve", "merge base_version not found in item history")
+				}
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to load merge base: %w", err)
+				}
+			}
+			current = &item
+		} else if itemCommit.MergeParents != nil {
+			return nil, nil, newSyncCommitError(http.StatusBadRequest, i, "", action, "merge_parents requires an item id")
+		}
+
+		itemIDStr := ""
+		if itemCommit.ID != nil {
+			itemIDStr = *itemCommit.ID
+		}
+
+		encryptedBlob, err := crypto.DecodeBase64(itemCommit.EncryptedBlob)
+		if err != nil {
+			return nil, nil, newSyncCommitError(http.StatusBadRequest, i, itemIDStr, action, "invalid encrypted_blob format")
+		}
+		iv, err := crypto.DecodeBase64(itemCommit.IV)
+		if err != nil {
+			return nil, nil, newSyncCommitError(http.StatusBadRequest, i, itemIDStr, action, "invalid iv format")
+		}
+		tag, err := crypto.DecodeBase64(itemCommit.Tag)
+		if err != nil {
+			return nil, nil, newSyncCommitError(http.StatusBadRequest, i, itemIDStr, action, "invalid tag format")
+		}
+
+		keyVersion, ok := keyVersions[itemCommit.KeyVersion]
+		if !ok {
+			keyVersion, err = writeKeyVersion(ctx, qtx, vaultID, itemCommit.KeyVersion)
+			if status := keyVersionErrorStatus(err); status != 0 {
+				return nil, nil, newSyncCommitError(status, i, itemIDStr, action, err.Error())
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to fetch vault key: %w", err)
+			}
+			keyVersions[itemCommit.KeyVersion] = keyVersion
+		}
+
+		pending = append(pending, pendingSyncItem{
+			index:         i,
+			commit:        itemCommit,
+			current:       current,
+			encryptedBlob: encryptedBlob,
+			iv:            iv,
+			tag:           tag,
+			keyVersion:    keyVersion,
+		})
+	}
+
+	for i, itemIDStr := range req.DeletedItems {
+		if _, ok := currentItems[deletedIDs[i].Bytes]; !ok {
+			conflicts = append(conflicts, SyncConflict{
+				ItemID:          itemIDStr,
+				ConflictType:    "not_found",
+				AttemptedAction: "delete",
+			})
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return nil, conflicts, nil
+	}
+
+	// Process new items and updates
+	committedItems := make([]VaultItemResponse, 0, len(pending))
+	for _, p := range pending {
+		var item sqlc.VaultItem
+		if p.current == nil {
+			itemID := uuid.New()
+			item, err = qtx.CreateVaultItem(ctx, sqlc.CreateVaultItemParams{
+				ID:             pgtype.UUID{Bytes: itemID, Valid: true},
+				VaultID:        vaultID,
+				ItemType:       p.commit.ItemType,
+				EncryptedBlob:  p.encryptedBlob,
+				Iv:             p.iv,
+				Tag:            p.tag,
+				Meta:           p.commit.Meta,
+				Version:        1,
+				VaultVersionID: pgtype.Int4{Int32: version.ID, Valid: true},
+				KeyVersion:     p.keyVersion,
+			})
+			if err != nil {
+				return nil, nil, newSyncCommitError(http.StatusInternalServerError, p.index, "", "create", "failed to create vault item")
+			}
+		} else {
+			if err := qtx.ArchiveVaultItemRevision(ctx, p.current.ID); err != nil {
+				return nil, nil, newSyncCommitError(http.StatusInternalServerError, p.index, *p.commit.ID, "update", "failed to archive item revision")
+			}
+			item, err = qtx.UpdateVaultItem(ctx, sqlc.UpdateVaultItemParams{
+				ID:              p.current.ID,
+				EncryptedBlob:   p.encryptedBlob,
+				Iv:              p.iv,
+				Tag:             p.tag,
+				Meta:            p.commit.Meta,
+				Version:         p.current.Version + 1,
+				VaultVersionID:  pgtype.Int4{Int32: version.ID, Valid: true},
+				KeyVersion:      p.keyVersion,
+				ExpectedVersion: p.current.Version,
+			})
+			if err == pgx.ErrNoRows {
+				return nil, nil, newSyncCommitError(http.StatusPreconditionFailed, p.index, *p.commit.ID, "update", "item changed concurrently")
+			}
+			if err != nil {
+				return nil, nil, newSyncCommitError(http.StatusInternalServerError, p.index, *p.commit.ID, "update", "failed to update vault item")
+			}
+		}
+
+		committedItems = append(committedItems, VaultItemResponse{
+			ID:            uuidToString(item.ID),
+			VaultID:       item.VaultID,
+			ItemType:      item.ItemType,
+			EncryptedBlob: crypto.EncodeBase64(item.EncryptedBlob),
+			IV:            crypto.EncodeBase64(item.Iv),
+			Tag:           crypto.EncodeBase64(item.Tag),
+			Meta:          item.Meta,
+			Version:       item.Version,
+			KeyVersion:    item.KeyVersion,
+			CreatedAt:     timestampToTime(item.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+			UpdatedAt:     timestampToTime(item.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		})
+	}
+
+	// Process deletions
+	for i, itemIDStr := range req.DeletedItems {
+		// Keep the final ciphertext so a restore can bring the item back
+		if err := qtx.ArchiveVaultItemRevision(ctx, deletedIDs[i]); err != nil {
+			return nil, nil, newSyncCommitError(http.StatusInternalServerError, i, itemIDStr, "delete", "failed to archive item revision")
+		}
+		if err := qtx.DeleteVaultItem(ctx, deletedIDs[i]); err != nil {
+			return nil, nil, newSyncCommitError(http.StatusInternalServerError, i, itemIDStr, "delete", "failed to delete vault item")
+		}
+
+		_, err := qtx.CreateVaultItemTombstone(ctx, sqlc.CreateVaultItemTombstoneParams{
+			ItemID:          deletedIDs[i],
+			VaultID:         vaultID,
+			DeletedByDevice: version.CreatedByDevice,
+			VaultVersionID:  version.ID,
+		})
+		if err != nil {
+			return nil, nil, newSyncCommitError(http.StatusInternalServerError, i, itemIDStr, "delete", "failed to record deletion")
+		}
+	}
+
+	return committedItems, nil, nil
+}
+
+// GetVaultVersions retrieves version history for a vault
+// GET /api/vaults/:id/versions
+func (h *SyncHandler) GetVaultVersions(c *gin.Context) {
+	vaultID, err := parseIntParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault_id"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	// Parse limit parameter
+	limitStr := c.DefaultQuery("limit", "10")
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	queries := h.services.GetDB().GetQueries()
+
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleViewer); !ok {
+		return
+	}
+
+	// Get versions
+	versions, err := queries.GetVaultVersionsByVaultID(c.Request.Context(), sqlc.GetVaultVersionsByVaultIDParams{
+		VaultID: vaultID,
+		Limit:   int32(limit),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch versions"})
+		return
+	}
+
+	response := make([]VaultVersionResponse, len(versions))
+	for i, version := range versions {
+		response[i] = VaultVersionResponse{
+			ID:              version.ID,
+			VaultID:         version.VaultID,
+			ObjectKey:       version.ObjectKey,
+			MAC:             bytesToStringPtr(version.Mac),
+			CreatedByDevice: uuidToStringPtr(version.CreatedByDevice),
+			CreatedAt:       timestampToTime(version.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetVaultVersionSnapshot downloads the snapshot stored for a vault version
+// GET /api/vaults/:id/versions/:version_id/snapshot
+func (h *SyncHandler) GetVaultVersionSnapshot(c *gin.Context) {
+	vaultID, err := parseIntParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault_id"})
+		return
+	}
+
+	versionID, err := parseIntParam(c.Param("version_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version_id"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	queries := h.services.GetDB().GetQueries()
+
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleViewer); !ok {
+		return
+	}
+
+	version, err := queries.GetVaultVersionByIDAndVault(ctx, sqlc.GetVaultVersionByIDAndVaultParams{
+		VaultID: vaultID,
+		ID:      versionID,
+	})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		return
+	}
+
+	snapshot, err := readVaultSnapshot(ctx, h.services.ObjectStore(), version)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		// Versions written before snapshots were stored have nothing behind their key
+		c.JSON(http.StatusNotFound, gin.H{"error": "no snapshot stored for this version"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read vault snapshot"})
+		return
+	}
+
+	c.JSON(http.StatusOK, VaultSnapshotResponse{
+		VaultSnapshot:   *snapshot,
+		MAC:             bytesToStringPtr(version.Mac),
+		CreatedByDevice: uuidToStringPtr(version.CreatedByDevice),
+		CreatedAt:       timestampToTime(version.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+	})
+}
+
+// RestoreVaultVersion rolls the vault back to the state stored for a version.
+// Deleted items are recreated, changed items reverted and newer items deleted,
+// all recorded as one new version created by the requesting device.
+// POST /api/vaults/:id/versions/:version_id/restore
+func (h *SyncHandler) RestoreVaultVersion(c *gin.Context) {
+	vaultID, err := parseIntParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault_id"})
+		return
+	}
+
+	versionID, err := parseIntParam(c.Param("version_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version_id"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	// No body is also acceptable - restore without a MAC
+	var req RestoreVaultVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reencrypted, ok := decodeReencryptedItems(c, req.ReencryptedItems)
+	if !ok {
+		return
+	}
+
+	// The request signature was checked by SignatureVerificationMiddleware
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	queries := h.services.GetDB().GetQueries()
+
+	// Restoring rewrites items, so viewers may not
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleEditor); !ok {
+		return
+	}
+
+	target, err := queries.GetVaultVersionByIDAndVault(ctx, sqlc.GetVaultVersionByIDAndVaultParams{
+		VaultID: vaultID,
+		ID:      versionID,
+	})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		return
+	}
+
+	snapshot, err := readVaultSnapshot(ctx, h.services.ObjectStore(), target)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no snapshot stored for this version"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read vault snapshot"})
+		return
+	}
+
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start restore"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
+
+	vaultVersion, err := createVaultVersion(ctx, qtx, vaultID, device.ID)
+	if err == errKeyRotationInProgress {
+		c.JSON(http.StatusConflict, gin.H{"error": "vault key rotation in progress"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create version"})
+		return
+	}
+
+	restoredItems, deletedItemIDs, err := applyVaultRestore(ctx, qtx, vaultVersion, snapshot, reencrypted)
+	if err != nil {
+		var staleErr *staleSnapshotItemsError
+		if errors.As(err, &staleErr) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":    "snapshot items are encrypted with a retired vault key, send them re-encrypted in reencrypted_items",
+				"item_ids": staleErr.itemIDs,
+			})
+			return
+		}
+		if status := keyVersionErrorStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": "reencrypted_items: " + err.Error()})
+			return
+		}
+		if err == errUnknownSnapshotItem {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore vault"})
+		return
+	}
+
+	snapshotItems, err := qtx.GetVaultItemsByVaultID(ctx, vaultID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch vault items"})
+		return
+	}
+	manifestDigest := snapshotManifestDigest(vaultID, snapshotItems)
+
+	mac, err := verifySnapshotMAC(device.Ed25519Public, manifestDigest, req.SnapshotMAC)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":           "restore rolled back: " + err.Error(),
+			"manifest_digest": hex.EncodeToString(manifestDigest),
+		})
+		return
+	}
+
+	snapshotData, err := buildVaultSnapshot(ctx, qtx, vaultVersion, snapshotItems, mac)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build vault snapshot"})
+		return
+	}
+
+	items, err := qtx.GetVaultItemVersionsByVaultID(ctx, vaultID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch vault items"})
+		return
+	}
+	newETag := generateVaultETag(vaultID, vaultVersion.ID, items)
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to commit restore"})
+		return
+	}
+
+	storeVaultSnapshot(ctx, h.services.ObjectStore(), vaultVersion, snapshotData)
+	publishVaultVersion(ctx, h.services, vaultVersion)
+
+	c.Header("ETag", newETag)
+	c.JSON(http.StatusOK, RestoreVaultVersionResponse{
+		VaultID:             vaultID,
+		RestoredFromVersion: target.ID,
+		NewVersionID:        vaultVersion.ID,
+		RestoredItems:       restoredItems,
+		DeletedItemIDs:      deletedItemIDs,
+		ETag:                newETag,
+	})
+}
+
+// errUnknownSnapshotItem is returned for a re-encrypted item the snapshot does not contain
+var errUnknownSnapshotItem = errors.New("reencrypted_items names an item that is not in the snapshot")
+
+// decodeReencryptedItems decodes the re-encrypted snapshot items by item id
+func decodeReencryptedItems(c *gin.Context, items []ReencryptedSnapshotItem) (map[[16]byte]restoreCiphertext, bool) {
+	decoded := make(map[[16]byte]restoreCiphertext, len(items))
+	for _, item := range items {
+		itemID, err := uuid.Parse(item.ID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id in reencrypted_items"})
+			return nil, false
+		}
+		if _, ok := decoded[itemID]; ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "duplicate item id in reencrypted_items"})
+			return nil, false
+		}
+
+		encryptedBlob, err := crypto.DecodeBase64(item.EncryptedBlob)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid encrypted_blob format in reencrypted_items"})
+			return nil, false
+		}
+		iv, err := crypto.DecodeBase64(item.IV)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid iv format in reencrypted_items"})
+			return nil, false
+		}
+		tag, err := crypto.DecodeBase64(item.Tag)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tag format in reencrypted_items"})
+			return nil, false
+		}
+
+		decoded[itemID] = restoreCiphertext{encryptedBlob: encryptedBlob, iv: iv, tag: tag, keyVersion: item.KeyVersion}
+	}
+	return decoded, true
+}
+
+// applyVaultRestore rewrites the vault's items to match snapshot inside the
+// caller's transaction, stamping every change with version. Restored items get
+// a version above their current and snapshot versions so clients holding either
+// copy treat the restored one as newer, and above every archived revision so
+// history is never overwritten. Removed items are archived before deletion.
+// Every restored ciphertext must be under the vault's current key: snapshot
+// items that are not, including ones without a key version, have to be supplied
+// re-encrypted in reencrypted, or nothing is written and a
+// *staleSnapshotItemsError lists them.
+func applyVaultRestore(ctx context.Context, qtx *sqlc.Queries, version sqlc.VaultVersion, snapshot *VaultSnapshot, reencrypted map[[16]byte]restoreCiphertext) ([]VaultItemResponse, []string, error) {
+	current, err := qtx.GetVaultItemsByVaultID(ctx, version.VaultID)
+	if err != nil {
+		return nil, nil, err
+	}
+	currentByID := make(map[[16]byte]sqlc.VaultItem, len(current))
+	for _, item := range current {
+		currentByID[item.ID.Bytes] = item
+	}
+
+	// Check every item's key before writing anything
+	ciphertexts := make([]restoreCiphertext, len(snapshot.Items))
+	unchanged := make([]bool, len(snapshot.Items))
+	var staleItemIDs []string
+	for i, snapItem := range snapshot.Items {
+		itemID, err := uuid.Parse(snapItem.ID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid item id in snapshot: %w", err)
+		}
+
+		ciphertext, replaced := reencrypted[itemID]
+		if replaced {
+			delete(reencrypted, itemID)
+		} else {
+			if ciphertext.encryptedBlob, err = crypto.DecodeBase64(snapItem.EncryptedBlob); err != nil {
+				return nil, nil, err
+			}
+			if ciphertext.iv, err = crypto.DecodeBase64(snapItem.IV); err != nil {
+				return nil, nil, err
+			}
+			if ciphertext.tag, err = crypto.DecodeBase64(snapItem.Tag); err != nil {
+				return nil, nil, err
+			}
+			ciphertext.keyVersion = snapItem.KeyVersion
+		}
+		ciphertexts[i] = ciphertext
+
+		if cur, ok := currentByID[itemID]; ok {
+			if bytes.Equal(cur.EncryptedBlob, ciphertext.encryptedBlob) && bytes.Equal(cur.Iv, ciphertext.iv) &&
+				bytes.Equal(cur.Tag, ciphertext.tag) && bytes.Equal(cur.Meta, snapItem.Meta) && cur.KeyVersion == ciphertext.keyVersion {
+				unchanged[i] = true
+				continue
+			}
+		}
+
+		if _, err := writeKeyVersion(ctx, qtx, version.VaultID, ciphertext.keyVersion); err != nil {
+			if replaced || keyVersionErrorStatus(err) == 0 {
+				return nil, nil, err
+			}
+			staleItemIDs = append(staleItemIDs, snapItem.ID)
+		}
+	}
+	if len(reencrypted) > 0 {
+		return nil, nil, errUnknownSnapshotItem
+	}
+	if len(staleItemIDs) > 0 {
+		return nil, nil, &staleSnapshotItemsError{itemIDs: staleItemIDs}
+	}
+
+	vaultVersionID := pgtype.Int4{Int32: version.ID, Valid: true}
+	restoredItems := []VaultItemResponse{}
+	inSnapshot := make(map[[16]byte]bool, len(snapshot.Items))
+
+	for i, snapItem := range snapshot.Items {
+		itemID := uuid.MustParse(snapItem.ID)
+		inSnapshot[itemID] = true
+		if unchanged[i] {
+			continue
+		}
+
+		encryptedBlob, iv, tag := ciphertexts[i].encryptedBlob, ciphertexts[i].iv, ciphertexts[i].tag
+		keyVersion := ciphertexts[i].keyVersion
+
+		var item sqlc.VaultItem
+		if cur, ok := currentByID[itemID]; ok {
+			if err := qtx.ArchiveVaultItemRevision(ctx, cur.ID); err != nil {
+				return nil, nil, err
+			}
+			archived, err := qtx.GetLatestVaultItemRevisionVersion(ctx, cur.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			item, err = qtx.UpdateVaultItem(ctx, sqlc.UpdateVaultItemParams{
+				ID:              cur.ID,
+				EncryptedBlob:   encryptedBlob,
+				Iv:              iv,
+				Tag:             tag,
+				Meta:            snapItem.Meta,
+				Version:         max(cur.Version, snapItem.Version, archived) + 1,
+				VaultVersionID:  vaultVersionID,
+				KeyVersion:      keyVersion,
+				ExpectedVersion: cur.Version,
+			})
+		} else {
+			// Recreate the deleted item under its original id; its tombstone would
+			// otherwise tell delta pulls to delete it again
+			pgItemID := pgtype.UUID{Bytes: itemID, Valid: true}
+			if err := qtx.DeleteVaultItemTombstone(ctx, pgItemID); err != nil {
+				return nil, nil, err
+			}
+			archived, err := qtx.GetLatestVaultItemRevisionVersion(ctx, pgItemID)
+			if err != nil {
+				return nil, nil, err
+			}
+			item, err = qtx.CreateVaultItem(ctx, sqlc.CreateVaultItemParams{
+				ID:             pgItemID,
+				VaultID:        version.VaultID,
+				ItemType:       snapItem.ItemType,
+				EncryptedBlob:  encryptedBlob,
+				Iv:             iv,
+				Tag:            tag,
+				Meta:           snapItem.Meta,
+				Version:        max(snapItem.Version, archived) + 1,
+				VaultVersionID: vaultVersionID,
+				KeyVersion:     keyVersion,
+			})
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		restoredItems = append(restoredItems, VaultItemResponse{
+			ID:            uuidToString(item.ID),
+			VaultID:       item.VaultID,
+			ItemType:      item.ItemType,
+			EncryptedBlob: crypto.EncodeBase64(item.EncryptedBlob),
+			IV:            crypto.EncodeBase64(item.Iv),
+			Tag:           crypto.EncodeBase64(item.Tag),
+			Meta:          item.Meta,
+			Version:       item.Version,
+			KeyVersion:    item.KeyVersion,
+			CreatedAt:     timestampToTime(item.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+			UpdatedAt:     timestampToTime(item.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		})
+	}
+
+	// Items created after the restored version are removed
+	deletedItemIDs := []string{}
+	for _, item := range current {
+		if inSnapshot[item.ID.Bytes] {
+			continue
+		}
+		if err := qtx.ArchiveVaultItemRevision(ctx, item.ID); err != nil {
+			return nil, nil, err
+		}
+		if err := qtx.DeleteVaultItem(ctx, item.ID); err != nil {
+			return nil, nil, err
+		}
+		if _, err := qtx.CreateVaultItemTombstone(ctx, sqlc.CreateVaultItemTombstoneParams{
+			ItemID:          item.ID,
+			VaultID:         version.VaultID,
+			DeletedByDevice: version.CreatedByDevice,
+			VaultVersionID:  version.ID,
+		}); err != nil {
+			return nil, nil, err
+		}
+		deletedItemIDs = append(deletedItemIDs, uuidToString(item.ID))
+	}
+
+	return restoredItems, deletedItemIDs, nil
+}
+
+// newVersionConflict builds a version_mismatch conflict carrying the server's
+// current ciphertext and, when history still has it, the client's base version
+func newVersionConflict(ctx context.Context, qtx *sqlc.Queries, item sqlc.VaultItem, baseVersion int32, action string) (SyncConflict, error) {
+	conflict := SyncConflict{
+		ItemID:          uuidToString(item.ID),
+		ConflictType:    "version_mismatch",
+		CurrentVersion:  item.Version,
+		AttemptedAction: action,
+		BaseVersion:     &baseVersion,
+		Current: &VaultItemResponse{
+			ID:            uuidToString(item.ID),
+			VaultID:       item.VaultID,
+			ItemType:      item.ItemType,
+			EncryptedBlob: crypto.EncodeBase64(item.EncryptedBlob),
+			IV:            crypto.EncodeBase64(item.Iv),
+			Tag:           crypto.EncodeBase64(item.Tag),
+			Meta:          item.Meta,
+			Version:       item.Version,
+			KeyVersion:    item.KeyVersion,
+			CreatedAt:     timestampToTime(item.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+			UpdatedAt:     timestampToTime(item.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		},
+	}
+
+	revision, err := qtx.GetVaultItemRevision(ctx, sqlc.GetVaultItemRevisionParams{
+		ItemID:  item.ID,
+		VaultID: item.VaultID,
+		Version: baseVersion,
+	})
+	if err == pgx.ErrNoRows {
+		return conflict, nil
+	}
+	if err != nil {
+		return SyncConflict{}, err
+	}
+
+	conflict.Base = &VaultItemRevisionResponse{
+		ItemID:          uuidToString(revision.ItemID),
+		VaultID:         revision.VaultID,
+		Version:         revision.Version,
+		KeyVersion:      revision.KeyVersion,
+		EncryptedBlob:   crypto.EncodeBase64(revision.EncryptedBlob),
+		IV:              crypto.EncodeBase64(revision.Iv),
+		Tag:             crypto.EncodeBase64(revision.Tag),
+		Meta:            revision.Meta,
+		CreatedByDevice: uuidToStringPtr(revision.CreatedByDevice),
+		CreatedAt:       timestampToTime(revision.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+	}
+	return conflict, nil
+}
+
+// publishVaultVersion tells every device with access to the vault that it moved to a new version
+func publishVaultVersion(ctx context.Context, svc services.Service, version sqlc.VaultVersion) {
+	svc.PublishVaultEvent(ctx, version.VaultID, services.EventVaultVersion, map[string]interface{}{
+		"vault_id":          version.VaultID,
+		"version_id":        version.ID,
+		"created_by_device": uuidToStringPtr(version.CreatedByDevice),
+	})
+}
+
+// errKeyRotationInProgress is returned by createVaultVersion while a key
+// rotation is staged, since items written now would miss the re-encryption
+var errKeyRotationInProgress = errors.New("vault key rotation in progress")
+
+// createVaultVersion records a new vault version inside the caller's transaction.
+// The vault row is locked first so concurrent writers get version ids in commit
+// order, which delta pulls rely on. The caller builds the snapshot with
+// buildVaultSnapshot once the version's items are written. Writes are refused
+// with errKeyRotationInProgress while the vault key is being rotated.
+func createVaultVersion(ctx context.Context, qtx *sqlc.Queries, vaultID int32, deviceID pgtype.UUID) (sqlc.VaultVersion, error) {
+	if _, err := qtx.LockVaultForUpdate(ctx, vaultID); err != nil {
+		return sqlc.VaultVersion{}, err
+	}
+	if _, err := qtx.GetActiveVaultKeyRotation(ctx, vaultID); err == nil {
+		return sqlc.VaultVersion{}, errKeyRotationInProgress
+	} else if err != pgx.ErrNoRows {
+		return sqlc.VaultVersion{}, err
+	}
+	return qtx.CreateVaultVersion(ctx, sqlc.CreateVaultVersionParams{
+		VaultID:         vaultID,
+		ObjectKey:       vaultVersionObjectKey(vaultID),
+		Mac:             nil, // set by buildVaultSnapshot when the client supplies one
+		CreatedByDevice: deviceID,
+	})
+}
+
+// syncCursorStart sorts after every item id, so a first page starting at
+// version V only returns items written in versions after V
+var syncCursorStart = pgtype.UUID{
+	Bytes: [16]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	Valid: true,
+}
+
+// encodeSyncCursor returns an opaque cursor positioned after the given item
+func encodeSyncCursor(item sqlc.VaultItem) string {
+	var versionID int32 = 0
+	if item.VaultVersionID.Valid {
+		versionID = item.VaultVersionID.Int32
+	}
+	return crypto.EncodeBase64RawURL([]byte(fmt.Sprintf("%d:%s", versionID, uuidToString(item.ID))))
+}
+
+// decodeSyncCursor parses a cursor produced by encodeSyncCursor
+func decodeSyncCursor(cursor string) (int32, pgtype.UUID, error) {
+	raw, err := crypto.DecodeBase64RawURL(cursor)
+	if err != nil {
+		return 0, pgtype.UUID{}, fmt.Errorf("invalid cursor")
+	}
+	versionStr, itemIDStr, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, pgtype.UUID{}, fmt.Errorf("invalid cursor")
+	}
+	versionID, err := strconv.ParseInt(versionStr, 10, 32)
+	if err != nil {
+		return 0, pgtype.UUID{}, fmt.Errorf("invalid cursor")
+	}
+	itemID, err := uuid.Parse(itemIDStr)
+	if err != nil {
+		return 0, pgtype.UUID{}, fmt.Errorf("invalid cursor")
+	}
+	return int32(versionID), pgtype.UUID{Bytes: itemID, Valid: true}, nil
+}
+
+// vaultVersionObjectKey returns a unique object store key for a new vault version snapshot
+func vaultVersionObjectKey(vaultID int32) string {
+	return fmt.Sprintf("vaults/%d/versions/%s.snapshot", vaultID, uuid.New().String())
+}
+
+// snapshotManifestDigest hashes the vault contents a snapshot covers. Items are
+// identified by the digest of their ciphertext rather than their id so a client
+// can compute it before the server assigns ids to new items:
+// SHA-256 of "<vault_id>" followed by one "\n<hex sha256(blob|iv|tag)>:<version>"
+// line per item, lines sorted.
+func snapshotManifestDigest(vaultID int32, items []sqlc.VaultItem) []byte {
+	entries := make([]string, len(items))
+	for i, item := range items {
+		hash := sha256.New()
+		hash.Write(item.EncryptedBlob)
+		hash.Write(item.Iv)
+		hash.Write(item.Tag)
+		entries[i] = fmt.Sprintf("%x:%d", hash.Sum(nil), item.Version)
+	}
+	sort.Strings(entries)
+
+	hash := sha256.New()
+	hash.Write([]byte(fmt.Sprintf("%d", vaultID)))
+	for _, entry := range entries {
+		hash.Write([]byte("\n" + entry))
+	}
+	return hash.Sum(nil)
+}
+
+// verifySnapshotMAC decodes a client-supplied snapshot_mac and checks it is the
+// device's signature over the manifest digest. An empty MAC is allowed and yields nil.
+func verifySnapshotMAC(devicePubKey ed25519.PublicKey, manifestDigest []byte, encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	mac, err := crypto.DecodeBase64(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot_mac format")
+	}
+	if !VerifyDeviceSignature(devicePubKey, manifestDigest, mac) {
+		return nil, fmt.Errorf("snapshot_mac does not match the vault state")
+	}
+	return mac, nil
+}
+
+// snapshotVaultVersion builds the snapshot of the current vault contents for version
+func snapshotVaultVersion(ctx context.Context, qtx *sqlc.Queries, version sqlc.VaultVersion) ([]byte, error) {
+	items, err := qtx.GetVaultItemsByVaultID(ctx, version.VaultID)
+	if err != nil {
+		return nil, err
+	}
+	return buildVaultSnapshot(ctx, qtx, version, items, nil)
+}
+
+// buildVaultSnapshot encodes items as the snapshot for version and records mac
+// on the version inside the caller's transaction. The encoded snapshot is
+// uploaded with storeVaultSnapshot once the transaction has committed, so a
+// rolled back write never leaves an object behind.
+func buildVaultSnapshot(ctx context.Context, qtx *sqlc.Queries, version sqlc.VaultVersion, items []sqlc.VaultItem, mac []byte) ([]byte, error) {
+	snapshot := VaultSnapshot{
+		VaultID:        version.VaultID,
+		VersionID:      version.ID,
+		ManifestDigest: hex.EncodeToString(snapshotManifestDigest(version.VaultID, items)),
+		Items:          make([]VaultItemResponse, len(items)),
+	}
+	for i, item := range items {
+		snapshot.Items[i] = VaultItemResponse{
+			ID:            uuidToString(item.ID),
+			VaultID:       item.VaultID,
+			ItemType:      item.ItemType,
+			EncryptedBlob: crypto.EncodeBase64(item.EncryptedBlob),
+			IV:            crypto.EncodeBase64(item.Iv),
+			Tag:           crypto.EncodeBase64(item.Tag),
+			Meta:          item.Meta,
+			Version:       item.Version,
+			KeyVersion:    item.KeyVersion,
+			CreatedAt:     timestampToTime(item.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+			UpdatedAt:     timestampToTime(item.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		}
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	if mac != nil {
+		if err := qtx.UpdateVaultVersionMac(ctx, sqlc.UpdateVaultVersionMacParams{
+			ID:  version.ID,
+			Mac: mac,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// storeVaultSnapshot uploads a snapshot built by buildVaultSnapshot after its
+// version has committed. The write already succeeded, so a failed upload is
+// logged and the version is left without a snapshot to restore from.
+func storeVaultSnapshot(ctx context.Context, store storage.ObjectStore, version sqlc.VaultVersion, data []byte) {
+	if err := store.Put(ctx, version.ObjectKey, data); err != nil {
+		log.Printf("failed to store snapshot for vault %d version %d: %v", version.VaultID, version.ID, err)
+	}
+}
+
+// readVaultSnapshot loads the snapshot behind a version and checks it against
+// its manifest digest so a corrupted object is never served as valid
+func readVaultSnapshot(ctx context.Context, store storage.ObjectStore, version sqlc.VaultVersion) (*VaultSnapshot, error) {
+	data, err := store.Get(ctx, version.ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot VaultSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	if snapshot.VaultID != version.VaultID || snapshot.VersionID != version.ID {
+		return nil, fmt.Errorf("snapshot does not belong to version %d", version.ID)
+	}
+
+	items := make([]sqlc.VaultItem, len(snapshot.Items))
+	for i, item := range snapshot.Items {
+		blob, err := crypto.DecodeBase64(item.EncryptedBlob)
+		if err != nil {
+			return nil, err
+		}
+		iv, err := crypto.DecodeBase64(item.IV)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := crypto.DecodeBase64(item.Tag)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = sqlc.VaultItem{EncryptedBlob: blob, Iv: iv, Tag: tag, Version: item.Version}
+	}
+	if hex.EncodeToString(snapshotManifestDigest(version.VaultID, items)) != snapshot.ManifestDigest {
+		return nil, fmt.Errorf("snapshot for version %d failed its manifest check", version.ID)
+	}
+
+	return &snapshot, nil
 }
 
 // generateVaultETag generates an ETag for vault state
-func generateVaultETag(vaultID int32, versionID int32, items []sqlc.VaultItem) string {
+func generateVaultETag(vaultID int32, versionID int32, items []sqlc.GetVaultItemVersionsByVaultIDRow) string {
 	hash := sha256.New()
 	hash.Write([]byte(fmt.Sprintf("%d:%d:%d", vaultID, versionID, len(items))))
 	for _, item := range items {
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"yamony/internal/database/sqlc"
+	"yamony/internal/server/middleware"
+	"yamony/internal/server/services"
+)
+
+// TwoFactorHandler manages TOTP enrolment and recovery codes for the signed-in user
+type TwoFactorHandler struct {
+	services services.Service
+}
+
+func NewTwoFactorHandler(services services.Service) *TwoFactorHandler {
+	return &TwoFactorHandler{services: services}
+}
+
+// TwoFactorCodeRequest re-authenticates a 2FA change with a TOTP code or a recovery code
+type TwoFactorCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// DisableTwoFactorRequest re-authenticates with the password as well as a second
+// factor, so a stolen session and one code are not enough to switch 2FA off
+type DisableTwoFactorRequest struct {
+	TwoFactorCodeRequest
+	SrpProof *SrpProof `json:"srp_proof" binding:"required"`
+}
+
+// ConfirmTOTPRequest proves the authenticator app was set up correctly
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// GetStatus reports which second factors are set up and how many recovery codes are left
+// GET /api/2fa
+func (h *TwoFactorHandler) GetStatus(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	methods, err := h.services.TwoFactorMethods(ctx, userID.(int32))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get two-factor status"})
+		return
+	}
+
+	remaining, err := h.services.GetDB().GetQueries().CountUnusedMfaRecoveryCodes(ctx, userID.(int32))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get two-factor status"})
+		return
+	}
+
+	totpEnabled := false
+	for _, method := range methods {
+		if method == services.MFAMethodTOTP {
+			totpEnabled = true
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  len(methods) > 0,
+		"methods":                  methods,
+		"totp_enabled":             totpEnabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// EnrollTOTP creates a new authenticator secret. The otpauth URI can be shown
+// as a QR code; 2FA is only switched on by ConfirmTOTP
+// POST /api/2fa/totp
+func (h *TwoFactorHandler) EnrollTOTP(c *gin.Context) {
+	userValue, exists := c.Get(middleware.UserKey)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+	user := userValue.(*sqlc.GetUserByIDRow)
+
+	enrollment, err := h.services.EnrollTOTP(c.Request.Context(), user.ID, user.Email)
+	if err != nil {
+		if err == services.ErrMFAAlreadyEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start two-factor enrolment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      enrollment.Secret,
+		"otpauth_uri": enrollment.URI,
+	})
+}
+
+// ConfirmTOTP switches 2FA on and returns recovery codes, which are only shown once
+// POST /api/2fa/totp/confirm
+func (h *TwoFactorHandler) ConfirmTOTP(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req ConfirmTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.services.ConfirmTOTP(c.Request.Context(), userID.(int32), req.Code)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidMFACode:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid two-factor code"})
+		case services.ErrMFANotEnabled:
+			c.JSON(http.StatusNotFound, gin.H{"error": "no two-factor enrolment in progress"})
+		case services.ErrMFAAlreadyEnabled:
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to confirm two-factor authentication"})
+		}
+		return
+	}
+
+	h.services.PublishUserEvent(c.Request.Context(), userID.(int32), services.EventTwoFactorChanged, map[string]interface{}{
+		"enabled": true,
+	})
+
+	rotateSessionToken(c, h.services)
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTwoFactor switches 2FA off after re-authenticating with the password
+// and a second factor
+// POST /api/2fa/disable
+func (h *TwoFactorHandler) DisableTwoFactor(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !requireTwoFactorCode(c, req.TwoFactorCodeRequest) {
+		return
+	}
+
+	if !requireSrpProof(c, h.services, userID.(int32), req.SrpProof) {
+		return
+	}
+
+	err := h.services.DisableTwoFactor(c.Request.Context(), userID.(int32), req.Code, req.RecoveryCode)
+	if err != nil {
+		if err == services.ErrMFARequiredForShares {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is required while you share vaults or items"})
+			return
+		}
+		respondTwoFactorError(c, err, "failed to disable two-factor authentication")
+		return
+	}
+
+	h.services.PublishUserEvent(c.Request.Context(), userID.(int32), services.EventTwoFactorChanged, map[string]interface{}{
+		"enabled": false,
+	})
+
+	rotateSessionToken(c, h.services)
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces every recovery code after re-authenticating
+// POST /api/2fa/recovery-codes
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if !bindTwoFactorCode(c, &req) {
+		return
+	}
+
+	codes, err := h.services.RegenerateMFARecoveryCodes(c.Request.Context(), userID.(int32), req.Code, req.RecoveryCode)
+	if err != nil {
+		respondTwoFactorError(c, err, "failed to regenerate recovery codes")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// bindTwoFactorCode binds a re-authentication request that must carry a code
+func bindTwoFactorCode(c *gin.Context, req *TwoFactorCodeRequest) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	return requireTwoFactorCode(c, *req)
+}
+
+// requireTwoFactorCode rejects a re-authentication request that carries no code
+func requireTwoFactorCode(c *gin.Context, req TwoFactorCodeRequest) bool {
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code or recovery_code is required"})
+		return false
+	}
+
+	return true
+}
+
+// respondTwoFactorError maps second-factor verification errors to responses
+func respondTwoFactorError(c *gin.Context, err error, message string) {
+	switch err {
+	case services.ErrInvalidMFACode:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+	case services.ErrMFATooManyAttempts:
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many two-factor attempts, try again later"})
+	case services.ErrMFANotEnabled:
+		c.JSON(http.StatusNotFound, gin.H{"error": "two-factor authentication is not enabled"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// requireTwoFactor rejects the request unless the user has 2FA enabled. Every
+// account taking part in a share must have it
+func requireTwoFactor(c *gin.Context, svc services.Service, userID int32) bool {
+	enabled, err := svc.TwoFactorEnabled(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check two-factor status"})
+		return false
+	}
+
+	if !enabled {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "two-factor authentication must be enabled to share vaults",
+			"code":  "mfa_required",
+		})
+		return false
+	}
+
+	return true
+}
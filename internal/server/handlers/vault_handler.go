@@ -40,6 +40,11 @@ type VaultResponse struct {
 	ItemCount   int32  `json:"item_count"`
 	CreatedAt   string `json:"created_at"`
 	UpdatedAt   string `json:"updated_at"`
+
+	// RotationRecommended is set when a device that knew the vault key was
+	// revoked, until a new key version is uploaded
+	RotationRecommended   bool    `json:"rotation_recommended"`
+	RotationRecommendedAt *string `json:"rotation_recommended_at,omitempty"`
 }
 
 // CreateVault creates a new vault
@@ -165,6 +170,11 @@ func (h *VaultHandler) GetVaults(c *gin.Context) {
 			vaultResp.Theme = vault.Theme.String
 		}
 
+		if vault.RotationRecommendedAt.Valid {
+			vaultResp.RotationRecommended = true
+			vaultResp.RotationRecommendedAt = timestampToStringPtr(vault.RotationRecommendedAt)
+		}
+
 		response = append(response, vaultResp)
 	}
 
@@ -218,6 +228,11 @@ func (h *VaultHandler) GetVault(c *gin.Context) {
 		response.Theme = vault.Theme.String
 	}
 
+	if vault.RotationRecommendedAt.Valid {
+		response.RotationRecommended = true
+		response.RotationRecommendedAt = timestampToStringPtr(vault.RotationRecommendedAt)
+	}
+
 	c.JSON(http.StatusOK, response)
 }
 
@@ -306,6 +321,11 @@ func (h *VaultHandler) UpdateVault(c *gin.Context) {
 		response.Theme = vault.Theme.String
 	}
 
+	if vault.RotationRecommendedAt.Valid {
+		response.RotationRecommended = true
+		response.RotationRecommendedAt = timestampToStringPtr(vault.RotationRecommendedAt)
+	}
+
 	c.JSON(http.StatusOK, response)
 }
 
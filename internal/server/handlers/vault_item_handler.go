@@ -1,12 +1,12 @@
 package handlers
 
 import (
-	"crypto/sha256"
 	"encoding/json"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 
 	"yamony/internal/crypto"
@@ -30,6 +30,7 @@ type CreateVaultItemRequest struct {
 	Tag           string          `json:"tag" binding:"required"`
 	Meta          json.RawMessage `json:"meta,omitempty"`
 	Version       int32           `json:"version"`
+	KeyVersion    int32           `json:"key_version"` // vault key version that encrypted the blob; must be the current key
 }
 
 // UpdateVaultItemRequest represents the request to update a vault item
@@ -39,6 +40,7 @@ type UpdateVaultItemRequest struct {
 	Tag           string          `json:"tag" binding:"required"`
 	Meta          json.RawMessage `json:"meta,omitempty"`
 	BaseVersion   int32           `json:"base_version" binding:"required"`
+	KeyVersion    int32           `json:"key_version"` // vault key version that encrypted the blob; must be the current key
 }
 
 // VaultItemResponse represents a vault item
@@ -51,18 +53,47 @@ type VaultItemResponse struct {
 	Tag           string          `json:"tag"`
 	Meta          json.RawMessage `json:"meta,omitempty"`
 	Version       int32           `json:"version"`
+	KeyVersion    int32           `json:"key_version"`
 	CreatedAt     string          `json:"created_at"`
 	UpdatedAt     string          `json:"updated_at"`
 }
 
 // VaultItemListResponse is a simplified response for listing items
 type VaultItemListResponse struct {
-	ID        string          `json:"id"`
-	ItemType  string          `json:"item_type"`
-	Meta      json.RawMessage `json:"meta,omitempty"`
-	Version   int32           `json:"version"`
-	CreatedAt string          `json:"created_at"`
-	UpdatedAt string          `json:"updated_at"`
+	ID         string          `json:"id"`
+	ItemType   string          `json:"item_type"`
+	Meta       json.RawMessage `json:"meta,omitempty"`
+	Version    int32           `json:"version"`
+	KeyVersion int32           `json:"key_version"`
+	CreatedAt  string          `json:"created_at"`
+	UpdatedAt  string          `json:"updated_at"`
+}
+
+// VaultItemRevisionListResponse describes a revision without its ciphertext
+type VaultItemRevisionListResponse struct {
+	Version         int32   `json:"version"`
+	KeyVersion      int32   `json:"key_version"`
+	CreatedByDevice *string `json:"created_by_device,omitempty"`
+	CreatedAt       string  `json:"created_at"`
+}
+
+// VaultItemRevisionResponse represents a previous ciphertext of a vault item
+type VaultItemRevisionResponse struct {
+	ItemID          string          `json:"item_id"`
+	VaultID         int32           `json:"vault_id"`
+	Version         int32           `json:"version"`
+	KeyVersion      int32           `json:"key_version"`
+	EncryptedBlob   string          `json:"encrypted_blob"`
+	IV              string          `json:"iv"`
+	Tag             string          `json:"tag"`
+	Meta            json.RawMessage `json:"meta,omitempty"`
+	CreatedByDevice *string         `json:"created_by_device,omitempty"`
+	CreatedAt       string          `json:"created_at"`
+}
+
+// RestoreVaultItemRevisionRequest represents the request to restore an item revision
+type RestoreVaultItemRevisionRequest struct {
+	BaseVersion int32 `json:"base_version" binding:"required"`
 }
 
 // CreateVaultItem creates a new encrypted vault item
@@ -86,48 +117,16 @@ func (h *VaultItemHandler) CreateVaultItem(c *gin.Context) {
 		return
 	}
 
-	// Verify device signature on write operation
-	sigData, err := ExtractDeviceSignature(c)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required: " + err.Error()})
+	// The request signature was checked by SignatureVerificationMiddleware
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required"})
 		return
 	}
 
 	queries := h.services.GetDB().GetQueries()
 
-	vault, err := queries.GetVaultByID(c.Request.Context(), vaultID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
-		return
-	}
-
-	if vault.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized to access this vault"})
-		return
-	}
-
-	// Verify device belongs to user and is not revoked
-	pgDeviceID := pgtype.UUID{}
-	_ = pgDeviceID.Scan(sigData.DeviceID.String())
-
-	device, err := queries.GetDeviceByID(c.Request.Context(), pgDeviceID)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "device not found"})
-		return
-	}
-
-	if device.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "device does not belong to user"})
-		return
-	}
-
-	// Verify signature
-	bodyBytes, _ := c.Get("raw_body")
-	bodyHash := sha256.Sum256(bodyBytes.([]byte))
-	canonicalMsg := CreateCanonicalMessage(c.Request.Method, c.Request.URL.Path, sigData.Timestamp, bodyHash[:])
-
-	if !VerifyDeviceSignature(device.Ed25519Public, canonicalMsg, sigData.Signature) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid device signature"})
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleEditor); !ok {
 		return
 	}
 
@@ -161,22 +160,69 @@ func (h *VaultItemHandler) CreateVaultItem(c *gin.Context) {
 	pgItemID := pgtype.UUID{}
 	_ = pgItemID.Scan(itemID.String())
 
+	ctx := c.Request.Context()
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create vault item"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
+
+	// Every write gets its own vault version so delta pulls pick it up
+	vaultVersion, err := createVaultVersion(ctx, qtx, vaultID, device.ID)
+	if err == errKeyRotationInProgress {
+		c.JSON(http.StatusConflict, gin.H{"error": "vault key rotation in progress"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create version"})
+		return
+	}
+
+	keyVersion, err := writeKeyVersion(ctx, qtx, vaultID, req.KeyVersion)
+	if status := keyVersionErrorStatus(err); status != 0 {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch vault key"})
+		return
+	}
+
 	// Create vault item
-	vaultItem, err := queries.CreateVaultItem(c.Request.Context(), sqlc.CreateVaultItemParams{
-		ID:            pgItemID,
-		VaultID:       vaultID,
-		ItemType:      req.ItemType,
-		EncryptedBlob: encryptedBlob,
-		Iv:            iv,
-		Tag:           tag,
-		Meta:          req.Meta,
-		Version:       version,
+	vaultItem, err := qtx.CreateVaultItem(ctx, sqlc.CreateVaultItemParams{
+		ID:             pgItemID,
+		VaultID:        vaultID,
+		ItemType:       req.ItemType,
+		EncryptedBlob:  encryptedBlob,
+		Iv:             iv,
+		Tag:            tag,
+		Meta:           req.Meta,
+		Version:        version,
+		VaultVersionID: pgtype.Int4{Int32: vaultVersion.ID, Valid: true},
+		KeyVersion:     keyVersion,
 	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create vault item"})
 		return
 	}
 
+	snapshotData, err := snapshotVaultVersion(ctx, qtx, vaultVersion)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build vault snapshot"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create vault item"})
+		return
+	}
+
+	storeVaultSnapshot(ctx, h.services.ObjectStore(), vaultVersion, snapshotData)
+	publishVaultVersion(ctx, h.services, vaultVersion)
+
 	response := VaultItemResponse{
 		ID:            uuidToString(vaultItem.ID),
 		VaultID:       vaultItem.VaultID,
@@ -186,6 +232,7 @@ func (h *VaultItemHandler) CreateVaultItem(c *gin.Context) {
 		Tag:           crypto.EncodeBase64(vaultItem.Tag),
 		Meta:          vaultItem.Meta,
 		Version:       vaultItem.Version,
+		KeyVersion:    vaultItem.KeyVersion,
 		CreatedAt:     timestampToTime(vaultItem.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
 		UpdatedAt:     timestampToTime(vaultItem.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
 	}
@@ -210,15 +257,7 @@ func (h *VaultItemHandler) GetVaultItems(c *gin.Context) {
 
 	queries := h.services.GetDB().GetQueries()
 
-	// Verify vault belongs to user
-	vault, err := queries.GetVaultByID(c.Request.Context(), vaultID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
-		return
-	}
-
-	if vault.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized to access this vault"})
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleViewer); !ok {
 		return
 	}
 
@@ -244,12 +283,13 @@ func (h *VaultItemHandler) GetVaultItems(c *gin.Context) {
 	response := make([]VaultItemListResponse, len(items))
 	for i, item := range items {
 		response[i] = VaultItemListResponse{
-			ID:        uuidToString(item.ID),
-			ItemType:  item.ItemType,
-			Meta:      item.Meta,
-			Version:   item.Version,
-			CreatedAt: timestampToTime(item.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
-			UpdatedAt: timestampToTime(item.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
+			ID:         uuidToString(item.ID),
+			ItemType:   item.ItemType,
+			Meta:       item.Meta,
+			Version:    item.Version,
+			KeyVersion: item.KeyVersion,
+			CreatedAt:  timestampToTime(item.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+			UpdatedAt:  timestampToTime(item.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
 		}
 	}
 
@@ -279,15 +319,7 @@ func (h *VaultItemHandler) GetVaultItem(c *gin.Context) {
 
 	queries := h.services.GetDB().GetQueries()
 
-	// Verify vault belongs to user
-	vault, err := queries.GetVaultByID(c.Request.Context(), vaultID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
-		return
-	}
-
-	if vault.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized to access this vault"})
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleViewer); !ok {
 		return
 	}
 
@@ -316,6 +348,7 @@ func (h *VaultItemHandler) GetVaultItem(c *gin.Context) {
 		Tag:           crypto.EncodeBase64(vaultItem.Tag),
 		Meta:          vaultItem.Meta,
 		Version:       vaultItem.Version,
+		KeyVersion:    vaultItem.KeyVersion,
 		CreatedAt:     timestampToTime(vaultItem.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
 		UpdatedAt:     timestampToTime(vaultItem.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
 	}
@@ -350,49 +383,16 @@ func (h *VaultItemHandler) UpdateVaultItem(c *gin.Context) {
 		return
 	}
 
-	// Verify device signature
-	sigData, err := ExtractDeviceSignature(c)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required: " + err.Error()})
+	// The request signature was checked by SignatureVerificationMiddleware
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required"})
 		return
 	}
 
 	queries := h.services.GetDB().GetQueries()
 
-	// Verify vault belongs to user
-	vault, err := queries.GetVaultByID(c.Request.Context(), vaultID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
-		return
-	}
-
-	if vault.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized to access this vault"})
-		return
-	}
-
-	// Verify device
-	pgDeviceID := pgtype.UUID{}
-	_ = pgDeviceID.Scan(sigData.DeviceID.String())
-
-	device, err := queries.GetDeviceByID(c.Request.Context(), pgDeviceID)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "device not found"})
-		return
-	}
-
-	if device.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "device does not belong to user"})
-		return
-	}
-
-	// Verify signature
-	bodyBytes, _ := c.Get("raw_body")
-	bodyHash := sha256.Sum256(bodyBytes.([]byte))
-	canonicalMsg := CreateCanonicalMessage(c.Request.Method, c.Request.URL.Path, sigData.Timestamp, bodyHash[:])
-
-	if !VerifyDeviceSignature(device.Ed25519Public, canonicalMsg, sigData.Signature) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid device signature"})
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleEditor); !ok {
 		return
 	}
 
@@ -444,19 +444,81 @@ func (h *VaultItemHandler) UpdateVaultItem(c *gin.Context) {
 	// Update with incremented version
 	newVersion := currentItem.Version + 1
 
-	updatedItem, err := queries.UpdateVaultItem(c.Request.Context(), sqlc.UpdateVaultItemParams{
-		ID:            pgItemID,
-		EncryptedBlob: encryptedBlob,
-		Iv:            iv,
-		Tag:           tag,
-		Meta:          req.Meta,
-		Version:       newVersion,
+	ctx := c.Request.Context()
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update vault item"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
+
+	// Every write gets its own vault version so delta pulls pick it up
+	vaultVersion, err := createVaultVersion(ctx, qtx, vaultID, device.ID)
+	if err == errKeyRotationInProgress {
+		c.JSON(http.StatusConflict, gin.H{"error": "vault key rotation in progress"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create version"})
+		return
+	}
+
+	keyVersion, err := writeKeyVersion(ctx, qtx, vaultID, req.KeyVersion)
+	if status := keyVersionErrorStatus(err); status != 0 {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch vault key"})
+		return
+	}
+
+	// Keep the ciphertext being replaced in the item's history
+	if err := qtx.ArchiveVaultItemRevision(ctx, pgItemID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to archive item revision"})
+		return
+	}
+
+	updatedItem, err := qtx.UpdateVaultItem(ctx, sqlc.UpdateVaultItemParams{
+		ID:              pgItemID,
+		EncryptedBlob:   encryptedBlob,
+		Iv:              iv,
+		Tag:             tag,
+		Meta:            req.Meta,
+		Version:         newVersion,
+		VaultVersionID:  pgtype.Int4{Int32: vaultVersion.ID, Valid: true},
+		KeyVersion:      keyVersion,
+		ExpectedVersion: currentItem.Version,
 	})
+	if err == pgx.ErrNoRows {
+		// Another write changed the item after the version check above
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"error":            "vault item changed concurrently, fetch it and retry",
+			"provided_version": req.BaseVersion,
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update vault item"})
 		return
 	}
 
+	snapshotData, err := snapshotVaultVersion(ctx, qtx, vaultVersion)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build vault snapshot"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update vault item"})
+		return
+	}
+
+	storeVaultSnapshot(ctx, h.services.ObjectStore(), vaultVersion, snapshotData)
+	publishVaultVersion(ctx, h.services, vaultVersion)
+
 	response := VaultItemResponse{
 		ID:            uuidToString(updatedItem.ID),
 		VaultID:       updatedItem.VaultID,
@@ -466,6 +528,7 @@ func (h *VaultItemHandler) UpdateVaultItem(c *gin.Context) {
 		Tag:           crypto.EncodeBase64(updatedItem.Tag),
 		Meta:          updatedItem.Meta,
 		Version:       updatedItem.Version,
+		KeyVersion:    updatedItem.KeyVersion,
 		CreatedAt:     timestampToTime(updatedItem.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
 		UpdatedAt:     timestampToTime(updatedItem.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
 	}
@@ -494,73 +557,370 @@ func (h *VaultItemHandler) DeleteVaultItem(c *gin.Context) {
 		return
 	}
 
-	// Verify device signature
-	sigData, err := ExtractDeviceSignature(c)
+	// The request signature was checked by SignatureVerificationMiddleware
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required"})
+		return
+	}
+
+	queries := h.services.GetDB().GetQueries()
+
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleEditor); !ok {
+		return
+	}
+
+	pgItemID := pgtype.UUID{}
+	_ = pgItemID.Scan(itemID.String())
+
+	// Verify item exists and belongs to vault
+	item, err := queries.GetVaultItemByID(c.Request.Context(), pgItemID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "vault item not found"})
+		return
+	}
+
+	if item.VaultID != vaultID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "item does not belong to this vault"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete vault item"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
+
+	// Record the deletion as a new vault version so other devices receive the tombstone on pull
+	vaultVersion, err := createVaultVersion(ctx, qtx, vaultID, device.ID)
+	if err == errKeyRotationInProgress {
+		c.JSON(http.StatusConflict, gin.H{"error": "vault key rotation in progress"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create version"})
+		return
+	}
+
+	// Keep the final ciphertext in the item's history so a restore can bring it back
+	if err := qtx.ArchiveVaultItemRevision(ctx, pgItemID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to archive item revision"})
+		return
+	}
+
+	// Delete item
+	err = qtx.DeleteVaultItem(ctx, pgItemID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete vault item"})
+		return
+	}
+
+	_, err = qtx.CreateVaultItemTombstone(ctx, sqlc.CreateVaultItemTombstoneParams{
+		ItemID:          pgItemID,
+		VaultID:         vaultID,
+		DeletedByDevice: vaultVersion.CreatedByDevice,
+		VaultVersionID:  vaultVersion.ID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record deletion"})
+		return
+	}
+
+	snapshotData, err := snapshotVaultVersion(ctx, qtx, vaultVersion)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build vault snapshot"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete vault item"})
+		return
+	}
+
+	storeVaultSnapshot(ctx, h.services.ObjectStore(), vaultVersion, snapshotData)
+	publishVaultVersion(ctx, h.services, vaultVersion)
+
+	c.JSON(http.StatusOK, gin.H{"message": "vault item deleted successfully"})
+}
+
+// GetVaultItemRevisions lists the previous versions of a vault item (without encrypted blobs)
+// GET /api/vaults/:id/items/:item_id/revisions
+func (h *VaultItemHandler) GetVaultItemRevisions(c *gin.Context) {
+	vaultID, err := parseIntParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault_id"})
+		return
+	}
+
+	itemID, err := uuid.Parse(c.Param("item_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item_id"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
 		return
 	}
 
 	queries := h.services.GetDB().GetQueries()
 
-	// Verify vault belongs to user
-	vault, err := queries.GetVaultByID(c.Request.Context(), vaultID)
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleViewer); !ok {
+		return
+	}
+
+	// Revisions are scoped to the vault, so history of a deleted item stays listable
+	revisions, err := queries.GetVaultItemRevisions(c.Request.Context(), sqlc.GetVaultItemRevisionsParams{
+		ItemID:  pgtype.UUID{Bytes: itemID, Valid: true},
+		VaultID: vaultID,
+	})
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch item revisions"})
 		return
 	}
 
-	if vault.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized to access this vault"})
+	response := make([]VaultItemRevisionListResponse, len(revisions))
+	for i, revision := range revisions {
+		response[i] = VaultItemRevisionListResponse{
+			Version:         revision.Version,
+			KeyVersion:      revision.KeyVersion,
+			CreatedByDevice: uuidToStringPtr(revision.CreatedByDevice),
+			CreatedAt:       timestampToTime(revision.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetVaultItemRevision retrieves one previous version of a vault item
+// GET /api/vaults/:id/items/:item_id/revisions/:version
+func (h *VaultItemHandler) GetVaultItemRevision(c *gin.Context) {
+	vaultID, err := parseIntParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault_id"})
 		return
 	}
 
-	// Verify device
-	pgDeviceID := pgtype.UUID{}
-	_ = pgDeviceID.Scan(sigData.DeviceID.String())
+	itemID, err := uuid.Parse(c.Param("item_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item_id"})
+		return
+	}
 
-	device, err := queries.GetDeviceByID(c.Request.Context(), pgDeviceID)
+	version, err := parseIntParam(c.Param("version"))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "device not found"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
 		return
 	}
 
-	if device.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "device does not belong to user"})
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
 		return
 	}
 
-	// Verify signature
-	bodyBytes, _ := c.Get("raw_body")
-	bodyHash := sha256.Sum256(bodyBytes.([]byte))
-	canonicalMsg := CreateCanonicalMessage(c.Request.Method, c.Request.URL.Path, sigData.Timestamp, bodyHash[:])
+	queries := h.services.GetDB().GetQueries()
 
-	if !VerifyDeviceSignature(device.Ed25519Public, canonicalMsg, sigData.Signature) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid device signature"})
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleViewer); !ok {
 		return
 	}
 
-	pgItemID := pgtype.UUID{}
-	_ = pgItemID.Scan(itemID.String())
+	revision, err := queries.GetVaultItemRevision(c.Request.Context(), sqlc.GetVaultItemRevisionParams{
+		ItemID:  pgtype.UUID{Bytes: itemID, Valid: true},
+		VaultID: vaultID,
+		Version: version,
+	})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "revision not found"})
+		return
+	}
 
-	// Verify item exists and belongs to vault
-	item, err := queries.GetVaultItemByID(c.Request.Context(), pgItemID)
+	c.JSON(http.StatusOK, VaultItemRevisionResponse{
+		ItemID:          uuidToString(revision.ItemID),
+		VaultID:         revision.VaultID,
+		Version:         revision.Version,
+		KeyVersion:      revision.KeyVersion,
+		EncryptedBlob:   crypto.EncodeBase64(revision.EncryptedBlob),
+		IV:              crypto.EncodeBase64(revision.Iv),
+		Tag:             crypto.EncodeBase64(revision.Tag),
+		Meta:            revision.Meta,
+		CreatedByDevice: uuidToStringPtr(revision.CreatedByDevice),
+		CreatedAt:       timestampToTime(revision.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+	})
+}
+
+// RestoreVaultItemRevision makes a previous version the item's current content.
+// Like UpdateVaultItem it requires the client's base_version to match the current
+// version, and the replaced ciphertext is kept in the history. Revisions under a
+// key retired by a rotation are refused with 409.
+// POST /api/vaults/:id/items/:item_id/revisions/:version/restore
+func (h *VaultItemHandler) RestoreVaultItemRevision(c *gin.Context) {
+	vaultID, err := parseIntParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault_id"})
+		return
+	}
+
+	itemID, err := uuid.Parse(c.Param("item_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item_id"})
+		return
+	}
+
+	revisionVersion, err := parseIntParam(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req RestoreVaultItemRevisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The request signature was checked by SignatureVerificationMiddleware
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device authentication required"})
+		return
+	}
+
+	queries := h.services.GetDB().GetQueries()
+
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleEditor); !ok {
+		return
+	}
+
+	pgItemID := pgtype.UUID{Bytes: itemID, Valid: true}
+
+	ctx := c.Request.Context()
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore revision"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
+
+	// Creating the version locks the vault, so the version check below cannot race another write
+	vaultVersion, err := createVaultVersion(ctx, qtx, vaultID, device.ID)
+	if err == errKeyRotationInProgress {
+		c.JSON(http.StatusConflict, gin.H{"error": "vault key rotation in progress"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create version"})
+		return
+	}
+
+	currentItem, err := qtx.GetVaultItemByID(ctx, pgItemID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "vault item not found"})
 		return
 	}
 
-	if item.VaultID != vaultID {
+	if currentItem.VaultID != vaultID {
 		c.JSON(http.StatusForbidden, gin.H{"error": "item does not belong to this vault"})
 		return
 	}
 
-	// Delete item
-	err = queries.DeleteVaultItem(c.Request.Context(), pgItemID)
+	// Check version for optimistic concurrency control
+	if currentItem.Version != req.BaseVersion {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":            "version conflict",
+			"current_version":  currentItem.Version,
+			"provided_version": req.BaseVersion,
+		})
+		return
+	}
+
+	revision, err := qtx.GetVaultItemRevision(ctx, sqlc.GetVaultItemRevisionParams{
+		ItemID:  pgItemID,
+		VaultID: vaultID,
+		Version: revisionVersion,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete vault item"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "revision not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "vault item deleted successfully"})
+	// A revision from before a rotation is encrypted with a retired key. The
+	// client has to decrypt it and send it back as a normal update instead
+	keyVersion, err := writeKeyVersion(ctx, qtx, vaultID, revision.KeyVersion)
+	if err != nil {
+		if status := keyVersionErrorStatus(err); status != 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":       "revision is encrypted with a retired vault key, re-encrypt it with the current key and update the item",
+				"key_version": revision.KeyVersion,
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check key version"})
+		return
+	}
+
+	// Keep the ciphertext being replaced in the item's history
+	if err := qtx.ArchiveVaultItemRevision(ctx, pgItemID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to archive item revision"})
+		return
+	}
+
+	restoredItem, err := qtx.UpdateVaultItem(ctx, sqlc.UpdateVaultItemParams{
+		ID:              pgItemID,
+		EncryptedBlob:   revision.EncryptedBlob,
+		Iv:              revision.Iv,
+		Tag:             revision.Tag,
+		Meta:            revision.Meta,
+		Version:         currentItem.Version + 1,
+		VaultVersionID:  pgtype.Int4{Int32: vaultVersion.ID, Valid: true},
+		KeyVersion:      keyVersion,
+		ExpectedVersion: currentItem.Version,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore revision"})
+		return
+	}
+
+	snapshotData, err := snapshotVaultVersion(ctx, qtx, vaultVersion)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build vault snapshot"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore revision"})
+		return
+	}
+
+	storeVaultSnapshot(ctx, h.services.ObjectStore(), vaultVersion, snapshotData)
+	publishVaultVersion(ctx, h.services, vaultVersion)
+
+	response := VaultItemResponse{
+		ID:            uuidToString(restoredItem.ID),
+		VaultID:       restoredItem.VaultID,
+		ItemType:      restoredItem.ItemType,
+		EncryptedBlob: crypto.EncodeBase64(restoredItem.EncryptedBlob),
+		IV:            crypto.EncodeBase64(restoredItem.Iv),
+		Tag:           crypto.EncodeBase64(restoredItem.Tag),
+		Meta:          restoredItem.Meta,
+		Version:       restoredItem.Version,
+		KeyVersion:    restoredItem.KeyVersion,
+		CreatedAt:     timestampToTime(restoredItem.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		UpdatedAt:     timestampToTime(restoredItem.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
+	}
+
+	c.JSON(http.StatusOK, response)
 }
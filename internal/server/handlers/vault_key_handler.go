@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 
 	"yamony/internal/crypto"
 	"yamony/internal/database/sqlc"
@@ -26,7 +29,7 @@ type UploadVaultKeyRequest struct {
 	WrapTag    string          `json:"wrap_tag" binding:"required"`
 	KDFSalt    string          `json:"kdf_salt" binding:"required"`
 	KDFParams  json.RawMessage `json:"kdf_params" binding:"required"`
-	Version    int32           `json:"version"` // defaults to 1
+	Version    int32           `json:"version"` // must be 1 if set
 }
 
 // VaultKeyResponse represents a vault key
@@ -35,14 +38,22 @@ type VaultKeyResponse struct {
 	WrappedVEK string          `json:"wrapped_vek"`
 	WrapIV     string          `json:"wrap_iv"`
 	WrapTag    string          `json:"wrap_tag"`
-	KDFSalt    string          `json:"kdf_salt"`
-	KDFParams  json.RawMessage `json:"kdf_params"`
+	KDFSalt    string          `json:"kdf_salt,omitempty"`
+	KDFParams  json.RawMessage `json:"kdf_params,omitempty"`
 	Version    int32           `json:"version"`
+	ItemCount  *int64          `json:"item_count,omitempty"` // items encrypted with this version, in the versions list
 	CreatedAt  string          `json:"created_at"`
 	UpdatedAt  string          `json:"updated_at"`
+	// Set for vault members: the VEK is wrapped for them by ECDH in this sharing
+	// record instead of with the owner's password-derived key, normally for the
+	// device the session is bound to
+	SharingRecordID   *string `json:"sharing_record_id,omitempty"`
+	RecipientDeviceID *string `json:"recipient_device_id,omitempty"`
 }
 
-// UploadVaultKey uploads a wrapped VEK for a vault
+// UploadVaultKey uploads the vault's first wrapped VEK. Later key versions are
+// created by the rotation workflow, which re-encrypts items and re-wraps shares
+// before switching over
 // POST /api/vaults/:id/keys
 func (h *VaultKeyHandler) UploadVaultKey(c *gin.Context) {
 	vaultID, err := parseIntParam(c.Param("id"))
@@ -63,16 +74,9 @@ func (h *VaultKeyHandler) UploadVaultKey(c *gin.Context) {
 		return
 	}
 
-	// Verify vault belongs to user
+	// Only the owner wraps the VEK with their password-derived key
 	queries := h.services.GetDB().GetQueries()
-	vault, err := queries.GetVaultByID(c.Request.Context(), vaultID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
-		return
-	}
-
-	if vault.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized to access this vault"})
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleOwner); !ok {
 		return
 	}
 
@@ -113,27 +117,54 @@ func (h *VaultKeyHandler) UploadVaultKey(c *gin.Context) {
 		return
 	}
 
-	// Set default version if not provided
-	version := req.Version
-	if version == 0 {
-		version = 1
+	if req.Version != 0 && req.Version != 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "only the first vault key can be uploaded, rotate the key through /api/vaults/:id/rotations"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := h.services.GetDB().BeginTx(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create vault key"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
+
+	// Once the vault has a key, new versions only come from the rotation workflow
+	if _, err := qtx.LockVaultForUpdate(ctx, vaultID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create vault key"})
+		return
+	}
+	if _, err := qtx.GetVaultKeyByVaultID(ctx, vaultID); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "vault already has a key, rotate it through /api/vaults/:id/rotations"})
+		return
+	} else if err != pgx.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create vault key"})
+		return
 	}
 
 	// Create vault key
-	vaultKey, err := queries.CreateVaultKey(c.Request.Context(), sqlc.CreateVaultKeyParams{
+	vaultKey, err := qtx.CreateVaultKey(ctx, sqlc.CreateVaultKeyParams{
 		VaultID:    vaultID,
 		WrappedVek: wrappedVEK,
 		WrapIv:     wrapIV,
 		WrapTag:    wrapTag,
 		KdfSalt:    kdfSalt,
 		KdfParams:  req.KDFParams,
-		Version:    version,
+		Version:    1,
 	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create vault key"})
 		return
 	}
 
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create vault key"})
+		return
+	}
+
 	response := VaultKeyResponse{
 		VaultID:    vaultKey.VaultID,
 		WrappedVEK: crypto.EncodeBase64(vaultKey.WrappedVek),
@@ -149,9 +180,16 @@ func (h *VaultKeyHandler) UploadVaultKey(c *gin.Context) {
 	c.JSON(http.StatusCreated, response)
 }
 
-// GetVaultKey retrieves the wrapped VEK for a vault
+// GetVaultKey retrieves the wrapped VEK for a vault. Members of any role get
+// the VEK as wrapped for the signing device in their sharing record
 // GET /api/vaults/:id/keys
 func (h *VaultKeyHandler) GetVaultKey(c *gin.Context) {
+	device, ok := verifiedDevice(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "device signature required"})
+		return
+	}
+
 	vaultID, err := parseIntParam(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault_id"})
@@ -164,16 +202,9 @@ func (h *VaultKeyHandler) GetVaultKey(c *gin.Context) {
 		return
 	}
 
-	// Verify vault belongs to user
 	queries := h.services.GetDB().GetQueries()
-	vault, err := queries.GetVaultByID(c.Request.Context(), vaultID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
-		return
-	}
-
-	if vault.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized to access this vault"})
+	role, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleViewer)
+	if !ok {
 		return
 	}
 
@@ -184,6 +215,44 @@ func (h *VaultKeyHandler) GetVaultKey(c *gin.Context) {
 		return
 	}
 
+	if role != vaultRoleOwner {
+		record, err := queries.GetSharingRecordByVaultAndRecipient(c.Request.Context(), sqlc.GetSharingRecordByVaultAndRecipientParams{
+			VaultID:         vaultID,
+			RecipientUserID: userID.(int32),
+		})
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "vault key not found"})
+			return
+		}
+
+		record, keyDeviceID, err := withDeviceKey(c.Request.Context(), queries, record, device.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get share key"})
+			return
+		}
+		if len(record.WrappedKey) == 0 {
+			// The device can ask the sender to wrap the key for it
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":    "vault key is not wrapped for this device",
+				"share_id": uuidToString(record.ID),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, VaultKeyResponse{
+			VaultID:           vaultID,
+			WrappedVEK:        crypto.EncodeBase64(record.WrappedKey),
+			WrapIV:            crypto.EncodeBase64(record.WrapIv),
+			WrapTag:           crypto.EncodeBase64(record.WrapTag),
+			Version:           vaultKey.Version,
+			SharingRecordID:   uuidToStringPtr(record.ID),
+			RecipientDeviceID: uuidToStringPtr(keyDeviceID),
+			CreatedAt:         timestampToTime(record.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
+			UpdatedAt:         timestampToTime(vaultKey.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
+		})
+		return
+	}
+
 	response := VaultKeyResponse{
 		VaultID:    vaultKey.VaultID,
 		WrappedVEK: crypto.EncodeBase64(vaultKey.WrappedVek),
@@ -220,16 +289,9 @@ func (h *VaultKeyHandler) GetVaultKeyVersion(c *gin.Context) {
 		return
 	}
 
-	// Verify vault belongs to user
+	// Key history is wrapped with the owner's password-derived key
 	queries := h.services.GetDB().GetQueries()
-	vault, err := queries.GetVaultByID(c.Request.Context(), vaultID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
-		return
-	}
-
-	if vault.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized to access this vault"})
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleOwner); !ok {
 		return
 	}
 
@@ -273,16 +335,9 @@ func (h *VaultKeyHandler) GetVaultKeyVersions(c *gin.Context) {
 		return
 	}
 
-	// Verify vault belongs to user
+	// Key history is wrapped with the owner's password-derived key
 	queries := h.services.GetDB().GetQueries()
-	vault, err := queries.GetVaultByID(c.Request.Context(), vaultID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
-		return
-	}
-
-	if vault.UserID != userID.(int32) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized to access this vault"})
+	if _, ok := requireVaultRole(c, queries, vaultID, userID.(int32), vaultRoleOwner); !ok {
 		return
 	}
 
@@ -293,8 +348,20 @@ func (h *VaultKeyHandler) GetVaultKeyVersions(c *gin.Context) {
 		return
 	}
 
+	// Count items per key version so clients can tell when an old key is unused
+	counts, err := queries.CountVaultItemsByKeyVersion(c.Request.Context(), vaultID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count items by key version"})
+		return
+	}
+	itemCounts := make(map[int32]int64, len(counts))
+	for _, count := range counts {
+		itemCounts[count.KeyVersion] = count.ItemCount
+	}
+
 	response := make([]VaultKeyResponse, len(vaultKeys))
 	for i, vaultKey := range vaultKeys {
+		itemCount := itemCounts[vaultKey.Version]
 		response[i] = VaultKeyResponse{
 			VaultID:    vaultKey.VaultID,
 			WrappedVEK: crypto.EncodeBase64(vaultKey.WrappedVek),
@@ -303,6 +370,7 @@ func (h *VaultKeyHandler) GetVaultKeyVersions(c *gin.Context) {
 			KDFSalt:    crypto.EncodeBase64(vaultKey.KdfSalt),
 			KDFParams:  vaultKey.KdfParams,
 			Version:    vaultKey.Version,
+			ItemCount:  &itemCount,
 			CreatedAt:  timestampToTime(vaultKey.CreatedAt).Format("2006-01-02T15:04:05Z07:00"),
 			UpdatedAt:  timestampToTime(vaultKey.UpdatedAt).Format("2006-01-02T15:04:05Z07:00"),
 		}
@@ -310,3 +378,55 @@ func (h *VaultKeyHandler) GetVaultKeyVersions(c *gin.Context) {
 
 	c.JSON(http.StatusOK, response)
 }
+
+var (
+	// errUnknownKeyVersion is returned for a key version the vault does not have
+	errUnknownKeyVersion = errors.New("key_version does not exist for this vault")
+	// errKeyVersionRequired is returned by writeKeyVersion when a write does not
+	// say which key encrypted it
+	errKeyVersionRequired = errors.New("key_version is required")
+	// errStaleKeyVersion is returned by writeKeyVersion for a key retired by a
+	// finalized rotation
+	errStaleKeyVersion = errors.New("key_version is older than the vault's current key, fetch the current key and re-encrypt")
+)
+
+// writeKeyVersion checks the key version a write claims its blob is encrypted
+// with. Only the vault's current key is accepted, so nothing new is stored under
+// a key a finalized rotation has retired. A vault whose first key has not been
+// uploaded yet accepts version 1.
+func writeKeyVersion(ctx context.Context, qtx *sqlc.Queries, vaultID int32, keyVersion int32) (int32, error) {
+	if keyVersion == 0 {
+		return 0, errKeyVersionRequired
+	}
+
+	current, err := qtx.GetVaultKeyByVaultID(ctx, vaultID)
+	if err == pgx.ErrNoRows {
+		if keyVersion != 1 {
+			return 0, errUnknownKeyVersion
+		}
+		return keyVersion, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if keyVersion < current.Version {
+		return 0, errStaleKeyVersion
+	}
+	if keyVersion > current.Version {
+		return 0, errUnknownKeyVersion
+	}
+	return keyVersion, nil
+}
+
+// keyVersionErrorStatus maps a writeKeyVersion error to its HTTP status, or
+// returns 0 for errors that are not the client's fault
+func keyVersionErrorStatus(err error) int {
+	switch err {
+	case errKeyVersionRequired, errUnknownKeyVersion:
+		return http.StatusBadRequest
+	case errStaleKeyVersion:
+		return http.StatusConflict
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+
+	"yamony/internal/database/sqlc"
+)
+
+// Vault roles, from least to most privileged. Members hold one of viewer,
+// editor or manager through an accepted vault share; the owner is implicit.
+const (
+	vaultRoleViewer  = "viewer"  // pull, read items, history and their wrapped key
+	vaultRoleEditor  = "editor"  // viewer, plus write items and restore versions
+	vaultRoleManager = "manager" // editor, plus share the vault with viewers and editors
+	vaultRoleOwner   = "owner"   // everything, including roles, key rotation and deletion
+)
+
+var vaultRoleRanks = map[string]int{
+	vaultRoleViewer:  1,
+	vaultRoleEditor:  2,
+	vaultRoleManager: 3,
+	vaultRoleOwner:   4,
+}
+
+// validMemberRole reports whether role can be granted through a share
+func validMemberRole(role string) bool {
+	return role == vaultRoleViewer || role == vaultRoleEditor || role == vaultRoleManager
+}
+
+// vaultRoleAtLeast reports whether role grants everything min does
+func vaultRoleAtLeast(role, min string) bool {
+	rank, ok := vaultRoleRanks[role]
+	return ok && rank >= vaultRoleRanks[min]
+}
+
+// requireVaultRole looks up the user's role in the vault and writes the error
+// response when it is below min
+func requireVaultRole(c *gin.Context, queries *sqlc.Queries, vaultID, userID int32, min string) (string, bool) {
+	accessLevel, err := queries.CheckUserVaultAccess(c.Request.Context(), sqlc.CheckUserVaultAccessParams{
+		ID:     vaultID,
+		UserID: userID,
+	})
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
+		return "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check vault access"})
+		return "", false
+	}
+
+	role, _ := accessLevel.(string)
+	if role == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "no access to this vault"})
+		return "", false
+	}
+	if !vaultRoleAtLeast(role, min) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "this action requires the " + min + " role", "role": role})
+		return "", false
+	}
+
+	return role, true
+}
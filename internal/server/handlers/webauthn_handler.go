@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"yamony/internal/crypto"
+	"yamony/internal/database/sqlc"
+	"yamony/internal/server/middleware"
+	"yamony/internal/server/services"
+)
+
+// webauthnTimeout is how long the browser is told to wait for the authenticator, in milliseconds
+const webauthnTimeout = 5 * 60 * 1000
+
+// webauthnMaxNameLength bounds the label users give a passkey
+const webauthnMaxNameLength = 64
+
+// WebAuthnHandler registers passkeys and security keys and logs in with them,
+// both passwordless and as the second factor of a pending login
+type WebAuthnHandler struct {
+	services services.Service
+}
+
+func NewWebAuthnHandler(services services.Service) *WebAuthnHandler {
+	return &WebAuthnHandler{services: services}
+}
+
+// WebAuthnCredentialDescriptor names a credential in creation and request options
+type WebAuthnCredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// BeginPasskeyRegistrationRequest re-authenticates before a passkey is added,
+// with the password or a second factor
+type BeginPasskeyRegistrationRequest struct {
+	TwoFactorCodeRequest
+	SrpProof *SrpProof `json:"srp_proof"`
+}
+
+// DeletePasskeyRequest re-authenticates with the password, so a stolen session
+// cannot strip the account of a second factor
+type DeletePasskeyRequest struct {
+	SrpProof *SrpProof `json:"srp_proof" binding:"required"`
+}
+
+// FinishPasskeyRegistrationRequest carries the response to navigator.credentials.create().
+// Binary fields are base64url encoded as in the WebAuthn JSON serialisation
+type FinishPasskeyRegistrationRequest struct {
+	ChallengeID       string `json:"challenge_id" binding:"required"`
+	Name              string `json:"name" binding:"required"`
+	ClientDataJSON    string `json:"client_data_json" binding:"required"`
+	AttestationObject string `json:"attestation_object" binding:"required"`
+}
+
+// PasskeyLoginOptionsRequest opens a login ceremony. MFAToken is set, or held in
+// the cookie session, when the passkey is the second factor of a pending login
+type PasskeyLoginOptionsRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+// PasskeyLoginRequest carries the response to navigator.credentials.get()
+type PasskeyLoginRequest struct {
+	ChallengeID       string `json:"challenge_id" binding:"required"`
+	CredentialID      string `json:"credential_id" binding:"required"`
+	ClientDataJSON    string `json:"client_data_json" binding:"required"`
+	AuthenticatorData string `json:"authenticator_data" binding:"required"`
+	Signature         string `json:"signature" binding:"required"`
+	UserHandle        string `json:"user_handle"`
+	MFAToken          string `json:"mfa_token"`
+}
+
+// PasskeyResponse describes a registered credential without its public key
+type PasskeyResponse struct {
+	ID           int32      `json:"id"`
+	Name         string     `json:"name"`
+	CredentialID string     `json:"credential_id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+// BeginRegistration returns creation options for navigator.credentials.create().
+// A passkey can log in without the password, so adding one needs the password
+// or a second factor again
+// POST /api/devices/passkeys/options
+func (h *WebAuthnHandler) BeginRegistration(c *gin.Context) {
+	userValue, exists := c.Get(middleware.UserKey)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+	user := userValue.(*sqlc.GetUserByIDRow)
+
+	var req BeginPasskeyRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case req.SrpProof != nil:
+		if !requireSrpProof(c, h.services, user.ID, req.SrpProof) {
+			return
+		}
+	case req.Code != "" || req.RecoveryCode != "":
+		if err := h.services.VerifySecondFactor(c.Request.Context(), user.ID, req.Code, req.RecoveryCode); err != nil {
+			respondTwoFactorError(c, err, "failed to verify two-factor code")
+			return
+		}
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "srp_proof, code or recovery_code is required", "code": "password_required"})
+		return
+	}
+
+	ceremony, err := h.services.BeginWebAuthnRegistration(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start passkey registration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"challenge_id": uuidToString(ceremony.ChallengeID),
+		"public_key": gin.H{
+			"rp": gin.H{"id": ceremony.RPID, "name": "Yamony"},
+			"user": gin.H{
+				"id":          encodeWebAuthnBytes(ceremony.UserHandle),
+				"name":        user.Email,
+				"displayName": user.Username,
+			},
+			"challenge": encodeWebAuthnBytes(ceremony.Challenge),
+			"pubKeyCredParams": []gin.H{
+				{"type": "public-key", "alg": crypto.COSEAlgES256},
+				{"type": "public-key", "alg": crypto.COSEAlgEdDSA},
+				{"type": "public-key", "alg": crypto.COSEAlgRS256},
+			},
+			"timeout":     webauthnTimeout,
+			"attestation": "none",
+			"authenticatorSelection": gin.H{
+				"residentKey":      "preferred",
+				"userVerification": "preferred",
+			},
+			"excludeCredentials": webauthnDescriptors(ceremony.CredentialIDs),
+		},
+	})
+}
+
+// FinishRegistration verifies the authenticator's response and stores the passkey
+// POST /api/devices/passkeys
+func (h *WebAuthnHandler) FinishRegistration(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req FinishPasskeyRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > webauthnMaxNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("name must be 1 to %d characters", webauthnMaxNameLength)})
+		return
+	}
+
+	challengeID, ok := parseWebAuthnChallengeID(c, req.ChallengeID)
+	if !ok {
+		return
+	}
+
+	clientDataJSON, err := decodeWebAuthnBytes(req.ClientDataJSON)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid client_data_json format"})
+		return
+	}
+
+	attestationObject, err := decodeWebAuthnBytes(req.AttestationObject)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid attestation_object format"})
+		return
+	}
+
+	credential, err := h.services.FinishWebAuthnRegistration(c.Request.Context(), userID.(int32), challengeID, name, clientDataJSON, attestationObject)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrWebAuthnFailed):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err == services.ErrWebAuthnCredentialExists:
+			c.JSON(http.StatusConflict, gin.H{"error": "passkey is already registered"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register passkey"})
+		}
+		return
+	}
+
+	h.services.PublishUserEvent(c.Request.Context(), userID.(int32), services.EventTwoFactorChanged, map[string]interface{}{
+		"passkey_id": credential.ID,
+	})
+
+	rotateSessionToken(c, h.services)
+
+	c.JSON(http.StatusCreated, passkeyResponse(*credential))
+}
+
+// GetPasskeys lists the user's registered passkeys and security keys
+// GET /api/devices/passkeys
+func (h *WebAuthnHandler) GetPasskeys(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	credentials, err := h.services.GetDB().GetQueries().ListWebauthnCredentials(c.Request.Context(), userID.(int32))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch passkeys"})
+		return
+	}
+
+	response := make([]PasskeyResponse, len(credentials))
+	for i, credential := range credentials {
+		response[i] = passkeyResponse(credential)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DeletePasskey removes a passkey after a password proof. Users taking part in
+// shares cannot remove their last second factor
+// DELETE /api/devices/passkeys/:id
+func (h *WebAuthnHandler) DeletePasskey(c *gin.Context) {
+	credentialID, err := parseIntParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid passkey id"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req DeletePasskeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !requireSrpProof(c, h.services, userID.(int32), req.SrpProof) {
+		return
+	}
+
+	if err := h.services.DeleteWebAuthnCredential(c.Request.Context(), userID.(int32), credentialID); err != nil {
+		switch err {
+		case services.ErrWebAuthnCredentialNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "passkey not found"})
+		case services.ErrMFARequiredForShares:
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is required while you share vaults or items"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete passkey"})
+		}
+		return
+	}
+
+	h.services.PublishUserEvent(c.Request.Context(), userID.(int32), services.EventTwoFactorChanged, map[string]interface{}{
+		"passkey_id": credentialID,
+		"removed":    true,
+	})
+
+	rotateSessionToken(c, h.services)
+
+	c.JSON(http.StatusOK, gin.H{"message": "passkey deleted"})
+}
+
+// BeginLogin returns request options for navigator.credentials.get(). Without a
+// pending login any discoverable passkey may answer and must verify the user
+// POST /api/login/passkey/options
+func (h *WebAuthnHandler) BeginLogin(c *gin.Context) {
+	// The body is optional for a passwordless login
+	var req PasskeyLoginOptionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mfaToken := pendingMFAToken(c, req.MFAToken)
+	ceremony, err := h.services.BeginWebAuthnLogin(c.Request.Context(), mfaToken)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidCredentials:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login expired, sign in again"})
+		case services.ErrMFANotEnabled:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no passkeys registered for this account"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start passkey login"})
+		}
+		return
+	}
+
+	userVerification := "required"
+	if mfaToken != "" {
+		userVerification = "preferred"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"challenge_id": uuidToString(ceremony.ChallengeID),
+		"public_key": gin.H{
+			"challenge":        encodeWebAuthnBytes(ceremony.Challenge),
+			"rpId":             ceremony.RPID,
+			"timeout":          webauthnTimeout,
+			"userVerification": userVerification,
+			"allowCredentials": webauthnDescriptors(ceremony.CredentialIDs),
+		},
+	})
+}
+
+// FinishLogin verifies a passkey assertion. It completes the pending login when
+// there is one and is a passwordless login otherwise
+// POST /api/login/passkey
+func (h *WebAuthnHandler) FinishLogin(c *gin.Context) {
+	var req PasskeyLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	challengeID, ok := parseWebAuthnChallengeID(c, req.ChallengeID)
+	if !ok {
+		return
+	}
+
+	credentialID, assertion, ok := decodeWebAuthnAssertion(c, req)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	mfaToken := pendingMFAToken(c, req.MFAToken)
+
+	var (
+		user         *sqlc.GetUserByIDRow
+		sessionToken string
+		activePageID int32
+		err          error
+	)
+	if mfaToken != "" {
+		user, sessionToken, activePageID, err = h.services.VerifyMFAWebAuthn(ctx, mfaToken, challengeID, credentialID, assertion)
+	} else {
+		user, sessionToken, activePageID, err = h.services.FinishWebAuthnLogin(ctx, challengeID, credentialID, assertion)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrWebAuthnFailed):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "passkey verification failed"})
+		case err == services.ErrInvalidCredentials:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login expired, sign in again"})
+		default:
+			fmt.Println("Passkey login error ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to login"})
+		}
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Delete(mfaTokenKey)
+	session.Set(middleware.SessionTokenKey, sessionToken)
+
+	if activePageID > 0 {
+		session.Set(middleware.ActivePageID, activePageID)
+	}
+
+	if err := session.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "login successful",
+		"user": UserResponse{
+			ID:            user.ID,
+			Username:      user.Username,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerified,
+			Image:         user.Image,
+		},
+	})
+}
+
+func passkeyResponse(credential sqlc.WebauthnCredential) PasskeyResponse {
+	return PasskeyResponse{
+		ID:           credential.ID,
+		Name:         credential.Name,
+		CredentialID: encodeWebAuthnBytes(credential.CredentialID),
+		CreatedAt:    timestampToTime(credential.CreatedAt),
+		LastUsedAt:   timestampToTimePtr(credential.LastUsedAt),
+	}
+}
+
+func webauthnDescriptors(credentialIDs [][]byte) []WebAuthnCredentialDescriptor {
+	descriptors := make([]WebAuthnCredentialDescriptor, len(credentialIDs))
+	for i, id := range credentialIDs {
+		descriptors[i] = WebAuthnCredentialDescriptor{Type: "public-key", ID: encodeWebAuthnBytes(id)}
+	}
+	return descriptors
+}
+
+func parseWebAuthnChallengeID(c *gin.Context, value string) (pgtype.UUID, bool) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid challenge_id"})
+		return pgtype.UUID{}, false
+	}
+
+	pgID := pgtype.UUID{}
+	_ = pgID.Scan(id.String())
+	return pgID, true
+}
+
+// decodeWebAuthnAssertion decodes the base64url fields of a get() response
+func decodeWebAuthnAssertion(c *gin.Context, req PasskeyLoginRequest) ([]byte, crypto.WebAuthnAssertion, bool) {
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"credential_id", req.CredentialID},
+		{"client_data_json", req.ClientDataJSON},
+		{"authenticator_data", req.AuthenticatorData},
+		{"signature", req.Signature},
+	}
+
+	decoded := make([][]byte, len(fields))
+	for i, field := range fields {
+		value, err := decodeWebAuthnBytes(field.value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s format", field.name)})
+			return nil, crypto.WebAuthnAssertion{}, false
+		}
+		decoded[i] = value
+	}
+
+	// Only discoverable credentials return a user handle
+	var userHandle []byte
+	if req.UserHandle != "" {
+		value, err := decodeWebAuthnBytes(req.UserHandle)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_handle format"})
+			return nil, crypto.WebAuthnAssertion{}, false
+		}
+		userHandle = value
+	}
+
+	return decoded[0], crypto.WebAuthnAssertion{
+		ClientDataJSON:    decoded[1],
+		AuthenticatorData: decoded[2],
+		Signature:         decoded[3],
+		UserHandle:        userHandle,
+	}, true
+}
+
+// encodeWebAuthnBytes encodes binary fields as unpadded base64url, as browsers expect
+func encodeWebAuthnBytes(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeWebAuthnBytes decodes base64url with or without padding
+func decodeWebAuthnBytes(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runPeriodically calls fn every interval in the background for the lifetime of the process
+func runPeriodically(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if err := fn(ctx); err != nil {
+				log.Printf("background job %s failed: %v", name, err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// runForever keeps a long-running fn alive in the background, restarting it
+// after retryDelay whenever it returns
+func runForever(name string, retryDelay time.Duration, fn func(ctx context.Context) error) {
+	go func() {
+		for {
+			if err := fn(context.Background()); err != nil {
+				log.Printf("background job %s stopped: %v", name, err)
+			}
+			time.Sleep(retryDelay)
+		}
+	}()
+}
+
+// startBackgroundJobs schedules the periodic maintenance tasks
+func (s *Server) startBackgroundJobs() {
+	runPeriodically("purge-tombstones", time.Hour, s.services.PurgeExpiredTombstones)
+	runPeriodically("purge-nonces", time.Minute, s.services.PurgeExpiredNonces)
+	runPeriodically("purge-sessions", time.Hour, s.services.PurgeExpiredSessions)
+	runPeriodically("purge-device-challenges", time.Hour, s.services.PurgeExpiredDeviceChallenges)
+	runPeriodically("purge-srp-handshakes", time.Hour, s.services.PurgeExpiredSrpHandshakes)
+	runPeriodically("purge-mfa-challenges", time.Hour, s.services.PurgeExpiredMfaChallenges)
+	runPeriodically("purge-webauthn-challenges", time.Hour, s.services.PurgeExpiredWebauthnChallenges)
+	runPeriodically("purge-share-invitations", time.Hour, s.services.PurgeExpiredShareInvitations)
+	runPeriodically("purge-email-verifications", time.Hour, s.services.PurgeExpiredEmailVerifications)
+	runPeriodically("expire-shares", time.Minute, s.services.ExpireShares)
+	runForever("listen-events", 5*time.Second, s.services.ListenForEvents)
+}
@@ -42,6 +42,7 @@ func AuthMiddleware(service services.Service) gin.HandlerFunc {
 
 		c.Set("user_id", user.ID)
 		c.Set(UserKey, user)
+		c.Set(SessionTokenKey, sessionToken.(string))
 		c.Next()
 	}
 }
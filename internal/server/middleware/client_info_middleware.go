@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"yamony/internal/server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientInfoMiddleware records the user agent and IP address of each request
+// in its context, so sessions started by the request can be told apart later
+func ClientInfoMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := services.WithClientInfo(c.Request.Context(), services.ClientInfo{
+			UserAgent: c.Request.UserAgent(),
+			IPAddress: c.ClientIP(),
+		})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"log"
 	"net/http"
+	"os"
+	"strings"
 
 	"yamony/internal/server/handlers"
 	"yamony/internal/server/middleware"
@@ -15,11 +18,16 @@ import (
 func (s *Server) RegisterRoutes() http.Handler {
 	r := gin.Default()
 
+	// Sessions record c.ClientIP(), which must not come from a header any client can set
+	if err := r.SetTrustedProxies(trustedProxiesFromEnv()); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// CORS middleware
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3001", "https://yamony.com"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowHeaders:     []string{"Accept", "Authorization", "Content-Type", "X-Device-Id", "X-Device-Signature", "X-Device-Timestamp"},
+		AllowHeaders:     []string{"Accept", "Authorization", "Content-Type", "X-Device-Id", "X-Device-Signature", "X-Device-Timestamp", "X-Device-Nonce"},
 		AllowCredentials: true,
 	}))
 
@@ -27,23 +35,37 @@ func (s *Server) RegisterRoutes() http.Handler {
 	store := cookie.NewStore(sessionSecret)
 	store.Options(sessions.Options{
 		Path:     "/",
-		MaxAge:   86400 * 7,
+		MaxAge:   86400 * 30, // the server expires sessions sooner when they go unused
 		HttpOnly: true,
 		Secure:   false, // Set to true in production with HTTPS
 		SameSite: http.SameSiteLaxMode,
 	})
 	r.Use(sessions.Sessions("yamony_session", store))
+	r.Use(middleware.ClientInfoMiddleware())
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(s.services)
+	kdfHandler := handlers.NewKDFHandler(s.services)
+	twoFactorHandler := handlers.NewTwoFactorHandler(s.services)
+	webAuthnHandler := handlers.NewWebAuthnHandler(s.services)
+	sessionHandler := handlers.NewSessionHandler(s.services)
 	deviceHandler := handlers.NewDeviceHandler(s.services)
 	vaultHandler := handlers.NewVaultHandler(s.services)
 	vaultKeyHandler := handlers.NewVaultKeyHandler(s.services)
+	keyRotationHandler := handlers.NewKeyRotationHandler(s.services)
 	vaultItemHandler := handlers.NewVaultItemHandler(s.services)
 	shareHandler := handlers.NewShareHandler(s.services)
+	shareKeyHandler := handlers.NewShareKeyHandler(s.services)
+	shareInvitationHandler := handlers.NewShareInvitationHandler(s.services)
 	syncHandler := handlers.NewSyncHandler(s.services)
+	eventHandler := handlers.NewEventHandler(s.services)
 	uploadHandler := handlers.NewUploadHandler()
 
+	// Mutating vault routes must be signed by one of the user's devices
+	signed := handlers.SignatureVerificationMiddleware(s.services)
+	// Reads that hand out wrapped keys are signed too
+	signedRead := handlers.DeviceSignatureMiddleware(s.services)
+
 	r.GET("/", s.HelloWorldHandler)
 	r.GET("/health", s.healthHandler)
 
@@ -51,7 +73,14 @@ func (s *Server) RegisterRoutes() http.Handler {
 	{
 		auth.POST("/register", authHandler.Register)
 		auth.POST("/login", authHandler.Login)
+		auth.POST("/login/srp/init", authHandler.SrpLoginInit)
+		auth.POST("/login/srp/verify", authHandler.SrpLoginVerify)
+		auth.POST("/login/mfa", authHandler.VerifyMFALogin)
+		auth.POST("/login/passkey/options", webAuthnHandler.BeginLogin)
+		auth.POST("/login/passkey", webAuthnHandler.FinishLogin)
 		auth.POST("/logout", authHandler.Logout)
+		auth.POST("/auth/verify-email", authHandler.VerifyEmail)
+		auth.GET("/auth/kdf-params", kdfHandler.GetKDFParams)
 
 		// Google OAuth routes
 		auth.GET("/auth/google", authHandler.GoogleLogin)
@@ -63,47 +92,98 @@ func (s *Server) RegisterRoutes() http.Handler {
 	protected.Use(middleware.AuthMiddleware(s.services))
 	{
 		protected.GET("/me", authHandler.Me)
-		protected.GET("/sessions", authHandler.GetSessionByUserID)
+		protected.PUT("/me/kdf-params", signed, kdfHandler.UpgradeKDFParams)
+		protected.POST("/me/srp", authHandler.UpgradeToSrp)
+		protected.POST("/me/email/verification", authHandler.ResendEmailVerification)
+
+		// Session routes
+		protected.GET("/sessions", sessionHandler.GetSessions)
+		protected.DELETE("/sessions/:id", sessionHandler.RevokeSession)
+		protected.POST("/sessions/revoke-others", sessionHandler.RevokeOtherSessions)
+
+		// Two-factor authentication routes
+		protected.GET("/2fa", twoFactorHandler.GetStatus)
+		protected.POST("/2fa/totp", twoFactorHandler.EnrollTOTP)
+		protected.POST("/2fa/totp/confirm", twoFactorHandler.ConfirmTOTP)
+		protected.POST("/2fa/disable", twoFactorHandler.DisableTwoFactor)
+		protected.POST("/2fa/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes)
 
 		// Device routes
 		protected.POST("/devices/register", deviceHandler.RegisterDevice)
 		protected.POST("/devices/verify", deviceHandler.VerifyDevice)
 		protected.GET("/devices", deviceHandler.GetDevices)
-		protected.DELETE("/devices/:id", deviceHandler.RevokeDevice)
+		protected.DELETE("/devices/:id", signed, deviceHandler.RevokeDevice)
+		protected.POST("/devices/:id/approve", signed, deviceHandler.ApproveDevice)
+		protected.POST("/devices/:id/recover", deviceHandler.RecoverDevice)
+		protected.POST("/devices/recovery-code", signed, deviceHandler.RegenerateRecoveryCode)
 		protected.GET("/users/:user_id/public-keys", deviceHandler.GetUserPublicKeys)
 
+		// Passkey routes, listed next to devices
+		protected.GET("/devices/passkeys", webAuthnHandler.GetPasskeys)
+		protected.POST("/devices/passkeys/options", signed, webAuthnHandler.BeginRegistration)
+		protected.POST("/devices/passkeys", signed, webAuthnHandler.FinishRegistration)
+		protected.DELETE("/devices/passkeys/:id", signed, webAuthnHandler.DeletePasskey)
+
 		// Vault routes
-		protected.POST("/vaults", vaultHandler.CreateVault)
+		protected.POST("/vaults", signed, vaultHandler.CreateVault)
 		protected.GET("/vaults", vaultHandler.GetVaults)
 		protected.GET("/vaults/:id", vaultHandler.GetVault)
-		protected.PUT("/vaults/:id", vaultHandler.UpdateVault)
-		protected.DELETE("/vaults/:id", vaultHandler.DeleteVault)
+		protected.PUT("/vaults/:id", signed, vaultHandler.UpdateVault)
+		protected.DELETE("/vaults/:id", signed, vaultHandler.DeleteVault)
 
 		// Vault key routes
-		protected.POST("/vaults/:id/keys", vaultKeyHandler.UploadVaultKey)
-		protected.GET("/vaults/:id/keys", vaultKeyHandler.GetVaultKey)
+		protected.POST("/vaults/:id/keys", signed, vaultKeyHandler.UploadVaultKey)
+		protected.GET("/vaults/:id/keys", signedRead, vaultKeyHandler.GetVaultKey)
 		protected.GET("/vaults/:id/keys/versions", vaultKeyHandler.GetVaultKeyVersions)
 		protected.GET("/vaults/:id/keys/versions/:version", vaultKeyHandler.GetVaultKeyVersion)
 
+		// Key rotation routes
+		protected.POST("/vaults/:id/rotations", signed, keyRotationHandler.StartKeyRotation)
+		protected.GET("/vaults/:id/rotations/:rotation_id", keyRotationHandler.GetKeyRotation)
+		protected.POST("/vaults/:id/rotations/:rotation_id/items", signed, keyRotationHandler.UploadRotationItems)
+		protected.POST("/vaults/:id/rotations/:rotation_id/shares", signed, keyRotationHandler.UploadRotationShares)
+		protected.POST("/vaults/:id/rotations/:rotation_id/finalize", signed, keyRotationHandler.FinalizeKeyRotation)
+		protected.DELETE("/vaults/:id/rotations/:rotation_id", signed, keyRotationHandler.AbortKeyRotation)
+
 		// Vault item routes
-		protected.POST("/vaults/:id/items", vaultItemHandler.CreateVaultItem)
+		protected.POST("/vaults/:id/items", signed, vaultItemHandler.CreateVaultItem)
 		protected.GET("/vaults/:id/items", vaultItemHandler.GetVaultItems)
 		protected.GET("/vaults/:id/items/:item_id", vaultItemHandler.GetVaultItem)
-		protected.PUT("/vaults/:id/items/:item_id", vaultItemHandler.UpdateVaultItem)
-		protected.DELETE("/vaults/:id/items/:item_id", vaultItemHandler.DeleteVaultItem)
+		protected.PUT("/vaults/:id/items/:item_id", signed, vaultItemHandler.UpdateVaultItem)
+		protected.DELETE("/vaults/:id/items/:item_id", signed, vaultItemHandler.DeleteVaultItem)
+		protected.GET("/vaults/:id/items/:item_id/revisions", vaultItemHandler.GetVaultItemRevisions)
+		protected.GET("/vaults/:id/items/:item_id/revisions/:version", vaultItemHandler.GetVaultItemRevision)
+		protected.POST("/vaults/:id/items/:item_id/revisions/:version/restore", signed, vaultItemHandler.RestoreVaultItemRevision)
 
 		// Sharing routes
-		protected.POST("/vaults/:id/share", shareHandler.ShareVault)
+		protected.POST("/vaults/:id/share", signed, shareHandler.ShareVault)
+		protected.POST("/vaults/:id/share/items", signed, shareHandler.ShareItem)
 		protected.GET("/vaults/shared", shareHandler.GetSharedVaults)
 		protected.GET("/shares/pending", shareHandler.GetPendingShares)
+		protected.GET("/shares/items", shareHandler.GetSharedItems)
+		protected.GET("/shares/:id/item", shareHandler.GetSharedItem)
 		protected.POST("/shares/:id/accept", shareHandler.AcceptShare)
 		protected.POST("/shares/:id/reject", shareHandler.RejectShare)
-		protected.DELETE("/shares/:id", shareHandler.RevokeShare)
+		protected.DELETE("/shares/:id", signed, shareHandler.RevokeShare)
+		protected.PUT("/shares/:id/role", signed, shareHandler.UpdateShareRole)
+		protected.PUT("/shares/:id/expiry", signed, shareHandler.UpdateShareExpiry)
+		protected.GET("/shares/key-requests", shareKeyHandler.GetShareKeyRequests)
+		protected.POST("/shares/:id/keys", signed, shareKeyHandler.AddShareKeys)
+		protected.POST("/shares/:id/keys/request", signed, shareKeyHandler.RequestShareKey)
+		protected.POST("/vaults/:id/invitations", signed, shareInvitationHandler.CreateShareInvitation)
+		protected.GET("/vaults/:id/invitations", shareInvitationHandler.GetShareInvitations)
+		protected.POST("/invitations/:id/complete", signed, shareInvitationHandler.CompleteShareInvitation)
+		protected.DELETE("/invitations/:id", signed, shareInvitationHandler.CancelShareInvitation)
 
 		// Sync and versioning routes
 		protected.POST("/vaults/:id/sync/pull", syncHandler.PullVaultChanges)
-		protected.POST("/vaults/:id/sync/commit", syncHandler.CommitVaultChanges)
+		protected.POST("/vaults/:id/sync/commit", signed, syncHandler.CommitVaultChanges)
 		protected.GET("/vaults/:id/versions", syncHandler.GetVaultVersions)
+		protected.GET("/vaults/:id/versions/:version_id/snapshot", syncHandler.GetVaultVersionSnapshot)
+		protected.POST("/vaults/:id/versions/:version_id/restore", signed, syncHandler.RestoreVaultVersion)
+
+		// Real-time change notifications
+		protected.GET("/events", eventHandler.StreamEvents)
 
 		// Storage upload route
 		protected.POST("/storage/upload", uploadHandler.UploadToGCS)
@@ -113,6 +193,23 @@ func (s *Server) RegisterRoutes() http.Handler {
 	return r
 }
 
+// trustedProxiesFromEnv reads the comma-separated proxy IPs or CIDRs allowed to
+// set X-Forwarded-For. Unset, no proxy is trusted and the peer address is used
+func trustedProxiesFromEnv() []string {
+	value := os.Getenv("TRUSTED_PROXIES")
+	if value == "" {
+		return nil
+	}
+
+	var proxies []string
+	for _, proxy := range strings.Split(value, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
 func (s *Server) HelloWorldHandler(c *gin.Context) {
 	resp := make(map[string]string)
 	resp["message"] = "Hello World"
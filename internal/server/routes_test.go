@@ -30,3 +30,31 @@ func TestHelloWorldHandler(t *testing.T) {
 		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
 	}
 }
+
+func TestTrustedProxiesFromEnv(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	if proxies := trustedProxiesFromEnv(); proxies != nil {
+		t.Errorf("expected no trusted proxies, got %v", proxies)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", " 10.0.0.0/8, ,192.168.1.2 ")
+	proxies := trustedProxiesFromEnv()
+	if len(proxies) != 2 || proxies[0] != "10.0.0.0/8" || proxies[1] != "192.168.1.2" {
+		t.Errorf("unexpected trusted proxies: %v", proxies)
+	}
+
+	// With no trusted proxy, a spoofed X-Forwarded-For is ignored
+	r := gin.New()
+	if err := r.SetTrustedProxies(nil); err != nil {
+		t.Fatal(err)
+	}
+	r.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+	req := httptest.NewRequest("GET", "/ip", nil)
+	req.RemoteAddr = "203.0.113.9:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Body.String() != "203.0.113.9" {
+		t.Errorf("expected the peer address, got %v", rr.Body.String())
+	}
+}
@@ -33,6 +33,8 @@ func NewServer() *http.Server {
 		services: services.New(db),
 	}
 
+	NewServer.startBackgroundJobs()
+
 	// Declare Server config
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", NewServer.port),
@@ -1,14 +1,17 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
+	"yamony/internal/crypto"
 	"yamony/internal/database/sqlc"
 
 	"github.com/jackc/pgx/v5"
@@ -21,9 +24,14 @@ var (
 	ErrInvalidCredentials = errors.New("invalid email or password")
 	ErrUserNotFound       = errors.New("user not found")
 	ErrEmailAlreadyExists = errors.New("email already exists")
+	ErrSrpAlreadyEnabled  = errors.New("account already logs in with srp")
+	ErrKDFParamsMismatch  = errors.New("kdf settings do not match the account")
 )
 
-func (s *service) RegisterUser(ctx context.Context, username, email, password string) (*sqlc.CreateUserRow, string, int32, error) {
+// RegisterUser creates an account that logs in over SRP. Only the salt and
+// verifier derived on the client are stored, never the master password, along
+// with the Argon2id salt and params the client chose for its master key.
+func (s *service) RegisterUser(ctx context.Context, username, email string, srpSalt, srpVerifier, kdfSalt, kdfParams []byte) (*sqlc.CreateUserRow, string, int32, error) {
 	_, err := s.db.GetQueries().GetUserByEmail(ctx, email)
 	if err == nil {
 		return nil, "", 0, ErrEmailAlreadyExists
@@ -32,17 +40,15 @@ func (s *service) RegisterUser(ctx context.Context, username, email, password st
 		return nil, "", 0, fmt.Errorf("failed to check existing user: %w", err)
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return nil, "", 0, fmt.Errorf("failed to hash password: %w", err)
-	}
-
 	params := sqlc.CreateUserParams{
 		Username:      username,
 		Email:         email,
-		PasswordHash:  string(hashedPassword),
 		EmailVerified: false,
 		Image:         "",
+		SrpSalt:       srpSalt,
+		SrpVerifier:   srpVerifier,
+		KdfSalt:       kdfSalt,
+		KdfParams:     kdfParams,
 	}
 
 	user, err := s.db.GetQueries().CreateUser(ctx, params)
@@ -50,31 +56,22 @@ func (s *service) RegisterUser(ctx context.Context, username, email, password st
 		return nil, "", 0, fmt.Errorf("failed to create user: %w", err)
 	}
 
-	sessionToken, err := generateSessionToken()
+	sessionToken, _, err := s.createLoginSession(ctx, user.ID)
 	if err != nil {
-		return nil, "", 0, fmt.Errorf("failed to generate session token: %w", err)
-	}
-
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-
-	var expiresAtPg pgtype.Timestamp
-	expiresAtPg.Time = expiresAt
-	expiresAtPg.Valid = true
-
-	sessionParams := sqlc.CreateSessionParams{
-		UserID:       user.ID,
-		SessionToken: sessionToken,
-		ExpiresAt:    expiresAtPg,
+		return nil, "", 0, err
 	}
 
-	_, err = s.db.GetQueries().CreateSession(ctx, sessionParams)
-	if err != nil {
-		return nil, "", 0, fmt.Errorf("failed to create session: %w", err)
+	// The account works without it; the link can be sent again from /api/me/email/verification
+	if err := s.SendEmailVerification(ctx, user.ID); err != nil {
+		log.Printf("failed to send email verification for user %d: %v", user.ID, err)
 	}
 
 	return &user, sessionToken, 0, nil
 }
 
+// LoginUser checks the bcrypt password of an account created before SRP login.
+// Accounts that have an SRP verifier never accept it, and the client is
+// expected to move the account over with UpgradeToSrp right after
 func (s *service) LoginUser(ctx context.Context, email, password string) (*sqlc.GetUserByEmailRow, string, int32, error) {
 	user, err := s.db.GetQueries().GetUserByEmail(ctx, email)
 	if err != nil {
@@ -84,37 +81,132 @@ func (s *service) LoginUser(ctx context.Context, email, password string) (*sqlc.
 		return nil, "", 0, fmt.Errorf("failed to get user: %w", err)
 	}
 
+	credentials, err := s.db.GetQueries().GetUserSrpCredentials(ctx, email)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("failed to get user: %w", err)
+	}
+	if len(credentials.SrpVerifier) > 0 || user.PasswordHash == "" {
+		return nil, "", 0, ErrInvalidCredentials
+	}
+
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	if err != nil {
 		return nil, "", 0, ErrInvalidCredentials
 	}
 
+	if err := s.requireSecondFactor(ctx, user.ID); err != nil {
+		return &user, "", 0, err
+	}
+
+	sessionToken, activePageID, err := s.createLoginSession(ctx, user.ID)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return &user, sessionToken, activePageID, nil
+}
+
+// UpgradeToSrp moves a password account to SRP login. The password is checked
+// once more, and the bcrypt hash is cleared in the same write that stores the
+// verifier. KDF settings the account already has must be kept, since its vault
+// keys are wrapped with them
+func (s *service) UpgradeToSrp(ctx context.Context, userID int32, password string, srpSalt, srpVerifier, kdfSalt, kdfParams []byte) error {
+	queries := s.db.GetQueries()
+
+	user, err := queries.GetUserByID(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	account, err := queries.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	credentials, err := queries.GetUserSrpCredentials(ctx, user.Email)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if len(credentials.SrpVerifier) > 0 || account.PasswordHash == "" {
+		return ErrSrpAlreadyEnabled
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)); err != nil {
+		return ErrInvalidCredentials
+	}
+
+	if len(credentials.KdfSalt) > 0 {
+		if !bytes.Equal(credentials.KdfSalt, kdfSalt) || !sameKDFParams(credentials.KdfParams, kdfParams) {
+			return ErrKDFParamsMismatch
+		}
+	}
+
+	upgraded, err := queries.UpgradeUserToSrp(ctx, sqlc.UpgradeUserToSrpParams{
+		ID:          userID,
+		KdfSalt:     kdfSalt,
+		KdfParams:   kdfParams,
+		SrpSalt:     srpSalt,
+		SrpVerifier: srpVerifier,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upgrade credentials: %w", err)
+	}
+	if upgraded == 0 {
+		return ErrSrpAlreadyEnabled
+	}
+
+	return nil
+}
+
+// sameKDFParams compares two encoded KDF settings by value, since the stored
+// JSONB does not keep the client's formatting
+func sameKDFParams(stored, requested []byte) bool {
+	var a, b crypto.KDFParams
+	if err := json.Unmarshal(stored, &a); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(requested, &b); err != nil {
+		return false
+	}
+	return a == b
+}
+
+// createLoginSession starts a session for a user who just authenticated and
+// restores their most recently used page. The client the request came from
+// is recorded on the session
+func (s *service) createLoginSession(ctx context.Context, userID int32) (string, int32, error) {
 	sessionToken, err := generateSessionToken()
 	if err != nil {
-		return nil, "", 0, fmt.Errorf("failed to generate session token: %w", err)
+		return "", 0, fmt.Errorf("failed to generate session token: %w", err)
 	}
 
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
+	now := time.Now()
+	expiresAt := sessionExpiry(now, now)
 
 	var expiresAtPg pgtype.Timestamp
 	expiresAtPg.Time = expiresAt
 	expiresAtPg.Valid = true
 
 	var activePageID int32
-	recentPage, err := s.db.GetQueries().GetUserMostRecentPage(ctx, user.ID)
+	recentPage, err := s.db.GetQueries().GetUserMostRecentPage(ctx, userID)
 	if err == nil {
 		activePageID = recentPage.ID
 	}
 
+	client := clientInfoFromContext(ctx)
 	sessionParams := sqlc.CreateSessionParams{
-		UserID:       user.ID,
+		UserID:       userID,
 		SessionToken: sessionToken,
 		ExpiresAt:    expiresAtPg,
+		UserAgent:    client.UserAgent,
+		IpAddress:    client.IPAddress,
 	}
 
 	session, err := s.db.GetQueries().CreateSession(ctx, sessionParams)
 	if err != nil {
-		return nil, "", 0, fmt.Errorf("failed to create session: %w", err)
+		return "", 0, fmt.Errorf("failed to create session: %w", err)
 	}
 
 	if activePageID > 0 {
@@ -131,9 +223,12 @@ func (s *service) LoginUser(ctx context.Context, email, password string) (*sqlc.
 		}
 	}
 
-	return &user, sessionToken, activePageID, nil
+	return sessionToken, activePageID, nil
 }
 
+// ValidateSession resolves a session token to its user. Sessions expire after
+// sessionIdleTimeout without use and sessionMaxAge after login; each use
+// slides the idle expiry forward
 func (s *service) ValidateSession(ctx context.Context, sessionToken string) (*sqlc.GetUserByIDRow, error) {
 	session, err := s.db.GetQueries().GetSessionByToken(ctx, sessionToken)
 	if err != nil {
@@ -143,9 +238,14 @@ func (s *service) ValidateSession(ctx context.Context, sessionToken string) (*sq
 		return nil, fmt.Errorf("failed to get session: %w", err)
 	}
 
-	if session.ExpiresAt.Valid && time.Now().After(session.ExpiresAt.Time) {
+	now := time.Now()
+	if sessionExpired(session, now) {
 		_ = s.db.GetQueries().DeleteSession(ctx, session.ID)
-		return nil, errors.New("session expired")
+		return nil, ErrSessionExpired
+	}
+
+	if err := s.touchSession(ctx, session, now); err != nil {
+		fmt.Printf("Warning: failed to record session activity: %v\n", err)
 	}
 
 	user, err := s.db.GetQueries().GetUserByID(ctx, session.UserID)
@@ -173,8 +273,13 @@ func (s *service) LogoutUser(ctx context.Context, sessionToken string) error {
 	return nil
 }
 
-func (s *service) GetSessionByUserID(ctx context.Context, userID int32) ([]sqlc.Session, error) {
-	sessions, err := s.db.GetQueries().GetSessionsByUserID(ctx, userID)
+// ListSessions returns the user's sessions without their tokens, marking the
+// one identified by currentToken
+func (s *service) ListSessions(ctx context.Context, userID int32, currentToken string) ([]sqlc.GetSessionsByUserIDRow, error) {
+	sessions, err := s.db.GetQueries().GetSessionsByUserID(ctx, sqlc.GetSessionsByUserIDParams{
+		CurrentToken: currentToken,
+		UserID:       userID,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get sessions by user ID: %w", err)
 	}
@@ -271,25 +376,9 @@ func (s *service) GoogleOAuthLogin(ctx context.Context, code string) (*sqlc.GetU
 			return nil, "", 0, fmt.Errorf("failed to create user: %w", err)
 		}
 
-		sessionToken, err := generateSessionToken()
+		sessionToken, _, err := s.createLoginSession(ctx, newUser.ID)
 		if err != nil {
-			return nil, "", 0, fmt.Errorf("failed to generate session token: %w", err)
-		}
-
-		expiresAt := time.Now().Add(7 * 24 * time.Hour)
-		var expiresAtPg pgtype.Timestamp
-		expiresAtPg.Time = expiresAt
-		expiresAtPg.Valid = true
-
-		sessionParams := sqlc.CreateSessionParams{
-			UserID:       newUser.ID,
-			SessionToken: sessionToken,
-			ExpiresAt:    expiresAtPg,
-		}
-
-		_, err = s.db.GetQueries().CreateSession(ctx, sessionParams)
-		if err != nil {
-			return nil, "", 0, fmt.Errorf("failed to create session: %w", err)
+			return nil, "", 0, err
 		}
 
 		userRow := &sqlc.GetUserByEmailRow{
@@ -306,46 +395,14 @@ func (s *service) GoogleOAuthLogin(ctx context.Context, code string) (*sqlc.GetU
 		return userRow, sessionToken, 0, nil
 	}
 
-	// User exists, create session
-	sessionToken, err := generateSessionToken()
-	if err != nil {
-		return nil, "", 0, fmt.Errorf("failed to generate session token: %w", err)
-	}
-
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-	var expiresAtPg pgtype.Timestamp
-	expiresAtPg.Time = expiresAt
-	expiresAtPg.Valid = true
-
-	var activePageID int32
-	recentPage, err := s.db.GetQueries().GetUserMostRecentPage(ctx, user.ID)
-	if err == nil {
-		activePageID = recentPage.ID
-	}
-
-	sessionParams := sqlc.CreateSessionParams{
-		UserID:       user.ID,
-		SessionToken: sessionToken,
-		ExpiresAt:    expiresAtPg,
+	if err := s.requireSecondFactor(ctx, user.ID); err != nil {
+		return &user, "", 0, err
 	}
 
-	session, err := s.db.GetQueries().CreateSession(ctx, sessionParams)
+	// User exists, create session
+	sessionToken, activePageID, err := s.createLoginSession(ctx, user.ID)
 	if err != nil {
-		return nil, "", 0, fmt.Errorf("failed to create session: %w", err)
-	}
-
-	if activePageID > 0 {
-		var activePageIDPg pgtype.Int4
-		activePageIDPg.Int32 = activePageID
-		activePageIDPg.Valid = true
-
-		_, err = s.db.GetQueries().UpdateSessionWithActivePage(ctx, sqlc.UpdateSessionWithActivePageParams{
-			ID:           session.ID,
-			ActivePageID: activePageIDPg,
-		})
-		if err != nil {
-			fmt.Printf("Warning: failed to update session with active page: %v\n", err)
-		}
+		return nil, "", 0, err
 	}
 
 	return &user, sessionToken, activePageID, nil
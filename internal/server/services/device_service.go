@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// nonceRetention covers the whole window in which a signed request's timestamp
+// is accepted (up to 5 minutes either side), so a replay is always caught
+const nonceRetention = 10 * time.Minute
+
+// PurgeExpiredNonces deletes request nonces too old to be replayed
+func (s *service) PurgeExpiredNonces(ctx context.Context) error {
+	cutoff := pgtype.Timestamp{
+		Time:  time.Now().Add(-nonceRetention),
+		Valid: true,
+	}
+
+	if _, err := s.db.GetQueries().DeleteDeviceNoncesBefore(ctx, cutoff); err != nil {
+		return fmt.Errorf("failed to purge nonces: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeExpiredDeviceChallenges deletes registration challenges that can no longer be answered
+func (s *service) PurgeExpiredDeviceChallenges(ctx context.Context) error {
+	if _, err := s.db.GetQueries().DeleteExpiredDeviceChallenges(ctx); err != nil {
+		return fmt.Errorf("failed to purge device challenges: %w", err)
+	}
+
+	return nil
+}
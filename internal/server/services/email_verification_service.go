@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"yamony/internal/database/sqlc"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// emailVerificationTTL bounds how long a verification link stays valid
+const emailVerificationTTL = 24 * time.Hour
+
+var (
+	ErrEmailAlreadyVerified     = errors.New("email already verified")
+	ErrInvalidEmailVerification = errors.New("invalid or expired email verification link")
+)
+
+// SendEmailVerification mails the user a one-time link proving they own their
+// address. Earlier links stop working. Only a hash of the token is stored
+func (s *service) SendEmailVerification(ctx context.Context, userID int32) error {
+	queries := s.db.GetQueries()
+
+	user, err := queries.GetUserByID(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	token, err := generateSessionToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate verification token: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
+	if err := qtx.DeleteUserEmailVerifications(ctx, userID); err != nil {
+		return fmt.Errorf("failed to replace verification links: %w", err)
+	}
+	if err := qtx.CreateEmailVerification(ctx, sqlc.CreateEmailVerificationParams{
+		TokenHash: hashEmailVerificationToken(token),
+		UserID:    userID,
+		Email:     user.Email,
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().Add(emailVerificationTTL), Valid: true},
+	}); err != nil {
+		return fmt.Errorf("failed to create verification link: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	link := s.relyingParty.Origin + "/verify-email?token=" + url.QueryEscape(token)
+	body := "Confirm your email address for Yamony by opening this link within 24 hours:\n\n" + link + "\n"
+	if err := s.mailer.Send(ctx, user.Email, "Confirm your email address", body); err != nil {
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyEmail redeems a verification link and marks the address it was sent to
+// as verified. Share invitations waiting for that address are released once
+// the user also has an active device, the same as when a device is verified
+func (s *service) VerifyEmail(ctx context.Context, token string) (int32, error) {
+	queries := s.db.GetQueries()
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := queries.WithTx(tx)
+	verification, err := qtx.ClaimEmailVerification(ctx, hashEmailVerificationToken(token))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, ErrInvalidEmailVerification
+		}
+		return 0, fmt.Errorf("failed to claim verification link: %w", err)
+	}
+
+	verified, err := qtx.MarkUserEmailVerified(ctx, sqlc.MarkUserEmailVerifiedParams{
+		ID:    verification.UserID,
+		Email: verification.Email,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to verify email: %w", err)
+	}
+	if verified == 0 {
+		return 0, ErrInvalidEmailVerification
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	devices, err := queries.GetDevicesByUserID(ctx, verification.UserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get devices: %w", err)
+	}
+	if len(devices) > 0 {
+		s.ReleaseShareInvitations(ctx, verification.UserID)
+	}
+
+	return verification.UserID, nil
+}
+
+// PurgeExpiredEmailVerifications deletes verification links that can no longer be used
+func (s *service) PurgeExpiredEmailVerifications(ctx context.Context) error {
+	if _, err := s.db.GetQueries().DeleteExpiredEmailVerifications(ctx); err != nil {
+		return fmt.Errorf("failed to purge email verifications: %w", err)
+	}
+
+	return nil
+}
+
+func hashEmailVerificationToken(token string) []byte {
+	digest := sha256.Sum256([]byte(token))
+	return digest[:]
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// eventsChannel is the Postgres NOTIFY channel shared by all server instances
+const eventsChannel = "yamony_events"
+
+// maxEventPayload stays under Postgres' 8000 byte NOTIFY payload limit
+const maxEventPayload = 7900
+
+// Event types pushed to a user's devices
+const (
+	EventVaultVersion    = "vault_version"
+	EventShareInvitation = "share_invitation"
+	EventDeviceRevoked   = "device_revoked"
+	EventKeyRotated      = "key_rotated"
+
+	EventDeviceApprovalRequested = "device_approval_requested"
+	EventDeviceApproved          = "device_approved"
+	EventRotationRecommended     = "rotation_recommended"
+	EventShareRoleChanged        = "share_role_changed"
+	EventShareInvitationReady    = "share_invitation_ready"
+	EventShareKeyRequested       = "share_key_requested"
+	EventShareKeysAdded          = "share_keys_added"
+	EventShareExpired            = "share_expired"
+	EventShareExpiryChanged      = "share_expiry_changed"
+	EventShareKeysStale          = "share_keys_stale"
+	EventKDFParamsChanged        = "kdf_params_changed"
+	EventTwoFactorChanged        = "two_factor_changed"
+)
+
+// Event is a change notification addressed to a set of users
+type Event struct {
+	Type    string                 `json:"type"`
+	UserIDs []int32                `json:"user_ids"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+// eventHub fans events received from Postgres out to this instance's subscribers
+type eventHub struct {
+	mu          sync.RWMutex
+	subscribers map[int32]map[chan Event]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[int32]map[chan Event]struct{})}
+}
+
+// SubscribeEvents registers a stream for userID. The returned function must be
+// called to unsubscribe; it closes the channel.
+func (s *service) SubscribeEvents(userID int32) (<-chan Event, func()) {
+	ch := make(chan Event, 16)
+
+	s.events.mu.Lock()
+	if s.events.subscribers[userID] == nil {
+		s.events.subscribers[userID] = make(map[chan Event]struct{})
+	}
+	s.events.subscribers[userID][ch] = struct{}{}
+	s.events.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.events.mu.Lock()
+			delete(s.events.subscribers[userID], ch)
+			if len(s.events.subscribers[userID]) == 0 {
+				delete(s.events.subscribers, userID)
+			}
+			s.events.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// dispatch delivers an event to local subscribers. Slow subscribers miss events
+// rather than block the listener; clients recover by pulling.
+func (h *eventHub) dispatch(event Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, userID := range event.UserIDs {
+		for ch := range h.subscribers[userID] {
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}
+}
+
+// ListenForEvents receives events published by any server instance and hands
+// them to local subscribers until ctx is cancelled or the connection drops
+func (s *service) ListenForEvents(ctx context.Context) error {
+	return s.db.Listen(ctx, eventsChannel, func(payload string) {
+		var event Event
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			log.Printf("ignoring malformed event: %v", err)
+			return
+		}
+		s.events.dispatch(event)
+	})
+}
+
+// PublishUserEvent notifies all of a user's devices. Delivery is best effort,
+// so failures are logged rather than returned.
+func (s *service) PublishUserEvent(ctx context.Context, userID int32, eventType string, data map[string]interface{}) {
+	s.publishEvent(ctx, Event{Type: eventType, UserIDs: []int32{userID}, Data: data})
+}
+
+// PublishVaultEvent notifies the vault owner and every user it is shared with
+func (s *service) PublishVaultEvent(ctx context.Context, vaultID int32, eventType string, data map[string]interface{}) {
+	queries := s.db.GetQueries()
+
+	vault, err := queries.GetVaultByID(ctx, vaultID)
+	if err != nil {
+		log.Printf("failed to publish %s event for vault %d: %v", eventType, vaultID, err)
+		return
+	}
+	userIDs := []int32{vault.UserID}
+
+	shares, err := queries.GetSharingRecordsByVaultID(ctx, vaultID)
+	if err != nil {
+		log.Printf("failed to publish %s event for vault %d: %v", eventType, vaultID, err)
+		return
+	}
+	for _, share := range shares {
+		userIDs = append(userIDs, share.RecipientUserID)
+	}
+
+	s.publishEvent(ctx, Event{Type: eventType, UserIDs: userIDs, Data: data})
+}
+
+func (s *service) publishEvent(ctx context.Context, event Event) {
+	payload, err := json.Marshal(event)
+	if err == nil && len(payload) > maxEventPayload {
+		err = fmt.Errorf("payload of %d bytes exceeds NOTIFY limit", len(payload))
+	}
+	if err == nil {
+		err = s.db.Notify(ctx, eventsChannel, string(payload))
+	}
+	if err != nil {
+		log.Printf("failed to publish %s event: %v", event.Type, err)
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"yamony/internal/crypto"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AccountSaltLength is the length of every account KDF and SRP salt. Stand-ins
+// for unknown emails have the same length, so a salt's size gives nothing away
+const AccountSaltLength = 32
+
+// lookupKeyFromEnv returns the secret used to derive stand-in KDF values for
+// unknown emails. Startup fails without ACCOUNT_LOOKUP_SECRET: a random key
+// would change the stand-ins on every restart, and an email whose values change
+// is one that is not registered
+func lookupKeyFromEnv() []byte {
+	secret := os.Getenv("ACCOUNT_LOOKUP_SECRET")
+	if secret == "" {
+		log.Fatalf("ACCOUNT_LOOKUP_SECRET must be set")
+	}
+	return []byte(secret)
+}
+
+// standIn derives a value that is stable for an email but unrelated to any account
+func (s *service) standIn(purpose, email string) []byte {
+	mac := hmac.New(sha256.New, s.lookupKey)
+	mac.Write([]byte(purpose + ":" + strings.ToLower(email)))
+	return mac.Sum(nil)[:AccountSaltLength]
+}
+
+// kdfParamsOrStandIn returns the account's KDF salt and params, or deterministic
+// stand-ins when the email is unknown or the account has none, so responses do
+// not reveal which emails are registered
+func (s *service) kdfParamsOrStandIn(email string, salt, params []byte) ([]byte, []byte, error) {
+	if len(salt) > 0 && len(params) > 0 {
+		return salt, params, nil
+	}
+
+	params, err := crypto.MarshalKDFParams(crypto.DefaultKDFParams())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode kdf params: %w", err)
+	}
+
+	return s.standIn("kdf_salt", email), params, nil
+}
+
+// GetKDFParams returns the salt and Argon2id params a client needs to derive the
+// master key for an email before logging in
+func (s *service) GetKDFParams(ctx context.Context, email string) ([]byte, []byte, error) {
+	credentials, err := s.db.GetQueries().GetUserSrpCredentials(ctx, email)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return s.kdfParamsOrStandIn(email, credentials.KdfSalt, credentials.KdfParams)
+}
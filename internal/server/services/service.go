@@ -2,19 +2,49 @@ package services
 
 import (
 	"context"
+	"log"
 	"os"
+	"time"
+	"yamony/internal/crypto"
 	"yamony/internal/database"
 	"yamony/internal/database/sqlc"
+	"yamony/internal/mail"
+	"yamony/internal/storage"
 
+	"github.com/jackc/pgx/v5/pgtype"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
 type Service interface {
-	RegisterUser(ctx context.Context, username, email, password string) (*sqlc.CreateUserRow, string, int32, error)
+	RegisterUser(ctx context.Context, username, email string, srpSalt, srpVerifier, kdfSalt, kdfParams []byte) (*sqlc.CreateUserRow, string, int32, error)
 	LoginUser(ctx context.Context, email, password string) (*sqlc.GetUserByEmailRow, string, int32, error)
+	SendEmailVerification(ctx context.Context, userID int32) error
+	VerifyEmail(ctx context.Context, token string) (int32, error)
+	UpgradeToSrp(ctx context.Context, userID int32, password string, srpSalt, srpVerifier, kdfSalt, kdfParams []byte) error
+	BeginSrpLogin(ctx context.Context, email string, clientPublic []byte) (*SrpChallenge, error)
+	FinishSrpLogin(ctx context.Context, handshakeID pgtype.UUID, clientProof []byte) (*sqlc.GetUserByIDRow, []byte, string, int32, error)
+	VerifySrpProof(ctx context.Context, userID int32, handshakeID pgtype.UUID, clientProof []byte) error
+	GetKDFParams(ctx context.Context, email string) ([]byte, []byte, error)
+	VerifyMFALogin(ctx context.Context, mfaToken, code, recoveryCode string) (*sqlc.GetUserByIDRow, string, int32, error)
+	TwoFactorEnabled(ctx context.Context, userID int32) (bool, error)
+	TwoFactorMethods(ctx context.Context, userID int32) ([]string, error)
+	EnrollTOTP(ctx context.Context, userID int32, accountName string) (*TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID int32, code string) ([]string, error)
+	VerifySecondFactor(ctx context.Context, userID int32, code, recoveryCode string) error
+	DisableTwoFactor(ctx context.Context, userID int32, code, recoveryCode string) error
+	RegenerateMFARecoveryCodes(ctx context.Context, userID int32, code, recoveryCode string) ([]string, error)
+	BeginWebAuthnRegistration(ctx context.Context, userID int32) (*WebAuthnCeremony, error)
+	FinishWebAuthnRegistration(ctx context.Context, userID int32, challengeID pgtype.UUID, name string, clientDataJSON, attestationObject []byte) (*sqlc.WebauthnCredential, error)
+	DeleteWebAuthnCredential(ctx context.Context, userID, credentialID int32) error
+	BeginWebAuthnLogin(ctx context.Context, mfaToken string) (*WebAuthnCeremony, error)
+	FinishWebAuthnLogin(ctx context.Context, challengeID pgtype.UUID, credentialID []byte, assertion crypto.WebAuthnAssertion) (*sqlc.GetUserByIDRow, string, int32, error)
+	VerifyMFAWebAuthn(ctx context.Context, mfaToken string, challengeID pgtype.UUID, credentialID []byte, assertion crypto.WebAuthnAssertion) (*sqlc.GetUserByIDRow, string, int32, error)
 	ValidateSession(ctx context.Context, sessionToken string) (*sqlc.GetUserByIDRow, error)
 	LogoutUser(ctx context.Context, sessionToken string) error
+	RevokeSession(ctx context.Context, userID, sessionID int32, currentToken string) (bool, error)
+	RevokeOtherSessions(ctx context.Context, userID int32, currentToken string) (int64, error)
+	RotateSession(ctx context.Context, sessionToken string) (string, error)
 	SyncActivePageToSession(ctx context.Context, sessionToken string, pageID int32) error
 	CreatePage(ctx context.Context, userID int32, handle string, is_active bool) (*sqlc.Page, error)
 	UpdatePage(ctx context.Context, activePageID int32, isActive bool, name, handle, image, bannerImage, bio string) (*sqlc.Page, error)
@@ -24,16 +54,39 @@ type Service interface {
 	SetActivePage(ctx context.Context, pageID int32) error
 	GetPageByHandle(ctx context.Context, handle string) (*sqlc.Page, error)
 	SetNextPageAsActive(ctx context.Context, user_id int32) (*sqlc.Page, error)
-	GetSessionByUserID(ctx context.Context, userID int32) ([]sqlc.Session, error)
+	ListSessions(ctx context.Context, userID int32, currentToken string) ([]sqlc.GetSessionsByUserIDRow, error)
 	GetAllUserPage(ctx context.Context, userID int32) ([]sqlc.Page, error)
 	GetGoogleOAuthConfig() *oauth2.Config
 	GoogleOAuthLogin(ctx context.Context, code string) (*sqlc.GetUserByEmailRow, string, int32, error)
+	TombstoneRetention() time.Duration
+	PurgeExpiredTombstones(ctx context.Context) error
+	PurgeExpiredNonces(ctx context.Context) error
+	PurgeExpiredSessions(ctx context.Context) error
+	PurgeExpiredDeviceChallenges(ctx context.Context) error
+	PurgeExpiredSrpHandshakes(ctx context.Context) error
+	PurgeExpiredMfaChallenges(ctx context.Context) error
+	PurgeExpiredWebauthnChallenges(ctx context.Context) error
+	PurgeExpiredShareInvitations(ctx context.Context) error
+	PurgeExpiredEmailVerifications(ctx context.Context) error
+	ExpireShares(ctx context.Context) error
+	ReleaseShareInvitations(ctx context.Context, userID int32)
+	ObjectStore() storage.ObjectStore
+	PublishUserEvent(ctx context.Context, userID int32, eventType string, data map[string]interface{})
+	PublishVaultEvent(ctx context.Context, vaultID int32, eventType string, data map[string]interface{})
+	SubscribeEvents(userID int32) (<-chan Event, func())
+	ListenForEvents(ctx context.Context) error
 	GetDB() database.Service
 }
 
 type service struct {
-	db                database.Service
-	googleOAuthConfig *oauth2.Config
+	db                 database.Service
+	googleOAuthConfig  *oauth2.Config
+	tombstoneRetention time.Duration
+	objectStore        storage.ObjectStore
+	events             *eventHub
+	lookupKey          []byte
+	relyingParty       crypto.WebAuthnRelyingParty
+	mailer             mail.Sender
 }
 
 func New(db database.Service) Service {
@@ -48,9 +101,25 @@ func New(db database.Service) Service {
 		Endpoint: google.Endpoint,
 	}
 
+	objectStore, err := storage.NewFromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("failed to initialize object store: %v", err)
+	}
+
+	mailer, err := mail.NewFromEnv()
+	if err != nil {
+		log.Fatalf("failed to initialize mail sender: %v", err)
+	}
+
 	return &service{
-		db:                db,
-		googleOAuthConfig: googleOAuthConfig,
+		db:                 db,
+		googleOAuthConfig:  googleOAuthConfig,
+		tombstoneRetention: tombstoneRetentionFromEnv(),
+		objectStore:        objectStore,
+		events:             newEventHub(),
+		lookupKey:          lookupKeyFromEnv(),
+		relyingParty:       webauthnRelyingPartyFromEnv(),
+		mailer:             mailer,
 	}
 }
 
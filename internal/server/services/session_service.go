@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"yamony/internal/database/sqlc"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// sessionIdleTimeout is how long a session survives without being used.
+	// Each use slides the expiry forward by this much
+	sessionIdleTimeout = 7 * 24 * time.Hour
+	// sessionMaxAge caps a session's lifetime from login however active it is
+	sessionMaxAge = 30 * 24 * time.Hour
+	// sessionTouchInterval limits how often activity is written back for a session
+	sessionTouchInterval = time.Minute
+
+	// Long user agents are truncated rather than rejected
+	sessionUserAgentMaxLength = 512
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionExpired  = errors.New("session expired")
+)
+
+// ClientInfo describes the client a request came from, recorded on the
+// sessions it starts
+type ClientInfo struct {
+	UserAgent string
+	IPAddress string
+}
+
+type clientInfoKey struct{}
+
+// WithClientInfo attaches the requesting client to ctx
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+func clientInfoFromContext(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	if len(info.UserAgent) > sessionUserAgentMaxLength {
+		info.UserAgent = info.UserAgent[:sessionUserAgentMaxLength]
+	}
+	// Headers are not guaranteed to be UTF-8, and truncating may split a character
+	info.UserAgent = strings.ToValidUTF8(info.UserAgent, "")
+	return info
+}
+
+// sessionExpiry returns when a session used at now should expire: one idle
+// timeout later, but never past the absolute max age from createdAt
+func sessionExpiry(createdAt, now time.Time) time.Time {
+	expiresAt := now.Add(sessionIdleTimeout)
+	if limit := createdAt.Add(sessionMaxAge); expiresAt.After(limit) {
+		return limit
+	}
+	return expiresAt
+}
+
+// sessionExpired reports whether a session is past its sliding expiry or its
+// absolute max age
+func sessionExpired(session sqlc.Session, now time.Time) bool {
+	if session.ExpiresAt.Valid && now.After(session.ExpiresAt.Time) {
+		return true
+	}
+	return session.CreatedAt.Valid && now.After(session.CreatedAt.Time.Add(sessionMaxAge))
+}
+
+// RevokeSession ends one of the user's sessions. It reports whether that was
+// the session making the request
+func (s *service) RevokeSession(ctx context.Context, userID, sessionID int32, currentToken string) (bool, error) {
+	queries := s.db.GetQueries()
+
+	deleted, err := queries.DeleteUserSession(ctx, sqlc.DeleteUserSessionParams{
+		ID:     sessionID,
+		UserID: userID,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete session: %w", err)
+	}
+	if deleted == 0 {
+		return false, ErrSessionNotFound
+	}
+
+	_, err = queries.GetSessionByToken(ctx, currentToken)
+	if err == pgx.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return false, nil
+}
+
+// RevokeOtherSessions signs the user out everywhere except the session making
+// the request and returns how many sessions were ended
+func (s *service) RevokeOtherSessions(ctx context.Context, userID int32, currentToken string) (int64, error) {
+	queries := s.db.GetQueries()
+
+	current, err := queries.GetSessionByToken(ctx, currentToken)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, ErrSessionNotFound
+		}
+		return 0, fmt.Errorf("failed to get session: %w", err)
+	}
+	if current.UserID != userID {
+		return 0, ErrSessionNotFound
+	}
+
+	revoked, err := queries.DeleteOtherUserSessions(ctx, sqlc.DeleteOtherUserSessionsParams{
+		UserID: userID,
+		ID:     current.ID,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+
+	return revoked, nil
+}
+
+// RotateSession replaces a session's token, keeping everything else about the
+// session. Called after privilege changes so a token captured earlier stops working
+func (s *service) RotateSession(ctx context.Context, sessionToken string) (string, error) {
+	newToken, err := generateSessionToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+
+	rotated, err := s.db.GetQueries().RotateSessionToken(ctx, sqlc.RotateSessionTokenParams{
+		NewToken: newToken,
+		OldToken: sessionToken,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to rotate session token: %w", err)
+	}
+	if rotated == 0 {
+		return "", ErrSessionNotFound
+	}
+
+	return newToken, nil
+}
+
+// PurgeExpiredSessions deletes sessions past their expiry
+func (s *service) PurgeExpiredSessions(ctx context.Context) error {
+	if _, err := s.db.GetQueries().DeleteExpiredSessions(ctx); err != nil {
+		return fmt.Errorf("failed to purge sessions: %w", err)
+	}
+
+	return nil
+}
+
+// touchSession records activity on a session and slides its expiry forward
+func (s *service) touchSession(ctx context.Context, session sqlc.Session, now time.Time) error {
+	if session.LastActiveAt.Valid && now.Sub(session.LastActiveAt.Time) < sessionTouchInterval {
+		return nil
+	}
+
+	createdAt := session.CreatedAt.Time
+	if !session.CreatedAt.Valid {
+		createdAt = now
+	}
+
+	ipAddress := clientInfoFromContext(ctx).IPAddress
+	if ipAddress == "" {
+		ipAddress = session.IpAddress
+	}
+
+	return s.db.GetQueries().TouchSession(ctx, sqlc.TouchSessionParams{
+		ID:        session.ID,
+		ExpiresAt: pgtype.Timestamp{Time: sessionExpiry(createdAt, now), Valid: true},
+		IpAddress: ipAddress,
+	})
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// ReleaseShareInvitations binds the user's pending email invitations to them
+// and asks each sender to wrap the vault key for the user's devices. Called
+// once the user has an active device and again when their email is verified;
+// the server never sees the key itself. Nothing is released to an account
+// whose email is unverified
+func (s *service) ReleaseShareInvitations(ctx context.Context, userID int32) {
+	invitations, err := s.db.GetQueries().MarkShareInvitationsReady(ctx, userID)
+	if err != nil {
+		log.Printf("failed to release share invitations for user %d: %v", userID, err)
+		return
+	}
+
+	for _, invitation := range invitations {
+		s.PublishUserEvent(ctx, invitation.SenderUserID, EventShareInvitationReady, map[string]interface{}{
+			"invitation_id":     invitation.ID.String(),
+			"vault_id":          invitation.VaultID,
+			"email":             invitation.Email,
+			"recipient_user_id": userID,
+			"role":              invitation.Role,
+		})
+	}
+}
+
+// PurgeExpiredShareInvitations deletes invitations that expired before the invitee joined
+func (s *service) PurgeExpiredShareInvitations(ctx context.Context) error {
+	if _, err := s.db.GetQueries().DeleteExpiredShareInvitations(ctx); err != nil {
+		return fmt.Errorf("failed to purge share invitations: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"fmt"
+)
+
+// ExpireShares marks shares whose expiry has passed as expired and tells the
+// vault owner and the former recipient. Like a revoked share, an expired one
+// leaves the recipient holding the vault key or the item's IEK, so the vault is
+// flagged for rotation in the same transaction
+func (s *service) ExpireShares(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.db.GetQueries().WithTx(tx)
+
+	expired, err := qtx.ExpireSharingRecords(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to expire shares: %w", err)
+	}
+
+	owners := make(map[int32]int32, len(expired))
+	for _, record := range expired {
+		if _, ok := owners[record.VaultID]; ok {
+			continue
+		}
+
+		vault, err := qtx.GetVaultByID(ctx, record.VaultID)
+		if err != nil {
+			return fmt.Errorf("failed to get vault %d: %w", record.VaultID, err)
+		}
+		owners[record.VaultID] = vault.UserID
+
+		if err := qtx.FlagVaultForRotation(ctx, record.VaultID); err != nil {
+			return fmt.Errorf("failed to flag vault %d for rotation: %w", record.VaultID, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit expired shares: %w", err)
+	}
+
+	for _, record := range expired {
+		data := map[string]interface{}{
+			"share_id":          record.ID.String(),
+			"vault_id":          record.VaultID,
+			"recipient_user_id": record.RecipientUserID,
+		}
+		if record.ItemID.Valid {
+			data["item_id"] = record.ItemID.String()
+		}
+
+		s.PublishUserEvent(ctx, owners[record.VaultID], EventShareExpired, data)
+		s.PublishUserEvent(ctx, record.RecipientUserID, EventShareExpired, data)
+	}
+	for vaultID := range owners {
+		s.PublishVaultEvent(ctx, vaultID, EventRotationRecommended, map[string]interface{}{
+			"vault_id": vaultID,
+			"reason":   "share_expired",
+		})
+	}
+
+	return nil
+}
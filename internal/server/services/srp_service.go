@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"yamony/internal/crypto"
+	"yamony/internal/database/sqlc"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// srpHandshakeTTL bounds how long a client has to answer the server ephemeral
+const srpHandshakeTTL = 2 * time.Minute
+
+// SrpChallenge is the server's answer to the first round of an SRP login
+type SrpChallenge struct {
+	HandshakeID  pgtype.UUID
+	Salt         []byte
+	ServerPublic []byte
+	KdfSalt      []byte
+	KdfParams    []byte
+}
+
+// BeginSrpLogin stores a fresh server ephemeral for the account and returns
+// what the client needs to compute its proof
+func (s *service) BeginSrpLogin(ctx context.Context, email string, clientPublic []byte) (*SrpChallenge, error) {
+	queries := s.db.GetQueries()
+
+	credentials, err := queries.GetUserSrpCredentials(ctx, email)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return s.standInSrpChallenge(email, nil, nil)
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	// Accounts created before SRP login get the same answer as unknown emails,
+	// with their real KDF values so the response matches GetKDFParams
+	if len(credentials.SrpVerifier) == 0 || len(credentials.SrpSalt) == 0 {
+		return s.standInSrpChallenge(email, credentials.KdfSalt, credentials.KdfParams)
+	}
+
+	serverSecret, serverPublic, err := crypto.GenerateSRPServerEphemeral(credentials.SrpVerifier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate server ephemeral: %w", err)
+	}
+
+	kdfSalt, kdfParams, err := s.kdfParamsOrStandIn(email, credentials.KdfSalt, credentials.KdfParams)
+	if err != nil {
+		return nil, err
+	}
+
+	handshake, err := queries.CreateSrpHandshake(ctx, sqlc.CreateSrpHandshakeParams{
+		UserID:       credentials.ID,
+		ClientPublic: clientPublic,
+		ServerSecret: serverSecret,
+		ServerPublic: serverPublic,
+		ExpiresAt:    pgtype.Timestamp{Time: time.Now().Add(srpHandshakeTTL), Valid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create srp handshake: %w", err)
+	}
+
+	return &SrpChallenge{
+		HandshakeID:  handshake.ID,
+		Salt:         credentials.SrpSalt,
+		ServerPublic: serverPublic,
+		KdfSalt:      kdfSalt,
+		KdfParams:    kdfParams,
+	}, nil
+}
+
+// standInSrpChallenge answers the first round for an unknown email, or an account
+// without a verifier, like it would for a real one. No handshake is stored, so
+// the proof is always rejected.
+func (s *service) standInSrpChallenge(email string, kdfSalt, kdfParams []byte) (*SrpChallenge, error) {
+	_, serverPublic, err := crypto.GenerateSRPServerEphemeral(s.standIn("srp_verifier", email))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate server ephemeral: %w", err)
+	}
+
+	kdfSalt, kdfParams, err = s.kdfParamsOrStandIn(email, kdfSalt, kdfParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SrpChallenge{
+		HandshakeID:  pgtype.UUID{Bytes: uuid.New(), Valid: true},
+		Salt:         s.standIn("srp_salt", email),
+		ServerPublic: serverPublic,
+		KdfSalt:      kdfSalt,
+		KdfParams:    kdfParams,
+	}, nil
+}
+
+// FinishSrpLogin checks the client proof against the stored verifier and, if it
+// matches, creates the same session as LoginUser. It returns the server proof so
+// the client can authenticate the server in turn.
+func (s *service) FinishSrpLogin(ctx context.Context, handshakeID pgtype.UUID, clientProof []byte) (*sqlc.GetUserByIDRow, []byte, string, int32, error) {
+	user, serverProof, err := s.verifySrpHandshake(ctx, handshakeID, clientProof)
+	if err != nil {
+		return nil, nil, "", 0, err
+	}
+
+	// The server proof is still returned so the client can check it before sending a second factor
+	if err := s.requireSecondFactor(ctx, user.ID); err != nil {
+		return &user, serverProof, "", 0, err
+	}
+
+	sessionToken, activePageID, err := s.createLoginSession(ctx, user.ID)
+	if err != nil {
+		return nil, nil, "", 0, err
+	}
+
+	return &user, serverProof, sessionToken, activePageID, nil
+}
+
+// VerifySrpProof checks a fresh SRP proof from a signed-in user, for actions
+// that need the password again. The handshake comes from BeginSrpLogin with the
+// user's email and is used up whatever the outcome
+func (s *service) VerifySrpProof(ctx context.Context, userID int32, handshakeID pgtype.UUID, clientProof []byte) error {
+	user, _, err := s.verifySrpHandshake(ctx, handshakeID, clientProof)
+	if err != nil {
+		return err
+	}
+	if user.ID != userID {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// verifySrpHandshake claims a handshake and checks the client proof against
+// the account's current verifier, returning the server proof
+func (s *service) verifySrpHandshake(ctx context.Context, handshakeID pgtype.UUID, clientProof []byte) (sqlc.GetUserByIDRow, []byte, error) {
+	queries := s.db.GetQueries()
+
+	// Claiming deletes the handshake, so every proof attempt needs a new server ephemeral
+	handshake, err := queries.ClaimSrpHandshake(ctx, handshakeID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return sqlc.GetUserByIDRow{}, nil, ErrInvalidCredentials
+		}
+		return sqlc.GetUserByIDRow{}, nil, fmt.Errorf("failed to claim srp handshake: %w", err)
+	}
+
+	user, err := queries.GetUserByID(ctx, handshake.UserID)
+	if err != nil {
+		return sqlc.GetUserByIDRow{}, nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	credentials, err := queries.GetUserSrpCredentials(ctx, user.Email)
+	if err != nil {
+		return sqlc.GetUserByIDRow{}, nil, fmt.Errorf("failed to get srp credentials: %w", err)
+	}
+
+	serverProof, err := crypto.VerifySRPClientProof(
+		credentials.Email,
+		credentials.SrpSalt,
+		credentials.SrpVerifier,
+		handshake.ClientPublic,
+		handshake.ServerSecret,
+		handshake.ServerPublic,
+		clientProof,
+	)
+	if err != nil {
+		return sqlc.GetUserByIDRow{}, nil, ErrInvalidCredentials
+	}
+
+	return user, serverProof, nil
+}
+
+// PurgeExpiredSrpHandshakes deletes login handshakes that can no longer be completed
+func (s *service) PurgeExpiredSrpHandshakes(ctx context.Context) error {
+	if _, err := s.db.GetQueries().DeleteExpiredSrpHandshakes(ctx); err != nil {
+		return fmt.Errorf("failed to purge srp handshakes: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"yamony/internal/storage"
+)
+
+// defaultTombstoneRetention is used when TOMBSTONE_RETENTION_DAYS is unset or invalid
+const defaultTombstoneRetention = 90 * 24 * time.Hour
+
+func tombstoneRetentionFromEnv() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TOMBSTONE_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		return defaultTombstoneRetention
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// TombstoneRetention returns how long deletion tombstones are kept for sync.
+// Clients that last synced before this window must do a full resync.
+func (s *service) TombstoneRetention() time.Duration {
+	return s.tombstoneRetention
+}
+
+// PurgeExpiredTombstones deletes tombstones older than the retention window
+func (s *service) PurgeExpiredTombstones(ctx context.Context) error {
+	cutoff := pgtype.Timestamp{
+		Time:  time.Now().Add(-s.tombstoneRetention),
+		Valid: true,
+	}
+
+	if _, err := s.db.GetQueries().DeleteVaultItemTombstonesBefore(ctx, cutoff); err != nil {
+		return fmt.Errorf("failed to purge tombstones: %w", err)
+	}
+
+	return nil
+}
+
+// ObjectStore returns the store holding vault version snapshots
+func (s *service) ObjectStore() storage.ObjectStore {
+	return s.objectStore
+}
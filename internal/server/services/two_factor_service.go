@@ -0,0 +1,481 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"yamony/internal/crypto"
+	"yamony/internal/database/sqlc"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// totpIssuer labels the account in authenticator apps
+	totpIssuer = "Yamony"
+	// mfaChallengeTTL bounds how long a login may wait for its second factor
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaMaxAttempts is how many codes a pending login may try before it is dropped
+	mfaMaxAttempts = 5
+	// mfaUserMaxAttempts is how many codes a user may try per mfaUserAttemptWindow,
+	// across every pending login and re-authentication
+	mfaUserMaxAttempts   = 10
+	mfaUserAttemptWindow = 15 * time.Minute
+	// mfaRecoveryCodeCount is how many one-time recovery codes are issued at once
+	mfaRecoveryCodeCount = 10
+	// mfaRecoveryCodeBytes gives each recovery code 50 bits, shown as 10 characters
+	mfaRecoveryCodeBytes = 5
+)
+
+// Second factors a pending login can be completed with
+const (
+	MFAMethodTOTP         = "totp"
+	MFAMethodRecoveryCode = "recovery_code"
+	MFAMethodWebAuthn     = "webauthn"
+)
+
+var (
+	ErrInvalidMFACode       = errors.New("invalid two-factor code")
+	ErrMFANotEnabled        = errors.New("two-factor authentication is not enabled")
+	ErrMFAAlreadyEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrMFARequiredForShares = errors.New("two-factor authentication is required while sharing vaults")
+	ErrMFATooManyAttempts   = errors.New("too many two-factor attempts")
+)
+
+var mfaRecoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFARequiredError is returned by the login flows instead of a session when the
+// account has 2FA enabled. Token identifies the pending login for VerifyMFALogin
+type MFARequiredError struct {
+	Token   string
+	Methods []string
+}
+
+func (e *MFARequiredError) Error() string {
+	return "second factor required"
+}
+
+// TOTPEnrollment is a fresh authenticator secret awaiting confirmation
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// TwoFactorEnabled reports whether the user has any second factor set up
+func (s *service) TwoFactorEnabled(ctx context.Context, userID int32) (bool, error) {
+	methods, err := secondFactorMethods(ctx, s.db.GetQueries(), userID)
+	if err != nil {
+		return false, err
+	}
+
+	return len(methods) > 0, nil
+}
+
+// TwoFactorMethods lists the second factors a login for the user can be completed with
+func (s *service) TwoFactorMethods(ctx context.Context, userID int32) ([]string, error) {
+	return secondFactorMethods(ctx, s.db.GetQueries(), userID)
+}
+
+// EnrollTOTP generates a new authenticator secret for the user, replacing any
+// unconfirmed one. 2FA stays off until ConfirmTOTP succeeds
+func (s *service) EnrollTOTP(ctx context.Context, userID int32, accountName string) (*TOTPEnrollment, error) {
+	secret, err := crypto.GenerateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate totp secret: %w", err)
+	}
+
+	_, err = s.db.GetQueries().UpsertTotpCredential(ctx, sqlc.UpsertTotpCredentialParams{
+		UserID: userID,
+		Secret: secret,
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrMFAAlreadyEnabled
+		}
+		return nil, fmt.Errorf("failed to store totp secret: %w", err)
+	}
+
+	return &TOTPEnrollment{
+		Secret: crypto.EncodeTOTPSecret(secret),
+		URI:    crypto.TOTPURI(totpIssuer, accountName, secret),
+	}, nil
+}
+
+// ConfirmTOTP turns 2FA on once the user proves their authenticator produces
+// valid codes, and returns a fresh set of recovery codes to show once
+func (s *service) ConfirmTOTP(ctx context.Context, userID int32, code string) ([]string, error) {
+	credential, err := s.db.GetQueries().GetTotpCredential(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrMFANotEnabled
+		}
+		return nil, fmt.Errorf("failed to get totp credential: %w", err)
+	}
+	if credential.ConfirmedAt.Valid {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := crypto.ValidateTOTP(credential.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.db.GetQueries().WithTx(tx)
+
+	confirmed, err := qtx.ConfirmTotpCredential(ctx, sqlc.ConfirmTotpCredentialParams{
+		UserID:       userID,
+		LastUsedStep: step,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm totp credential: %w", err)
+	}
+	if confirmed == 0 {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	codes, err := replaceMFARecoveryCodes(ctx, qtx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return codes, nil
+}
+
+// VerifySecondFactor checks a TOTP code, or else a one-time recovery code, for
+// a user with 2FA enabled. Each TOTP time step and recovery code works once.
+// Every attempt counts against the user, whichever pending login or
+// re-authentication it comes from, and a success clears the count
+func (s *service) VerifySecondFactor(ctx context.Context, userID int32, code, recoveryCode string) error {
+	queries := s.db.GetQueries()
+
+	attempts, err := queries.RecordMfaUserAttempt(ctx, sqlc.RecordMfaUserAttemptParams{
+		UserID:      userID,
+		WindowStart: pgtype.Timestamp{Time: time.Now().Add(-mfaUserAttemptWindow), Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record mfa attempt: %w", err)
+	}
+	if attempts > mfaUserMaxAttempts {
+		return ErrMFATooManyAttempts
+	}
+
+	if err := checkSecondFactor(ctx, queries, userID, code, recoveryCode); err != nil {
+		return err
+	}
+
+	if err := queries.ResetMfaUserAttempts(ctx, userID); err != nil {
+		return fmt.Errorf("failed to reset mfa attempts: %w", err)
+	}
+
+	return nil
+}
+
+// checkSecondFactor verifies a TOTP code or recovery code without counting the attempt
+func checkSecondFactor(ctx context.Context, queries *sqlc.Queries, userID int32, code, recoveryCode string) error {
+	credential, err := queries.GetTotpCredential(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrMFANotEnabled
+		}
+		return fmt.Errorf("failed to get totp credential: %w", err)
+	}
+	if !credential.ConfirmedAt.Valid {
+		return ErrMFANotEnabled
+	}
+
+	if code != "" {
+		step, ok := crypto.ValidateTOTP(credential.Secret, code, time.Now())
+		if !ok {
+			return ErrInvalidMFACode
+		}
+
+		claimed, err := queries.ClaimTotpStep(ctx, sqlc.ClaimTotpStepParams{
+			UserID:       userID,
+			LastUsedStep: step,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to claim totp step: %w", err)
+		}
+		if claimed == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	if recoveryCode != "" {
+		used, err := queries.UseMfaRecoveryCode(ctx, sqlc.UseMfaRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: hashMFARecoveryCode(recoveryCode),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to use recovery code: %w", err)
+		}
+		if used == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	return ErrInvalidMFACode
+}
+
+// DisableTwoFactor removes the authenticator and recovery codes after
+// re-checking a second factor. Accounts that take part in shares must keep
+// at least one second factor
+func (s *service) DisableTwoFactor(ctx context.Context, userID int32, code, recoveryCode string) error {
+	if err := s.VerifySecondFactor(ctx, userID, code, recoveryCode); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.db.GetQueries().WithTx(tx)
+
+	if err := qtx.DeleteTotpCredential(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete totp credential: %w", err)
+	}
+	if err := qtx.DeleteMfaRecoveryCodes(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err := ensureSharingSecondFactor(ctx, qtx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// RegenerateMFARecoveryCodes replaces all recovery codes after re-checking a second factor
+func (s *service) RegenerateMFARecoveryCodes(ctx context.Context, userID int32, code, recoveryCode string) ([]string, error) {
+	if err := s.VerifySecondFactor(ctx, userID, code, recoveryCode); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	codes, err := replaceMFARecoveryCodes(ctx, s.db.GetQueries().WithTx(tx), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return codes, nil
+}
+
+// requireSecondFactor is called by every login flow once the first factor has
+// passed. For accounts with 2FA it opens a pending login instead of a session.
+// Codes are counted per user as well, so each new pending login does not
+// bring a fresh set of guesses
+func (s *service) requireSecondFactor(ctx context.Context, userID int32) error {
+	methods, err := secondFactorMethods(ctx, s.db.GetQueries(), userID)
+	if err != nil {
+		return err
+	}
+	if len(methods) == 0 {
+		return nil
+	}
+
+	token, err := generateSessionToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate mfa token: %w", err)
+	}
+
+	_, err = s.db.GetQueries().CreateMfaChallenge(ctx, sqlc.CreateMfaChallengeParams{
+		TokenHash: hashMFAToken(token),
+		UserID:    userID,
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().Add(mfaChallengeTTL), Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create mfa challenge: %w", err)
+	}
+
+	return &MFARequiredError{
+		Token:   token,
+		Methods: methods,
+	}
+}
+
+// VerifyMFALogin completes a pending login with a second factor and creates
+// the same session as the first-factor login would have
+func (s *service) VerifyMFALogin(ctx context.Context, mfaToken, code, recoveryCode string) (*sqlc.GetUserByIDRow, string, int32, error) {
+	challenge, err := s.recordMFAAttempt(ctx, mfaToken)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	if err := s.VerifySecondFactor(ctx, challenge.UserID, code, recoveryCode); err != nil {
+		return nil, "", 0, err
+	}
+
+	return s.completeMFALogin(ctx, challenge)
+}
+
+// recordMFAAttempt counts an attempt against a pending login. Too many wrong
+// answers send the user back to the first factor
+func (s *service) recordMFAAttempt(ctx context.Context, mfaToken string) (*sqlc.MfaChallenge, error) {
+	queries := s.db.GetQueries()
+	tokenHash := hashMFAToken(mfaToken)
+
+	challenge, err := queries.RecordMfaChallengeAttempt(ctx, tokenHash)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to get mfa challenge: %w", err)
+	}
+
+	if challenge.Attempts > mfaMaxAttempts {
+		_ = queries.DeleteMfaChallenge(ctx, tokenHash)
+		return nil, ErrInvalidCredentials
+	}
+
+	return &challenge, nil
+}
+
+// completeMFALogin closes a pending login whose second factor was verified
+func (s *service) completeMFALogin(ctx context.Context, challenge *sqlc.MfaChallenge) (*sqlc.GetUserByIDRow, string, int32, error) {
+	queries := s.db.GetQueries()
+
+	if err := queries.DeleteMfaChallenge(ctx, challenge.TokenHash); err != nil {
+		return nil, "", 0, fmt.Errorf("failed to delete mfa challenge: %w", err)
+	}
+
+	user, err := queries.GetUserByID(ctx, challenge.UserID)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	sessionToken, activePageID, err := s.createLoginSession(ctx, user.ID)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return &user, sessionToken, activePageID, nil
+}
+
+// PurgeExpiredMfaChallenges deletes pending logins that can no longer be completed
+func (s *service) PurgeExpiredMfaChallenges(ctx context.Context) error {
+	if _, err := s.db.GetQueries().DeleteExpiredMfaChallenges(ctx); err != nil {
+		return fmt.Errorf("failed to purge mfa challenges: %w", err)
+	}
+
+	return nil
+}
+
+// secondFactorMethods lists the second factors the user has set up
+func secondFactorMethods(ctx context.Context, queries *sqlc.Queries, userID int32) ([]string, error) {
+	methods := []string{}
+
+	credential, err := queries.GetTotpCredential(ctx, userID)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("failed to get totp credential: %w", err)
+	}
+	if err == nil && credential.ConfirmedAt.Valid {
+		methods = append(methods, MFAMethodTOTP, MFAMethodRecoveryCode)
+	}
+
+	passkeys, err := queries.CountWebauthnCredentials(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count webauthn credentials: %w", err)
+	}
+	if passkeys > 0 {
+		methods = append(methods, MFAMethodWebAuthn)
+	}
+
+	return methods, nil
+}
+
+// ensureSharingSecondFactor is checked inside a transaction that removes a
+// second factor, and fails it if a user taking part in shares has none left
+func ensureSharingSecondFactor(ctx context.Context, qtx *sqlc.Queries, userID int32) error {
+	methods, err := secondFactorMethods(ctx, qtx, userID)
+	if err != nil {
+		return err
+	}
+	if len(methods) > 0 {
+		return nil
+	}
+
+	hasShares, err := qtx.UserHasActiveShares(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to check shares: %w", err)
+	}
+	if hasShares {
+		return ErrMFARequiredForShares
+	}
+
+	return nil
+}
+
+// replaceMFARecoveryCodes swaps the user's recovery codes for a new set and
+// returns the codes formatted for display
+func replaceMFARecoveryCodes(ctx context.Context, qtx *sqlc.Queries, userID int32) ([]string, error) {
+	if err := qtx.DeleteMfaRecoveryCodes(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	codes := make([]string, 0, mfaRecoveryCodeCount)
+	for len(codes) < mfaRecoveryCodeCount {
+		raw, err := crypto.GenerateRandomBytes(mfaRecoveryCodeBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+
+		encoded := mfaRecoveryCodeEncoding.EncodeToString(raw)
+		code := encoded[:4] + "-" + encoded[4:]
+
+		if err := qtx.CreateMfaRecoveryCode(ctx, sqlc.CreateMfaRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: hashMFARecoveryCode(code),
+		}); err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// hashMFARecoveryCode hashes a recovery code, ignoring case, dashes and spaces
+func hashMFARecoveryCode(code string) []byte {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	digest := sha256.Sum256([]byte(normalized))
+	return digest[:]
+}
+
+// hashMFAToken hashes a pending login token so the table never holds a usable token
+func hashMFAToken(token string) []byte {
+	digest := sha256.Sum256([]byte(token))
+	return digest[:]
+}
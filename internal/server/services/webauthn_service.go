@@ -0,0 +1,309 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"yamony/internal/crypto"
+	"yamony/internal/database/sqlc"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// webauthnChallengeTTL bounds how long a browser may take to answer a ceremony
+	webauthnChallengeTTL = 5 * time.Minute
+
+	webauthnCeremonyRegistration   = "registration"
+	webauthnCeremonyAuthentication = "authentication"
+)
+
+var (
+	ErrWebAuthnFailed             = errors.New("webauthn verification failed")
+	ErrWebAuthnCredentialExists   = errors.New("webauthn credential already registered")
+	ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+)
+
+// WebAuthnCeremony is an open registration or authentication ceremony. The
+// credential IDs are excluded from registration or allowed for authentication
+type WebAuthnCeremony struct {
+	ChallengeID   pgtype.UUID
+	Challenge     []byte
+	RPID          string
+	UserHandle    []byte
+	CredentialIDs [][]byte
+}
+
+// webauthnRelyingPartyFromEnv reads the relying party the frontend runs under
+func webauthnRelyingPartyFromEnv() crypto.WebAuthnRelyingParty {
+	rp := crypto.WebAuthnRelyingParty{
+		ID:     os.Getenv("WEBAUTHN_RP_ID"),
+		Origin: os.Getenv("WEBAUTHN_ORIGIN"),
+	}
+	if rp.ID == "" {
+		rp.ID = "localhost"
+	}
+	if rp.Origin == "" {
+		rp.Origin = "http://localhost:3001"
+	}
+	return rp
+}
+
+// webauthnUserHandle is the opaque user.id given to authenticators
+func webauthnUserHandle(userID int32) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+// BeginWebAuthnRegistration opens a ceremony to register a new passkey or
+// security key for the user
+func (s *service) BeginWebAuthnRegistration(ctx context.Context, userID int32) (*WebAuthnCeremony, error) {
+	credentials, err := s.db.GetQueries().ListWebauthnCredentials(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webauthn credentials: %w", err)
+	}
+
+	ceremony, err := s.createWebAuthnCeremony(ctx, pgtype.Int4{Int32: userID, Valid: true}, webauthnCeremonyRegistration, credentials)
+	if err != nil {
+		return nil, err
+	}
+	ceremony.UserHandle = webauthnUserHandle(userID)
+
+	return ceremony, nil
+}
+
+// FinishWebAuthnRegistration verifies the authenticator's response and stores the credential
+func (s *service) FinishWebAuthnRegistration(ctx context.Context, userID int32, challengeID pgtype.UUID, name string, clientDataJSON, attestationObject []byte) (*sqlc.WebauthnCredential, error) {
+	queries := s.db.GetQueries()
+
+	challenge, err := queries.ClaimWebauthnChallenge(ctx, sqlc.ClaimWebauthnChallengeParams{
+		ID:       challengeID,
+		Ceremony: webauthnCeremonyRegistration,
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrWebAuthnFailed
+		}
+		return nil, fmt.Errorf("failed to claim webauthn challenge: %w", err)
+	}
+	if !challenge.UserID.Valid || challenge.UserID.Int32 != userID {
+		return nil, ErrWebAuthnFailed
+	}
+
+	verified, err := s.relyingParty.VerifyRegistration(challenge.Challenge, clientDataJSON, attestationObject, false)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebAuthnFailed, err)
+	}
+
+	if _, err := queries.GetWebauthnCredentialByCredentialID(ctx, verified.ID); err == nil {
+		return nil, ErrWebAuthnCredentialExists
+	} else if err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("failed to check webauthn credential: %w", err)
+	}
+
+	credential, err := queries.CreateWebauthnCredential(ctx, sqlc.CreateWebauthnCredentialParams{
+		UserID:       userID,
+		CredentialID: verified.ID,
+		PublicKey:    verified.PublicKey,
+		SignCount:    int64(verified.SignCount),
+		Aaguid:       verified.AAGUID,
+		Name:         name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store webauthn credential: %w", err)
+	}
+
+	return &credential, nil
+}
+
+// DeleteWebAuthnCredential removes one of the user's credentials. Accounts that
+// take part in shares cannot remove their last second factor
+func (s *service) DeleteWebAuthnCredential(ctx context.Context, userID, credentialID int32) error {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.db.GetQueries().WithTx(tx)
+
+	deleted, err := qtx.DeleteWebauthnCredential(ctx, sqlc.DeleteWebauthnCredentialParams{
+		ID:     credentialID,
+		UserID: userID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete webauthn credential: %w", err)
+	}
+	if deleted == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+
+	if err := ensureSharingSecondFactor(ctx, qtx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// BeginWebAuthnLogin opens an authentication ceremony. With an mfa token it is
+// the second factor of a pending login and only that user's credentials are
+// allowed; without one it is a passwordless login with a discoverable credential
+func (s *service) BeginWebAuthnLogin(ctx context.Context, mfaToken string) (*WebAuthnCeremony, error) {
+	if mfaToken == "" {
+		return s.createWebAuthnCeremony(ctx, pgtype.Int4{}, webauthnCeremonyAuthentication, nil)
+	}
+
+	queries := s.db.GetQueries()
+	pending, err := queries.GetMfaChallenge(ctx, hashMFAToken(mfaToken))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to get mfa challenge: %w", err)
+	}
+
+	credentials, err := queries.ListWebauthnCredentials(ctx, pending.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webauthn credentials: %w", err)
+	}
+	if len(credentials) == 0 {
+		return nil, ErrMFANotEnabled
+	}
+
+	return s.createWebAuthnCeremony(ctx, pgtype.Int4{Int32: pending.UserID, Valid: true}, webauthnCeremonyAuthentication, credentials)
+}
+
+// FinishWebAuthnLogin completes a passwordless login. The authenticator must
+// have verified the user, so the passkey stands in for both factors
+func (s *service) FinishWebAuthnLogin(ctx context.Context, challengeID pgtype.UUID, credentialID []byte, assertion crypto.WebAuthnAssertion) (*sqlc.GetUserByIDRow, string, int32, error) {
+	userID, err := s.verifyWebAuthnAssertion(ctx, challengeID, pgtype.Int4{}, credentialID, assertion, true)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	user, err := s.db.GetQueries().GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	sessionToken, activePageID, err := s.createLoginSession(ctx, user.ID)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return &user, sessionToken, activePageID, nil
+}
+
+// VerifyMFAWebAuthn completes a pending login with a WebAuthn assertion as the second factor
+func (s *service) VerifyMFAWebAuthn(ctx context.Context, mfaToken string, challengeID pgtype.UUID, credentialID []byte, assertion crypto.WebAuthnAssertion) (*sqlc.GetUserByIDRow, string, int32, error) {
+	pending, err := s.recordMFAAttempt(ctx, mfaToken)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	expected := pgtype.Int4{Int32: pending.UserID, Valid: true}
+	if _, err := s.verifyWebAuthnAssertion(ctx, challengeID, expected, credentialID, assertion, false); err != nil {
+		return nil, "", 0, err
+	}
+
+	return s.completeMFALogin(ctx, pending)
+}
+
+// PurgeExpiredWebauthnChallenges deletes ceremonies that can no longer be completed
+func (s *service) PurgeExpiredWebauthnChallenges(ctx context.Context) error {
+	if _, err := s.db.GetQueries().DeleteExpiredWebauthnChallenges(ctx); err != nil {
+		return fmt.Errorf("failed to purge webauthn challenges: %w", err)
+	}
+
+	return nil
+}
+
+func (s *service) createWebAuthnCeremony(ctx context.Context, userID pgtype.Int4, ceremony string, credentials []sqlc.WebauthnCredential) (*WebAuthnCeremony, error) {
+	challenge, err := crypto.GenerateWebAuthnChallenge()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate webauthn challenge: %w", err)
+	}
+
+	stored, err := s.db.GetQueries().CreateWebauthnChallenge(ctx, sqlc.CreateWebauthnChallengeParams{
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Challenge: challenge,
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().Add(webauthnChallengeTTL), Valid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webauthn challenge: %w", err)
+	}
+
+	credentialIDs := make([][]byte, 0, len(credentials))
+	for _, credential := range credentials {
+		credentialIDs = append(credentialIDs, credential.CredentialID)
+	}
+
+	return &WebAuthnCeremony{
+		ChallengeID:   stored.ID,
+		Challenge:     challenge,
+		RPID:          s.relyingParty.ID,
+		CredentialIDs: credentialIDs,
+	}, nil
+}
+
+// verifyWebAuthnAssertion claims the ceremony, checks the assertion against the
+// stored credential and records its use. expectedUser is set when the ceremony
+// was opened for a known user
+func (s *service) verifyWebAuthnAssertion(ctx context.Context, challengeID pgtype.UUID, expectedUser pgtype.Int4, credentialID []byte, assertion crypto.WebAuthnAssertion, requireUserVerification bool) (int32, error) {
+	queries := s.db.GetQueries()
+
+	challenge, err := queries.ClaimWebauthnChallenge(ctx, sqlc.ClaimWebauthnChallengeParams{
+		ID:       challengeID,
+		Ceremony: webauthnCeremonyAuthentication,
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, ErrWebAuthnFailed
+		}
+		return 0, fmt.Errorf("failed to claim webauthn challenge: %w", err)
+	}
+	if challenge.UserID != expectedUser {
+		return 0, ErrWebAuthnFailed
+	}
+
+	credential, err := queries.GetWebauthnCredentialByCredentialID(ctx, credentialID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, ErrWebAuthnFailed
+		}
+		return 0, fmt.Errorf("failed to get webauthn credential: %w", err)
+	}
+	if expectedUser.Valid && credential.UserID != expectedUser.Int32 {
+		return 0, ErrWebAuthnFailed
+	}
+
+	// A passwordless login only learns the account from the credential, so the
+	// authenticator's user handle must name the same account
+	if (!expectedUser.Valid || len(assertion.UserHandle) > 0) && !bytes.Equal(assertion.UserHandle, webauthnUserHandle(credential.UserID)) {
+		return 0, ErrWebAuthnFailed
+	}
+
+	signCount, err := s.relyingParty.VerifyAssertion(challenge.Challenge, credential.PublicKey, uint32(credential.SignCount), assertion, requireUserVerification)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrWebAuthnFailed, err)
+	}
+
+	if err := queries.UpdateWebauthnCredentialUsage(ctx, sqlc.UpdateWebauthnCredentialUsageParams{
+		ID:        credential.ID,
+		SignCount: int64(signCount),
+	}); err != nil {
+		return 0, fmt.Errorf("failed to update webauthn credential: %w", err)
+	}
+
+	return credential.UserID, nil
+}
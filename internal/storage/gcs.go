@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// GCSStore keeps objects in a Google Cloud Storage bucket
+type GCSStore struct {
+	bucket *storage.BucketHandle
+}
+
+// NewGCSStore connects to the bucket, using credsJSON when given and
+// application default credentials otherwise
+func NewGCSStore(ctx context.Context, bucket, credsJSON string) (*GCSStore, error) {
+	var opts []option.ClientOption
+	if credsJSON != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(credsJSON)))
+	}
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage client: %w", err)
+	}
+	return &GCSStore{bucket: client.Bucket(bucket)}, nil
+}
+
+func (s *GCSStore) Put(ctx context.Context, key string, data []byte) error {
+	wc := s.bucket.Object(key).NewWriter(ctx)
+	wc.ContentType = "application/json"
+	if _, err := wc.Write(data); err != nil {
+		_ = wc.Close()
+		return fmt.Errorf("failed to upload object: %w", err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("failed to finalize object: %w", err)
+	}
+	return nil
+}
+
+func (s *GCSStore) Get(ctx context.Context, key string) ([]byte, error) {
+	rc, err := s.bucket.Object(key).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open object: %w", err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	return data, nil
+}
+
+func (s *GCSStore) Delete(ctx context.Context, key string) error {
+	err := s.bucket.Object(key).Delete(ctx)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
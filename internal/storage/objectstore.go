@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrObjectNotFound is returned by Get when no object exists at the key
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore persists opaque blobs such as vault snapshots under string keys
+type ObjectStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewFromEnv builds the object store selected by OBJECT_STORE ("local" or "gcs").
+// The local store writes under OBJECT_STORE_DIR and is the default.
+func NewFromEnv(ctx context.Context) (ObjectStore, error) {
+	switch backend := os.Getenv("OBJECT_STORE"); backend {
+	case "", "local":
+		dir := os.Getenv("OBJECT_STORE_DIR")
+		if dir == "" {
+			dir = "./data/objects"
+		}
+		return NewFileStore(dir)
+	case "gcs":
+		bucket := os.Getenv("GCS_BUCKET")
+		if bucket == "" {
+			return nil, fmt.Errorf("GCS_BUCKET is required for the gcs object store")
+		}
+		return NewGCSStore(ctx, bucket, os.Getenv("GOOGLE_CLOUD_CREDENTIALS_JSON"))
+	default:
+		return nil, fmt.Errorf("unknown OBJECT_STORE %q", backend)
+	}
+}